@@ -21,12 +21,19 @@ package cache_ui
 import (
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/director"
+	"github.com/pelicanplatform/pelican/metrics"
+	"github.com/pelicanplatform/pelican/param"
 	"github.com/pelicanplatform/pelican/server_utils"
+	log "github.com/sirupsen/logrus"
 )
 
 type (
 	CacheServer struct {
 		server_utils.NamespaceHolder
+
+		// The link statistics as of the previous advertisement, used to
+		// compute the cache's throughput between advertisements
+		lastLink *metrics.LinkSnapshot
 	}
 )
 
@@ -36,11 +43,40 @@ func (server *CacheServer) CreateAdvertisement(name string, originUrl string, or
 		URL:        originUrl,
 		WebURL:     originWebUrl,
 		Namespaces: server.GetNamespaceAds(),
+		Load:       server.getLoad(),
 	}
 
 	return ad, nil
 }
 
+// Gather the load signals the director uses to choose between caches
+func (server *CacheServer) getLoad() *director.ServerLoad {
+	load := director.ServerLoad{}
+
+	if link, ok := metrics.GetLinkSnapshot(); ok {
+		load.ActiveConnections = int64(link.Connections)
+		if server.lastLink != nil {
+			elapsed := link.Timestamp.Sub(server.lastLink.Timestamp).Seconds()
+			// xrootd's counters reset on restart; skip the rate until we have two
+			// samples from the same xrootd process
+			if elapsed > 0 && link.BytesOut >= server.lastLink.BytesOut {
+				load.BytesPerSecond = float64(link.BytesOut-server.lastLink.BytesOut) / elapsed
+			}
+		}
+		server.lastLink = &link
+	}
+
+	free, total, err := getDiskUsage(param.Cache_DataLocation.GetString())
+	if err != nil {
+		log.Debugln("Failed to determine free disk space of the cache:", err)
+	} else {
+		load.FreeDiskBytes = free
+		load.TotalDiskBytes = total
+	}
+
+	return &load
+}
+
 func (server *CacheServer) GetServerType() config.ServerType {
 	return config.CacheType
 }
@@ -19,181 +19,305 @@
 package classads
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"io"
-	"strconv"
+	"reflect"
 	"strings"
 )
 
+// ClassAd is a set of named expressions, in the syntax HTCondor uses to
+// describe jobs and transfers.  Attribute names are case-insensitive, but
+// keep the case and order they were first set with.
 type ClassAd struct {
-	attributes map[string]interface{}
+	names      []string
+	attributes map[string]Expr
 }
 
 func NewClassAd() *ClassAd {
 	return &ClassAd{
-		attributes: make(map[string]interface{}),
+		attributes: make(map[string]Expr),
 	}
 }
 
-// Get returns the value of the attribute with the given name.
+// Get returns the value of the attribute with the given name, evaluated.
+//
+// Integers are returned as int, reals as float64, lists as []interface{} and
+// nested ads as *ClassAd.  An attribute that is missing or undefined is nil,
+// and one that evaluates to error returns an error.
 func (c *ClassAd) Get(name string) (interface{}, error) {
-	if c.attributes == nil {
+	expr, ok := c.Lookup(name)
+	if !ok {
 		return nil, nil
-	} else if value, ok := c.attributes[name]; ok {
-		return value, nil
-	} else {
+	}
+	env := newEvalEnv(c)
+	return toGoValue(env.evalAttr(name, expr, env.scope))
+}
+
+// Evaluate an expression in the context of the ad, returning its value as Get does
+func (c *ClassAd) Evaluate(expression string) (interface{}, error) {
+	expr, err := ParseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	return toGoValue(expr.eval(newEvalEnv(c)))
+}
+
+func toGoValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case undefinedValue:
 		return nil, nil
+	case errorValue:
+		return nil, fmt.Errorf("classad expression evaluated to error: %s", v.reason)
+	case int64:
+		return int(v), nil
+	case *scope:
+		return v.ad, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for idx, element := range v {
+			var err error
+			if list[idx], err = toGoValue(element); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// Lookup returns the unevaluated expression of an attribute
+func (c *ClassAd) Lookup(name string) (Expr, bool) {
+	if c == nil || c.attributes == nil {
+		return nil, false
 	}
+	expr, ok := c.attributes[strings.ToLower(name)]
+	return expr, ok
 }
 
+// Set an attribute to a Go value: a string, bool, number, nested *ClassAd,
+// a slice of those, an Expr or nil (undefined)
 func (c *ClassAd) Set(name string, value interface{}) {
-	// Escape any quotes in the string
-	c.attributes[name] = value
+	c.SetExpr(name, toExpr(value))
 }
 
-func (c *ClassAd) String() string {
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	for name, value := range c.attributes {
-		buffer.WriteString(name)
-		buffer.WriteString(" = ")
-		switch v := value.(type) {
-		case string:
-			buffer.WriteString("\"")
-			newVal := strings.Replace(v, "\"", "\\\"", -1)
-			buffer.WriteString(newVal)
-			buffer.WriteString("\"")
-		default:
-			buffer.WriteString(fmt.Sprintf("%v", value))
-		}
-		buffer.WriteString("; ")
-	}
-	buffer.WriteString("]")
-	return buffer.String()
-}
-
-// ReadClassAd reads a ClassAd from the given reader.
-func ReadClassAd(reader io.Reader) (ads []ClassAd, err error) {
-
-	// Catch any panics and return an error instead
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("error reading classad: %v", r)
-		}
-	}()
-
-	scanner := bufio.NewScanner(reader)
-	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-
-		// Watch out for brackets inside quotes
-		insideQuotes := false
-		for i, curChar := range data {
-			if curChar == '"' && !(i > 0 && data[i-1] == '\\') {
-				insideQuotes = !insideQuotes
-			} else if curChar == ']' && !insideQuotes {
-				return i + 1, data[0 : i+1], nil
-			}
+// SetExpr sets an attribute to an expression, to be evaluated when it is read
+func (c *ClassAd) SetExpr(name string, expr Expr) {
+	if c.attributes == nil {
+		c.attributes = make(map[string]Expr)
+	}
+	key := strings.ToLower(name)
+	if _, exists := c.attributes[key]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.attributes[key] = expr
+}
+
+// Delete an attribute, if the ad has it
+func (c *ClassAd) Delete(name string) {
+	key := strings.ToLower(name)
+	if _, exists := c.attributes[key]; !exists {
+		return
+	}
+	delete(c.attributes, key)
+	for idx, existing := range c.names {
+		if strings.ToLower(existing) == key {
+			c.names = append(c.names[:idx], c.names[idx+1:]...)
+			break
 		}
-		if atEOF {
-			return len(data), data, nil
+	}
+}
+
+// Names returns the attribute names of the ad, in the order they were set
+func (c *ClassAd) Names() []string {
+	return append([]string(nil), c.names...)
+}
+
+func toExpr(value interface{}) Expr {
+	switch v := value.(type) {
+	case nil:
+		return &literalExpr{undefinedValue{}}
+	case Expr:
+		return v
+	case string:
+		return &literalExpr{v}
+	case bool:
+		return &literalExpr{v}
+	case *ClassAd:
+		return &adExpr{v}
+	case ClassAd:
+		return &adExpr{&v}
+	case []*ClassAd:
+		elements := make([]Expr, len(v))
+		for idx, ad := range v {
+			elements[idx] = &adExpr{ad}
 		}
-		return 0, nil, nil
+		return &listExpr{elements}
 	}
-	scanner.Split(split)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
+
+	// The numbers and slices of every other type
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &literalExpr{reflected.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &literalExpr{int64(reflected.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return &literalExpr{reflected.Float()}
+	case reflect.Slice, reflect.Array:
+		elements := make([]Expr, reflected.Len())
+		for idx := range elements {
+			elements[idx] = toExpr(reflected.Index(idx).Interface())
 		}
-		// Parse the classad
-		ad, err := ParseClassAd(line)
+		return &listExpr{elements}
+	}
+	return &literalExpr{fmt.Sprint(value)}
+}
+
+// String gives the ad in the new ClassAd syntax, with its attributes in the
+// order they were set
+func (c *ClassAd) String() string {
+	var builder strings.Builder
+	builder.WriteString("[")
+	for _, name := range c.names {
+		builder.WriteString(attrNameString(name))
+		builder.WriteString(" = ")
+		builder.WriteString(c.attributes[strings.ToLower(name)].String())
+		builder.WriteString("; ")
+	}
+	builder.WriteString("]")
+	return builder.String()
+}
+
+// ReadClassAd reads the ClassAds from the given reader.
+//
+// The ads may be in the new syntax, each enclosed in brackets, or in the old
+// syntax of one attribute per line with ads separated by blank lines.
+func ReadClassAd(reader io.Reader) ([]ClassAd, error) {
+	input, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !isNewSyntax(string(input)) {
+		return readOldClassAds(string(input))
+	}
+	p := &parser{lexer: newLexer(string(input))}
+
+	var ads []ClassAd
+	for {
+		tok, err := p.lexer.next()
 		if err != nil {
 			return nil, err
 		}
-		ads = append(ads, ad)
-
-	}
-	if scanner.Err() != nil {
-		return nil, scanner.Err()
+		if tok.kind == tokenEOF {
+			return ads, nil
+		}
+		if tok.kind != tokenOperator || tok.text != "[" {
+			return nil, p.lexer.errorf(tok.pos, "expected '[' to start a classad but found %q", tok.text)
+		}
+		ad, err := p.parseAdBody("]")
+		if err != nil {
+			return nil, err
+		}
+		ads = append(ads, *ad)
 	}
-	return ads, nil
 }
 
+// ParseClassAd parses a single ClassAd, in either the new or the old syntax.
+// The brackets around an ad in the new syntax may be left off.
 func ParseClassAd(line string) (ClassAd, error) {
-	var ad ClassAd
-	ad.attributes = make(map[string]interface{})
-
-	// Trim the spaces and "[" "]"
-	line = strings.TrimSpace(line)
-	line = strings.TrimPrefix(line, "[")
-	line = strings.TrimSuffix(line, "]")
-
-	attributeScanner := bufio.NewScanner(strings.NewReader(line))
-	attributeScanner.Split(attributeSplitFunc)
-	for attributeScanner.Scan() {
-		attrStr := attributeScanner.Text()
-		attrStr = strings.TrimSpace(attrStr)
-		if attrStr == "" {
-			continue
+	if !isNewSyntax(line) {
+		ad, err := parseOldClassAd(line)
+		if err != nil {
+			return ClassAd{}, err
 		}
+		return *ad, nil
+	}
+	p := &parser{lexer: newLexer(line)}
+	if err := p.expect("["); err != nil {
+		return ClassAd{}, err
+	}
+	ad, err := p.parseAdBody("]")
+	if err != nil {
+		return ClassAd{}, err
+	}
+	if err = p.expectEOF(); err != nil {
+		return ClassAd{}, err
+	}
+	return *ad, nil
+}
 
-		// Split on the first "="
-		attrSplit := strings.SplitN(attrStr, "=", 2)
-		name := strings.TrimSpace(attrSplit[0])
-
-		// Check for quoted attribute and remove it
-		value := strings.TrimSpace(attrSplit[1])
-		// If the value is quotes, we know it's a string
-		if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
-			ad.Set(name, strings.Trim(value, "\""))
-		} else if _, err := strconv.Atoi(value); err == nil {
-			// If the value is a number, we know it's a number
-			intValue, err := strconv.Atoi(value)
-			if err == nil {
-				ad.Set(name, intValue)
-			}
-		} else if value == "true" || value == "false" {
-			// If the value is a boolean, we know it's a boolean
-			ad.Set(name, value == "true")
-		} else if _, err := strconv.ParseFloat(value, 64); err == nil {
-			// If the value is a float, we know it's a float
-			floatValue, err := strconv.ParseFloat(value, 64)
-			if err == nil {
-				ad.Set(name, floatValue)
-			}
+// Whether the input starts with a bracketed ad, after any comments (which
+// in the old syntax are lines starting with #)
+func isNewSyntax(input string) bool {
+	l := newLexer(input)
+	for {
+		if err := l.skipSpace(); err != nil {
+			return false
+		}
+		if l.pos >= len(input) || input[l.pos] != '#' {
+			break
+		}
+		if end := strings.IndexByte(input[l.pos:], '\n'); end >= 0 {
+			l.pos += end
 		} else {
-			// Otherwise, we assume it's a string
-			ad.Set(name, value)
+			l.pos = len(input)
 		}
-
 	}
-	return ad, nil
+	return l.pos < len(input) && input[l.pos] == '['
 }
 
-// Split the classad by attribute, at the first semi-colon not in quotes
-func attributeSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
+func readOldClassAds(input string) ([]ClassAd, error) {
+	var ads []ClassAd
+	for _, block := range splitOldClassAds(input) {
+		ad, err := parseOldClassAd(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(ad.names) > 0 {
+			ads = append(ads, *ad)
+		}
 	}
+	return ads, nil
+}
 
-	// Watch out for semi-colons inside quotes
-	insideQuotes := false
-	for i, curChar := range data {
-		if curChar == '"' && !(i > 0 && data[i-1] == '\\') {
-			insideQuotes = !insideQuotes
-		} else if curChar == ';' && !insideQuotes {
-			// Do not return the semi-colon
-			// Trim any spaces
-			return i + 1, bytes.TrimSpace(data[0:i]), nil
+// Old syntax ads are separated by blank lines
+func splitOldClassAds(input string) []string {
+	var blocks []string
+	var current []string
+	for _, line := range strings.Split(input, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, strings.Join(current, "\n"))
+				current = nil
+			}
+			continue
 		}
+		current = append(current, line)
 	}
-	if atEOF {
-		return len(data), bytes.TrimSpace(data), nil
+	if len(current) > 0 {
+		blocks = append(blocks, strings.Join(current, "\n"))
 	}
-	return 0, nil, nil
+	return blocks
+}
+
+// Parse an ad in the old syntax, with one "Name = expression" per line.  A
+// line may also hold several attributes separated by semicolons.
+func parseOldClassAd(input string) (*ClassAd, error) {
+	ad := NewClassAd()
+	for lineNum, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := &parser{lexer: newOldSyntaxLexer(line)}
+		lineAd, err := p.parseAdBody("")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum+1, err)
+		}
+		for _, name := range lineAd.names {
+			expr, _ := lineAd.Lookup(name)
+			ad.SetExpr(name, expr)
+		}
+	}
+	return ad, nil
 }
@@ -19,11 +19,11 @@
 package classads
 
 import (
-	"bufio"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReadClassAd(t *testing.T) {
@@ -139,18 +139,239 @@ func TestOddClassads(t *testing.T) {
 
 }
 
-func TestAttributeSplitFunc(t *testing.T) {
+func TestParseUnbracketedClassAd(t *testing.T) {
 	input := `LocalFileName = "/var/lib/condor/execute/dir_22284/glide_9GSlr9/execute/dir_69758/file2"; Url = "stash:///osgconnect/public/$USER/file1; stash:///osgconnect/public/$USER/file2"`
 
-	scanner := bufio.NewScanner(strings.NewReader(input))
-	scanner.Split(attributeSplitFunc)
-	attributes := make([]string, 0)
-	for scanner.Scan() {
-		attributes = append(attributes, scanner.Text())
+	ad, err := ParseClassAd(input)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"LocalFileName", "Url"}, ad.Names())
+	localFileName, err := ad.Get("LocalFileName")
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/condor/execute/dir_22284/glide_9GSlr9/execute/dir_69758/file2", localFileName)
+	url, err := ad.Get("Url")
+	assert.NoError(t, err)
+	assert.Equal(t, "stash:///osgconnect/public/$USER/file1; stash:///osgconnect/public/$USER/file2", url)
+}
+
+func TestStringNestedClassAd(t *testing.T) {
+	inner1 := NewClassAd()
+	inner1.Set("Server", "cache1:8443")
+	inner2 := NewClassAd()
+	inner2.Set("Attempt", 2)
+	ad := NewClassAd()
+	ad.Set("Attempts", []*ClassAd{inner1, inner2})
+	assert.Equal(t, "[Attempts = { [Server = \"cache1:8443\"; ], [Attempt = 2; ] }; ]", ad.String())
+
+	ad = NewClassAd()
+	ad.Set("Data", inner2)
+	assert.Equal(t, "[Data = [Attempt = 2; ]; ]", ad.String())
+}
+
+func TestClassAdValues(t *testing.T) {
+	input := `[
+		Name = "file";
+		Size = 1024;
+		Ratio = 0.5;
+		Enabled = true;
+		Nothing = undefined;
+		Broken = error;
+		Sizes = { 1, 2.5, "three" };
+		Nested = [ Inner = Size * 2; Deeper = [ Value = Inner + 1; ] ];
+		Ads = { [ Id = 1 ], [ Id = 2 ] };
+	]`
+	ad, err := ParseClassAd(input)
+	require.NoError(t, err)
+
+	value, err := ad.Get("Name")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", value)
+	value, err = ad.Get("size")
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, value)
+	value, err = ad.Get("Ratio")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, value)
+	value, err = ad.Get("Enabled")
+	assert.NoError(t, err)
+	assert.Equal(t, true, value)
+	value, err = ad.Get("Nothing")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	value, err = ad.Get("Missing")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	_, err = ad.Get("Broken")
+	assert.Error(t, err)
+
+	value, err = ad.Get("Sizes")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, 2.5, "three"}, value)
+
+	// Nested ads see the attributes of the ads enclosing them
+	value, err = ad.Evaluate("Nested.Inner")
+	assert.NoError(t, err)
+	assert.Equal(t, 2048, value)
+	value, err = ad.Evaluate("Nested.Deeper.Value")
+	assert.NoError(t, err)
+	assert.Equal(t, 2049, value)
+	value, err = ad.Get("Nested")
+	assert.NoError(t, err)
+	nested, ok := value.(*ClassAd)
+	require.True(t, ok)
+	assert.Equal(t, []string{"Inner", "Deeper"}, nested.Names())
+
+	value, err = ad.Evaluate("Ads[1].Id")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+	value, err = ad.Evaluate("Ads.Id")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, 2}, value)
+}
+
+func TestClassAdExpressions(t *testing.T) {
+	ad := NewClassAd()
+	ad.Set("A", 10)
+	ad.Set("B", 4)
+	ad.Set("Str", "Hello")
+	ad.Set("List", []string{"a", "b"})
+	ad.Set("Undef", nil)
+	ad.SetExpr("Loop", &attrRefExpr{"Loop"})
+
+	tests := []struct {
+		expression string
+		expected   interface{}
+	}{
+		{"A + B * 2", 18},
+		{"(A + B) * 2", 28},
+		{"A / B", 2},
+		{"A / 4.0", 2.5},
+		{"A % B", 2},
+		{"-A + 1", -9},
+		{"A > B && B > 0", true},
+		{"A < B || Str == \"hello\"", true},
+		{"Str =?= \"hello\"", false},
+		{"Str is \"Hello\"", true},
+		{"Undef =?= undefined", true},
+		{"Undef isnt undefined", false},
+		{"Undef == 1", nil},
+		{"false && Undef", false},
+		{"true || Undef", true},
+		{"Undef || true", true},
+		{"A > B ? \"big\" : \"small\"", "big"},
+		{"ifThenElse(A < B, 1, 2)", 2},
+		{"strcat(Str, \", \", A)", "Hello, 10"},
+		{"toUpper(Str)", "HELLO"},
+		{"substr(Str, 1, 3)", "ell"},
+		{"size(List)", 2},
+		{"member(\"B\", List)", true},
+		{"List[0]", "a"},
+		{"isUndefined(Undef)", true},
+		{"isError(1/0)", true},
+		{"int(\"42\") + real(1)", 43.0},
+		{"floor(2.7)", 2},
+		{"A << 2 | 1", 41},
+		{"MY.A", 10},
+		{"[X = A + 1].X", 11},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			value, err := ad.Evaluate(test.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, value)
+		})
+	}
+
+	for _, expression := range []string{"1 / 0", "Str + 1", "List[5]", "unknownFunction(1)", "Loop"} {
+		_, err := ad.Evaluate(expression)
+		assert.Error(t, err, expression)
+	}
+	for _, expression := range []string{"A +", "(A", "\"unterminated", "A = B"} {
+		_, err := ad.Evaluate(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestClassAdRoundTrip(t *testing.T) {
+	ad := NewClassAd()
+	ad.Set("Zebra", "Tabs\tand\nnewlines, \"quotes\" and \\backslashes")
+	ad.Set("Apple", 1.0)
+	ad.Set("Mango", []interface{}{1, "two", false, nil})
+	ad.Set("Odd Name", 3)
+	expr, err := ParseExpr("Apple * 2 + (Zebra =?= \"x\" ? 1 : 0)")
+	require.NoError(t, err)
+	ad.SetExpr("Computed", expr)
+	ad.Set("Empty", []string{})
+
+	// Attributes keep the order they were set in, and replacing one keeps its place
+	ad.Set("apple", 2.0)
+	adStr := ad.String()
+	assert.Equal(t, `[Zebra = "Tabs\tand\nnewlines, \"quotes\" and \\backslashes"; Apple = 2.0; Mango = { 1, "two", false, undefined }; 'Odd Name' = 3; Computed = Apple * 2 + (Zebra =?= "x" ? 1 : 0); Empty = {}; ]`, adStr)
+
+	ad2, err := ParseClassAd(adStr)
+	require.NoError(t, err)
+	assert.Equal(t, adStr, ad2.String())
+	for _, name := range ad.Names() {
+		value1, err1 := ad.Get(name)
+		value2, err2 := ad2.Get(name)
+		assert.Equal(t, value1, value2, name)
+		assert.Equal(t, err1, err2, name)
+	}
+
+	ad2.Delete("ZEBRA")
+	assert.Equal(t, []string{"Apple", "Mango", "Odd Name", "Computed", "Empty"}, ad2.Names())
+}
+
+func TestReadOldClassAd(t *testing.T) {
+	input := `# A comment
+Owner = "user"
+TransferInput = "osdf:///one, osdf:///two"
+RequestCpus = 1 + 1
+Iwd = "C:\new\Users\"quoted\""
+
+Owner = "other"
+RequestCpus = 4
+`
+	ads, err := ReadClassAd(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, ads, 2)
+	owner, err := ads[0].Get("Owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", owner)
+	cpus, err := ads[0].Get("RequestCpus")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cpus)
+	owner, err = ads[1].Get("Owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", owner)
+
+	ad, err := ParseClassAd(input)
+	require.NoError(t, err)
+	transferInput, err := ad.Get("TransferInput")
+	assert.NoError(t, err)
+	assert.Equal(t, "osdf:///one, osdf:///two", transferInput)
+	// Backslashes only escape quotes in the old syntax
+	iwd, err := ad.Get("Iwd")
+	assert.NoError(t, err)
+	assert.Equal(t, `C:\new\Users"quoted"`, iwd)
+
+	_, err = ReadClassAd(strings.NewReader("[ A = 1; ] [ B = ; ]"))
+	assert.Error(t, err)
+}
+
+func TestInvalidLiterals(t *testing.T) {
+	for _, expression := range []string{
+		"9223372036854775808",
+		"0x10000000000000000",
+		`"\400"`,
+		`"\777"`,
+	} {
+		_, err := ParseExpr(expression)
+		assert.Error(t, err, expression)
 	}
-	assert.Equal(t, nil, scanner.Err(), "attributeSplitFunc() failed: %s", scanner.Err())
-	assert.Equal(t, 2, len(attributes), "attributeSplitFunc() returned %d attributes, expected 2", len(attributes))
-	assert.Equal(t, `LocalFileName = "/var/lib/condor/execute/dir_22284/glide_9GSlr9/execute/dir_69758/file2"`, attributes[0])
-	assert.Equal(t, `Url = "stash:///osgconnect/public/$USER/file1; stash:///osgconnect/public/$USER/file2"`, attributes[1])
 
+	ad, err := ParseClassAd(`[ A = "\101\377"; ]`)
+	require.NoError(t, err)
+	value, err := ad.Get("A")
+	assert.NoError(t, err)
+	assert.Equal(t, "A\xff", value)
 }
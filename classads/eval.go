@@ -0,0 +1,750 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, University of Nebraska-Lincoln
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package classads
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values produced by evaluating an expression are one of undefinedValue,
+// errorValue, bool, int64, float64, string, []interface{} (a list) or *scope
+// (an ad, along with the ads enclosing it).
+type (
+	undefinedValue struct{}
+
+	errorValue struct {
+		// Why the expression evaluated to error, for reporting
+		reason string
+	}
+
+	// An ad, and the ads enclosing it, in which attribute references are resolved
+	scope struct {
+		ad     *ClassAd
+		parent *scope
+	}
+
+	evalEnv struct {
+		scope *scope
+		// The attributes being evaluated further up the stack, to catch
+		// attributes that refer to themselves
+		active map[activeAttr]bool
+	}
+
+	activeAttr struct {
+		ad   *ClassAd
+		name string
+	}
+)
+
+// Find the expression of an attribute, and the scope it is defined in
+func (s *scope) lookup(name string) (Expr, *scope) {
+	for current := s; current != nil; current = current.parent {
+		if expr, ok := current.ad.Lookup(name); ok {
+			return expr, current
+		}
+	}
+	return nil, nil
+}
+
+func newEvalEnv(ad *ClassAd) *evalEnv {
+	return &evalEnv{scope: &scope{ad: ad}, active: make(map[activeAttr]bool)}
+}
+
+// Evaluate an attribute's expression within the scope defining it
+func (env *evalEnv) evalAttr(name string, expr Expr, definedIn *scope) interface{} {
+	key := activeAttr{definedIn.ad, strings.ToLower(name)}
+	if env.active[key] {
+		return errorValue{"attribute " + name + " refers to itself"}
+	}
+	env.active[key] = true
+	defer delete(env.active, key)
+	return expr.eval(&evalEnv{scope: definedIn, active: env.active})
+}
+
+func (e *literalExpr) eval(env *evalEnv) interface{} {
+	return e.value
+}
+
+func (e *attrRefExpr) eval(env *evalEnv) interface{} {
+	expr, definedIn := env.scope.lookup(e.name)
+	if expr != nil {
+		return env.evalAttr(e.name, expr, definedIn)
+	}
+	// Unless an ad defines them, MY and PARENT name the enclosing ads
+	switch strings.ToLower(e.name) {
+	case "my":
+		return env.scope
+	case "parent":
+		if env.scope.parent != nil {
+			return env.scope.parent
+		}
+	}
+	return undefinedValue{}
+}
+
+func (e *selectExpr) eval(env *evalEnv) interface{} {
+	switch v := e.expr.eval(env).(type) {
+	case *scope:
+		// Only the selected ad's own attributes, though they are evaluated
+		// within the ads enclosing it
+		expr, ok := v.ad.Lookup(e.name)
+		if !ok {
+			return undefinedValue{}
+		}
+		return env.evalAttr(e.name, expr, v)
+	case undefinedValue:
+		return v
+	case []interface{}:
+		// Selecting from a list selects from each ad in it
+		result := make([]interface{}, len(v))
+		for idx, element := range v {
+			result[idx] = (&selectExpr{expr: &literalExpr{element}, name: e.name}).eval(env)
+		}
+		return result
+	default:
+		return errorValue{"cannot select " + e.name + " from a non-ad"}
+	}
+}
+
+func (e *subscriptExpr) eval(env *evalEnv) interface{} {
+	container := e.expr.eval(env)
+	index := e.index.eval(env)
+	if isError(container) {
+		return container
+	}
+	if isError(index) {
+		return index
+	}
+	if isUndefined(container) || isUndefined(index) {
+		return undefinedValue{}
+	}
+	switch v := container.(type) {
+	case []interface{}:
+		idx, ok := index.(int64)
+		if !ok {
+			return errorValue{"list index is not an integer"}
+		}
+		if idx < 0 || idx >= int64(len(v)) {
+			return errorValue{"list index out of range"}
+		}
+		return v[idx]
+	case *scope:
+		name, ok := index.(string)
+		if !ok {
+			return errorValue{"ad subscript is not a string"}
+		}
+		return (&selectExpr{expr: &literalExpr{v}, name: name}).eval(env)
+	}
+	return errorValue{"subscript of a value that is neither a list nor an ad"}
+}
+
+func (e *listExpr) eval(env *evalEnv) interface{} {
+	result := make([]interface{}, len(e.elements))
+	for idx, element := range e.elements {
+		result[idx] = element.eval(env)
+	}
+	return result
+}
+
+func (e *adExpr) eval(env *evalEnv) interface{} {
+	return &scope{ad: e.ad, parent: env.scope}
+}
+
+func (e *condExpr) eval(env *evalEnv) interface{} {
+	cond := e.cond.eval(env)
+	if isError(cond) || isUndefined(cond) {
+		return cond
+	}
+	truth, ok := toBool(cond)
+	if !ok {
+		return errorValue{"condition is not a boolean"}
+	}
+	if truth {
+		return e.then.eval(env)
+	}
+	return e.otherwise.eval(env)
+}
+
+func isUndefined(v interface{}) bool {
+	_, ok := v.(undefinedValue)
+	return ok
+}
+
+func isError(v interface{}) bool {
+	_, ok := v.(errorValue)
+	return ok
+}
+
+// Booleans, and numbers (which are true when non-zero), in a boolean context
+func toBool(v interface{}) (bool, bool) {
+	switch value := v.(type) {
+	case bool:
+		return value, true
+	case int64:
+		return value != 0, true
+	case float64:
+		return value != 0, true
+	}
+	return false, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (e *unaryExpr) eval(env *evalEnv) interface{} {
+	operand := e.operand.eval(env)
+	if isError(operand) || isUndefined(operand) {
+		return operand
+	}
+	switch e.op {
+	case "+":
+		if _, ok := toFloat(operand); ok {
+			return operand
+		}
+	case "-":
+		switch v := operand.(type) {
+		case int64:
+			return -v
+		case float64:
+			return -v
+		}
+	case "!":
+		if truth, ok := toBool(operand); ok {
+			return !truth
+		}
+	case "~":
+		if v, ok := operand.(int64); ok {
+			return ^v
+		}
+	}
+	return errorValue{"invalid operand for " + e.op}
+}
+
+func (e *binaryExpr) eval(env *evalEnv) interface{} {
+	switch e.op {
+	case "&&", "||":
+		return e.evalLogical(env)
+	case "=?=", "is":
+		return identical(e.left.eval(env), e.right.eval(env))
+	case "=!=", "isnt":
+		return !identical(e.left.eval(env), e.right.eval(env))
+	}
+
+	left := e.left.eval(env)
+	right := e.right.eval(env)
+	if isError(left) {
+		return left
+	}
+	if isError(right) {
+		return right
+	}
+	if isUndefined(left) || isUndefined(right) {
+		return undefinedValue{}
+	}
+	switch e.op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compare(e.op, left, right)
+	case "+", "-", "*", "/", "%":
+		return arithmetic(e.op, left, right)
+	}
+
+	// The bitwise operators
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if !lok || !rok {
+		if lb, ok := left.(bool); ok {
+			if rb, ok := right.(bool); ok {
+				switch e.op {
+				case "&":
+					return lb && rb
+				case "|":
+					return lb || rb
+				case "^":
+					return lb != rb
+				}
+			}
+		}
+		return errorValue{"invalid operands for " + e.op}
+	}
+	switch e.op {
+	case "&":
+		return l & r
+	case "|":
+		return l | r
+	case "^":
+		return l ^ r
+	case "<<":
+		return l << uint64(r&63)
+	case ">>":
+		return l >> uint64(r&63)
+	case ">>>":
+		return int64(uint64(l) >> uint64(r&63))
+	}
+	return errorValue{"unknown operator " + e.op}
+}
+
+// The logical operators only evaluate their right side when they must, and
+// treat undefined as unknown: false && undefined is false, true || undefined is true
+func (e *binaryExpr) evalLogical(env *evalEnv) interface{} {
+	left := e.left.eval(env)
+	if isError(left) {
+		return left
+	}
+	var leftTruth, leftKnown bool
+	if !isUndefined(left) {
+		var ok bool
+		if leftTruth, ok = toBool(left); !ok {
+			return errorValue{"non-boolean operand for " + e.op}
+		}
+		leftKnown = true
+		if e.op == "&&" && !leftTruth {
+			return false
+		}
+		if e.op == "||" && leftTruth {
+			return true
+		}
+	}
+	right := e.right.eval(env)
+	if isError(right) {
+		return right
+	}
+	if isUndefined(right) {
+		return undefinedValue{}
+	}
+	rightTruth, ok := toBool(right)
+	if !ok {
+		return errorValue{"non-boolean operand for " + e.op}
+	}
+	if !leftKnown {
+		if (e.op == "&&" && !rightTruth) || (e.op == "||" && rightTruth) {
+			return rightTruth
+		}
+		return undefinedValue{}
+	}
+	return rightTruth
+}
+
+// The meta-equality of =?=: same type and value, with strings compared
+// case-sensitively, and never undefined
+func identical(left, right interface{}) bool {
+	switch l := left.(type) {
+	case undefinedValue:
+		return isUndefined(right)
+	case errorValue:
+		return isError(right)
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for idx := range l {
+			if !identical(l[idx], r[idx]) {
+				return false
+			}
+		}
+		return true
+	case *scope:
+		r, ok := right.(*scope)
+		return ok && l.ad == r.ad
+	}
+	return left == right
+}
+
+func compare(op string, left, right interface{}) interface{} {
+	var cmp int
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return errorValue{"cannot compare a string with a non-string"}
+		}
+		// Comparisons of strings ignore case; use =?= to tell case apart
+		cmp = strings.Compare(strings.ToLower(l), strings.ToLower(r))
+	default:
+		if l, ok := left.(int64); ok {
+			if r, ok := right.(int64); ok {
+				switch {
+				case l < r:
+					cmp = -1
+				case l > r:
+					cmp = 1
+				}
+				break
+			}
+		}
+		lf, lok := toFloat(left)
+		rf, rok := toFloat(right)
+		if !lok || !rok {
+			return errorValue{"cannot compare these values with " + op}
+		}
+		_, lint := left.(int64)
+		_, rint := right.(int64)
+		if !lint || !rint {
+			switch {
+			case lf < rf:
+				cmp = -1
+			case lf > rf:
+				cmp = 1
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func arithmetic(op string, left, right interface{}) interface{} {
+	l, lint := left.(int64)
+	r, rint := right.(int64)
+	if lint && rint {
+		switch op {
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			if r == 0 {
+				return errorValue{"division by zero"}
+			}
+			return l / r
+		case "%":
+			if r == 0 {
+				return errorValue{"division by zero"}
+			}
+			return l % r
+		}
+	}
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return errorValue{"invalid operands for " + op}
+	}
+	switch op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	case "/":
+		if rf == 0 {
+			return errorValue{"division by zero"}
+		}
+		return lf / rf
+	default:
+		if rf == 0 {
+			return errorValue{"division by zero"}
+		}
+		return math.Mod(lf, rf)
+	}
+}
+
+// A builtin function, given its unevaluated arguments
+type builtin func(env *evalEnv, args []Expr) interface{}
+
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"ifthenelse":  builtinIfThenElse,
+		"isundefined": typeCheck(isUndefined),
+		"iserror":     typeCheck(isError),
+		"isstring":    typeCheck(func(v interface{}) bool { _, ok := v.(string); return ok }),
+		"isinteger":   typeCheck(func(v interface{}) bool { _, ok := v.(int64); return ok }),
+		"isreal":      typeCheck(func(v interface{}) bool { _, ok := v.(float64); return ok }),
+		"isboolean":   typeCheck(func(v interface{}) bool { _, ok := v.(bool); return ok }),
+		"islist":      typeCheck(func(v interface{}) bool { _, ok := v.([]interface{}); return ok }),
+		"isclassad":   typeCheck(func(v interface{}) bool { _, ok := v.(*scope); return ok }),
+		"strcat":      strict(builtinStrcat),
+		"substr":      strict(builtinSubstr),
+		"size":        strict(builtinSize),
+		"tolower":     strict(stringFunc(strings.ToLower)),
+		"toupper":     strict(stringFunc(strings.ToUpper)),
+		"strcmp":      strict(stringCompare(false)),
+		"stricmp":     strict(stringCompare(true)),
+		"member":      strict(builtinMember),
+		"int":         strict(builtinInt),
+		"real":        strict(builtinReal),
+		"string":      strict(builtinString),
+		"floor":       strict(roundFunc(math.Floor)),
+		"ceiling":     strict(roundFunc(math.Ceil)),
+		"round":       strict(roundFunc(math.Round)),
+		"time":        strict(func(args []interface{}) interface{} { return time.Now().Unix() }),
+	}
+}
+
+func (e *callExpr) eval(env *evalEnv) interface{} {
+	fn, ok := builtins[strings.ToLower(e.name)]
+	if !ok {
+		return errorValue{"unknown function " + e.name}
+	}
+	return fn(env, e.args)
+}
+
+func builtinIfThenElse(env *evalEnv, args []Expr) interface{} {
+	if len(args) != 3 {
+		return errorValue{"ifThenElse takes three arguments"}
+	}
+	return (&condExpr{cond: args[0], then: args[1], otherwise: args[2]}).eval(env)
+}
+
+func typeCheck(check func(interface{}) bool) builtin {
+	return func(env *evalEnv, args []Expr) interface{} {
+		if len(args) != 1 {
+			return errorValue{"type checks take one argument"}
+		}
+		return check(args[0].eval(env))
+	}
+}
+
+// A function of evaluated arguments, which is undefined or error if any of
+// its arguments are
+func strict(fn func(args []interface{}) interface{}) builtin {
+	return func(env *evalEnv, args []Expr) interface{} {
+		values := make([]interface{}, len(args))
+		for idx, arg := range args {
+			values[idx] = arg.eval(env)
+			if isError(values[idx]) || isUndefined(values[idx]) {
+				return values[idx]
+			}
+		}
+		return fn(values)
+	}
+}
+
+// Convert a value to a string for the string functions
+func toString(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case int64, float64, bool:
+		return valueString(value), true
+	}
+	return "", false
+}
+
+func builtinStrcat(args []interface{}) interface{} {
+	var builder strings.Builder
+	for _, arg := range args {
+		s, ok := toString(arg)
+		if !ok {
+			return errorValue{"strcat of a non-scalar"}
+		}
+		builder.WriteString(s)
+	}
+	return builder.String()
+}
+
+func builtinSubstr(args []interface{}) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return errorValue{"substr takes two or three arguments"}
+	}
+	s, ok := args[0].(string)
+	offset, ok2 := args[1].(int64)
+	if !ok || !ok2 {
+		return errorValue{"invalid arguments to substr"}
+	}
+	length := int64(len(s))
+	// Negative offsets and lengths count from the end of the string
+	if offset < 0 {
+		offset += length
+	}
+	offset = max64(0, min64(offset, length))
+	end := length
+	if len(args) == 3 {
+		count, ok := args[2].(int64)
+		if !ok {
+			return errorValue{"invalid length for substr"}
+		}
+		if count < 0 {
+			end = length + count
+		} else {
+			end = offset + count
+		}
+	}
+	end = max64(offset, min64(end, length))
+	return s[offset:end]
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func builtinSize(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errorValue{"size takes one argument"}
+	}
+	switch v := args[0].(type) {
+	case string:
+		return int64(len(v))
+	case []interface{}:
+		return int64(len(v))
+	case *scope:
+		return int64(len(v.ad.names))
+	}
+	return errorValue{"size of a value that has none"}
+}
+
+func stringFunc(fn func(string) string) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			return errorValue{"string functions take one argument"}
+		}
+		s, ok := toString(args[0])
+		if !ok {
+			return errorValue{"not a string"}
+		}
+		return fn(s)
+	}
+}
+
+func stringCompare(ignoreCase bool) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			return errorValue{"string comparisons take two arguments"}
+		}
+		a, ok := toString(args[0])
+		b, ok2 := toString(args[1])
+		if !ok || !ok2 {
+			return errorValue{"not a string"}
+		}
+		if ignoreCase {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		return int64(strings.Compare(a, b))
+	}
+}
+
+func builtinMember(args []interface{}) interface{} {
+	if len(args) != 2 {
+		return errorValue{"member takes two arguments"}
+	}
+	list, ok := args[1].([]interface{})
+	if !ok {
+		return errorValue{"the second argument to member must be a list"}
+	}
+	for _, element := range list {
+		if result, ok := compare("==", args[0], element).(bool); ok && result {
+			return true
+		}
+	}
+	return false
+}
+
+func builtinInt(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errorValue{"int takes one argument"}
+	}
+	switch v := args[0].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return int64(f)
+		}
+	}
+	return errorValue{"cannot convert to an integer"}
+}
+
+func builtinReal(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errorValue{"real takes one argument"}
+	}
+	if s, ok := args[0].(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+		return errorValue{"cannot convert to a real"}
+	}
+	if f, ok := toFloat(args[0]); ok {
+		return f
+	}
+	return errorValue{"cannot convert to a real"}
+}
+
+func builtinString(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return errorValue{"string takes one argument"}
+	}
+	if s, ok := toString(args[0]); ok {
+		return s
+	}
+	return valueString(args[0])
+}
+
+func roundFunc(fn func(float64) float64) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			return errorValue{"rounding functions take one argument"}
+		}
+		if v, ok := args[0].(int64); ok {
+			return v
+		}
+		f, ok := toFloat(args[0])
+		if !ok {
+			return errorValue{"cannot round a non-number"}
+		}
+		return int64(fn(f))
+	}
+}
@@ -0,0 +1,578 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, University of Nebraska-Lincoln
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package classads
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed ClassAd expression, which may be as simple as a literal
+type Expr interface {
+	// String gives the expression in ClassAd syntax
+	String() string
+	// How tightly the expression binds, for deciding where parentheses are needed
+	precedence() int
+	eval(env *evalEnv) interface{}
+}
+
+type (
+	literalExpr struct {
+		value interface{}
+	}
+
+	attrRefExpr struct {
+		name string
+	}
+
+	// expr.name
+	selectExpr struct {
+		expr Expr
+		name string
+	}
+
+	// expr[index]
+	subscriptExpr struct {
+		expr  Expr
+		index Expr
+	}
+
+	unaryExpr struct {
+		op      string
+		operand Expr
+	}
+
+	binaryExpr struct {
+		op          string
+		left, right Expr
+	}
+
+	// cond ? then : otherwise
+	condExpr struct {
+		cond, then, otherwise Expr
+	}
+
+	callExpr struct {
+		name string
+		args []Expr
+	}
+
+	listExpr struct {
+		elements []Expr
+	}
+
+	adExpr struct {
+		ad *ClassAd
+	}
+)
+
+const (
+	precCond = iota + 1
+	precOr
+	precAnd
+	precBitOr
+	precBitXor
+	precBitAnd
+	precEquality
+	precRelational
+	precShift
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPostfix
+	precPrimary
+)
+
+// The binary operators and their precedence
+var binaryPrecedence = map[string]int{
+	"||": precOr,
+	"&&": precAnd,
+	"|":  precBitOr,
+	"^":  precBitXor,
+	"&":  precBitAnd,
+	"==": precEquality, "!=": precEquality, "=?=": precEquality, "=!=": precEquality, "is": precEquality, "isnt": precEquality,
+	"<": precRelational, "<=": precRelational, ">": precRelational, ">=": precRelational,
+	"<<": precShift, ">>": precShift, ">>>": precShift,
+	"+": precAdditive, "-": precAdditive,
+	"*": precMultiplicative, "/": precMultiplicative, "%": precMultiplicative,
+}
+
+func (e *literalExpr) precedence() int   { return precPrimary }
+func (e *attrRefExpr) precedence() int   { return precPrimary }
+func (e *selectExpr) precedence() int    { return precPostfix }
+func (e *subscriptExpr) precedence() int { return precPostfix }
+func (e *unaryExpr) precedence() int     { return precUnary }
+func (e *binaryExpr) precedence() int    { return binaryPrecedence[e.op] }
+func (e *condExpr) precedence() int      { return precCond }
+func (e *callExpr) precedence() int      { return precPrimary }
+func (e *listExpr) precedence() int      { return precPrimary }
+func (e *adExpr) precedence() int        { return precPrimary }
+
+// Give an operand, in parentheses if it binds less tightly than its operator
+func operandString(operand Expr, minPrecedence int) string {
+	if operand.precedence() < minPrecedence {
+		return "(" + operand.String() + ")"
+	}
+	return operand.String()
+}
+
+func formatReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "real(\"INF\")"
+	case math.IsInf(f, -1):
+		return "real(\"-INF\")"
+	case math.IsNaN(f):
+		return "real(\"NaN\")"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	// Make sure it reads back as a real rather than an integer
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case undefinedValue:
+		return "undefined"
+	case errorValue:
+		return "error"
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatReal(v)
+	case string:
+		return quoteString(v, '"')
+	case []interface{}:
+		elements := make([]string, len(v))
+		for idx, element := range v {
+			elements[idx] = valueString(element)
+		}
+		return listString(elements)
+	case *scope:
+		return v.ad.String()
+	}
+	return "error"
+}
+
+func listString(elements []string) string {
+	if len(elements) == 0 {
+		return "{}"
+	}
+	return "{ " + strings.Join(elements, ", ") + " }"
+}
+
+// Attribute names that aren't plain identifiers have to be quoted
+func attrNameString(name string) string {
+	if name == "" || !isIdentStart(name[0]) || isKeyword(name) {
+		return quoteString(name, '\'')
+	}
+	for idx := 1; idx < len(name); idx++ {
+		if !isIdentStart(name[idx]) && !isDigit(name[idx]) {
+			return quoteString(name, '\'')
+		}
+	}
+	return name
+}
+
+func isKeyword(name string) bool {
+	switch strings.ToLower(name) {
+	case "true", "false", "undefined", "error", "is", "isnt":
+		return true
+	}
+	return false
+}
+
+func (e *literalExpr) String() string {
+	return valueString(e.value)
+}
+
+func (e *attrRefExpr) String() string {
+	return attrNameString(e.name)
+}
+
+func (e *selectExpr) String() string {
+	return operandString(e.expr, precPostfix) + "." + attrNameString(e.name)
+}
+
+func (e *subscriptExpr) String() string {
+	return operandString(e.expr, precPostfix) + "[" + e.index.String() + "]"
+}
+
+func (e *unaryExpr) String() string {
+	return e.op + operandString(e.operand, precUnary)
+}
+
+func (e *binaryExpr) String() string {
+	prec := e.precedence()
+	op := e.op
+	if op == "is" || op == "isnt" {
+		op = "=?="
+		if e.op == "isnt" {
+			op = "=!="
+		}
+	}
+	// Operators are left-associative, so the right operand needs parentheses
+	// even when it binds just as tightly
+	return operandString(e.left, prec) + " " + op + " " + operandString(e.right, prec+1)
+}
+
+func (e *condExpr) String() string {
+	return operandString(e.cond, precOr) + " ? " + e.then.String() + " : " + operandString(e.otherwise, precCond)
+}
+
+func (e *callExpr) String() string {
+	args := make([]string, len(e.args))
+	for idx, arg := range e.args {
+		args[idx] = arg.String()
+	}
+	return e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *listExpr) String() string {
+	elements := make([]string, len(e.elements))
+	for idx, element := range e.elements {
+		elements[idx] = element.String()
+	}
+	return listString(elements)
+}
+
+func (e *adExpr) String() string {
+	return e.ad.String()
+}
+
+// ParseExpr parses a single ClassAd expression
+func ParseExpr(input string) (Expr, error) {
+	p := &parser{lexer: newLexer(input)}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+type parser struct {
+	lexer *lexer
+}
+
+func (p *parser) expectEOF() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	if tok.kind != tokenEOF {
+		return p.lexer.errorf(tok.pos, "unexpected %q", tok.text)
+	}
+	return nil
+}
+
+// Consume the next token if it is the given operator
+func (p *parser) accept(op string) (bool, error) {
+	tok, err := p.lexer.peek()
+	if err != nil {
+		return false, err
+	}
+	if tok.kind == tokenOperator && tok.text == op {
+		_, err = p.lexer.next()
+		return true, err
+	}
+	return false, nil
+}
+
+func (p *parser) expect(op string) error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	if tok.kind != tokenOperator || tok.text != op {
+		if tok.kind == tokenEOF {
+			return p.lexer.errorf(tok.pos, "expected %q but the input ended", op)
+		}
+		return p.lexer.errorf(tok.pos, "expected %q but found %q", op, tok.text)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	cond, err := p.parseBinary(precOr)
+	if err != nil {
+		return nil, err
+	}
+	if found, err := p.accept("?"); err != nil || !found {
+		return cond, err
+	}
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// The binary operator at the next token, if there is one
+func (p *parser) peekBinaryOp() (string, int, error) {
+	tok, err := p.lexer.peek()
+	if err != nil {
+		return "", 0, err
+	}
+	op := tok.text
+	switch tok.kind {
+	case tokenOperator:
+	case tokenIdent:
+		op = strings.ToLower(op)
+		if op != "is" && op != "isnt" {
+			return "", 0, nil
+		}
+	default:
+		return "", 0, nil
+	}
+	prec, ok := binaryPrecedence[op]
+	if !ok {
+		return "", 0, nil
+	}
+	return op, prec, nil
+}
+
+// Parse operators binding at least as tightly as minPrecedence
+func (p *parser) parseBinary(minPrecedence int) (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, prec, err := p.peekBinaryOp()
+		if err != nil {
+			return nil, err
+		}
+		if op == "" || prec < minPrecedence {
+			return left, nil
+		}
+		if _, err = p.lexer.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok, err := p.lexer.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokenOperator && strings.Contains("-+!~", tok.text) && len(tok.text) == 1 {
+		if _, err = p.lexer.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: tok.text, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (Expr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if found, err := p.accept("."); err != nil {
+			return nil, err
+		} else if found {
+			tok, err := p.lexer.next()
+			if err != nil {
+				return nil, err
+			}
+			if tok.kind != tokenIdent {
+				return nil, p.lexer.errorf(tok.pos, "expected an attribute name after '.'")
+			}
+			expr = &selectExpr{expr: expr, name: tok.text}
+			continue
+		}
+		if found, err := p.accept("["); err != nil {
+			return nil, err
+		} else if found {
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			expr = &subscriptExpr{expr: expr, index: index}
+			continue
+		}
+		return expr, nil
+	}
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tokenInt:
+		value, err := strconv.ParseInt(tok.text, 0, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, p.lexer.errorf(tok.pos, "integer %s is out of range", tok.text)
+		} else if err != nil {
+			return nil, p.lexer.errorf(tok.pos, "invalid integer %s", tok.text)
+		}
+		return &literalExpr{value}, nil
+	case tokenReal:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(tok.pos, "invalid real %s", tok.text)
+		}
+		return &literalExpr{value}, nil
+	case tokenString:
+		return &literalExpr{tok.text}, nil
+	case tokenIdent:
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return &literalExpr{true}, nil
+		case "false":
+			return &literalExpr{false}, nil
+		case "undefined":
+			return &literalExpr{undefinedValue{}}, nil
+		case "error":
+			return &literalExpr{errorValue{"error literal"}}, nil
+		}
+		if found, err := p.accept("("); err != nil {
+			return nil, err
+		} else if found {
+			args, err := p.parseExprList(")")
+			if err != nil {
+				return nil, err
+			}
+			return &callExpr{name: tok.text, args: args}, nil
+		}
+		return &attrRefExpr{name: tok.text}, nil
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		case "{":
+			elements, err := p.parseExprList("}")
+			if err != nil {
+				return nil, err
+			}
+			return &listExpr{elements: elements}, nil
+		case "[":
+			ad, err := p.parseAdBody("]")
+			if err != nil {
+				return nil, err
+			}
+			return &adExpr{ad: ad}, nil
+		}
+	case tokenEOF:
+		return nil, p.lexer.errorf(tok.pos, "expected an expression but the input ended")
+	}
+	return nil, p.lexer.errorf(tok.pos, "unexpected %q", tok.text)
+}
+
+// Parse comma-separated expressions up to the closing operator
+func (p *parser) parseExprList(closing string) ([]Expr, error) {
+	exprs := []Expr{}
+	if found, err := p.accept(closing); err != nil || found {
+		return exprs, err
+	}
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if found, err := p.accept(","); err != nil {
+			return nil, err
+		} else if !found {
+			return exprs, p.expect(closing)
+		}
+	}
+}
+
+// Parse the attributes of an ad, separated by semicolons, up to the closing
+// operator (or the end of the input, if closing is empty)
+func (p *parser) parseAdBody(closing string) (*ClassAd, error) {
+	ad := NewClassAd()
+	for {
+		tok, err := p.lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case closing == "" && tok.kind == tokenEOF:
+			return ad, nil
+		case tok.kind == tokenOperator && tok.text == closing:
+			return ad, nil
+		case tok.kind == tokenOperator && tok.text == ";":
+			// Allow empty attributes, such as after a trailing semicolon
+			continue
+		case tok.kind == tokenEOF:
+			return nil, p.lexer.errorf(tok.pos, "expected %q but the input ended", closing)
+		case tok.kind != tokenIdent:
+			return nil, p.lexer.errorf(tok.pos, "expected an attribute name but found %q", tok.text)
+		}
+		if err = p.expect("="); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		ad.SetExpr(tok.text, expr)
+
+		next, err := p.lexer.peek()
+		if err != nil {
+			return nil, err
+		}
+		if next.kind == tokenOperator && next.text == ";" {
+			continue
+		}
+		if !(next.kind == tokenOperator && next.text == closing) && !(closing == "" && next.kind == tokenEOF) {
+			return nil, p.lexer.errorf(next.pos, "expected ';' after the value of %s", tok.text)
+		}
+	}
+}
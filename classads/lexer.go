@@ -0,0 +1,279 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, University of Nebraska-Lincoln
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package classads
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenReal
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	// The text of the token; for strings and quoted attribute names, with
+	// the quotes removed and the escapes resolved
+	text string
+	// Where the token starts in the input, for error messages
+	pos int
+}
+
+// The operators, longest first so that the longest match wins
+var operators = []string{
+	">>>", "=?=", "=!=",
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "~", "&", "|", "^", "?", ":", ";", ",", ".", "=", "(", ")", "[", "]", "{", "}",
+}
+
+type lexer struct {
+	input string
+	pos   int
+	// The token after the current position, once it has been peeked at
+	peeked *token
+	// Whether strings are in the old syntax, where a backslash only escapes
+	// a quote and is otherwise taken literally
+	oldSyntax bool
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input}
+}
+
+// A lexer for a line of an ad in the old syntax
+func newOldSyntaxLexer(input string) *lexer {
+	return &lexer{input: input, oldSyntax: true}
+}
+
+func (l *lexer) errorf(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("classad syntax error at offset %d: %s", pos, fmt.Sprintf(format, args...))
+}
+
+// Skip over whitespace and comments
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.input) {
+		switch {
+		case strings.ContainsRune(" \t\r\n\f\v", rune(l.input[l.pos])):
+			l.pos++
+		case strings.HasPrefix(l.input[l.pos:], "//"):
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.input)
+			} else {
+				l.pos += end + 1
+			}
+		case strings.HasPrefix(l.input[l.pos:], "/*"):
+			end := strings.Index(l.input[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf(l.pos, "unterminated comment")
+			}
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) peek() (token, error) {
+	if l.peeked != nil {
+		return *l.peeked, nil
+	}
+	tok, err := l.scan()
+	if err != nil {
+		return tok, err
+	}
+	l.peeked = &tok
+	return tok, nil
+}
+
+func (l *lexer) next() (token, error) {
+	if l.peeked != nil {
+		tok := *l.peeked
+		l.peeked = nil
+		return tok, nil
+	}
+	return l.scan()
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) scan() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+	c := l.input[l.pos]
+	switch {
+	case isIdentStart(c):
+		for l.pos < len(l.input) && (isIdentStart(l.input[l.pos]) || isDigit(l.input[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenIdent, text: l.input[start:l.pos], pos: start}, nil
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		return l.scanNumber()
+	case c == '"':
+		text, err := l.scanQuoted('"')
+		return token{kind: tokenString, text: text, pos: start}, err
+	case c == '\'':
+		// A quoted attribute name
+		text, err := l.scanQuoted('\'')
+		return token{kind: tokenIdent, text: text, pos: start}, err
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokenOperator, text: op, pos: start}, nil
+		}
+	}
+	return token{}, l.errorf(start, "unexpected character %q", c)
+}
+
+func (l *lexer) scanNumber() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.input[l.pos:], "0x") || strings.HasPrefix(l.input[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(l.input) && strings.ContainsRune("0123456789abcdefABCDEF", rune(l.input[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenInt, text: l.input[start:l.pos], pos: start}, nil
+	}
+	kind := tokenInt
+	for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos < len(l.input) && l.input[l.pos] == '.' {
+		kind = tokenReal
+		l.pos++
+		for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		exponent := l.pos + 1
+		if exponent < len(l.input) && (l.input[exponent] == '+' || l.input[exponent] == '-') {
+			exponent++
+		}
+		if exponent < len(l.input) && isDigit(l.input[exponent]) {
+			kind = tokenReal
+			l.pos = exponent
+			for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	return token{kind: kind, text: l.input[start:l.pos], pos: start}, nil
+}
+
+// Scan a string or quoted attribute name, resolving its escapes
+func (l *lexer) scanQuoted(quote byte) (string, error) {
+	start := l.pos
+	l.pos++
+	var builder strings.Builder
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return builder.String(), nil
+		case c == '\\' && l.oldSyntax:
+			// Windows paths and the like come through untouched
+			if l.pos+1 < len(l.input) && l.input[l.pos+1] == quote {
+				l.pos++
+			}
+			builder.WriteByte(l.input[l.pos])
+			l.pos++
+		case c == '\\' && l.pos+1 < len(l.input):
+			l.pos++
+			escaped := l.input[l.pos]
+			switch escaped {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case 'r':
+				builder.WriteByte('\r')
+			case 'b':
+				builder.WriteByte('\b')
+			case 'f':
+				builder.WriteByte('\f')
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				end := l.pos + 1
+				for end < len(l.input) && end < l.pos+3 && l.input[end] >= '0' && l.input[end] <= '7' {
+					end++
+				}
+				value, err := strconv.ParseUint(l.input[l.pos:end], 8, 8)
+				if err != nil {
+					return "", l.errorf(l.pos-1, "octal escape \\%s is out of range", l.input[l.pos:end])
+				}
+				builder.WriteByte(byte(value))
+				l.pos = end - 1
+			default:
+				// Including quotes and backslashes
+				builder.WriteByte(escaped)
+			}
+			l.pos++
+		default:
+			builder.WriteByte(c)
+			l.pos++
+		}
+	}
+	return "", l.errorf(start, "unterminated string")
+}
+
+// Quote a string for a ClassAd, escaping it so it reads back the same
+func quoteString(s string, quote byte) string {
+	var builder strings.Builder
+	builder.WriteByte(quote)
+	for idx := 0; idx < len(s); idx++ {
+		switch c := s[idx]; c {
+		case quote, '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case '\n':
+			builder.WriteString("\\n")
+		case '\t':
+			builder.WriteString("\\t")
+		case '\r':
+			builder.WriteString("\\r")
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	builder.WriteByte(quote)
+	return builder.String()
+}
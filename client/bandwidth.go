@@ -0,0 +1,141 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// The smallest burst a token bucket allows, so a single read of a transfer's
+// buffer doesn't have to be split into many waits
+const minBandwidthBurst = 32 * 1024
+
+type (
+	// Throttles a transfer to the rates of several token buckets at once:
+	// typically the one shared by every transfer in the process, and one for
+	// the transfer itself
+	bandwidthLimiter struct {
+		buckets []*rate.Limiter
+	}
+
+	// Reads from an underlying reader no faster than the limiter allows
+	throttledReader struct {
+		ctx     context.Context
+		reader  io.Reader
+		limiter *bandwidthLimiter
+	}
+)
+
+var (
+	// The token bucket for Client.MaxBandwidth, shared by all the transfers in the process
+	sharedBandwidth      *rate.Limiter
+	sharedBandwidthRate  int64
+	sharedBandwidthMutex sync.Mutex
+)
+
+// A token bucket refilling at bytesPerSecond, or nil if that's unlimited
+func newTokenBucket(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	// Bursts of a tenth of a second's worth keep the rate smooth
+	burst := bytesPerSecond / 10
+	if burst < minBandwidthBurst {
+		burst = minBandwidthBurst
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// The process-wide token bucket for bytesPerSecond, or nil if that's unlimited.
+// Changing the rate adjusts the existing bucket for transfers in progress.
+func sharedBandwidthLimit(bytesPerSecond int64) *rate.Limiter {
+	sharedBandwidthMutex.Lock()
+	defer sharedBandwidthMutex.Unlock()
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if sharedBandwidth == nil {
+		sharedBandwidth = newTokenBucket(bytesPerSecond)
+	} else if sharedBandwidthRate != bytesPerSecond {
+		sharedBandwidth.SetLimit(rate.Limit(bytesPerSecond))
+		sharedBandwidth.SetBurst(newTokenBucket(bytesPerSecond).Burst())
+	}
+	sharedBandwidthRate = bytesPerSecond
+	return sharedBandwidth
+}
+
+// A limiter for a single transfer of the client: the engine's bucket, plus
+// one of the transfer's own if the client has a per-transfer limit. Returns
+// nil if the transfer is unlimited.
+func (tc *TransferClient) newBandwidthLimiter() *bandwidthLimiter {
+	limiter := &bandwidthLimiter{}
+	if tc.engine.bandwidth != nil {
+		limiter.buckets = append(limiter.buckets, tc.engine.bandwidth)
+	}
+	if bucket := newTokenBucket(tc.options.LimitRate); bucket != nil {
+		limiter.buckets = append(limiter.buckets, bucket)
+	}
+	if len(limiter.buckets) == 0 {
+		return nil
+	}
+	return limiter
+}
+
+// WaitN blocks until n bytes may be transferred, satisfying grab's RateLimiter
+func (bl *bandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if bl == nil {
+		return nil
+	}
+	for _, bucket := range bl.buckets {
+		// A bucket can't hand out more than its burst at once
+		for remaining := n; remaining > 0; {
+			chunk := remaining
+			if burst := bucket.Burst(); chunk > burst {
+				chunk = burst
+			}
+			if err := bucket.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+			remaining -= chunk
+		}
+	}
+	return nil
+}
+
+// Wrap reader so it's read no faster than the limiter allows
+func (bl *bandwidthLimiter) reader(ctx context.Context, reader io.Reader) io.Reader {
+	if bl == nil {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, reader: reader, limiter: bl}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.reader.Read(p)
+	if n > 0 {
+		if waitErr := tr.limiter.WaitN(tr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
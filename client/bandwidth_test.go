@@ -0,0 +1,92 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteRate(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"0":      0,
+		"500":    500,
+		"500k":   500000,
+		"1.5M":   1500000,
+		"10MB":   10000000,
+		"10MB/s": 10000000,
+		"1Ki":    1024,
+		"2MiB":   2 * 1024 * 1024,
+		"1GiB/s": 1024 * 1024 * 1024,
+		" 3 G ":  3000000000,
+		"1T":     1000000000000,
+	} {
+		rate, err := ParseByteRate(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, rate, value)
+	}
+	for _, value := range []string{"", "fast", "-5k", "10X", "10kk"} {
+		_, err := ParseByteRate(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	// An unlimited client doesn't throttle at all
+	tc := &TransferClient{engine: &TransferEngine{}}
+	limiter := tc.newBandwidthLimiter()
+	assert.Nil(t, limiter)
+	reader := bytes.NewReader(nil)
+	assert.Equal(t, io.Reader(reader), limiter.reader(context.Background(), reader))
+	assert.NoError(t, limiter.WaitN(context.Background(), 1<<30))
+
+	// The bucket starts full, so only the data beyond the first burst waits:
+	// (100000 - 32768) bytes at 200kB/s is over a third of a second
+	tc.options.LimitRate = 200000
+	limiter = tc.newBandwidthLimiter()
+	require.NotNil(t, limiter)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.reader(context.Background(), bytes.NewReader(make([]byte, 100000))))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100000), n)
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+
+	// Waiting stops when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, limiter.WaitN(ctx, 1<<20))
+}
+
+func TestSharedBandwidthLimit(t *testing.T) {
+	assert.Nil(t, sharedBandwidthLimit(0))
+	bucket := sharedBandwidthLimit(1000000)
+	require.NotNil(t, bucket)
+	assert.Equal(t, 100000, bucket.Burst())
+
+	// Every engine shares the one bucket, adjusted to the latest rate
+	assert.Same(t, bucket, sharedBandwidthLimit(200000))
+	assert.Equal(t, float64(200000), float64(bucket.Limit()))
+	assert.Equal(t, minBandwidthBurst, bucket.Burst())
+}
@@ -0,0 +1,299 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// A digest algorithm, named as in the HTTP digest algorithm registry
+	ChecksumType string
+
+	// A digest of an object's contents
+	ChecksumInfo struct {
+		Algorithm ChecksumType
+		Value     []byte
+	}
+
+	ChecksumMismatchError struct {
+		Expected ChecksumInfo
+		Computed []byte
+	}
+
+	// The server didn't provide a digest of the object, but one was required
+	MissingChecksumError struct {
+		Requested []ChecksumType
+	}
+)
+
+const (
+	ChecksumCRC32C ChecksumType = "crc32c"
+	ChecksumMD5    ChecksumType = "md5"
+	ChecksumSHA256 ChecksumType = "sha-256"
+)
+
+var (
+	// Digests requested from the server when the user didn't ask for any in
+	// particular, in order of preference. These are verified if the server
+	// provides them, but aren't required.
+	defaultChecksums = []ChecksumType{ChecksumCRC32C, ChecksumMD5, ChecksumSHA256}
+)
+
+func (ci ChecksumInfo) String() string {
+	return string(ci.Algorithm) + ":" + hex.EncodeToString(ci.Value)
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: server reported %s %x but the transferred data has %x",
+		e.Expected.Algorithm, e.Expected.Value, e.Computed)
+}
+
+func (e *MissingChecksumError) Error() string {
+	names := make([]string, len(e.Requested))
+	for idx, checksumType := range e.Requested {
+		names[idx] = string(checksumType)
+	}
+	return "server did not provide any of the required checksums (" + strings.Join(names, ", ") + ")"
+}
+
+// Parse a comma-separated list of checksum algorithms, e.g. "crc32c,sha-256"
+func ParseChecksumTypes(value string) ([]ChecksumType, error) {
+	var checksumTypes []ChecksumType
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		// Be forgiving of the common spelling of SHA-256
+		if name == "sha256" {
+			name = string(ChecksumSHA256)
+		}
+		checksumType := ChecksumType(name)
+		if checksumType.digestSize() == 0 {
+			return nil, errors.Errorf("unknown checksum type %q; must be one of %s, %s or %s",
+				name, ChecksumCRC32C, ChecksumMD5, ChecksumSHA256)
+		}
+		checksumTypes = append(checksumTypes, checksumType)
+	}
+	if len(checksumTypes) == 0 {
+		return nil, errors.New("no checksum types provided")
+	}
+	return checksumTypes, nil
+}
+
+func (ct ChecksumType) digestSize() int {
+	switch ct {
+	case ChecksumCRC32C:
+		return crc32.Size
+	case ChecksumMD5:
+		return md5.Size
+	case ChecksumSHA256:
+		return sha256.Size
+	}
+	return 0
+}
+
+func (ct ChecksumType) newHash() hash.Hash {
+	switch ct {
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumMD5:
+		return md5.New()
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// The checksums to request for a transfer, and whether the transfer must
+// fail if the server provides none of them
+func requestedChecksums(ctx context.Context) ([]ChecksumType, bool) {
+	if checksums := transferClientFromContext(ctx).options.Checksums; len(checksums) > 0 {
+		return checksums, true
+	}
+	return defaultChecksums, false
+}
+
+// Ask the server for digests of the object, in both the RFC 3230 (Want-Digest)
+// and RFC 9530 (Want-Repr-Digest) forms
+func setWantDigest(header http.Header, checksumTypes []ChecksumType) {
+	wantDigest := make([]string, len(checksumTypes))
+	wantReprDigest := make([]string, len(checksumTypes))
+	for idx, checksumType := range checksumTypes {
+		// Earlier entries are preferred
+		preference := len(checksumTypes) - idx
+		wantDigest[idx] = fmt.Sprintf("%s;q=%.1f", checksumType, float64(preference)/float64(len(checksumTypes)))
+		wantReprDigest[idx] = fmt.Sprintf("%s=%d", checksumType, preference)
+	}
+	header.Set("Want-Digest", strings.Join(wantDigest, ", "))
+	header.Set("Want-Repr-Digest", strings.Join(wantReprDigest, ", "))
+}
+
+// Decode a digest value; XRootD gives crc32c in hex and the RFCs use base64
+func decodeDigest(checksumType ChecksumType, value string) ([]byte, bool) {
+	if len(value) == 2*checksumType.digestSize() {
+		if decoded, err := hex.DecodeString(value); err == nil {
+			return decoded, true
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != checksumType.digestSize() {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// Collect the digests the server provided in the Repr-Digest and Digest headers
+func parseDigests(header http.Header) map[ChecksumType][]byte {
+	digests := make(map[ChecksumType][]byte)
+	for _, headerName := range []string{"Repr-Digest", "Digest"} {
+		for _, headerValue := range header.Values(headerName) {
+			for _, entry := range strings.Split(headerValue, ",") {
+				name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+				if !found {
+					continue
+				}
+				checksumType := ChecksumType(strings.ToLower(name))
+				if checksumType.digestSize() == 0 {
+					continue
+				}
+				if _, ok := digests[checksumType]; ok {
+					continue
+				}
+				// Repr-Digest values are structured field byte sequences, :<base64>:
+				value = strings.Trim(value, ":")
+				if decoded, ok := decodeDigest(checksumType, value); ok {
+					digests[checksumType] = decoded
+				} else {
+					log.Debugf("Ignoring malformed %s digest %q from %s header", checksumType, value, headerName)
+				}
+			}
+		}
+	}
+	return digests
+}
+
+// Computes the requested digests of everything written to it
+type checksumWriter struct {
+	checksumTypes []ChecksumType
+	hashes        []hash.Hash
+	writer        io.Writer
+}
+
+func newChecksumWriter(checksumTypes []ChecksumType) *checksumWriter {
+	cw := &checksumWriter{checksumTypes: checksumTypes}
+	writers := make([]io.Writer, len(checksumTypes))
+	for idx, checksumType := range checksumTypes {
+		h := checksumType.newHash()
+		cw.hashes = append(cw.hashes, h)
+		writers[idx] = h
+	}
+	cw.writer = io.MultiWriter(writers...)
+	return cw
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	return cw.writer.Write(p)
+}
+
+func (cw *checksumWriter) checksums() []ChecksumInfo {
+	infos := make([]ChecksumInfo, len(cw.hashes))
+	for idx, h := range cw.hashes {
+		infos[idx] = ChecksumInfo{Algorithm: cw.checksumTypes[idx], Value: h.Sum(nil)}
+	}
+	return infos
+}
+
+// Compare the digests computed for the transferred data against those the
+// server reported. Digests the server didn't report are ignored; if none were
+// reported, that's only an error when required is set.
+func compareChecksums(computed []ChecksumInfo, expected map[ChecksumType][]byte, required bool) ([]ChecksumInfo, error) {
+	var verified []ChecksumInfo
+	for _, info := range computed {
+		expectedValue, ok := expected[info.Algorithm]
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(expectedValue, info.Value) {
+			return nil, &ChecksumMismatchError{
+				Expected: ChecksumInfo{Algorithm: info.Algorithm, Value: expectedValue},
+				Computed: info.Value,
+			}
+		}
+		verified = append(verified, info)
+	}
+	if len(verified) == 0 && required {
+		requested := make([]ChecksumType, len(computed))
+		for idx, info := range computed {
+			requested[idx] = info.Algorithm
+		}
+		return nil, &MissingChecksumError{Requested: requested}
+	}
+	return verified, nil
+}
+
+// Verify a downloaded file against the digests the server reported. Only the
+// algorithms the server reported are computed.
+func verifyFileChecksums(localPath string, expected map[ChecksumType][]byte, checksumTypes []ChecksumType, required bool) ([]ChecksumInfo, error) {
+	var toCompute []ChecksumType
+	for _, checksumType := range checksumTypes {
+		if _, ok := expected[checksumType]; ok {
+			toCompute = append(toCompute, checksumType)
+		}
+	}
+	if len(toCompute) == 0 {
+		if required {
+			return nil, &MissingChecksumError{Requested: checksumTypes}
+		}
+		log.Debugln("Server did not provide a checksum for", localPath, "; skipping verification")
+		return nil, nil
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to open downloaded file to verify its checksum")
+	}
+	defer file.Close()
+	cw := newChecksumWriter(toCompute)
+	if _, err = io.Copy(cw, file); err != nil {
+		return nil, errors.Wrap(err, "Failed to read downloaded file to verify its checksum")
+	}
+	return compareChecksums(cw.checksums(), expected, required)
+}
+
+// Note the checksums verified for the file at localPath with the transfer in progress
+func recordChecksums(ctx context.Context, localPath string, checksums []ChecksumInfo) {
+	transferClientFromContext(ctx).recordChecksums(localPath, checksums)
+}
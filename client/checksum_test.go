@@ -0,0 +1,130 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksumTypes(t *testing.T) {
+	checksumTypes, err := ParseChecksumTypes("crc32c, SHA256")
+	require.NoError(t, err)
+	assert.Equal(t, []ChecksumType{ChecksumCRC32C, ChecksumSHA256}, checksumTypes)
+
+	_, err = ParseChecksumTypes("adler32")
+	assert.Error(t, err)
+	_, err = ParseChecksumTypes("")
+	assert.Error(t, err)
+}
+
+func TestParseDigests(t *testing.T) {
+	sha := sha256.Sum256([]byte("hello"))
+	header := http.Header{}
+	header.Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sha[:])+":")
+	header.Set("Digest", "crc32c=0a1b2c3d, adler32=01020304, md5=bogus")
+	digests := parseDigests(header)
+	assert.Equal(t, map[ChecksumType][]byte{
+		ChecksumSHA256: sha[:],
+		ChecksumCRC32C: {0x0a, 0x1b, 0x2c, 0x3d},
+	}, digests)
+}
+
+func TestDownloadChecksums(t *testing.T) {
+	contents := []byte("Hello, checksummed world!")
+	crc := crc32.Checksum(contents, crc32.MakeTable(crc32.Castagnoli))
+	goodDigest := "crc32c=" + hex.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)})
+	digest := goodDigest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, r.Header.Get("Want-Digest"))
+		if digest != "" {
+			w.Header().Set("Digest", digest)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	srvURL.Path = "/test/object"
+	transfer := TransferDetails{Url: *srvURL}
+	// A client of the transfer's own, to collect the checksums it verifies
+	download := func(dest string, options ...TransferOption) (*TransferClient, error) {
+		client, err := NewTransferEngine(context.Background()).NewClient(options...)
+		require.NoError(t, err)
+		tc, err := client.forTransfer(srvURL, false)
+		require.NoError(t, err)
+		_, err = downloadHTTP(tc.withContext(context.Background()), transfer, dest, "")
+		return tc, err
+	}
+
+	t.Run("matching-checksum", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "object")
+		tc, err := download(dest)
+		require.NoError(t, err)
+		checksums := tc.verifiedChecksums(dest)
+		require.Len(t, checksums, 1)
+		assert.Equal(t, goodDigest, "crc32c="+hex.EncodeToString(checksums[0].Value))
+	})
+
+	t.Run("mismatched-checksum", func(t *testing.T) {
+		digest = "crc32c=00000000"
+		defer func() { digest = goodDigest }()
+		dest := filepath.Join(t.TempDir(), "object")
+		_, err := DownloadHTTP(transfer, dest, "")
+		var cme *ChecksumMismatchError
+		require.ErrorAs(t, err, &cme)
+		assert.True(t, IsRetryable(err))
+		assert.NoFileExists(t, dest)
+		assert.NoFileExists(t, partialPath(dest))
+	})
+
+	t.Run("missing-optional-checksum", func(t *testing.T) {
+		digest = ""
+		defer func() { digest = goodDigest }()
+		dest := filepath.Join(t.TempDir(), "object")
+		tc, err := download(dest)
+		require.NoError(t, err)
+		assert.Empty(t, tc.verifiedChecksums(dest))
+	})
+
+	t.Run("missing-required-checksum", func(t *testing.T) {
+		digest = ""
+		defer func() { digest = goodDigest }()
+		dest := filepath.Join(t.TempDir(), "object")
+		_, err := download(dest, WithChecksums(ChecksumSHA256))
+		var mce *MissingChecksumError
+		require.ErrorAs(t, err, &mce)
+		_, err = os.Stat(dest)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
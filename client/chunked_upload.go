@@ -0,0 +1,321 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// An upload sent in chunks to a staging object beside the destination,
+	// which is moved into place once every chunk has arrived
+	chunkedUpload struct {
+		ctx          context.Context
+		client       *http.Client
+		file         *os.File
+		size         int64
+		dest         *url.URL
+		staging      *url.URL
+		token        string
+		limiter      *bandwidthLimiter
+		minimumSpeed int64
+		progressBar  *mpb.Bar
+
+		// The checksummer is fed the file in order, once, however many
+		// times its chunks are sent
+		checksumMutex sync.Mutex
+		checksummer   *checksumWriter
+		checksummed   int64
+	}
+
+	// Reads a chunk of the file being uploaded, checksumming what hasn't
+	// been already
+	chunkReader struct {
+		upload *chunkedUpload
+		reader io.Reader
+		offset int64
+	}
+)
+
+var (
+	// The file should be uploaded in a single request instead: it's too small
+	// to be worth chunking, or the origin doesn't take ranged uploads
+	errChunkedUploadUnsupported = errors.New("object cannot be uploaded in chunks")
+
+	// How long to wait before retrying a chunk; each later retry waits as
+	// much again
+	chunkRetryDelay = time.Second
+)
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.upload.checksumMutex.Lock()
+	if end := cr.offset + int64(n); cr.offset <= cr.upload.checksummed && end > cr.upload.checksummed {
+		if _, writeErr := cr.upload.checksummer.Write(p[cr.upload.checksummed-cr.offset : n]); writeErr != nil {
+			log.Warningln("Failed to checksum the upload:", writeErr)
+		}
+		cr.upload.checksummed = end
+	}
+	cr.upload.checksumMutex.Unlock()
+	cr.offset += int64(n)
+	return n, err
+}
+
+// A hidden, uniquely-named object in the same collection as dest, so moving
+// it into place is a rename on the origin
+func stagingUrl(dest *url.URL) (*url.URL, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate a name for the staged upload")
+	}
+	staging := *dest
+	staging.Path = path.Join(path.Dir(dest.Path), "."+path.Base(dest.Path)+".pelican-upload-"+hex.EncodeToString(suffix))
+	return &staging, nil
+}
+
+// Upload the size bytes of file to dest in chunks of Client.UploadChunkSize,
+// retrying each up to Client.UploadChunkRetries times, then move the staged
+// object into place and verify its checksums.
+//
+// Returns errChunkedUploadUnsupported if the file should be uploaded in a
+// single request instead, having cleaned up anything already staged.
+func uploadChunked(ctx context.Context, file *os.File, size int64, name string, dest *url.URL, token string) (uploaded int64, verified []ChecksumInfo, err error) {
+	tc := transferClientFromContext(ctx)
+	chunkSize := int64(param.Client_UploadChunkSize.GetInt())
+	if chunkSize <= 0 || size <= chunkSize {
+		return 0, nil, errChunkedUploadUnsupported
+	}
+	staging, err := stagingUrl(dest)
+	if err != nil {
+		return 0, nil, err
+	}
+	start := time.Now()
+	defer func() {
+		tc.recordAttempt(TransferAttempt{
+			Server:           dest.Host,
+			Proxy:            IsProxyEnabled(),
+			Start:            start,
+			End:              time.Now(),
+			TransferredBytes: uploaded,
+			Error:            err,
+		})
+	}()
+
+	checksumTypes, checksumRequired := requestedChecksums(ctx)
+	upload := &chunkedUpload{
+		ctx:          ctx,
+		client:       &http.Client{Transport: tc.engine.getTransport(true)},
+		file:         file,
+		size:         size,
+		dest:         dest,
+		staging:      staging,
+		token:        token,
+		limiter:      tc.newBandwidthLimiter(),
+		minimumSpeed: tc.minimumUploadSpeed(),
+		checksummer:  newChecksumWriter(checksumTypes),
+	}
+	if tc.options.ProgressBars {
+		upload.progressBar = getProgressContainer().AddBar(size,
+			mpb.PrependDecorators(
+				decor.Name(name, decor.WCSyncSpaceR),
+				decor.CountersKibiByte("% .2f / % .2f"),
+			),
+			mpb.AppendDecorators(
+				decor.OnComplete(decor.EwmaETA(decor.ET_STYLE_GO, 90), ""),
+				decor.OnComplete(decor.Name(" ] "), ""),
+				decor.OnComplete(decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 5), "Done!"),
+			),
+		)
+		defer func() {
+			if err == nil {
+				upload.progressBar.SetTotal(size, true)
+			} else {
+				upload.progressBar.Abort(true)
+			}
+			if tc.options.Recursive {
+				upload.progressBar.Wait()
+			} else {
+				getProgressContainer().Wait()
+			}
+		}()
+	}
+
+	log.Debugf("Uploading %d bytes to %s in chunks of %d, staged at %s", size, dest, chunkSize, staging.Path)
+	retries := param.Client_UploadChunkRetries.GetInt()
+	for offset := int64(0); offset < size; offset += chunkSize {
+		end := offset + chunkSize
+		if end > size {
+			end = size
+		}
+		if err = upload.sendChunkWithRetries(byteRange{offset, end}, retries); err != nil {
+			upload.discard()
+			if errors.Is(err, errChunkedUploadUnsupported) {
+				return 0, nil, err
+			}
+			return offset, nil, err
+		}
+	}
+	uploaded = size
+	if err = upload.commit(); err != nil {
+		upload.discard()
+		return
+	}
+	verified, err = verifyUpload(ctx, dest, token, nil, upload.checksummer.checksums(), checksumRequired)
+	return
+}
+
+func (cu *chunkedUpload) sendChunkWithRetries(r byteRange, retries int) (err error) {
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			log.Warningf("Retrying bytes %d-%d of the upload to %s after error: %v", r.Start, r.End-1, cu.dest, err)
+			select {
+			case <-cu.ctx.Done():
+				return cu.ctx.Err()
+			case <-time.After(time.Duration(attempt) * chunkRetryDelay):
+			}
+		}
+		if err = cu.sendChunk(r); err == nil {
+			return nil
+		}
+		if errors.Is(err, errChunkedUploadUnsupported) || cu.ctx.Err() != nil {
+			return err
+		}
+		if cu.progressBar != nil {
+			cu.progressBar.SetCurrent(r.Start)
+		}
+	}
+	return err
+}
+
+// PUT a single chunk into the staging object, then check the origin put it
+// where it was asked to rather than ignoring the range
+func (cu *chunkedUpload) sendChunk(r byteRange) error {
+	ctx, cancel := context.WithCancel(cu.ctx)
+	defer cancel()
+
+	var transferred atomic.Int64
+	chunk := &chunkReader{upload: cu, reader: io.NewSectionReader(cu.file, r.Start, r.End-r.Start), offset: r.Start}
+	reader := &countingReader{reader: cu.limiter.reader(ctx, chunk), count: &transferred, progressBar: cu.progressBar}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, cu.staging.String(), reader)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create chunk upload request")
+	}
+	req.ContentLength = r.End - r.Start
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End-1, cu.size))
+	req.Header.Set("Authorization", "Bearer "+cu.token)
+
+	// The watchdog covers the whole request, as a stalled origin may never
+	// even send the response headers
+	done := make(chan struct{})
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- (&sourceSpeed{started: time.Now()}).watch(&transferred, cu.minimumSpeed, done, cancel)
+	}()
+	resp, err := cu.client.Do(req)
+	close(done)
+	if slowErr := <-watchErr; slowErr != nil && err != nil {
+		return slowErr
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusBadRequest, http.StatusNotImplemented, http.StatusRequestedRangeNotSatisfiable:
+		if r.Start == 0 {
+			log.Debugln("Origin rejected a ranged upload with HTTP status", resp.StatusCode)
+			return errChunkedUploadUnsupported
+		}
+		fallthrough
+	default:
+		return &HttpErrResp{resp.StatusCode, fmt.Sprintf("Chunk upload failed (HTTP status %d)", resp.StatusCode)}
+	}
+
+	info, err := headObject(ctx, []TransferDetails{{Url: *cu.staging, Proxy: true}}, cu.token)
+	if err != nil {
+		return errors.Wrap(err, "Failed to check the size of the staged upload")
+	}
+	if info.size != r.End {
+		log.Debugf("Staged upload is %d bytes after writing up to %d; the origin ignored the range", info.size, r.End)
+		return errChunkedUploadUnsupported
+	}
+	return nil
+}
+
+// Move the staged object into place with a WebDAV MOVE, replacing any
+// existing object
+func (cu *chunkedUpload) commit() error {
+	req, err := http.NewRequestWithContext(cu.ctx, "MOVE", cu.staging.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create request to commit the upload")
+	}
+	req.Header.Set("Destination", cu.dest.String())
+	req.Header.Set("Overwrite", "T")
+	req.Header.Set("Authorization", "Bearer "+cu.token)
+	resp, err := cu.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Failed to commit the upload")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return &HttpErrResp{resp.StatusCode, fmt.Sprintf("Failed to move the staged upload into place (HTTP status %d)", resp.StatusCode)}
+	}
+	return nil
+}
+
+// Remove the staging object, as far as we can; the transfer may have been
+// cancelled, so this doesn't use its context
+func (cu *chunkedUpload) discard() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, cu.staging.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+cu.token)
+	resp, err := cu.client.Do(req)
+	if err != nil {
+		log.Warningln("Failed to remove the staged upload", cu.staging.Path, ":", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		log.Warningf("Failed to remove the staged upload %s (HTTP status %d)", cu.staging.Path, resp.StatusCode)
+	}
+}
@@ -0,0 +1,200 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pelicanplatform/pelican/namespaces"
+)
+
+// An origin holding its objects in memory.  It takes ranged PUTs, unless
+// ignoreRanges is set, and WebDAV MOVEs; failPuts PUTs fail before it
+// accepts any more.
+type memoryOrigin struct {
+	mutex        sync.Mutex
+	objects      map[string][]byte
+	ignoreRanges bool
+	failPuts     int
+	puts         int
+}
+
+func (mo *memoryOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mo.mutex.Lock()
+	defer mo.mutex.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		mo.puts++
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if mo.failPuts > 0 {
+			mo.failPuts--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var start int
+		if contentRange := r.Header.Get("Content-Range"); contentRange != "" && !mo.ignoreRanges {
+			if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else {
+			mo.objects[r.URL.Path] = nil
+		}
+		object := mo.objects[r.URL.Path]
+		if len(object) < start+len(body) {
+			object = append(object, make([]byte, start+len(body)-len(object))...)
+		}
+		copy(object[start:], body)
+		mo.objects[r.URL.Path] = object
+		w.WriteHeader(http.StatusOK)
+	case http.MethodHead:
+		object, ok := mo.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		w.Header().Set("Digest", fmt.Sprintf("crc32c=%08x", crc32.Checksum(object, crc32.MakeTable(crc32.Castagnoli))))
+		w.WriteHeader(http.StatusOK)
+	case "MOVE":
+		dest, err := url.Parse(r.Header.Get("Destination"))
+		object, ok := mo.objects[r.URL.Path]
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		mo.objects[dest.Path] = object
+		delete(mo.objects, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(mo.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func setupChunkedUpload(t *testing.T, origin *memoryOrigin) (context.Context, namespaces.Namespace, string, []byte) {
+	server := httptest.NewTLSServer(origin)
+	t.Cleanup(server.Close)
+
+	viper.Reset()
+	viper.Set("Client.UploadChunkSize", 1024)
+	viper.Set("Client.UploadChunkRetries", 2)
+	t.Cleanup(viper.Reset)
+	oldDelay := chunkRetryDelay
+	chunkRetryDelay = 10 * time.Millisecond
+	t.Cleanup(func() { chunkRetryDelay = oldDelay })
+
+	te := NewTransferEngine(context.Background())
+	t.Cleanup(te.Close)
+	te.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	tc, err := te.NewClient()
+	require.NoError(t, err)
+	tc, err = tc.forTransfer(&url.URL{}, false)
+	require.NoError(t, err)
+
+	contents := make([]byte, 5000)
+	rand.New(rand.NewSource(0)).Read(contents)
+	localFile := filepath.Join(t.TempDir(), "upload.dat")
+	require.NoError(t, os.WriteFile(localFile, contents, 0644))
+	return tc.withContext(context.Background()), namespaces.Namespace{WriteBackHost: server.URL}, localFile, contents
+}
+
+func TestChunkedUpload(t *testing.T) {
+	origin := &memoryOrigin{objects: make(map[string][]byte)}
+	ctx, namespace, localFile, contents := setupChunkedUpload(t, origin)
+
+	// Each of the five chunks is a PUT of its own
+	uploaded, err := uploadFile(ctx, localFile, &url.URL{Path: "/test/first.dat"}, "", namespace)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), uploaded)
+	assert.Equal(t, 5, origin.puts)
+	assert.Equal(t, contents, origin.objects["/test/first.dat"])
+
+	// A chunk that fails is retried on its own
+	origin.puts = 0
+	origin.failPuts = 1
+	uploaded, err = uploadFile(ctx, localFile, &url.URL{Path: "/test/upload.dat"}, "", namespace)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), uploaded)
+	assert.Equal(t, 6, origin.puts)
+	assert.Equal(t, contents, origin.objects["/test/upload.dat"])
+	// The checksum is of the file as a whole, despite the retry
+	checksums := transferClientFromContext(ctx).verifiedChecksums(localFile)
+	require.Len(t, checksums, 1)
+	assert.Equal(t, ChecksumCRC32C, checksums[0].Algorithm)
+	// Nothing's left staged
+	for objectPath := range origin.objects {
+		assert.False(t, strings.Contains(objectPath, ".pelican-upload-"), objectPath)
+	}
+
+	// A chunk that keeps failing fails the upload, and the destination is untouched
+	origin.failPuts = 10
+	_, err = uploadFile(ctx, localFile, &url.URL{Path: "/test/failed.dat"}, "", namespace)
+	assert.Error(t, err)
+	origin.failPuts = 0
+	assert.Len(t, origin.objects, 2)
+	assert.NotContains(t, origin.objects, "/test/failed.dat")
+}
+
+func TestChunkedUploadFallback(t *testing.T) {
+	// An origin that ignores the ranges is sent the file in one request
+	origin := &memoryOrigin{objects: make(map[string][]byte), ignoreRanges: true}
+	ctx, namespace, localFile, contents := setupChunkedUpload(t, origin)
+
+	uploaded, err := uploadFile(ctx, localFile, &url.URL{Path: "/test/upload.dat"}, "", namespace)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), uploaded)
+	assert.Equal(t, contents, origin.objects["/test/upload.dat"])
+	assert.Len(t, origin.objects, 1)
+	// Two chunks find the range was ignored, then the whole file is sent
+	assert.Equal(t, 3, origin.puts)
+
+	// As are files no bigger than a chunk
+	origin.puts = 0
+	require.NoError(t, os.WriteFile(localFile, contents[:1024], 0644))
+	_, err = uploadFile(ctx, localFile, &url.URL{Path: "/test/small.dat"}, "", namespace)
+	require.NoError(t, err)
+	assert.Equal(t, contents[:1024], origin.objects["/test/small.dat"])
+	assert.Equal(t, 1, origin.puts)
+}
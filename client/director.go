@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -126,21 +127,21 @@ func CreateNsFromDirectorResp(dirResp *http.Response) (namespace namespaces.Name
 
 // Make a request to the director for a given verb/resource; return the
 // HTTP response object only if a 307 is returned.
-func queryDirector(verb, source, directorUrl string) (resp *http.Response, err error) {
+func queryDirector(ctx context.Context, verb, source, directorUrl string) (resp *http.Response, err error) {
+	tc := transferClientFromContext(ctx)
 	resourceUrl := directorUrl + source
 	// Here we use http.Transport to prevent the client from following the director's
 	// redirect. We use the Location url elsewhere (plus we still need to do the token
 	// dance!)
 	var client *http.Client
-	tr := config.GetTransport()
 	client = &http.Client{
-		Transport: tr,
+		Transport: tc.engine.getTransport(true),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	req, err := http.NewRequest(verb, resourceUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, verb, resourceUrl, nil)
 	if err != nil {
 		log.Errorln("Failed to create an HTTP request:", err)
 		return nil, err
@@ -149,7 +150,7 @@ func queryDirector(verb, source, directorUrl string) (resp *http.Response, err e
 	// Include the Client's version as a User-Agent header. The Director will decide
 	// if it supports the version, and provide an error message in the case that it
 	// cannot.
-	userAgent := "pelican-client/" + ObjectClientOptions.Version
+	userAgent := "pelican-client/" + config.PelicanVersion
 	req.Header.Set("User-Agent", userAgent)
 
 	// Perform the HTTP request
@@ -168,7 +169,7 @@ func queryDirector(verb, source, directorUrl string) (resp *http.Response, err e
 
 	// If we get a 404, the director will hopefully tell us why. It might be that the namespace doesn't exist
 	if resp.StatusCode == 404 {
-		return nil, errors.New("404: " + string(body))
+		return nil, &HttpErrResp{http.StatusNotFound, "404: " + string(body)}
 	} else if resp.StatusCode != 307 {
 		var respErr directorResponse
 		if unmarshalErr := json.Unmarshal(body, &respErr); unmarshalErr != nil { // Error creating json
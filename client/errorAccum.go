@@ -19,8 +19,10 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -28,40 +30,96 @@ import (
 	grab "github.com/opensaucerer/grab/v3"
 )
 
+// ErrorCategory is a coarse classification of why a transfer failed
+type ErrorCategory string
+
 type TimestampedError struct {
 	err       error
 	timestamp time.Time
 }
 
-var (
-	bunchOfErrors []TimestampedError
-	mu            sync.Mutex
+// The errors hit while making transfers, for reporting once all attempts have
+// failed. Each TransferClient keeps its own; the package-level functions use
+// one shared by the whole process.
+type errorAccumulator struct {
+	errors []TimestampedError
+	mu     sync.Mutex
 	// We will generate an error string including the time since startup
-	startup time.Time = time.Now()
+	startup time.Time
+}
+
+const (
+	ErrorCategoryAuth         ErrorCategory = "auth"
+	ErrorCategoryNotFound     ErrorCategory = "not-found"
+	ErrorCategoryTimeout      ErrorCategory = "timeout"
+	ErrorCategorySlowTransfer ErrorCategory = "slow-transfer"
+	ErrorCategoryServerError  ErrorCategory = "server-error"
+	ErrorCategoryOther        ErrorCategory = "other"
 )
 
+var (
+	defaultErrors = newErrorAccumulator()
+)
+
+func newErrorAccumulator() *errorAccumulator {
+	return &errorAccumulator{startup: time.Now()}
+}
+
 // AddError will add an accumulated error to the error stack
 func AddError(err error) bool {
-	mu.Lock()
-	defer mu.Unlock()
-	bunchOfErrors = append(bunchOfErrors, TimestampedError{err, time.Now()})
-	return true
+	return defaultErrors.add(err)
 }
 
 func ClearErrors() {
-	mu.Lock()
-	defer mu.Unlock()
-
-	bunchOfErrors = make([]TimestampedError, 0)
+	defaultErrors.clear()
 }
 
 func GetErrors() string {
-	mu.Lock()
-	defer mu.Unlock()
+	return defaultErrors.get()
+}
+
+// ErrorsRetryable returns if the errors in the stack are retryable later
+func ErrorsRetryable() bool {
+	return defaultErrors.retryable()
+}
+
+// Err is the error that was hit
+func (te TimestampedError) Err() error {
+	return te.err
+}
+
+// Timestamp is when the error was hit
+func (te TimestampedError) Timestamp() time.Time {
+	return te.timestamp
+}
+
+func (ea *errorAccumulator) add(err error) bool {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	ea.errors = append(ea.errors, TimestampedError{err, time.Now()})
+	return true
+}
+
+func (ea *errorAccumulator) clear() {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+
+	ea.errors = make([]TimestampedError, 0)
+}
+
+func (ea *errorAccumulator) list() []TimestampedError {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	return append([]TimestampedError(nil), ea.errors...)
+}
+
+func (ea *errorAccumulator) get() string {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
 	first := true
-	lastError := startup
+	lastError := ea.startup
 	var errorsFormatted []string
-	for idx, theError := range bunchOfErrors {
+	for idx, theError := range ea.errors {
 		errFmt := fmt.Sprintf("Attempt #%v: %s", idx+1, theError.err.Error())
 		timeElapsed := theError.timestamp.Sub(lastError)
 		timeFormat := timeElapsed.Truncate(100 * time.Millisecond).String()
@@ -69,7 +127,7 @@ func GetErrors() string {
 		if first {
 			errFmt += " since start)"
 		} else {
-			timeSinceStart := theError.timestamp.Sub(startup)
+			timeSinceStart := theError.timestamp.Sub(ea.startup)
 			timeSinceStartFormat := timeSinceStart.Truncate(100 * time.Millisecond).String()
 			errFmt += " elapsed, " + timeSinceStartFormat + " since start)"
 		}
@@ -89,11 +147,28 @@ func GetErrors() string {
 	return toReturn
 }
 
+func (ea *errorAccumulator) retryable() bool {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	// Loop through the errors and see if all of them are retryable
+	for _, theError := range ea.errors {
+		if !IsRetryable(theError.err) {
+			return false
+		}
+	}
+	return true
+}
+
 // IsRetryable will return true if the error is retryable
 func IsRetryable(err error) bool {
 	if errors.Is(err, &SlowTransferError{}) {
 		return true
 	}
+	// Corruption in transit is unlikely to repeat
+	var cme *ChecksumMismatchError
+	if errors.As(err, &cme) {
+		return true
+	}
 	if errors.Is(err, grab.ErrBadLength) {
 		return false
 	}
@@ -127,15 +202,45 @@ func IsRetryable(err error) bool {
 	return false
 }
 
-// ErrorsRetryable returns if the errors in the stack are retryable later
-func ErrorsRetryable() bool {
-	mu.Lock()
-	defer mu.Unlock()
-	// Loop through the errors and see if all of them are retryable
-	for _, theError := range bunchOfErrors {
-		if !IsRetryable(theError.err) {
-			return false
-		}
+// The HTTP status code behind an error, or 0 if there isn't one
+func errorStatusCode(err error) int {
+	var hep *HttpErrResp
+	if errors.As(err, &hep) {
+		return hep.Code
 	}
-	return true
+	var sce grab.StatusCodeError
+	if errors.As(err, &sce) {
+		return int(sce)
+	}
+	return 0
+}
+
+// CategorizeError works out the kind of failure behind a transfer error
+func CategorizeError(err error) ErrorCategory {
+	if errors.Is(err, &SlowTransferError{}) {
+		return ErrorCategorySlowTransfer
+	}
+	var mte *MissingTokenError
+	if errors.As(err, &mte) {
+		return ErrorCategoryAuth
+	}
+	var ste *StoppedTransferError
+	if errors.As(err, &ste) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+	switch code := errorStatusCode(err); {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrorCategoryAuth
+	case code == http.StatusNotFound:
+		return ErrorCategoryNotFound
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return ErrorCategoryTimeout
+	case code >= 500:
+		return ErrorCategoryServerError
+	}
+	return ErrorCategoryOther
 }
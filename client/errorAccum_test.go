@@ -19,18 +19,21 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"testing"
 
+	grab "github.com/opensaucerer/grab/v3"
 	"github.com/stretchr/testify/assert"
 )
 
 // TestErrorAccum tests simple adding and removing from the accumulator
 func TestErrorAccum(t *testing.T) {
-	bunchOfErrors = make([]TimestampedError, 0)
+	ClearErrors()
 	defer func() {
-		bunchOfErrors = make([]TimestampedError, 0)
+		ClearErrors()
 	}()
 	// Case 1: cache with http
 	err := errors.New("error1")
@@ -45,9 +48,9 @@ func TestErrorAccum(t *testing.T) {
 
 // TestErrorsRetryableFalse tests that errors are not retryable
 func TestErrorsRetryableFalse(t *testing.T) {
-	bunchOfErrors = make([]TimestampedError, 0)
+	ClearErrors()
 	defer func() {
-		bunchOfErrors = make([]TimestampedError, 0)
+		ClearErrors()
 	}()
 	// Case 2: cache with http
 	AddError(&SlowTransferError{})
@@ -65,9 +68,9 @@ func TestErrorsRetryableFalse(t *testing.T) {
 
 // TestErrorsRetryableTrue tests that errors are retryable
 func TestErrorsRetryableTrue(t *testing.T) {
-	bunchOfErrors = make([]TimestampedError, 0)
+	ClearErrors()
 	defer func() {
-		bunchOfErrors = make([]TimestampedError, 0)
+		ClearErrors()
 	}()
 	// Try with a retryable error nested error
 	AddError(&url.Error{Err: &SlowTransferError{}})
@@ -77,3 +80,15 @@ func TestErrorsRetryableTrue(t *testing.T) {
 	assert.True(t, ErrorsRetryable(), "ErrorsRetryable should be true")
 
 }
+
+// TestCategorizeError tests the classification of transfer errors
+func TestCategorizeError(t *testing.T) {
+	assert.Equal(t, ErrorCategorySlowTransfer, CategorizeError(&SlowTransferError{}))
+	assert.Equal(t, ErrorCategoryTimeout, CategorizeError(&StoppedTransferError{Err: "stopped"}))
+	assert.Equal(t, ErrorCategoryTimeout, CategorizeError(fmt.Errorf("request: %w", context.DeadlineExceeded)))
+	assert.Equal(t, ErrorCategoryAuth, CategorizeError(&MissingTokenError{"no token"}))
+	assert.Equal(t, ErrorCategoryAuth, CategorizeError(&HttpErrResp{403, "forbidden"}))
+	assert.Equal(t, ErrorCategoryNotFound, CategorizeError(&FileDownloadError{"failed", &ConnectionSetupError{Err: grab.StatusCodeError(404)}}))
+	assert.Equal(t, ErrorCategoryServerError, CategorizeError(&HttpErrResp{500, "internal error"}))
+	assert.Equal(t, ErrorCategoryOther, CategorizeError(errors.New("something else")))
+}
@@ -30,6 +30,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pelicanplatform/pelican/config"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -128,7 +129,7 @@ func GetBestCache(cacheListName string) ([]string, error) {
 					break
 				}
 				req.Header.Add("Cache-control", "max-age=0")
-				req.Header.Add("User-Agent", "pelican/"+ObjectClientOptions.Version)
+				req.Header.Add("User-Agent", "pelican/"+config.PelicanVersion)
 				resp, err = client.Do(req)
 				if err == nil {
 					break
@@ -186,7 +187,6 @@ func GetBestCache(cacheListName string) ([]string, error) {
 			caches_list[i], caches_list[j] = caches_list[j], caches_list[i]
 		})
 		minsite := caches_list[0]
-		NearestCacheList = caches_list
 		log.Debugf("Unable to use Geoip to find closest cache!  Returning random cache %s", minsite)
 		log.Debugf("Randomized list of nearest caches: %s", strings.Join(caches_list, ","))
 		return caches_list, nil
@@ -214,13 +214,14 @@ func GetBestCache(cacheListName string) ([]string, error) {
 		minsite := cachesList[cacheListName][minIndex-1]
 		log.Debugln("Closest cache:", minsite)
 
+		nearestCaches := make([]string, 0, len(ordered_list))
 		for _, ordered_index := range ordered_list {
 			orderedIndex, _ := strconv.Atoi(ordered_index)
-			NearestCacheList = append(NearestCacheList, cachesList[cacheListName][orderedIndex-1])
+			nearestCaches = append(nearestCaches, cachesList[cacheListName][orderedIndex-1])
 		}
 
 		log.Debugf("Returning closest cache: %s", minsite)
-		log.Debugf("Ordered list of nearest caches: %s", NearestCacheList)
-		return NearestCacheList, nil
+		log.Debugf("Ordered list of nearest caches: %s", nearestCaches)
+		return nearestCaches, nil
 	}
 }
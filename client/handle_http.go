@@ -24,6 +24,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"os"
@@ -51,6 +52,10 @@ import (
 var (
 	progressCtrOnce sync.Once
 	progressCtr     *mpb.Progress
+	progressOutput  io.Writer = os.Stdout
+
+	// How long an upload may go without sending anything before it's given up on
+	uploadStallTimeout = 20 * time.Second
 )
 
 type StoppedTransferError struct {
@@ -63,11 +68,18 @@ type StoppedTransferError struct {
 // the progress container routines launch in the server.
 func getProgressContainer() *mpb.Progress {
 	progressCtrOnce.Do(func() {
-		progressCtr = mpb.New()
+		progressCtr = mpb.New(mpb.WithOutput(progressOutput))
 	})
 	return progressCtr
 }
 
+// SetProgressOutput sets where the progress bars are drawn, stdout by
+// default.  It has to be called before the first transfer; streaming to
+// stdout needs the bars on stderr instead.
+func SetProgressOutput(output io.Writer) {
+	progressOutput = output
+}
+
 func (e *StoppedTransferError) Error() string {
 	return e.Err
 }
@@ -264,8 +276,48 @@ func GenerateTransferDetailsUsingCache(cache CacheInterface, opts TransferDetail
 	return nil
 }
 
-func download_http(sourceUrl *url.URL, destination string, payload *payloadStruct, namespace namespaces.Namespace, recursive bool, tokenName string) (bytesTransferred int64, err error) {
+// The transfers to try for a download from the namespace, one for each of
+// the caches to try in order
+func cacheTransfers(ctx context.Context, namespace namespaces.Namespace, packOption string) ([]TransferDetails, error) {
+	tc := transferClientFromContext(ctx)
+	// Check the env var "USE_OSDF_DIRECTOR" and decide if ordered caches should come from director
+	var transfers []TransferDetails
+	closestNamespaceCaches, err := tc.cachesForNamespace(namespace, tc.directorUrl != "")
+	if err != nil {
+		log.Errorln("Failed to get namespaced caches (treated as non-fatal):", err)
+	}
+
+	log.Debugln("Matched caches:", closestNamespaceCaches)
+
+	// Make sure we only try as many caches as we have
+	cachesToTry := tc.cachesToTry
+	if cachesToTry > len(closestNamespaceCaches) {
+		cachesToTry = len(closestNamespaceCaches)
+	}
+	log.Debugln("Trying the caches:", closestNamespaceCaches[:cachesToTry])
+
+	for _, cache := range closestNamespaceCaches[:cachesToTry] {
+		// Parse the cache URL
+		log.Debugln("Cache:", cache)
+		td := TransferDetailsOptions{
+			NeedsToken: namespace.ReadHTTPS || namespace.UseTokenOnRead,
+			PackOption: packOption,
+		}
+		transfers = append(transfers, GenerateTransferDetailsUsingCache(cache, td)...)
+	}
+
+	if len(transfers) > 0 {
+		log.Debugln("Transfers:", transfers[0].Url.Opaque)
+	} else {
+		log.Debugln("No transfers possible as no caches are found")
+		return nil, errors.New("No transfers possible as no caches are found")
+	}
+	return transfers, nil
+}
+
+func download_http(ctx context.Context, sourceUrl *url.URL, destination string, payload *payloadStruct, namespace namespaces.Namespace, recursive bool, tokenName string) (bytesTransferred int64, err error) {
 
+	tc := transferClientFromContext(ctx)
 	// First, create a handler for any panics that occur
 	defer func() {
 		if r := recover(); r != nil {
@@ -275,7 +327,7 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 			bytesTransferred = 0
 
 			// Attempt to add the panic to the error accumulator
-			AddError(errors.New(ret))
+			tc.errors.add(errors.New(ret))
 		}
 	}()
 
@@ -288,34 +340,17 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 	var token string
 	if namespace.UseTokenOnRead {
 		var err error
-		token, err = getToken(sourceUrl, namespace, false, tokenName)
+		token, err = getToken(ctx, sourceUrl, namespace, false, tokenName)
 		if err != nil {
 			log.Errorln("Failed to get token though required to read from this namespace:", err)
 			return 0, err
 		}
 	}
 
-	// Check the env var "USE_OSDF_DIRECTOR" and decide if ordered caches should come from director
-	var transfers []TransferDetails
 	var files []string
-	directorUrl := param.Federation_DirectorUrl.GetString()
-	closestNamespaceCaches, err := GetCachesFromNamespace(namespace, directorUrl != "")
-	if err != nil {
-		log.Errorln("Failed to get namespaced caches (treated as non-fatal):", err)
-	}
-
-	log.Debugln("Matched caches:", closestNamespaceCaches)
-
-	// Make sure we only try as many caches as we have
-	cachesToTry := CachesToTry
-	if cachesToTry > len(closestNamespaceCaches) {
-		cachesToTry = len(closestNamespaceCaches)
-	}
-	log.Debugln("Trying the caches:", closestNamespaceCaches[:cachesToTry])
-
 	if recursive {
 		var err error
-		files, err = walkDavDir(sourceUrl, namespace, token, "", false)
+		files, err = walkDavDir(ctx, sourceUrl, namespace, token, "", false)
 		if err != nil {
 			log.Errorln("Error from walkDavDir", err)
 			return 0, err
@@ -324,21 +359,9 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 		files = append(files, sourceUrl.Path)
 	}
 
-	for _, cache := range closestNamespaceCaches[:cachesToTry] {
-		// Parse the cache URL
-		log.Debugln("Cache:", cache)
-		td := TransferDetailsOptions{
-			NeedsToken: namespace.ReadHTTPS || namespace.UseTokenOnRead,
-			PackOption: packOption,
-		}
-		transfers = append(transfers, GenerateTransferDetailsUsingCache(cache, td)...)
-	}
-
-	if len(transfers) > 0 {
-		log.Debugln("Transfers:", transfers[0].Url.Opaque)
-	} else {
-		log.Debugln("No transfers possible as no caches are found")
-		return 0, errors.New("No transfers possible as no caches are found")
+	transfers, err := cacheTransfers(ctx, namespace, packOption)
+	if err != nil {
+		return 0, err
 	}
 	// Create the wait group and the transfer files
 	var wg sync.WaitGroup
@@ -347,13 +370,13 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 	results := make(chan TransferResults, len(files))
 	//tf := TransferFiles{files: files}
 
-	if ObjectClientOptions.Recursive && ObjectClientOptions.ProgressBars {
+	if tc.options.Recursive && tc.options.ProgressBars {
 		log.SetOutput(getProgressContainer())
 	}
 	// Start the workers
-	for i := 1; i <= 5; i++ {
+	for i := 1; i <= tc.workerCount(); i++ {
 		wg.Add(1)
-		go startDownloadWorker(sourceUrl.Path, destination, token, transfers, &wg, workChan, results)
+		go startDownloadWorker(ctx, sourceUrl.Path, destination, token, transfers, &wg, workChan, results)
 	}
 
 	// For each file, send it to the worker
@@ -381,7 +404,7 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 		}
 	}
 	// Make sure to close the progressContainer after all download complete
-	if ObjectClientOptions.Recursive && ObjectClientOptions.ProgressBars {
+	if tc.options.Recursive && tc.options.ProgressBars {
 		getProgressContainer().Wait()
 		log.SetOutput(os.Stdout)
 	}
@@ -389,9 +412,10 @@ func download_http(sourceUrl *url.URL, destination string, payload *payloadStruc
 
 }
 
-func startDownloadWorker(source string, destination string, token string, transfers []TransferDetails, wg *sync.WaitGroup, workChan <-chan string, results chan<- TransferResults) {
+func startDownloadWorker(ctx context.Context, source string, destination string, token string, transfers []TransferDetails, wg *sync.WaitGroup, workChan <-chan string, results chan<- TransferResults) {
 
 	defer wg.Done()
+	tc := transferClientFromContext(ctx)
 	var success bool
 	for file := range workChan {
 		// Remove the source from the file path
@@ -404,10 +428,28 @@ func startDownloadWorker(source string, destination string, token string, transf
 			results <- TransferResults{Error: errors.New("Failed to make directory:" + directory)}
 			continue
 		}
-		for _, transfer := range transfers {
+		fileTransfers := make([]TransferDetails, len(transfers))
+		for idx, transfer := range transfers {
 			transfer.Url.Path = file
+			fileTransfers[idx] = transfer
+		}
+		if downloaded, ok := tc.downloadFromLocalCache(ctx, fileTransfers, file, finalDest, token); ok {
+			results <- TransferResults{Downloaded: downloaded}
+			continue
+		}
+		// Large objects are fetched from several caches at once when we can; if that
+		// fails, fall back to trying the caches one at a time
+		if downloaded, err = downloadMultiSource(ctx, fileTransfers, finalDest, token); err == nil {
+			log.Debugln("Downloaded bytes:", downloaded)
+			tc.addToLocalCache(fileTransfers, file, finalDest)
+			results <- TransferResults{Downloaded: downloaded}
+			continue
+		} else if !errors.Is(err, errMultiSourceUnsupported) {
+			log.Debugln("Multi-source download failed; retrying from a single cache:", err)
+		}
+		for _, transfer := range fileTransfers {
 			log.Debugln("Constructed URL:", transfer.Url.String())
-			if downloaded, err = DownloadHTTP(transfer, finalDest, token); err != nil {
+			if downloaded, err = downloadHTTP(ctx, transfer, finalDest, token); err != nil {
 				log.Debugln("Failed to download:", err)
 				var ope *net.OpError
 				var cse *ConnectionSetupError
@@ -431,7 +473,7 @@ func startDownloadWorker(source string, destination string, token string, transf
 					errorString += "+ proxy=" + strconv.FormatBool(transfer.Proxy) +
 						": " + err.Error()
 				}
-				AddError(&FileDownloadError{errorString, err})
+				tc.errors.add(&FileDownloadError{errorString, err})
 				continue
 			} else {
 				log.Debugln("Downloaded bytes:", downloaded)
@@ -445,6 +487,7 @@ func startDownloadWorker(source string, destination string, token string, transf
 			results <- TransferResults{Error: errors.New("failed to download with HTTP")}
 			return
 		} else {
+			tc.addToLocalCache(fileTransfers, file, finalDest)
 			results <- TransferResults{
 				Downloaded: downloaded,
 				Error:      nil,
@@ -469,25 +512,68 @@ func parseTransferStatus(status string) (int, string) {
 
 // DownloadHTTP - Perform the actual download of the file
 func DownloadHTTP(transfer TransferDetails, dest string, token string) (int64, error) {
+	return downloadHTTP(context.Background(), transfer, dest, token)
+}
+
+// Trace the requests made with ctx for how long the first of them took to
+// see the start of its response
+func traceFirstByte(ctx context.Context, start time.Time) (context.Context, func() time.Duration) {
+	var firstByte atomic.Int64
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			firstByte.CompareAndSwap(0, int64(time.Since(start)))
+		},
+	})
+	return ctx, func() time.Duration { return time.Duration(firstByte.Load()) }
+}
+
+func downloadHTTP(ctx context.Context, transfer TransferDetails, dest string, token string) (downloaded int64, err error) {
+	return downloadHTTPTo(ctx, transfer, dest, nil, token)
+}
+
+// Download the transfer's object to dest or, if output is set, stream it to
+// output instead.  A stream can't be resumed, so it's written as it arrives
+// and its checksums are verified only once it has all been written.
+func downloadHTTPTo(ctx context.Context, transfer TransferDetails, dest string, output io.Writer, token string) (downloaded int64, err error) {
+	tc := transferClientFromContext(ctx)
+	start := time.Now()
+	ctx, timeToFirstByte := traceFirstByte(ctx, start)
+	defer func() {
+		tc.recordAttempt(TransferAttempt{
+			Server:           transfer.Url.Host,
+			Proxy:            transfer.Proxy,
+			Start:            start,
+			End:              time.Now(),
+			TimeToFirstByte:  timeToFirstByte(),
+			TransferredBytes: downloaded,
+			Error:            err,
+		})
+	}()
 
 	// Create the client, request, and context
 	client := grab.NewClient()
-	transport := config.GetTransport()
-	if !transfer.Proxy {
-		transport.Proxy = nil
-	}
 	httpClient, ok := client.HTTPClient.(*http.Client)
 	if !ok {
 		return 0, errors.New("Internal error: implementation is not a http.Client type")
 	}
-	httpClient.Transport = transport
+	httpClient.Transport = tc.engine.getTransport(transfer.Proxy)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	log.Debugln("Transfer URL String:", transfer.Url.String())
 	var req *grab.Request
-	var err error
 	var unpacker *autoUnpacker
+	// Plain downloads go to a partial file, which is resumed if we are
+	// interrupted and then moved into place once complete
+	var state *partialState
+	var streamChecksums *checksumWriter
+	checksumTypes, checksumRequired := requestedChecksums(ctx)
+	if transfer.PackOption == "" && output == nil {
+		if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			dest = path.Join(dest, path.Base(transfer.Url.Path))
+		}
+		state = resumeSequential(ctx, dest, transfer, token)
+	}
 	if transfer.PackOption != "" {
 		behavior, err := GetBehavior(transfer.PackOption)
 		if err != nil {
@@ -497,14 +583,23 @@ func DownloadHTTP(transfer TransferDetails, dest string, token string) (int64, e
 		if req, err = grab.NewRequestToWriter(unpacker, transfer.Url.String()); err != nil {
 			return 0, errors.Wrap(err, "Failed to create new download request")
 		}
-	} else if req, err = grab.NewRequest(dest, transfer.Url.String()); err != nil {
+	} else if output != nil {
+		streamChecksums = newChecksumWriter(checksumTypes)
+		if req, err = grab.NewRequestToWriter(io.MultiWriter(output, streamChecksums), transfer.Url.String()); err != nil {
+			return 0, errors.Wrap(err, "Failed to create new download request")
+		}
+	} else if req, err = grab.NewRequest(partialPath(dest), transfer.Url.String()); err != nil {
 		return 0, errors.Wrap(err, "Failed to create new download request")
 	}
 
 	if token != "" {
 		req.HTTPRequest.Header.Set("Authorization", "Bearer "+token)
 	}
+	if limiter := tc.newBandwidthLimiter(); limiter != nil {
+		req.RateLimiter = limiter
+	}
 	// Set the headers
+	setWantDigest(req.HTTPRequest.Header, checksumTypes)
 	req.HTTPRequest.Header.Set("X-Transfer-Status", "true")
 	req.HTTPRequest.Header.Set("TE", "trailers")
 	req.WithContext(ctx)
@@ -516,17 +611,40 @@ func DownloadHTTP(transfer TransferDetails, dest string, token string) (int64, e
 	// Progress ticker
 	progressTicker := time.NewTicker(500 * time.Millisecond)
 	defer progressTicker.Stop()
-	downloadLimit := param.Client_MinimumDownloadSpeed.GetInt()
-
-	// If we are doing a recursive, decrease the download limit by the number of likely workers ~5
-	if ObjectClientOptions.Recursive {
-		downloadLimit /= 5
+	// If we are doing a recursive, decrease the download limit by the number of workers
+	shares := 1
+	if tc.options.Recursive {
+		shares = tc.workerCount()
 	}
+	downloadLimit := tc.minimumDownloadSpeed(shares)
 
 	// Start the transfer
 	log.Debugln("Starting the HTTP transfer...")
 	filename := path.Base(dest)
+	if output != nil {
+		filename = path.Base(transfer.Url.Path)
+	}
 	resp := client.Do(req)
+	if transfer.PackOption == "" && output == nil {
+		if state == nil && resp.HTTPResponse != nil {
+			state = newPartialState(dest, transfer.Url, objectInfoFromResponse(resp.HTTPResponse, resp.Size()))
+		}
+		if state != nil {
+			// However the download ends, record how far we got
+			defer func() {
+				if resp.IsComplete() && resp.Err() == nil {
+					return
+				}
+				if completed := resp.BytesComplete(); completed > 0 {
+					if err := state.setCompletedPrefix(completed); err != nil {
+						log.Warningln("Failed to save the progress of the download:", err)
+					}
+				} else {
+					discardPartial(dest)
+				}
+			}()
+		}
+	}
 	// Check the error real quick
 	if resp.IsComplete() {
 		if err := resp.Err(); err != nil {
@@ -541,9 +659,9 @@ func DownloadHTTP(transfer TransferDetails, dest string, token string) (int64, e
 	// Size of the download
 	contentLength := resp.Size()
 	// Do a head request for content length if resp.Size is unknown
-	if contentLength <= 0 && ObjectClientOptions.ProgressBars {
-		headClient := &http.Client{Transport: config.GetTransport()}
-		headRequest, _ := http.NewRequest("HEAD", transfer.Url.String(), nil)
+	if contentLength <= 0 && tc.options.ProgressBars {
+		headClient := &http.Client{Transport: tc.engine.getTransport(true)}
+		headRequest, _ := http.NewRequestWithContext(ctx, "HEAD", transfer.Url.String(), nil)
 		headResponse, err := headClient.Do(headRequest)
 		if err != nil {
 			log.Errorln("Could not successfully get response for HEAD request")
@@ -559,7 +677,7 @@ func DownloadHTTP(transfer TransferDetails, dest string, token string) (int64, e
 	}
 
 	var progressBar *mpb.Bar
-	if ObjectClientOptions.ProgressBars {
+	if tc.options.ProgressBars {
 		progressBar = getProgressContainer().AddBar(0,
 			mpb.PrependDecorators(
 				decor.Name(filename, decor.WCSyncSpaceR),
@@ -586,7 +704,7 @@ Loop:
 	for {
 		select {
 		case <-progressTicker.C:
-			if ObjectClientOptions.ProgressBars {
+			if tc.options.ProgressBars {
 				progressBar.SetTotal(contentLength, false)
 				currentCompletedBytes := resp.BytesComplete()
 				bytesDelta := currentCompletedBytes - previousCompletedBytes
@@ -598,6 +716,12 @@ Loop:
 			}
 
 		case <-t.C:
+			// Save our progress in case we're killed outright
+			if state != nil {
+				if err := state.setCompletedPrefix(resp.BytesComplete()); err != nil {
+					log.Warningln("Failed to save the progress of the download:", err)
+				}
+			}
 			// Check that progress is being made and that it is not too slow
 			if resp.BytesComplete() == lastBytesComplete {
 				if noProgressStartTime.IsZero() {
@@ -605,7 +729,7 @@ Loop:
 				} else if time.Since(noProgressStartTime) > time.Duration(stoppedTransferTimeout)*time.Second {
 					errMsg := "No progress for more than " + time.Since(noProgressStartTime).Truncate(time.Millisecond).String()
 					log.Errorln(errMsg)
-					if ObjectClientOptions.ProgressBars {
+					if tc.options.ProgressBars {
 						progressBar.Abort(true)
 						progressBar.Wait()
 					}
@@ -638,7 +762,7 @@ Loop:
 				}
 				// The download is below the threshold for more than `SlowTransferWindow` seconds, cancel the download
 				cancel()
-				if ObjectClientOptions.ProgressBars {
+				if tc.options.ProgressBars {
 					progressBar.Abort(true)
 					progressBar.Wait()
 				}
@@ -659,7 +783,7 @@ Loop:
 
 		case <-resp.Done:
 			// download is complete
-			if ObjectClientOptions.ProgressBars {
+			if tc.options.ProgressBars {
 				downloadError := resp.Err()
 				if downloadError != nil {
 					log.Errorln(downloadError.Error())
@@ -669,7 +793,7 @@ Loop:
 					progressBar.SetTotal(contentLength, true)
 					// call wait here for the bar to complete and flush
 					// If recursive, we still want to use container so keep it open
-					if ObjectClientOptions.Recursive {
+					if tc.options.Recursive {
 						progressBar.Wait()
 					} else { // Otherwise just close it
 						getProgressContainer().Wait()
@@ -690,20 +814,24 @@ Loop:
 		}
 		log.Debugln("Got error from HTTP download", err)
 		return 0, err
-	} else {
+	} else if resp.HTTPResponse != nil {
 		// Check the trailers for any error information
 		trailer := resp.HTTPResponse.Trailer
 		if errorStatus := trailer.Get("X-Transfer-Status"); errorStatus != "" {
 			statusCode, statusText := parseTransferStatus(errorStatus)
 			if statusCode != 200 {
 				log.Debugln("Got error from file transfer")
+				// We can't trust what we got, so don't resume from it
+				if unpacker == nil && output == nil {
+					discardPartial(dest)
+				}
 				return 0, errors.New("transfer error: " + statusText)
 			}
 		}
 	}
 	// Valid responses include 200 and 206.  The latter occurs if the download was resumed after a
-	// prior attempt.
-	if resp.HTTPResponse.StatusCode != 200 && resp.HTTPResponse.StatusCode != 206 {
+	// prior attempt.  There's no response at all if a resumed download was already complete.
+	if resp.HTTPResponse != nil && resp.HTTPResponse.StatusCode != 200 && resp.HTTPResponse.StatusCode != 206 {
 		log.Debugln("Got failure status code:", resp.HTTPResponse.StatusCode)
 		return 0, &HttpErrResp{resp.HTTPResponse.StatusCode, fmt.Sprintf("Request failed (HTTP status %d): %s",
 			resp.HTTPResponse.StatusCode, resp.Err().Error())}
@@ -714,6 +842,38 @@ Loop:
 		if err := unpacker.Error(); err != nil {
 			return 0, err
 		}
+	} else if output != nil {
+		var expected map[ChecksumType][]byte
+		if resp.HTTPResponse != nil {
+			expected = parseDigests(resp.HTTPResponse.Header)
+		}
+		if _, err := compareChecksums(streamChecksums.checksums(), expected, checksumRequired); err != nil {
+			return resp.BytesComplete(), err
+		}
+	} else {
+		var expected map[ChecksumType][]byte
+		if resp.HTTPResponse != nil {
+			expected = parseDigests(resp.HTTPResponse.Header)
+		}
+		// A resumed download that was already complete never sees a response
+		if len(expected) == 0 && checksumRequired {
+			if info, err := headObject(ctx, []TransferDetails{transfer}, token); err == nil {
+				expected = info.digests
+			}
+		}
+		verified, err := verifyFileChecksums(partialPath(dest), expected, checksumTypes, checksumRequired)
+		if err != nil {
+			discardPartial(dest)
+			return 0, err
+		}
+		if err = finishPartial(dest); err != nil {
+			return 0, err
+		}
+		recordChecksums(ctx, dest, verified)
+		if state != nil {
+			// The validators came with the data, so they describe exactly these bytes
+			tc.recordDownload(dest, objectInfo{size: state.Size, etag: state.ETag, lastModified: state.LastModified})
+		}
 	}
 
 	log.Debugln("HTTP Transfer was successful")
@@ -773,42 +933,79 @@ func (pr *ProgressReader) Size() int64 {
 
 // Recursively uploads a directory with all files and nested dirs, keeping file structure on server side
 func UploadDirectory(src string, dest *url.URL, token string, namespace namespaces.Namespace) (int64, error) {
+	return uploadDirectory(context.Background(), src, dest, token, namespace)
+}
+
+func uploadDirectory(ctx context.Context, src string, dest *url.URL, token string, namespace namespaces.Namespace) (int64, error) {
+	tc := transferClientFromContext(ctx)
 	var files []string
 	var amountDownloaded int64
 	srcUrl := url.URL{Path: src}
 	// Get the list of files as well as make any directories on the server end
-	files, err := walkDavDir(&srcUrl, namespace, token, dest.Path, true)
+	files, err := walkDavDir(ctx, &srcUrl, namespace, token, dest.Path, true)
 	if err != nil {
 		return 0, err
 	}
 
-	if ObjectClientOptions.ProgressBars {
+	if tc.options.ProgressBars {
 		log.SetOutput(getProgressContainer())
 	}
-	// Upload all of our files within the proper directories
+	// Upload all of our files within the proper directories, several at a time
+	var (
+		wg         sync.WaitGroup
+		mutex      sync.Mutex
+		firstError error
+	)
+	work := make(chan string)
+	for i := 0; i < tc.workerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range work {
+				tempDest := url.URL{}
+				tempPath, err := url.JoinPath(dest.Path, file)
+				var uploaded int64
+				if err == nil {
+					tempDest.Path = tempPath
+					uploaded, err = uploadFile(ctx, file, &tempDest, token, namespace)
+				}
+				mutex.Lock()
+				amountDownloaded += uploaded
+				if err != nil && firstError == nil {
+					firstError = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
 	for _, file := range files {
-		tempDest := url.URL{}
-		tempDest.Path, err = url.JoinPath(dest.Path, file)
-		if err != nil {
-			return 0, err
-		}
-		downloaded, err := UploadFile(file, &tempDest, token, namespace)
-		if err != nil {
-			return 0, err
+		mutex.Lock()
+		failed := firstError != nil
+		mutex.Unlock()
+		if failed {
+			break
 		}
-		amountDownloaded += downloaded
+		work <- file
 	}
+	close(work)
+	wg.Wait()
 	// Close progress bar container
-	if ObjectClientOptions.ProgressBars {
+	if tc.options.ProgressBars {
 		getProgressContainer().Wait()
 		log.SetOutput(os.Stdout)
 	}
-	return amountDownloaded, err
+	if firstError != nil {
+		return 0, firstError
+	}
+	return amountDownloaded, nil
 }
 
 // UploadFile Uploads a file using HTTP
 func UploadFile(src string, origDest *url.URL, token string, namespace namespaces.Namespace) (int64, error) {
+	return uploadFile(context.Background(), src, origDest, token, namespace)
+}
 
+func uploadFile(ctx context.Context, src string, origDest *url.URL, token string, namespace namespaces.Namespace) (int64, error) {
 	log.Debugln("In UploadFile")
 	log.Debugln("Dest", origDest.String())
 
@@ -819,8 +1016,15 @@ func UploadFile(src string, origDest *url.URL, token string, namespace namespace
 		return 0, err
 	}
 
+	dest, err := writebackUrl(namespace, origDest)
+	if err != nil {
+		return 0, err
+	}
+
 	var ioreader io.ReadCloser
 	var sizer Sizer
+	var checksummer *checksumWriter
+	checksumTypes, _ := requestedChecksums(ctx)
 	pack := origDest.Query().Get("pack")
 	nonZeroSize := true
 	if pack != "" {
@@ -844,21 +1048,79 @@ func UploadFile(src string, origDest *url.URL, token string, namespace namespace
 			log.Errorln("Error opening local file:", err)
 			return 0, err
 		}
-		ioreader = file
+		// Large files go in chunks, if the origin allows it
+		uploaded, verified, err := uploadChunked(ctx, file, fileInfo.Size(), src, dest, token)
+		if !errors.Is(err, errChunkedUploadUnsupported) {
+			file.Close()
+			if err == nil {
+				recordChecksums(ctx, src, verified)
+			}
+			return uploaded, err
+		}
+		// Checksum the file as it's sent, to compare with what the origin received
+		checksummer = newChecksumWriter(checksumTypes)
+		ioreader = &teeReadCloser{io.TeeReader(file, checksummer), file}
 		sizer = &ConstantSizer{size: fileInfo.Size()}
 		nonZeroSize = fileInfo.Size() > 0
 	}
+	if !nonZeroSize {
+		ioreader.Close()
+		ioreader = nil
+	}
+
+	uploaded, verified, err := uploadReader(ctx, ioreader, sizer, src, dest, token, checksummer, true)
+	if err == nil && checksummer != nil {
+		recordChecksums(ctx, src, verified)
+	}
+	return uploaded, err
+}
+
+// Upload the contents of a stream to origDest.  A stream whose size is
+// unknown is sent with chunked transfer encoding.
+func uploadStream(ctx context.Context, stream io.Reader, size int64, origDest *url.URL, token string, namespace namespaces.Namespace) (int64, []ChecksumInfo, error) {
+	log.Debugln("Uploading a stream to", origDest.String())
+	checksumTypes, _ := requestedChecksums(ctx)
+	checksummer := newChecksumWriter(checksumTypes)
+	ioreader := &teeReadCloser{io.TeeReader(stream, checksummer), io.NopCloser(nil)}
+	dest, err := writebackUrl(namespace, origDest)
+	if err != nil {
+		return 0, nil, err
+	}
+	// A stream's pace is set by whatever is writing to it, so it's not
+	// given up on for being slow
+	return uploadReader(ctx, ioreader, &ConstantSizer{size: size}, StreamPath, dest, token, checksummer, false)
+}
 
+// The URL of origDest on the namespace's write-back host
+func writebackUrl(namespace namespaces.Namespace, origDest *url.URL) (*url.URL, error) {
 	// Parse the writeback host as a URL
 	writebackhostUrl, err := url.Parse(namespace.WriteBackHost)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	dest := &url.URL{
+	return &url.URL{
 		Host:   writebackhostUrl.Host,
 		Scheme: "https",
 		Path:   origDest.Path,
+	}, nil
+}
+
+// PUT the contents of ioreader, which sizer reports the progress of, to
+// dest.  A nil ioreader uploads an empty object.  If checksummer is set,
+// the checksums it computed are compared against those the origin reports
+// and the verified ones returned.  With watch set, the upload is given up
+// on if it stops or is too slow, as downloads are.
+func uploadReader(ctx context.Context, ioreader io.ReadCloser, sizer Sizer, name string, dest *url.URL, token string, checksummer *checksumWriter, watch bool) (int64, []ChecksumInfo, error) {
+	tc := transferClientFromContext(ctx)
+	checksumTypes, checksumRequired := requestedChecksums(ctx)
+
+	var transferred atomic.Int64
+	if ioreader != nil {
+		ioreader = &teeReadCloser{&countingReader{reader: ioreader, count: &transferred}, ioreader}
+	}
+	if limiter := tc.newBandwidthLimiter(); limiter != nil && ioreader != nil {
+		ioreader = &teeReadCloser{limiter.reader(ctx, ioreader), ioreader}
 	}
 
 	// Create the wrapped reader and send it to the request
@@ -866,33 +1128,48 @@ func UploadFile(src string, origDest *url.URL, token string, namespace namespace
 	errorChan := make(chan error, 1)
 	responseChan := make(chan *http.Response)
 	reader := &ProgressReader{ioreader, sizer, closed}
-	putContext, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	putContext, timeToFirstByte := traceFirstByte(ctx, start)
+	putContext, cancel := context.WithCancel(putContext)
 	defer cancel()
 	log.Debugln("Full destination URL:", dest.String())
+	var err error
 	var request *http.Request
 	// For files that are 0 length, we need to send a PUT request with an nil body
-	if nonZeroSize {
+	if ioreader != nil {
 		request, err = http.NewRequestWithContext(putContext, "PUT", dest.String(), reader)
 	} else {
 		request, err = http.NewRequestWithContext(putContext, "PUT", dest.String(), http.NoBody)
 	}
 	if err != nil {
 		log.Errorln("Error creating request:", err)
-		return 0, err
+		return 0, nil, err
+	}
+	if ioreader != nil && sizer.Size() < 0 {
+		request.ContentLength = -1
 	}
 	// Set the authorization header
 	request.Header.Set("Authorization", "Bearer "+token)
-	var lastKnownWritten int64
-	t := time.NewTicker(20 * time.Second)
-	defer t.Stop()
-	go doPut(request, responseChan, errorChan)
+	setWantDigest(request.Header, checksumTypes)
+	var putResponse *http.Response
+	go doPut(tc.engine.getTransport(true), request, responseChan, errorChan)
 	var lastError error = nil
 
+	watchDone := make(chan struct{})
+	watchErr := make(chan error, 1)
+	if watch {
+		go func() {
+			watchErr <- (&sourceSpeed{started: start}).watch(&transferred, tc.minimumUploadSpeed(), watchDone, cancel)
+		}()
+	} else {
+		watchErr <- nil
+	}
+
 	var progressBar *mpb.Bar
-	if ObjectClientOptions.ProgressBars {
+	if tc.options.ProgressBars {
 		progressBar = getProgressContainer().AddBar(0,
 			mpb.PrependDecorators(
-				decor.Name(src, decor.WCSyncSpaceR),
+				decor.Name(name, decor.WCSyncSpaceR),
 				decor.CountersKibiByte("% .2f / % .2f"),
 			),
 			mpb.AppendDecorators(
@@ -909,7 +1186,7 @@ func UploadFile(src string, origDest *url.URL, token string, namespace namespace
 				progressBar.Abort(true)
 			}
 			// If it is recursive, we need to reuse the mpb instance. Closed later
-			if ObjectClientOptions.Recursive {
+			if tc.options.Recursive {
 				progressBar.Wait()
 			} else { // If not recursive, go ahead and close it
 				getProgressContainer().Wait()
@@ -919,6 +1196,10 @@ func UploadFile(src string, origDest *url.URL, token string, namespace namespace
 	tickerDuration := 500 * time.Millisecond
 	progressTicker := time.NewTicker(tickerDuration)
 	defer progressTicker.Stop()
+	// Whatever the speed checks, an upload that stops altogether is given up on
+	stallTicker := time.NewTicker(uploadStallTimeout)
+	defer stallTicker.Stop()
+	var lastKnownWritten int64
 
 	// Do the select on a ticker, and the writeChan
 Loop:
@@ -930,18 +1211,14 @@ Loop:
 				progressBar.EwmaSetCurrent(reader.BytesComplete(), tickerDuration)
 			}
 
-		case <-t.C:
-			// If we are not making any progress, if we haven't written 1MB in the last 5 seconds
+		case <-stallTicker.C:
 			currentRead := reader.BytesComplete()
-			log.Debugln("Current read:", currentRead)
-			log.Debugln("Last known written:", lastKnownWritten)
 			if lastKnownWritten < currentRead {
-				// We have made progress!
 				lastKnownWritten = currentRead
 			} else {
-				// No progress has been made in the last 1 second
-				log.Errorln("No progress made in last 5 second in upload")
-				lastError = errors.New("upload cancelled, no progress in 5 seconds")
+				log.Errorln("No progress made in last", uploadStallTimeout, "in upload")
+				cancel()
+				lastError = &StoppedTransferError{Err: "upload cancelled, no progress in " + uploadStallTimeout.String()}
 				break Loop
 			}
 
@@ -949,6 +1226,7 @@ Loop:
 			// The file has been closed, we're done here
 			log.Debugln("File closed")
 		case response := <-responseChan:
+			putResponse = response
 			if response.StatusCode != 200 {
 				log.Errorln("Got failure status code:", response.StatusCode)
 				lastError = &HttpErrResp{response.StatusCode, fmt.Sprintf("Request failed (HTTP status %d)",
@@ -964,19 +1242,64 @@ Loop:
 
 		}
 	}
+	close(watchDone)
+	// If the watchdog gave up on the upload, that's the more useful error
+	if slowErr := <-watchErr; slowErr != nil && lastError != nil {
+		log.Errorln("Cancelled upload:", slowErr)
+		lastError = slowErr
+	}
 
-	if fileInfo.Size() == 0 {
-		return 0, lastError
-	} else {
-		return reader.BytesComplete(), lastError
+	var verified []ChecksumInfo
+	if lastError == nil && checksummer != nil {
+		verified, lastError = verifyUpload(ctx, dest, token, putResponse, checksummer.checksums(), checksumRequired)
+	}
+
+	var uploaded int64
+	if ioreader != nil {
+		uploaded = reader.BytesComplete()
 	}
+	tc.recordAttempt(TransferAttempt{
+		Server:           dest.Host,
+		Proxy:            IsProxyEnabled(),
+		Start:            start,
+		End:              time.Now(),
+		TimeToFirstByte:  timeToFirstByte(),
+		TransferredBytes: uploaded,
+		Error:            lastError,
+	})
+	return uploaded, verified, lastError
+
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
 
+// Compare the checksums of what we sent against what the origin reports it
+// received, either in response to the upload or when asked afterward
+func verifyUpload(ctx context.Context, dest *url.URL, token string, putResponse *http.Response, computed []ChecksumInfo, required bool) ([]ChecksumInfo, error) {
+	var expected map[ChecksumType][]byte
+	if putResponse != nil {
+		expected = parseDigests(putResponse.Header)
+	}
+	if len(expected) == 0 {
+		info, err := headObject(ctx, []TransferDetails{{Url: *dest, Proxy: true}}, token)
+		if err != nil {
+			if required {
+				return nil, errors.Wrap(err, "Failed to get the checksum of the uploaded object")
+			}
+			log.Debugln("Unable to get the checksum of the uploaded object:", err)
+			return nil, nil
+		}
+		expected = info.digests
+	}
+	return compareChecksums(computed, expected, required)
 }
 
 // Actually perform the Put request to the server
-func doPut(request *http.Request, responseChan chan<- *http.Response, errorChan chan<- error) {
-	var UploadClient = &http.Client{Transport: config.GetTransport()}
-	client := UploadClient
+func doPut(transport http.RoundTripper, request *http.Request, responseChan chan<- *http.Response, errorChan chan<- error) {
+	client := &http.Client{Transport: transport}
 	dump, _ := httputil.DumpRequestOut(request, false)
 	log.Debugf("Dumping request: %s", dump)
 	response, err := client.Do(request)
@@ -1002,33 +1325,36 @@ func doPut(request *http.Request, responseChan chan<- *http.Response, errorChan
 
 }
 
-func walkDavDir(url *url.URL, namespace namespaces.Namespace, token string, destPath string, upload bool) ([]string, error) {
-
-	// Create the client to walk the filesystem
-	rootUrl := *url
-	if namespace.DirListHost != "" {
-		// Parse the dir list host
-		dirListURL, err := url.Parse(namespace.DirListHost)
-		if err != nil {
-			log.Errorln("Failed to parse dirlisthost from namespaces into URL:", err)
-			return nil, err
-		}
-		rootUrl = *dirListURL
-
-	} else {
+// Create a WebDAV client for the namespace's directory listing host
+func newDirListClient(ctx context.Context, namespace namespaces.Namespace, token string) (*gowebdav.Client, error) {
+	if namespace.DirListHost == "" {
 		log.Errorln("Host for directory listings is unknown")
 		return nil, errors.New("Host for directory listings is unknown")
 	}
+	// Parse the dir list host
+	rootUrl, err := url.Parse(namespace.DirListHost)
+	if err != nil {
+		log.Errorln("Failed to parse dirlisthost from namespaces into URL:", err)
+		return nil, err
+	}
 	log.Debugln("Dir list host: ", rootUrl.String())
 
 	auth := &bearerAuth{token: token}
 	c := gowebdav.NewAuthClient(rootUrl.String(), auth)
 
 	// XRootD does not like keep alives and kills things, so turn them off.
-	transport := config.GetTransport()
-	c.SetTransport(transport)
+	c.SetTransport(transferClientFromContext(ctx).engine.getTransport(true))
+	return c, nil
+}
+
+func walkDavDir(ctx context.Context, url *url.URL, namespace namespaces.Namespace, token string, destPath string, upload bool) ([]string, error) {
+
+	// Create the client to walk the filesystem
+	c, err := newDirListClient(ctx, namespace, token)
+	if err != nil {
+		return nil, err
+	}
 	var files []string
-	var err error
 	if upload {
 		files, err = walkDirUpload(url.Path, c, destPath)
 	} else {
@@ -1096,8 +1422,13 @@ func walkDir(path string, client *gowebdav.Client) ([]string, error) {
 }
 
 func StatHttp(dest *url.URL, namespace namespaces.Namespace) (uint64, error) {
+	return statHttp(context.Background(), dest, namespace)
+}
+
+// Stat the object at dest with the token of the client in ctx
+func statHttp(ctx context.Context, dest *url.URL, namespace namespaces.Namespace) (uint64, error) {
 
-	scitoken_contents, err := getToken(dest, namespace, false, "")
+	scitoken_contents, err := getToken(ctx, dest, namespace, false, "")
 	if err != nil {
 		return 0, err
 	}
@@ -342,7 +342,7 @@ func TestUploadZeroLengthFile(t *testing.T) {
 	request.Header.Set("Authorization", "Bearer test")
 	errorChan := make(chan error, 1)
 	responseChan := make(chan *http.Response)
-	go doPut(request, responseChan, errorChan)
+	go doPut(config.GetTransport(), request, responseChan, errorChan)
 	select {
 	case err := <-errorChan:
 		assert.NoError(t, err)
@@ -372,7 +372,7 @@ func TestFailedUpload(t *testing.T) {
 	request.Header.Set("Authorization", "Bearer test")
 	errorChan := make(chan error, 1)
 	responseChan := make(chan *http.Response)
-	go doPut(request, responseChan, errorChan)
+	go doPut(config.GetTransport(), request, responseChan, errorChan)
 	select {
 	case err := <-errorChan:
 		assert.Error(t, err)
@@ -527,7 +527,8 @@ func TestFullUpload(t *testing.T) {
 		_, err = tempToken.WriteString(token)
 		assert.NoError(t, err, "Error writing to temp token file")
 		tempToken.Close()
-		ObjectClientOptions.Token = tempToken.Name()
+		tc, err := NewTransferEngine(ctx).NewClient(WithTokenLocation(tempToken.Name()))
+		require.NoError(t, err)
 
 		// Upload the file
 		tempPath := tempFile.Name()
@@ -535,19 +536,18 @@ func TestFullUpload(t *testing.T) {
 		uploadURL := "stash:///test/" + fileName
 
 		methods := []string{"http"}
-		uploaded, err := DoStashCPSingle(tempFile.Name(), uploadURL, methods, false)
+		uploaded, err := tc.Transfer(ctx, tempFile.Name(), uploadURL, methods, false)
 		assert.NoError(t, err, "Error uploading file")
 		assert.Equal(t, int64(len(testFileContent)), uploaded, "Uploaded file size does not match")
 
 		// Upload an osdf file
 		uploadURL = "osdf:///test/stuff/blah.txt"
 		assert.NoError(t, err, "Error parsing upload URL")
-		uploaded, err = DoStashCPSingle(tempFile.Name(), uploadURL, methods, false)
+		uploaded, err = tc.Transfer(ctx, tempFile.Name(), uploadURL, methods, false)
 		assert.NoError(t, err, "Error uploading file")
 		assert.Equal(t, int64(len(testFileContent)), uploaded, "Uploaded file size does not match")
 	})
 	t.Cleanup(func() {
-		ObjectClientOptions.Token = ""
 		os.RemoveAll(tmpPath)
 		os.RemoveAll(originDir)
 	})
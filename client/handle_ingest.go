@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path"
@@ -53,6 +54,12 @@ func generate_destination(filePath string, originPrefix string, shadowOriginPref
 }
 
 func DoShadowIngest(sourceFile string, originPrefix string, shadowOriginPrefix string) (int64, string, error) {
+	return defaultTransferClient().ShadowIngest(context.Background(), sourceFile, originPrefix, shadowOriginPrefix)
+}
+
+// Upload the file to the shadow origin, unless an upload of it is already
+// there or in progress, returning the shadow URL
+func (tc *TransferClient) ShadowIngest(ctx context.Context, sourceFile string, originPrefix string, shadowOriginPrefix string) (int64, string, error) {
 	// After each transfer attempt, we'll check to see if the local file was modified.  If so, we'll re-upload.
 	for idx := 0; idx < 10; idx++ {
 		shadowFile, localSize, err := generate_destination(sourceFile, originPrefix, shadowOriginPrefix)
@@ -67,7 +74,7 @@ func DoShadowIngest(sourceFile string, originPrefix string, shadowOriginPrefix s
 		startTime := lastUpdateTime
 		maxRuntime := float64(localSize/10*1024*1024) + 300
 		for {
-			remoteSize, err := CheckOSDF(shadowFile, methods)
+			remoteSize, err := checkOSDF(ctx, shadowFile, methods)
 			if httpErr, ok := err.(*HttpErrResp); ok {
 				if httpErr.Code == 404 {
 					break
@@ -103,7 +110,7 @@ func DoShadowIngest(sourceFile string, originPrefix string, shadowOriginPrefix s
 			time.Sleep(5 * time.Second)
 		}
 
-		uploadBytes, err := DoStashCPSingle(sourceFile, shadowFile, methods, false)
+		uploadBytes, err := tc.Transfer(ctx, sourceFile, shadowFile, methods, false)
 		if err != nil {
 			return 0, "", err
 		}
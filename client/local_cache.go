@@ -0,0 +1,460 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pelicanplatform/pelican/param"
+)
+
+// Each cached object is kept as a data file, named for the hash of its key,
+// alongside a metadata file with this suffix
+const localCacheMetaSuffix = ".json"
+
+type (
+	// LocalCache is a persistent cache of downloaded objects on the local
+	// disk, which can be shared by the processes on a host. Entries are
+	// revalidated with the federation before every use, so objects that have
+	// changed (or that the user may no longer read) are never served from it.
+	LocalCache struct {
+		dir string
+		// The size, in bytes, the cache is trimmed back to after each addition
+		maxSize int64
+	}
+
+	// LocalCacheEntry describes an object in the local cache
+	LocalCacheEntry struct {
+		// The director the object was found through, so objects at the same
+		// path in different federations are kept apart
+		DirectorUrl  string `json:"directorUrl"`
+		ObjectPath   string `json:"objectPath"`
+		Size         int64  `json:"size"`
+		ETag         string `json:"etag,omitempty"`
+		LastModified string `json:"lastModified,omitempty"`
+		// The checksums verified when the object was downloaded
+		Checksums []ChecksumInfo `json:"checksums,omitempty"`
+		Added     time.Time      `json:"added"`
+		LastUsed  time.Time      `json:"lastUsed"`
+		// The modification time of the data file when it was added; if it has
+		// changed, the data can't be trusted
+		DataModTime time.Time `json:"dataModTime"`
+
+		id string
+	}
+)
+
+// NewLocalCache opens (creating it if needed) the local cache in dir, which
+// is kept to at most maxSize bytes
+func NewLocalCache(dir string, maxSize int64) (*LocalCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Failed to create the local cache directory")
+	}
+	return &LocalCache{dir: dir, maxSize: maxSize}, nil
+}
+
+// ConfiguredLocalCache opens the local cache given by Client.LocalCacheLocation
+// and Client.LocalCacheSize, or returns nil if there isn't one configured
+func ConfiguredLocalCache() (*LocalCache, error) {
+	dir := param.Client_LocalCacheLocation.GetString()
+	if dir == "" {
+		return nil, nil
+	}
+	return NewLocalCache(dir, int64(param.Client_LocalCacheSize.GetInt()))
+}
+
+// The configured local cache for the transfer clients, which do without one
+// if it can't be opened
+func configuredLocalCache() *LocalCache {
+	lc, err := ConfiguredLocalCache()
+	if err != nil {
+		log.Warningln("Not using the local cache:", err)
+		return nil
+	}
+	return lc
+}
+
+func localCacheId(directorUrl string, objectPath string) string {
+	sum := sha256.Sum256([]byte(directorUrl + "\n" + objectPath))
+	return hex.EncodeToString(sum[:])
+}
+
+// Entries are spread over subdirectories so none of them gets too large
+func (lc *LocalCache) dataPath(id string) string {
+	return filepath.Join(lc.dir, id[:2], id)
+}
+
+func (lc *LocalCache) metaPath(id string) string {
+	return lc.dataPath(id) + localCacheMetaSuffix
+}
+
+func (lc *LocalCache) readEntry(metaFile string) (*LocalCacheEntry, error) {
+	contents, err := os.ReadFile(metaFile)
+	if err != nil {
+		return nil, err
+	}
+	entry := &LocalCacheEntry{}
+	if err = json.Unmarshal(contents, entry); err != nil {
+		return nil, errors.Wrapf(err, "Corrupt local cache entry %s", metaFile)
+	}
+	entry.id = strings.TrimSuffix(filepath.Base(metaFile), localCacheMetaSuffix)
+	return entry, nil
+}
+
+// Write the entry's metadata atomically, so other processes never see it half-written
+func (lc *LocalCache) writeEntry(entry *LocalCacheEntry) error {
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmpFile := lc.metaPath(entry.id) + ".tmp"
+	if err = os.WriteFile(tmpFile, contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, lc.metaPath(entry.id))
+}
+
+// Find the entry for an object, checking that its data is intact
+func (lc *LocalCache) lookup(directorUrl string, objectPath string) *LocalCacheEntry {
+	id := localCacheId(directorUrl, objectPath)
+	entry, err := lc.readEntry(lc.metaPath(id))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debugln("Ignoring local cache entry:", err)
+		}
+		return nil
+	}
+	info, err := os.Stat(lc.dataPath(id))
+	if err != nil || info.Size() != entry.Size || !info.ModTime().Equal(entry.DataModTime) {
+		log.Debugf("The local cache's copy of %s has been modified; removing it", objectPath)
+		lc.Remove(*entry)
+		return nil
+	}
+	return entry
+}
+
+// Entries lists the objects in the cache, most recently used first
+func (lc *LocalCache) Entries() ([]LocalCacheEntry, error) {
+	var entries []LocalCacheEntry
+	err := filepath.WalkDir(lc.dir, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(walkPath, localCacheMetaSuffix) {
+			return nil
+		}
+		entry, err := lc.readEntry(walkPath)
+		if err != nil {
+			// Another process may have just removed it
+			if !os.IsNotExist(err) {
+				log.Warningln(err)
+			}
+			return nil
+		}
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list the local cache")
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	return entries, nil
+}
+
+// Remove an entry from the cache
+func (lc *LocalCache) Remove(entry LocalCacheEntry) {
+	for _, file := range []string{lc.metaPath(entry.id), lc.dataPath(entry.id)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Warningf("Failed to remove %s from the local cache: %v", file, err)
+		}
+	}
+}
+
+// GC removes the least recently used entries until the cache is no larger
+// than maxSize bytes, returning how many entries were removed and the space freed
+func (lc *LocalCache) GC(maxSize int64) (removed int, freed int64, err error) {
+	entries, err := lc.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	for idx := len(entries) - 1; idx >= 0 && total > maxSize; idx-- {
+		lc.Remove(entries[idx])
+		total -= entries[idx].Size
+		freed += entries[idx].Size
+		removed++
+	}
+	return removed, freed, nil
+}
+
+// Add a downloaded file to the cache, replacing any older copy of the object
+func (lc *LocalCache) store(directorUrl string, objectPath string, localFile string, info objectInfo, checksums []ChecksumInfo) error {
+	if info.etag == "" && info.lastModified == "" {
+		log.Debugf("Not caching %s locally; the server gave no way to validate it", objectPath)
+		return nil
+	}
+	if info.size > lc.maxSize {
+		return nil
+	}
+	entry := &LocalCacheEntry{
+		DirectorUrl:  directorUrl,
+		ObjectPath:   objectPath,
+		Size:         info.size,
+		ETag:         info.etag,
+		LastModified: info.lastModified,
+		Checksums:    checksums,
+		Added:        time.Now(),
+		id:           localCacheId(directorUrl, objectPath),
+	}
+	entry.LastUsed = entry.Added
+	dataFile := lc.dataPath(entry.id)
+	if err := os.MkdirAll(filepath.Dir(dataFile), 0755); err != nil {
+		return err
+	}
+	tmpFile := dataFile + ".tmp"
+	if err := cloneOrCopyFile(localFile, tmpFile); err != nil {
+		return err
+	}
+	// Discourage writes to the cached data
+	if err := os.Chmod(tmpFile, 0444); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, dataFile); err != nil {
+		return err
+	}
+	stat, err := os.Stat(dataFile)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != info.size {
+		lc.Remove(*entry)
+		return errors.Errorf("the download of %s is %d bytes but the server reported %d", objectPath, stat.Size(), info.size)
+	}
+	entry.DataModTime = stat.ModTime()
+	if err = lc.writeEntry(entry); err != nil {
+		return err
+	}
+	if _, _, err = lc.GC(lc.maxSize); err != nil {
+		log.Warningln("Failed to trim the local cache:", err)
+	}
+	return nil
+}
+
+// Put a copy of a cached object at dest: a reflink where the filesystem
+// supports them, falling back to copying the data. The copy is the user's to
+// change, just like a download, so it's never a hard link to the cache.
+func (lc *LocalCache) materialize(entry *LocalCacheEntry, dest string) error {
+	dataFile := lc.dataPath(entry.id)
+	tmpDest := partialPath(dest)
+	if err := os.Remove(tmpDest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := cloneOrCopyFile(dataFile, tmpDest); err != nil {
+		return err
+	}
+	return os.Rename(tmpDest, dest)
+}
+
+// Note a use of the entry, for the least-recently-used eviction
+func (lc *LocalCache) touch(entry *LocalCacheEntry) {
+	entry.LastUsed = time.Now()
+	if err := lc.writeEntry(entry); err != nil {
+		log.Debugln("Failed to update the local cache entry:", err)
+	}
+}
+
+func cloneOrCopyFile(src string, dest string) error {
+	if err := reflinkFile(src, dest); err == nil {
+		return nil
+	}
+	return copyFile(src, dest)
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Ask the sources, in order, whether the cached copy of the object is still
+// current, until one of them answers. The object can't be used if none do.
+func validateLocalCacheEntry(ctx context.Context, sources []TransferDetails, token string, entry *LocalCacheEntry) bool {
+	for _, source := range sources {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, source.Url.String(), nil)
+		if err != nil {
+			return false
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+		resp, err := sourceClient(ctx, source).Do(req)
+		if err != nil {
+			log.Debugf("Failed to validate the local cache's copy of %s with %s: %v", entry.ObjectPath, source.Url.Host, err)
+			continue
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotModified:
+			return true
+		case http.StatusOK:
+			// Not every server handles conditional requests
+			info := objectInfoFromResponse(resp, resp.ContentLength)
+			if info.size >= 0 && info.size != entry.Size {
+				return false
+			}
+			if entry.ETag != "" && info.etag != "" {
+				return entry.ETag == info.etag
+			}
+			return entry.LastModified != "" && entry.LastModified == info.lastModified
+		default:
+			// Including authorization failures, which mean the user may no
+			// longer read the object
+			log.Debugf("Validating the local cache's copy of %s with %s failed with HTTP status %d", entry.ObjectPath, source.Url.Host, resp.StatusCode)
+			return false
+		}
+	}
+	return false
+}
+
+// Satisfy the download of objectPath to dest from the local cache, if it
+// holds a copy that is still current
+func (tc *TransferClient) downloadFromLocalCache(ctx context.Context, transfers []TransferDetails, objectPath string, dest string, token string) (int64, bool) {
+	lc := tc.localCache
+	if lc == nil || len(transfers) == 0 || transfers[0].PackOption != "" {
+		return 0, false
+	}
+	entry := lc.lookup(tc.directorUrl, objectPath)
+	if entry == nil {
+		return 0, false
+	}
+	start := time.Now()
+	if !validateLocalCacheEntry(ctx, transfers, token, entry) {
+		log.Debugf("The local cache's copy of %s is out of date", objectPath)
+		lc.Remove(*entry)
+		return 0, false
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = path.Join(dest, path.Base(objectPath))
+	}
+	if err := lc.materialize(entry, dest); err != nil {
+		log.Warningf("Failed to copy %s from the local cache: %v", objectPath, err)
+		return 0, false
+	}
+	lc.touch(entry)
+	checksums, err := cachedChecksums(ctx, entry, dest)
+	if err != nil {
+		log.Warningf("Failed to checksum the local cache's copy of %s: %v", objectPath, err)
+		return 0, false
+	}
+	tc.recordChecksums(dest, checksums)
+	log.Debugf("Used the local cache's copy of %s", objectPath)
+	tc.recordAttempt(TransferAttempt{Server: "local-cache", Start: start, End: time.Now(), TransferredBytes: entry.Size})
+	return entry.Size, true
+}
+
+// The checksums of a cached object: those verified when it was downloaded,
+// plus any others that were asked for, computed from the copy at dest
+func cachedChecksums(ctx context.Context, entry *LocalCacheEntry, dest string) ([]ChecksumInfo, error) {
+	checksumTypes, required := requestedChecksums(ctx)
+	if !required {
+		return entry.Checksums, nil
+	}
+	var checksums []ChecksumInfo
+	var missing []ChecksumType
+	for _, checksumType := range checksumTypes {
+		found := false
+		for _, checksum := range entry.Checksums {
+			if checksum.Algorithm == checksumType {
+				checksums = append(checksums, checksum)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, checksumType)
+		}
+	}
+	if len(missing) == 0 {
+		return checksums, nil
+	}
+	file, err := os.Open(dest)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cw := newChecksumWriter(missing)
+	if _, err = io.Copy(cw, file); err != nil {
+		return nil, err
+	}
+	return append(checksums, cw.checksums()...), nil
+}
+
+// Add a completed download to the local cache
+func (tc *TransferClient) addToLocalCache(transfers []TransferDetails, objectPath string, dest string) {
+	lc := tc.localCache
+	if lc == nil || len(transfers) == 0 || transfers[0].PackOption != "" {
+		return
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = path.Join(dest, path.Base(objectPath))
+	}
+	info, ok := tc.downloadedObject(dest)
+	if !ok {
+		log.Debugf("Not caching %s locally; its validators weren't recorded with the download", objectPath)
+		return
+	}
+	if err := lc.store(tc.directorUrl, objectPath, dest, info, tc.verifiedChecksums(dest)); err != nil {
+		log.Warningf("Failed to add %s to the local cache: %v", objectPath, err)
+	}
+}
@@ -0,0 +1,202 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCacheDownload(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		contents = "version one"
+		etag     = `"v1"`
+		gets     atomic.Int32
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if objectPath, found := strings.CutPrefix(r.URL.Path, "/director"); found {
+			w.Header().Set("Link", "<"+server.URL+">; rel=\"duplicate\"; pri=1")
+			w.Header().Set("X-Pelican-Namespace", "namespace=/test, require-token=false")
+			w.Header().Set("Location", server.URL+objectPath)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		// ServeContent answers conditional requests from the ETag
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Digest", "crc32c="+hex.EncodeToString(crc32cOf(contents)))
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(contents))
+	}))
+	defer server.Close()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("Federation.DirectorUrl", server.URL+"/director")
+	cacheDir := t.TempDir()
+	viper.Set("Client.LocalCacheLocation", cacheDir)
+	viper.Set("Client.LocalCacheSize", 1024)
+	dest := t.TempDir()
+
+	download := func(name string) string {
+		_, err := DoGet("osdf:///test/object", filepath.Join(dest, name), false)
+		require.NoError(t, err)
+		downloaded, err := os.ReadFile(filepath.Join(dest, name))
+		require.NoError(t, err)
+		return string(downloaded)
+	}
+
+	assert.Equal(t, "version one", download("first"))
+	assert.Equal(t, int32(1), gets.Load())
+
+	// The second download is served from the local cache, after validation
+	assert.Equal(t, "version one", download("second"))
+	assert.Equal(t, int32(1), gets.Load())
+
+	// The copy from the cache is as much the user's as a download, and
+	// changing it leaves the cache alone
+	downloaded, err := os.Stat(filepath.Join(dest, "first"))
+	require.NoError(t, err)
+	cached, err := os.Stat(filepath.Join(dest, "second"))
+	require.NoError(t, err)
+	assert.Equal(t, downloaded.Mode(), cached.Mode())
+	assert.NotZero(t, cached.Mode()&0200)
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "second"), []byte("scribbled on"), 0644))
+	assert.Equal(t, "version one", download("third"))
+	assert.Equal(t, int32(1), gets.Load())
+
+	lc, err := ConfiguredLocalCache()
+	require.NoError(t, err)
+	entries, err := lc.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/test/object", entries[0].ObjectPath)
+	assert.Equal(t, `"v1"`, entries[0].ETag)
+	assert.Equal(t, int64(len("version one")), entries[0].Size)
+
+	// Cache hits report checksums like downloads do, computing any that
+	// weren't verified when the object was cached
+	te := NewTransferEngine(context.Background())
+	defer te.Close()
+	tc, err := te.NewClient(WithChecksums(ChecksumCRC32C, ChecksumMD5))
+	require.NoError(t, err)
+	result, err := tc.Get(context.Background(), "osdf:///test/object", filepath.Join(dest, "checksummed"), false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), gets.Load())
+	md5Sum := md5.Sum([]byte("version one"))
+	assert.Equal(t, []ChecksumInfo{
+		{Algorithm: ChecksumCRC32C, Value: crc32cOf("version one")},
+		{Algorithm: ChecksumMD5, Value: md5Sum[:]},
+	}, result.Checksums)
+
+	// Once the object changes, it's downloaded again
+	mutex.Lock()
+	contents = "version two!"
+	etag = `"v2"`
+	mutex.Unlock()
+	assert.Equal(t, "version two!", download("fourth"))
+	assert.Equal(t, int32(2), gets.Load())
+	assert.Equal(t, "version two!", download("fifth"))
+	assert.Equal(t, int32(2), gets.Load())
+
+	// Nor is a cached copy used if it has been modified
+	dataFile := lc.dataPath(localCacheId(server.URL+"/director", "/test/object"))
+	require.NoError(t, os.Chmod(dataFile, 0644))
+	require.NoError(t, os.WriteFile(dataFile, []byte("scribbled on"), 0644))
+	assert.Equal(t, "version two!", download("sixth"))
+	assert.Equal(t, int32(3), gets.Load())
+}
+
+func crc32cOf(contents string) []byte {
+	return binary.BigEndian.AppendUint32(nil, crc32.Checksum([]byte(contents), crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func TestLocalCacheGC(t *testing.T) {
+	lc, err := NewLocalCache(t.TempDir(), 25)
+	require.NoError(t, err)
+	srcDir := t.TempDir()
+
+	add := func(name string) {
+		localFile := filepath.Join(srcDir, name)
+		require.NoError(t, os.WriteFile(localFile, []byte("0123456789"), 0644))
+		require.NoError(t, lc.store("https://director", "/test/"+name, localFile, objectInfo{size: 10, etag: `"` + name + `"`}, nil))
+	}
+	names := func() []string {
+		entries, err := lc.Entries()
+		require.NoError(t, err)
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.ObjectPath)
+		}
+		return result
+	}
+
+	add("a")
+	add("b")
+	time.Sleep(10 * time.Millisecond)
+	entry := lc.lookup("https://director", "/test/a")
+	require.NotNil(t, entry)
+	lc.touch(entry)
+
+	// Only two objects fit, so the least recently used one goes
+	time.Sleep(10 * time.Millisecond)
+	add("c")
+	assert.Equal(t, []string{"/test/c", "/test/a"}, names())
+
+	// Objects with nothing to validate them with aren't kept
+	localFile := filepath.Join(srcDir, "d")
+	require.NoError(t, os.WriteFile(localFile, []byte("0123456789"), 0644))
+	require.NoError(t, lc.store("https://director", "/test/d", localFile, objectInfo{size: 10}, nil))
+	assert.Nil(t, lc.lookup("https://director", "/test/d"))
+
+	// The same path in another federation is a different object
+	assert.Nil(t, lc.lookup("https://other-director", "/test/a"))
+
+	dest := filepath.Join(t.TempDir(), "copy")
+	require.NoError(t, lc.materialize(lc.lookup("https://director", "/test/a"), dest))
+	contents, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(contents))
+
+	removed, freed, err := lc.GC(0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, int64(20), freed)
+	assert.Empty(t, names())
+}
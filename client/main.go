@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -35,7 +36,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"time"
 
 	// "crypto/sha1"
 	// "encoding/hex"
@@ -45,8 +45,6 @@ import (
 
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/namespaces"
-	"github.com/pelicanplatform/pelican/param"
-	"github.com/spf13/viper"
 )
 
 type OptionsStruct struct {
@@ -54,27 +52,23 @@ type OptionsStruct struct {
 	Recursive    bool
 	Plugin       bool
 	Token        string
-	Version      string
+	// Checksums that must be verified for each transfer. If empty, any
+	// checksums the server offers are verified.
+	Checksums []ChecksumType
+	// The most bytes per second each transfer may use, or 0 for no limit.
+	// All transfers together are limited by Client.MaxBandwidth.
+	LimitRate int64
 }
 
-var ObjectClientOptions OptionsStruct
-
 var (
 	version string
 )
 
-// Nearest cache
-var NearestCache string
-
-// List of caches, in order from closest to furthest
-var NearestCacheList []string
 var CachesJsonLocation string
 
-// Number of caches to attempt to use in any invocation
-var CachesToTry int = 3
-
-// CacheOverride
-var CacheOverride bool
+// Number of caches to attempt to use in any invocation, unless the client
+// says otherwise
+const DefaultCachesToTry = 3
 
 type payloadStruct struct {
 	filename     string
@@ -104,27 +98,44 @@ func getTokenName(destination *url.URL) (scheme, tokenName string) {
 }
 
 // Do writeback to stash using SciTokens
-func doWriteBack(source string, destination *url.URL, namespace namespaces.Namespace, recursive bool) (int64, error) {
+func doWriteBack(ctx context.Context, source string, destination *url.URL, namespace namespaces.Namespace, recursive bool) (int64, error) {
 
-	scitoken_contents, err := getToken(destination, namespace, true, "")
+	scitoken_contents, err := getToken(ctx, destination, namespace, true, "")
 	if err != nil {
 		return 0, err
 	}
 	if recursive {
-		return UploadDirectory(source, destination, scitoken_contents, namespace)
+		return uploadDirectory(ctx, source, destination, scitoken_contents, namespace)
 	} else {
-		return UploadFile(source, destination, scitoken_contents, namespace)
+		return uploadFile(ctx, source, destination, scitoken_contents, namespace)
 	}
 }
 
+// MissingTokenError is returned when a transfer requires a token that can't
+// be found or generated
+type MissingTokenError struct {
+	Err string
+}
+
+func (e *MissingTokenError) Error() string {
+	return e.Err
+}
+
 // getToken returns the token to use for the given destination
 //
 // If token_name is not empty, it will be used as the token name.
 // If token_name is empty, the token name will be determined from the destination URL (if possible) using getTokenName
-func getToken(destination *url.URL, namespace namespaces.Namespace, isWrite bool, token_name string) (string, error) {
+func getToken(ctx context.Context, destination *url.URL, namespace namespaces.Namespace, isWrite bool, token_name string) (string, error) {
+	tc := transferClientFromContext(ctx)
 	if token_name == "" {
 		_, token_name = getTokenName(destination)
 	}
+	if tc.tokenSource != nil {
+		token, err := tc.tokenSource(destination, isWrite)
+		if err != nil || token != "" {
+			return token, err
+		}
+	}
 
 	type tokenJson struct {
 		AccessKey string `json:"access_token"`
@@ -136,9 +147,9 @@ func getToken(destination *url.URL, namespace namespaces.Namespace, isWrite bool
 		to by the environment variable "_CONDOR_CREDS".
 	*/
 	var token_location string
-	if ObjectClientOptions.Token != "" {
-		token_location = ObjectClientOptions.Token
-		log.Debugln("Getting token location from command line:", ObjectClientOptions.Token)
+	if tc.options.Token != "" {
+		token_location = tc.options.Token
+		log.Debugln("Getting token location from command line:", tc.options.Token)
 	} else {
 
 		// WLCG Token Discovery
@@ -185,7 +196,7 @@ func getToken(destination *url.URL, namespace namespaces.Namespace, isWrite bool
 		}
 
 		if token_location == "" {
-			if !ObjectClientOptions.Plugin {
+			if !tc.options.Plugin {
 				opts := config.TokenGenerationOpts{Operation: config.TokenSharedRead}
 				if isWrite {
 					opts.Operation = config.TokenSharedWrite
@@ -196,13 +207,13 @@ func getToken(destination *url.URL, namespace namespaces.Namespace, isWrite bool
 				}
 				log.Errorln("Failed to generate a new authorization token for this transfer: ", err)
 				log.Errorln("This transfer requires authorization to complete and no token is available")
-				err = errors.New("failed to find or generate a token as required for " + destination.String())
-				AddError(err)
+				err = &MissingTokenError{"failed to find or generate a token as required for " + destination.String()}
+				tc.errors.add(err)
 				return "", err
 			} else {
 				log.Errorln("Credential is required, but currently mssing")
-				err := errors.New("Credential is required for " + destination.String() + " but is currently missing")
-				AddError(err)
+				err := &MissingTokenError{"Credential is required for " + destination.String() + " but is currently missing"}
+				tc.errors.add(err)
 				return "", err
 			}
 		}
@@ -227,6 +238,11 @@ func getToken(destination *url.URL, namespace namespaces.Namespace, isWrite bool
 
 // Check the size of a remote file in an origin
 func CheckOSDF(destination string, methods []string) (remoteSize uint64, err error) {
+	return checkOSDF(context.Background(), destination, methods)
+}
+
+// Check the size of a remote file in an origin with the client of ctx
+func checkOSDF(ctx context.Context, destination string, methods []string) (remoteSize uint64, err error) {
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -263,9 +279,8 @@ func CheckOSDF(destination string, methods []string) (remoteSize uint64, err err
 		federationUrl, _ := url.Parse(dest_uri.String())
 		federationUrl.Scheme = "https"
 		federationUrl.Path = ""
-		viper.Set("Federation.DiscoveryUrl", federationUrl.String())
-		err = config.DiscoverFederation()
-		if err != nil {
+		// Make sure the federation exists, without changing the configured one
+		if _, err = config.DiscoverUrlFederation(federationUrl.String()); err != nil {
 			return 0, err
 		}
 	}
@@ -280,7 +295,7 @@ func CheckOSDF(destination string, methods []string) (remoteSize uint64, err err
 		switch method {
 		case "http":
 			log.Info("Trying HTTP...")
-			if remoteSize, err = StatHttp(dest_uri, ns); err == nil {
+			if remoteSize, err = statHttp(ctx, dest_uri, ns); err == nil {
 				return remoteSize, nil
 			}
 		default:
@@ -299,7 +314,7 @@ func GetCacheHostnames(testFile string) (urls []string, err error) {
 		return
 	}
 
-	caches, err := GetCachesFromNamespace(ns, false)
+	caches, err := defaultTransferClient().cachesForNamespace(ns, false)
 	if err != nil {
 		return
 	}
@@ -317,15 +332,22 @@ func GetCacheHostnames(testFile string) (urls []string, err error) {
 	return
 }
 
+// Get the caches to try for an object in the namespace, in order, as the
+// package-level functions would
 func GetCachesFromNamespace(namespace namespaces.Namespace, useDirector bool) (caches []CacheInterface, err error) {
+	return defaultTransferClient().cachesForNamespace(namespace, useDirector)
+}
+
+// Get the caches the client should try for an object in the namespace, in order
+func (tc *TransferClient) cachesForNamespace(namespace namespaces.Namespace, useDirector bool) (caches []CacheInterface, err error) {
 
-	// The global cache override is set
-	if CacheOverride {
-		log.Debugf("Using the cache (%s) from the config override\n", NearestCache)
+	// The client's cache override is set
+	if tc.cache != "" {
+		log.Debugf("Using the cache (%s) from the override\n", tc.cache)
 		cache := namespaces.Cache{
-			Endpoint:     NearestCache,
-			AuthEndpoint: NearestCache,
-			Resource:     NearestCache,
+			Endpoint:     tc.cache,
+			AuthEndpoint: tc.cache,
+			Resource:     tc.cache,
 		}
 		caches = []CacheInterface{cache}
 		return
@@ -341,23 +363,20 @@ func GetCachesFromNamespace(namespace namespaces.Namespace, useDirector bool) (c
 		return
 	}
 
-	if len(NearestCacheList) == 0 {
-		cacheListName := "xroot"
-		if namespace.ReadHTTPS || namespace.UseTokenOnRead {
-			cacheListName = "xroots"
-		}
-		// FIXME: GetBestCache, for some reason, sets the NearestCacheList global?
-		_, err = GetBestCache(cacheListName)
-		if err != nil {
-			log.Errorln("Failed to get best caches:", err)
-			return
-		}
+	cacheListName := "xroot"
+	if namespace.ReadHTTPS || namespace.UseTokenOnRead {
+		cacheListName = "xroots"
+	}
+	nearestCaches, err := tc.engine.getNearestCaches(cacheListName)
+	if err != nil {
+		log.Errorln("Failed to get best caches:", err)
+		return
 	}
 
-	log.Debugln("Nearest cache list:", NearestCacheList)
+	log.Debugln("Nearest cache list:", nearestCaches)
 	log.Debugln("Cache list name:", namespace.Caches)
 
-	matchedCaches := namespace.MatchCaches(NearestCacheList)
+	matchedCaches := namespace.MatchCaches(nearestCaches)
 	log.Debugln("Matched caches:", matchedCaches)
 	caches = make([]CacheInterface, len(matchedCaches))
 	for idx, val := range matchedCaches {
@@ -416,30 +435,35 @@ func discoverHTCondorToken(tokenName string) string {
 // Retrieve federation namespace information for a given URL.
 // If OSDFDirectorUrl is non-empty, then the namespace information will be pulled from the director;
 // otherwise, it is pulled from topology.
-func getNamespaceInfo(resourcePath, OSDFDirectorUrl string, isPut bool) (ns namespaces.Namespace, err error) {
+func getNamespaceInfo(ctx context.Context, resourcePath, OSDFDirectorUrl string, isPut bool) (ns namespaces.Namespace, err error) {
+	tc := transferClientFromContext(ctx)
 	// If we have a director set, go through that for namespace info, otherwise use topology
 	if OSDFDirectorUrl != "" {
-		log.Debugln("Will query director at", OSDFDirectorUrl, "for object", resourcePath)
 		verb := "GET"
 		if isPut {
 			verb = "PUT"
 		}
+		if cached, found := tc.engine.cachedNamespace(OSDFDirectorUrl, verb, resourcePath); found {
+			log.Debugln("Reusing the director's earlier response for namespace", cached.Path, "for object", resourcePath)
+			return cached, nil
+		}
+		log.Debugln("Will query director at", OSDFDirectorUrl, "for object", resourcePath)
 		var dirResp *http.Response
-		dirResp, err = queryDirector(verb, resourcePath, OSDFDirectorUrl)
+		dirResp, err = queryDirector(ctx, verb, resourcePath, OSDFDirectorUrl)
 		if err != nil {
 			if isPut && dirResp != nil && dirResp.StatusCode == 405 {
 				err = errors.New("Error 405: No writeable origins were found")
-				AddError(err)
+				tc.errors.add(err)
 				return
 			} else {
 				log.Errorln("Error while querying the Director:", err)
-				AddError(err)
+				tc.errors.add(err)
 				return
 			}
 		}
 		ns, err = CreateNsFromDirectorResp(dirResp)
 		if err != nil {
-			AddError(err)
+			tc.errors.add(err)
 			return
 		}
 
@@ -454,11 +478,16 @@ func getNamespaceInfo(resourcePath, OSDFDirectorUrl string, isPut bool) (ns name
 			}
 			ns.WriteBackHost = "https://" + writeBackUrl.Host
 		}
+		// With Director.EnableStat, the servers are ordered by which have the
+		// object, so the response is no good for any other
+		if dirResp.Header.Get("X-Pelican-Object-Specific") != "true" {
+			tc.engine.cacheNamespace(OSDFDirectorUrl, verb, resourcePath, ns)
+		}
 		return
 	} else {
 		ns, err = namespaces.MatchNamespace(resourcePath)
 		if err != nil {
-			AddError(err)
+			tc.errors.add(err)
 			return
 		}
 		return
@@ -473,231 +502,75 @@ remoteDestination: the end location of the upload
 recursive: a boolean indicating if the source is a directory or not
 */
 func DoPut(localObject string, remoteDestination string, recursive bool) (bytesTransferred int64, err error) {
-	isPut := true
-	// First, create a handler for any panics that occur
-	defer func() {
-		if r := recover(); r != nil {
-			log.Debugln("Panic captured while attempting to perform transfer (DoPut):", r)
-			log.Debugln("Panic caused by the following", string(debug.Stack()))
-			ret := fmt.Sprintf("Unrecoverable error (panic) captured in DoPut: %v", r)
-			err = errors.New(ret)
-			bytesTransferred = 0
-
-			// Attempt to add the panic to the error accumulator
-			AddError(errors.New(ret))
-		}
-	}()
-
-	// Parse the source and destination with URL parse
-	localObjectUrl, err := url.Parse(localObject)
-	if err != nil {
-		log.Errorln("Failed to parse source URL:", err)
-		return 0, err
-	}
-
-	remoteDestination, remoteDestScheme := correctURLWithUnderscore(remoteDestination)
-	remoteDestUrl, err := url.Parse(remoteDestination)
-	if err != nil {
-		log.Errorln("Failed to parse remote destination URL:", err)
-		return 0, err
-	}
-	remoteDestUrl.Scheme = remoteDestScheme
-
-	if remoteDestUrl.Host != "" {
-		if remoteDestUrl.Scheme == "osdf" || remoteDestUrl.Scheme == "stash" {
-			remoteDestUrl.Path, err = url.JoinPath(remoteDestUrl.Host, remoteDestUrl.Path)
-			if err != nil {
-				log.Errorln("Failed to join remote destination url path:", err)
-				return 0, err
-			}
-		} else if remoteDestUrl.Scheme == "pelican" {
-			federationUrl, _ := url.Parse(remoteDestUrl.String())
-			federationUrl.Scheme = "https"
-			federationUrl.Path = ""
-			viper.Set("Federation.DiscoveryUrl", federationUrl.String())
-			err = config.DiscoverFederation()
-			if err != nil {
-				return 0, err
-			}
-		}
-	}
-	remoteDestScheme, _ = getTokenName(remoteDestUrl)
-
-	understoodSchemes := []string{"file", "osdf", "pelican", ""}
-
-	_, foundDest := Find(understoodSchemes, remoteDestScheme)
-	if !foundDest {
-		return 0, fmt.Errorf("Do not understand the destination scheme: %s. Permitted values are %s",
-			remoteDestUrl.Scheme, strings.Join(understoodSchemes, ", "))
-	}
-
-	directorUrl := param.Federation_DirectorUrl.GetString()
-
-	// Get the namespace of the remote filesystem
-	// For write back, it will be the destination
-	if !strings.HasPrefix(remoteDestination, "/") {
-		remoteDestination = strings.TrimPrefix(remoteDestination, remoteDestScheme+"://")
-	}
-	ns, err := getNamespaceInfo(remoteDestination, directorUrl, isPut)
-	if err != nil {
-		log.Errorln(err)
-		return 0, errors.New("Failed to get namespace information from source")
-	}
-	uploadedBytes, err := doWriteBack(localObjectUrl.Path, remoteDestUrl, ns, recursive)
-	AddError(err)
-	return uploadedBytes, err
-
+	result, err := defaultTransferClient().Put(context.Background(), localObject, remoteDestination, recursive)
+	return result.TransferredBytes, err
 }
 
-/*
-	Start of transfer for pelican object get, gets information from the target source before doing our HTTP GET request
-
-remoteObject: the source file/directory you would like to upload
-localDestination: the end location of the upload
-recursive: a boolean indicating if the source is a directory or not
-*/
-func DoGet(remoteObject string, localDestination string, recursive bool) (bytesTransferred int64, err error) {
-	isPut := false
-	// First, create a handler for any panics that occur
-	defer func() {
-		if r := recover(); r != nil {
-			log.Debugln("Panic captured while attempting to perform transfer (DoGet):", r)
-			log.Debugln("Panic caused by the following", string(debug.Stack()))
-			ret := fmt.Sprintf("Unrecoverable error (panic) captured in DoGet: %v", r)
-			err = errors.New(ret)
-			bytesTransferred = 0
-
-			// Attempt to add the panic to the error accumulator
-			AddError(errors.New(ret))
-		}
-	}()
-
+// Parse a remote object given on the command line (an osdf://, stash:// or
+// pelican:// URL, or a bare path) and return its URL along with the object's
+// path in the federation
+func parseRemoteObject(remoteObject string) (remoteObjectUrl *url.URL, objectPath string, err error) {
 	// Parse the source with URL parse
 	remoteObject, remoteObjectScheme := correctURLWithUnderscore(remoteObject)
-	remoteObjectUrl, err := url.Parse(remoteObject)
+	remoteObjectUrl, err = url.Parse(remoteObject)
 	if err != nil {
 		log.Errorln("Failed to parse source URL:", err)
-		return 0, err
+		return nil, "", err
 	}
 	remoteObjectUrl.Scheme = remoteObjectScheme
 
-	// If there is a host specified, prepend it to the path in the osdf case
-	if remoteObjectUrl.Host != "" {
-		if remoteObjectUrl.Scheme == "osdf" {
-			remoteObjectUrl.Path, err = url.JoinPath(remoteObjectUrl.Host, remoteObjectUrl.Path)
-			if err != nil {
-				log.Errorln("Failed to join source url path:", err)
-				return 0, err
-			}
-		} else if remoteObjectUrl.Scheme == "pelican" {
-			federationUrl, _ := url.Parse(remoteObjectUrl.String())
-			federationUrl.Scheme = "https"
-			federationUrl.Path = ""
-			viper.Set("Federation.DiscoveryUrl", federationUrl.String())
-			err = config.DiscoverFederation()
-			if err != nil {
-				return 0, err
-			}
-		}
-	}
-
 	remoteObjectScheme, _ = getTokenName(remoteObjectUrl)
 
-	understoodSchemes := []string{"file", "osdf", "pelican", ""}
+	understoodSchemes := []string{"stash", "file", "osdf", "pelican", ""}
 
 	_, foundSource := Find(understoodSchemes, remoteObjectScheme)
 	if !foundSource {
-		return 0, fmt.Errorf("Do not understand the source scheme: %s. Permitted values are %s",
+		return nil, "", fmt.Errorf("Do not understand the source scheme: %s. Permitted values are %s",
 			remoteObjectUrl.Scheme, strings.Join(understoodSchemes, ", "))
 	}
 
-	if remoteObjectScheme == "osdf" || remoteObjectScheme == "pelican" {
-		remoteObject = remoteObjectUrl.Path
-	}
-
-	if string(remoteObject[0]) != "/" {
-		remoteObject = "/" + remoteObject
-	}
-
-	directorUrl := param.Federation_DirectorUrl.GetString()
-
-	ns, err := getNamespaceInfo(remoteObject, directorUrl, isPut)
-	if err != nil {
-		log.Errorln(err)
-		return 0, errors.New("Failed to get namespace information from source")
+	// If there is a host specified, prepend it to the path in the osdf case;
+	// for pelican:// URLs, it's the federation
+	if remoteObjectUrl.Host != "" && (remoteObjectScheme == "osdf" || remoteObjectScheme == "stash") {
+		remoteObjectUrl.Path, err = url.JoinPath(remoteObjectUrl.Host, remoteObjectUrl.Path)
+		if err != nil {
+			log.Errorln("Failed to join source url path:", err)
+			return nil, "", err
+		}
 	}
 
-	// get absolute path
-	localDestPath, _ := filepath.Abs(localDestination)
-
-	//Check if path exists or if its in a folder
-	if destStat, err := os.Stat(localDestPath); os.IsNotExist(err) {
-		localDestination = localDestPath
-	} else if destStat.IsDir() && remoteObjectUrl.Query().Get("pack") == "" {
-		// If we have an auto-pack request, it's OK for the destination to be a directory
-		// Otherwise, get the base name of the source and append it to the destination dir.
-		remoteObjectFilename := path.Base(remoteObject)
-		localDestination = path.Join(localDestPath, remoteObjectFilename)
+	if remoteObjectScheme == "osdf" || remoteObjectScheme == "stash" || remoteObjectScheme == "pelican" {
+		remoteObject = remoteObjectUrl.Path
 	}
 
-	payload := payloadStruct{}
-	payload.version = version
-
-	//Fill out the payload as much as possible
-	payload.filename = remoteObjectUrl.Path
-
-	parse_job_ad(payload)
-
-	payload.start1 = time.Now().Unix()
-
-	success := false
-
-	_, token_name := getTokenName(remoteObjectUrl)
-
-	var downloaded int64
-	if downloaded, err = download_http(remoteObjectUrl, localDestination, &payload, ns, recursive, token_name); err == nil {
-		success = true
+	if remoteObject == "" || string(remoteObject[0]) != "/" {
+		remoteObject = "/" + remoteObject
 	}
 
-	payload.end1 = time.Now().Unix()
-
-	payload.timestamp = payload.end1
-	payload.downloadTime = (payload.end1 - payload.start1)
-
-	if success {
-		payload.status = "Success"
+	return remoteObjectUrl, remoteObject, nil
+}
 
-		// Get the final size of the download file
-		payload.fileSize = downloaded
-		payload.downloadSize = downloaded
-	} else {
-		log.Error("Http GET failed! Unable to download file.")
-		payload.status = "Fail"
-	}
+/*
+	Start of transfer for pelican object get, gets information from the target source before doing our HTTP GET request
 
-	if !success {
-		return downloaded, errors.New("failed to download file")
-	} else {
-		return downloaded, nil
-	}
+remoteObject: the source file/directory you would like to upload
+localDestination: the end location of the upload
+recursive: a boolean indicating if the source is a directory or not
+*/
+func DoGet(remoteObject string, localDestination string, recursive bool) (bytesTransferred int64, err error) {
+	result, err := defaultTransferClient().Get(context.Background(), remoteObject, localDestination, recursive)
+	return result.TransferredBytes, err
 }
 
 // Start the transfer, whether read or write back. Primarily used for backwards compatibility
 func DoStashCPSingle(sourceFile string, destination string, methods []string, recursive bool) (bytesTransferred int64, err error) {
+	return defaultTransferClient().Transfer(context.Background(), sourceFile, destination, methods, recursive)
+}
 
-	// First, create a handler for any panics that occur
-	defer func() {
-		if r := recover(); r != nil {
-			log.Debugln("Panic captured while attempting to perform transfer (DoStashCPSingle):", r)
-			log.Debugln("Panic caused by the following", string(debug.Stack()))
-			ret := fmt.Sprintf("Unrecoverable error (panic) captured in DoStashCPSingle: %v", r)
-			err = errors.New(ret)
-			bytesTransferred = 0
-
-			// Attempt to add the panic to the error accumulator
-			AddError(errors.New(ret))
-		}
-	}()
+// Transfer between the source and destination, whichever of them is in the
+// federation, trying the download methods in order
+func (tc *TransferClient) Transfer(ctx context.Context, sourceFile string, destination string, methods []string, recursive bool) (bytesTransferred int64, err error) {
+	defer tc.recoverTransfer("Transfer", &err)
 
 	// Parse the source and destination with URL parse
 	sourceFile, source_scheme := correctURLWithUnderscore(sourceFile)
@@ -716,37 +589,6 @@ func DoStashCPSingle(sourceFile string, destination string, methods []string, re
 	}
 	dest_url.Scheme = dest_scheme
 
-	// If there is a host specified, prepend it to the path in the osdf case
-	if source_url.Host != "" {
-		if source_url.Scheme == "osdf" || source_url.Scheme == "stash" {
-			source_url.Path = "/" + path.Join(source_url.Host, source_url.Path)
-		} else if source_url.Scheme == "pelican" {
-			federationUrl, _ := url.Parse(source_url.String())
-			federationUrl.Scheme = "https"
-			federationUrl.Path = ""
-			viper.Set("Federation.DiscoveryUrl", federationUrl.String())
-			err = config.DiscoverFederation()
-			if err != nil {
-				return 0, err
-			}
-		}
-	}
-
-	if dest_url.Host != "" {
-		if dest_url.Scheme == "osdf" || dest_url.Scheme == "stash" {
-			dest_url.Path = "/" + path.Join(dest_url.Host, dest_url.Path)
-		} else if dest_url.Scheme == "pelican" {
-			federationUrl, _ := url.Parse(dest_url.String())
-			federationUrl.Scheme = "https"
-			federationUrl.Path = ""
-			viper.Set("Federation.DiscoveryUrl", federationUrl.String())
-			err = config.DiscoverFederation()
-			if err != nil {
-				return 0, err
-			}
-		}
-	}
-
 	sourceScheme, _ := getTokenName(source_url)
 	destScheme, _ := getTokenName(dest_url)
 
@@ -764,112 +606,45 @@ func DoStashCPSingle(sourceFile string, destination string, methods []string, re
 		return 0, errors.New("Do not understand destination scheme")
 	}
 
-	// Get the namespace of the remote filesystem
-	// For write back, it will be the destination
-	// For read it will be the source.
-
-	OSDFDirectorUrl := param.Federation_DirectorUrl.GetString()
 	isPut := destScheme == "stash" || destScheme == "osdf" || destScheme == "pelican"
+	isGet := sourceScheme == "stash" || sourceScheme == "osdf" || sourceScheme == "pelican"
+
+	if isPut && isGet {
+		log.Debugln("Detected copy between federation objects", source_url.Path, "and", dest_url.Path)
+		result, err := tc.Copy(ctx, sourceFile, destination, recursive)
+		return result.TransferredBytes, err
+	}
 
 	if isPut {
 		log.Debugln("Detected object write to remote federation object", dest_url.Path)
-		ns, err := getNamespaceInfo(dest_url.Path, OSDFDirectorUrl, isPut)
-		if err != nil {
-			log.Errorln(err)
-			return 0, errors.New("Failed to get namespace information from destination")
-		}
-		uploadedBytes, err := doWriteBack(source_url.Path, dest_url, ns, recursive)
-		AddError(err)
-		return uploadedBytes, err
+		result, err := tc.Put(ctx, source_url.Path, destination, recursive)
+		return result.TransferredBytes, err
 	}
 
 	if dest_url.Scheme == "file" {
 		destination = dest_url.Path
 	}
 
-	if sourceScheme == "stash" || sourceScheme == "osdf" || sourceScheme == "pelican" {
-		sourceFile = source_url.Path
-	}
-
-	if string(sourceFile[0]) != "/" {
-		sourceFile = "/" + sourceFile
-	}
-
-	ns, err := getNamespaceInfo(sourceFile, OSDFDirectorUrl, isPut)
-	if err != nil {
-		log.Errorln(err)
-		return 0, errors.New("Failed to get namespace information from source")
-	}
-
-	// get absolute path
-	destPath, _ := filepath.Abs(destination)
-
-	//Check if path exists or if its in a folder
-	if destStat, err := os.Stat(destPath); os.IsNotExist(err) {
-		destination = destPath
-	} else if destStat.IsDir() && source_url.Query().Get("pack") == "" {
-		// If we have an auto-pack request, it's OK for the destination to be a directory
-		// Otherwise, get the base name of the source and append it to the destination dir.
-		sourceFilename := path.Base(sourceFile)
-		destination = path.Join(destPath, sourceFilename)
-	}
-
-	payload := payloadStruct{}
-	payload.version = version
-
-	//Fill out the payload as much as possible
-	payload.filename = source_url.Path
-
-	// ??
-
-	parse_job_ad(payload)
-
-	payload.start1 = time.Now().Unix()
-
-	// Go thru the download methods
-	success := false
-
 	// If recursive, only do http method to guarantee freshest directory contents
-	if ObjectClientOptions.Recursive {
+	if recursive {
 		methods = []string{"http"}
 	}
 
-	_, token_name := getTokenName(source_url)
-
-	// switch statement?
-	var downloaded int64 = 0
-Loop:
+	// Go thru the download methods
 	for _, method := range methods {
-
 		switch method {
 		case "http":
 			log.Info("Trying HTTP...")
-			if downloaded, err = download_http(source_url, destination, &payload, ns, recursive, token_name); err == nil {
-				success = true
-				break Loop
+			result, err := tc.Get(ctx, sourceFile, destination, recursive)
+			if err == nil {
+				return result.TransferredBytes, nil
 			}
-
+			bytesTransferred = result.TransferredBytes
 		default:
 			log.Errorf("Unknown transfer method: %s", method)
 		}
 	}
-
-	payload.end1 = time.Now().Unix()
-
-	payload.timestamp = payload.end1
-	payload.downloadTime = (payload.end1 - payload.start1)
-
-	if success {
-		payload.status = "Success"
-
-		// Get the final size of the download file
-		payload.fileSize = downloaded
-		payload.downloadSize = downloaded
-		return downloaded, nil
-	} else {
-		payload.status = "Fail"
-		return downloaded, errors.New("All methods failed! Unable to download file.")
-	}
+	return bytesTransferred, errors.New("All methods failed! Unable to download file.")
 }
 
 // Find takes a slice and looks for an element in it. If found it will
@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"net"
 	"net/url"
 	"os"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/namespaces"
@@ -74,7 +76,7 @@ func TestGetToken(t *testing.T) {
 
 	// ENVs to test: BEARER_TOKEN, BEARER_TOKEN_FILE, XDG_RUNTIME_DIR/bt_u<uid>, TOKEN, _CONDOR_CREDS/scitoken.use, .condor_creds/scitokens.use
 	os.Setenv("BEARER_TOKEN", "bearer_token_contents")
-	token, err := getToken(url, namespace, true, "")
+	token, err := getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, "bearer_token_contents", token)
 	os.Unsetenv("BEARER_TOKEN")
@@ -87,7 +89,7 @@ func TestGetToken(t *testing.T) {
 	err = os.WriteFile(bearer_token_file, tmpFile, 0644)
 	assert.NoError(t, err)
 	os.Setenv("BEARER_TOKEN_FILE", bearer_token_file)
-	token, err = getToken(url, namespace, true, "")
+	token, err = getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("BEARER_TOKEN_FILE")
@@ -99,7 +101,7 @@ func TestGetToken(t *testing.T) {
 	err = os.WriteFile(bearer_token_file, tmpFile, 0644)
 	assert.NoError(t, err)
 	os.Setenv("XDG_RUNTIME_DIR", tmpDir)
-	token, err = getToken(url, namespace, true, "")
+	token, err = getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("XDG_RUNTIME_DIR")
@@ -111,7 +113,7 @@ func TestGetToken(t *testing.T) {
 	err = os.WriteFile(bearer_token_file, tmpFile, 0644)
 	assert.NoError(t, err)
 	os.Setenv("TOKEN", bearer_token_file)
-	token, err = getToken(url, namespace, true, "")
+	token, err = getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("TOKEN")
@@ -123,7 +125,7 @@ func TestGetToken(t *testing.T) {
 	err = os.WriteFile(bearer_token_file, tmpFile, 0644)
 	assert.NoError(t, err)
 	os.Setenv("_CONDOR_CREDS", tmpDir)
-	token, err = getToken(url, namespace, true, "")
+	token, err = getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -140,7 +142,7 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	renamedNamespace, err := namespaces.MatchNamespace("/user/ligo/frames")
 	assert.NoError(t, err)
-	token, err = getToken(renamedUrl, renamedNamespace, false, "")
+	token, err = getToken(context.Background(), renamedUrl, renamedNamespace, false, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -159,7 +161,7 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	renamedNamespace, err = namespaces.MatchNamespace("/user/ligo/frames")
 	assert.NoError(t, err)
-	token, err = getToken(renamedUrl, renamedNamespace, false, "")
+	token, err = getToken(context.Background(), renamedUrl, renamedNamespace, false, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -176,7 +178,7 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	renamedNamespace, err = namespaces.MatchNamespace("/user/ligo/frames")
 	assert.NoError(t, err)
-	token, err = getToken(renamedUrl, renamedNamespace, false, "")
+	token, err = getToken(context.Background(), renamedUrl, renamedNamespace, false, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -193,7 +195,7 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	renamedNamespace, err = namespaces.MatchNamespace("/user/ligo/frames")
 	assert.NoError(t, err)
-	token, err = getToken(renamedUrl, renamedNamespace, false, "")
+	token, err = getToken(context.Background(), renamedUrl, renamedNamespace, false, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -210,7 +212,7 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	renamedNamespace, err = namespaces.MatchNamespace("/user/ligo/frames")
 	assert.NoError(t, err)
-	token, err = getToken(renamedUrl, renamedNamespace, false, "renamed")
+	token, err = getToken(context.Background(), renamedUrl, renamedNamespace, false, "renamed")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	os.Unsetenv("_CONDOR_CREDS")
@@ -227,16 +229,16 @@ func TestGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	err = os.Chdir(tmpDir)
 	assert.NoError(t, err)
-	token, err = getToken(url, namespace, true, "")
+	token, err = getToken(context.Background(), url, namespace, true, "")
 	assert.NoError(t, err)
 	assert.Equal(t, token_contents, token)
 	err = os.Chdir(currentDir)
 	assert.NoError(t, err)
 
-	ObjectClientOptions.Plugin = true
-	_, err = getToken(url, namespace, true, "")
+	pluginClient, err := NewTransferEngine(context.Background()).NewClient(WithPluginMode())
+	require.NoError(t, err)
+	_, err = getToken(pluginClient.withContext(context.Background()), url, namespace, true, "")
 	assert.EqualError(t, err, "Credential is required for osdf:///user/foo but is currently missing")
-	ObjectClientOptions.Plugin = false
 
 }
 
@@ -0,0 +1,378 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+type (
+	ManifestFormat string
+
+	// A single transfer listed in a manifest
+	ManifestEntry struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		// The expected checksum of the object, as algorithm:value (e.g.
+		// md5:d41d8cd98f00b204e9800998ecf8427e), if it should be verified
+		Checksum string `json:"checksum,omitempty"`
+		// The expected size of the object in bytes, or -1 if it isn't known
+		Size int64 `json:"size"`
+	}
+
+	// The outcome of one transfer of a manifest
+	ManifestResult struct {
+		Source           string    `json:"source"`
+		Destination      string    `json:"destination"`
+		Success          bool      `json:"success"`
+		TransferredBytes int64     `json:"transferred_bytes"`
+		Checksums        []string  `json:"checksums,omitempty"`
+		Start            time.Time `json:"start"`
+		End              time.Time `json:"end"`
+		DurationSeconds  float64   `json:"duration_seconds"`
+		Attempts         int       `json:"attempts"`
+		Server           string    `json:"server,omitempty"`
+		Error            string    `json:"error,omitempty"`
+		ErrorCategory    string    `json:"error_category,omitempty"`
+		Retryable        bool      `json:"retryable,omitempty"`
+	}
+
+	// The summary of a whole manifest, with a result for every entry in the
+	// order they were listed
+	ManifestReport struct {
+		Transfers        []ManifestResult `json:"transfers"`
+		Succeeded        int              `json:"succeeded"`
+		Failed           int              `json:"failed"`
+		TransferredBytes int64            `json:"transferred_bytes"`
+		Start            time.Time        `json:"start"`
+		End              time.Time        `json:"end"`
+	}
+)
+
+const (
+	// A JSON list of entries
+	ManifestJSON ManifestFormat = "json"
+	// Lines of source,destination[,checksum[,size]], with an optional header
+	ManifestCSV ManifestFormat = "csv"
+	// Lines of "source destination [checksum] [size]", separated by whitespace
+	ManifestLines ManifestFormat = "lines"
+)
+
+// ReadManifest reads the transfers listed in a manifest file. The format is
+// taken from the file's extension (.json or .csv); anything else is read as
+// one transfer per line.
+func ReadManifest(manifestFile string) ([]ManifestEntry, error) {
+	contents, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read the manifest")
+	}
+	format := ManifestLines
+	switch strings.ToLower(filepath.Ext(manifestFile)) {
+	case ".json":
+		format = ManifestJSON
+	case ".csv":
+		format = ManifestCSV
+	default:
+		if bytes.HasPrefix(bytes.TrimSpace(contents), []byte("[")) {
+			format = ManifestJSON
+		}
+	}
+	entries, err := ParseManifest(bytes.NewReader(contents), format)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse the manifest %s", manifestFile)
+	}
+	return entries, nil
+}
+
+// ParseManifest reads the transfers of a manifest in the given format
+func ParseManifest(reader io.Reader, format ManifestFormat) (entries []ManifestEntry, err error) {
+	switch format {
+	case ManifestJSON:
+		entries, err = parseJSONManifest(reader)
+	case ManifestCSV:
+		entries, err = parseCSVManifest(reader)
+	case ManifestLines:
+		entries, err = parseLinesManifest(reader)
+	default:
+		return nil, errors.Errorf("unknown manifest format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for idx, entry := range entries {
+		if entry.Source == "" || entry.Destination == "" {
+			return nil, errors.Errorf("entry %d needs both a source and a destination", idx+1)
+		}
+		if _, err = entry.expectedChecksum(); err != nil {
+			return nil, errors.Wrapf(err, "entry %d", idx+1)
+		}
+	}
+	return entries, nil
+}
+
+func parseJSONManifest(reader io.Reader) ([]ManifestEntry, error) {
+	var jsonEntries []struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		Checksum    string `json:"checksum"`
+		Size        *int64 `json:"size"`
+	}
+	if err := json.NewDecoder(reader).Decode(&jsonEntries); err != nil {
+		return nil, err
+	}
+	entries := make([]ManifestEntry, len(jsonEntries))
+	for idx, jsonEntry := range jsonEntries {
+		entries[idx] = ManifestEntry{Source: jsonEntry.Source, Destination: jsonEntry.Destination, Checksum: jsonEntry.Checksum, Size: -1}
+		if jsonEntry.Size != nil {
+			entries[idx].Size = *jsonEntry.Size
+		}
+	}
+	return entries, nil
+}
+
+func parseCSVManifest(reader io.Reader) ([]ManifestEntry, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "source") {
+		records = records[1:]
+	}
+	entries := make([]ManifestEntry, 0, len(records))
+	for _, record := range records {
+		if len(record) < 2 || len(record) > 4 {
+			return nil, errors.Errorf("expected 2 to 4 fields but found %d in %q", len(record), strings.Join(record, ","))
+		}
+		entry := ManifestEntry{Source: strings.TrimSpace(record[0]), Destination: strings.TrimSpace(record[1]), Size: -1}
+		if len(record) > 2 {
+			entry.Checksum = strings.TrimSpace(record[2])
+		}
+		if len(record) > 3 && strings.TrimSpace(record[3]) != "" {
+			if entry.Size, err = strconv.ParseInt(strings.TrimSpace(record[3]), 10, 64); err != nil {
+				return nil, errors.Errorf("invalid size %q", record[3])
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Each line holds a source and destination, optionally followed by the
+// checksum and size in either order; a checksum is told apart by its colon
+func parseLinesManifest(reader io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, errors.Errorf("line %d: expected a source and destination, and optionally a checksum and size", lineNum)
+		}
+		entry := ManifestEntry{Source: fields[0], Destination: fields[1], Size: -1}
+		for _, field := range fields[2:] {
+			if strings.Contains(field, ":") {
+				entry.Checksum = field
+			} else if size, err := strconv.ParseInt(field, 10, 64); err == nil {
+				entry.Size = size
+			} else {
+				return nil, errors.Errorf("line %d: %q is neither a checksum nor a size", lineNum, field)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// The checksum the entry must match, if it has one
+func (entry ManifestEntry) expectedChecksum() (*ChecksumInfo, error) {
+	if entry.Checksum == "" {
+		return nil, nil
+	}
+	name, value, found := strings.Cut(entry.Checksum, ":")
+	if !found {
+		return nil, errors.Errorf("checksum %q must be of the form algorithm:value", entry.Checksum)
+	}
+	checksumTypes, err := ParseChecksumTypes(name)
+	if err != nil {
+		return nil, err
+	}
+	decoded, ok := decodeDigest(checksumTypes[0], value)
+	if !ok {
+		return nil, errors.Errorf("invalid %s checksum %q", checksumTypes[0], value)
+	}
+	return &ChecksumInfo{Algorithm: checksumTypes[0], Value: decoded}, nil
+}
+
+// Check a local file against the checksum in the manifest
+func verifyManifestChecksum(localPath string, expected ChecksumInfo) error {
+	_, err := verifyFileChecksums(localPath, map[ChecksumType][]byte{expected.Algorithm: expected.Value}, []ChecksumType{expected.Algorithm}, true)
+	if err != nil {
+		return errors.Wrapf(err, "%s does not match the manifest", localPath)
+	}
+	return nil
+}
+
+// TransferManifest makes the transfers of a manifest, up to workers at a
+// time (or, if workers is 0, the client's worker count), downloading each source to its destination (or, with upload set,
+// uploading it). Failures don't stop the remaining transfers; they're
+// reported in the result for each entry.
+func (tc *TransferClient) TransferManifest(ctx context.Context, entries []ManifestEntry, upload bool, recursive bool, workers int) ManifestReport {
+	report := ManifestReport{Transfers: make([]ManifestResult, len(entries)), Start: time.Now()}
+	if workers < 1 {
+		workers = tc.workerCount()
+	}
+
+	// A single bar for the whole manifest, rather than one for each file
+	var progressBar *mpb.Bar
+	if tc.options.ProgressBars {
+		progressBar = getProgressContainer().AddBar(int64(len(entries)),
+			mpb.PrependDecorators(
+				decor.Name("Manifest", decor.WCSyncSpaceR),
+				decor.CountersNoUnit("%d / %d"),
+			),
+			mpb.AppendDecorators(
+				decor.OnComplete(decor.Percentage(), "Done!"),
+			),
+		)
+	}
+
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				report.Transfers[idx] = tc.transferManifestEntry(ctx, entries[idx], upload, recursive)
+				if progressBar != nil {
+					progressBar.Increment()
+				}
+			}
+		}()
+	}
+	for idx := range entries {
+		work <- idx
+	}
+	close(work)
+	wg.Wait()
+
+	for _, result := range report.Transfers {
+		if result.Success {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		report.TransferredBytes += result.TransferredBytes
+	}
+	report.End = time.Now()
+	return report
+}
+
+func (tc *TransferClient) transferManifestEntry(ctx context.Context, entry ManifestEntry, upload bool, recursive bool) (result ManifestResult) {
+	result = ManifestResult{Source: entry.Source, Destination: entry.Destination, Start: time.Now()}
+	// Each transfer has its own errors, so they can be reported separately
+	entryTc := *tc
+	entryTc.errors = newErrorAccumulator()
+	entryTc.options.ProgressBars = false
+
+	var transferResult TransferResult
+	expected, err := entry.expectedChecksum()
+	if err == nil && upload && expected != nil {
+		// No point in uploading a file that's already wrong
+		err = verifyManifestChecksum(entry.Source, *expected)
+	}
+	if err == nil {
+		if upload {
+			transferResult, err = entryTc.Put(ctx, entry.Source, entry.Destination, recursive)
+		} else {
+			transferResult, err = entryTc.Get(ctx, entry.Source, entry.Destination, recursive)
+			result.Destination = transferResult.Destination
+		}
+	}
+	if err == nil && entry.Size >= 0 && !recursive && transferResult.TransferredBytes != entry.Size {
+		err = errors.Errorf("transferred %d bytes but the manifest expected %d", transferResult.TransferredBytes, entry.Size)
+	}
+	if err == nil && !upload && expected != nil && !recursive {
+		err = verifyManifestChecksum(transferResult.Destination, *expected)
+		if err != nil {
+			if removeErr := os.Remove(transferResult.Destination); removeErr != nil {
+				log.Warningln("Failed to remove the corrupt download:", removeErr)
+			}
+		}
+	}
+
+	result.End = time.Now()
+	result.DurationSeconds = result.End.Sub(result.Start).Seconds()
+	result.TransferredBytes = transferResult.TransferredBytes
+	result.Attempts = len(transferResult.Attempts)
+	if result.Attempts > 0 {
+		result.Server = transferResult.Attempts[result.Attempts-1].Server
+	}
+	for _, checksum := range transferResult.Checksums {
+		result.Checksums = append(result.Checksums, checksum.String())
+	}
+	if err == nil {
+		result.Success = true
+		return
+	}
+
+	// The accumulated errors say more than the summary the transfer returns
+	cause := err
+	result.Error = err.Error()
+	result.Retryable = IsRetryable(err)
+	if transferErrors := entryTc.Errors(); len(transferErrors) > 0 {
+		cause = transferErrors[len(transferErrors)-1].Err()
+		result.Error = entryTc.GetErrors()
+		result.Retryable = entryTc.ErrorsRetryable()
+	}
+	result.ErrorCategory = string(CategorizeError(cause))
+	log.Errorf("Failed to transfer %s to %s: %s", entry.Source, entry.Destination, result.Error)
+	return
+}
+
+// DoManifest makes the transfers of a manifest with the settings in the
+// configuration; see TransferClient.TransferManifest
+func DoManifest(entries []ManifestEntry, upload bool, recursive bool, workers int) ManifestReport {
+	return defaultTransferClient().TransferManifest(context.Background(), entries, upload, recursive, workers)
+}
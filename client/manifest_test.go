@@ -0,0 +1,130 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	expected := []ManifestEntry{
+		{Source: "osdf:///test/a.txt", Destination: "/tmp/a.txt", Checksum: "md5:5d41402abc4b2a76b9719d911017c592", Size: 5},
+		{Source: "osdf:///test/b.txt", Destination: "/tmp/b.txt", Size: -1},
+	}
+
+	entries, err := ParseManifest(strings.NewReader(`[
+		{"source": "osdf:///test/a.txt", "destination": "/tmp/a.txt", "checksum": "md5:5d41402abc4b2a76b9719d911017c592", "size": 5},
+		{"source": "osdf:///test/b.txt", "destination": "/tmp/b.txt"}
+	]`), ManifestJSON)
+	require.NoError(t, err)
+	assert.Equal(t, expected, entries)
+
+	entries, err = ParseManifest(strings.NewReader("source,destination,checksum,size\n"+
+		"osdf:///test/a.txt,/tmp/a.txt,md5:5d41402abc4b2a76b9719d911017c592,5\n"+
+		"# A comment\n"+
+		"osdf:///test/b.txt,/tmp/b.txt\n"), ManifestCSV)
+	require.NoError(t, err)
+	assert.Equal(t, expected, entries)
+
+	entries, err = ParseManifest(strings.NewReader("osdf:///test/a.txt /tmp/a.txt 5 md5:5d41402abc4b2a76b9719d911017c592\n\n"+
+		"# A comment\n"+
+		"osdf:///test/b.txt\t/tmp/b.txt\n"), ManifestLines)
+	require.NoError(t, err)
+	assert.Equal(t, expected, entries)
+
+	for _, manifest := range []string{
+		"osdf:///test/a.txt",
+		"osdf:///test/a.txt /tmp/a.txt notasize",
+		"osdf:///test/a.txt /tmp/a.txt md6:abcd",
+		"osdf:///test/a.txt /tmp/a.txt md5:abcd",
+	} {
+		_, err = ParseManifest(strings.NewReader(manifest), ManifestLines)
+		assert.Error(t, err, manifest)
+	}
+	_, err = ParseManifest(strings.NewReader(`[{"source": "osdf:///test/a.txt"}]`), ManifestJSON)
+	assert.Error(t, err)
+
+	// The format comes from the extension, or failing that, the contents
+	manifestFile := filepath.Join(t.TempDir(), "manifest")
+	require.NoError(t, os.WriteFile(manifestFile, []byte(`[{"source": "osdf:///test/b.txt", "destination": "/tmp/b.txt"}]`), 0644))
+	entries, err = ReadManifest(manifestFile)
+	require.NoError(t, err)
+	assert.Equal(t, expected[1:], entries)
+}
+
+func TestDoManifestDownload(t *testing.T) {
+	startListingFederation(t)
+	localDir := t.TempDir()
+
+	entries := []ManifestEntry{
+		{Source: "osdf:///test/a.txt", Destination: filepath.Join(localDir, "a.txt"), Checksum: "md5:5d41402abc4b2a76b9719d911017c592", Size: 5},
+		{Source: "osdf:///test/dir/b.txt", Destination: filepath.Join(localDir, "b.txt"), Size: -1},
+		{Source: "osdf:///test/dir/b.txt", Destination: filepath.Join(localDir, "wrong-size.txt"), Size: 3},
+		{Source: "osdf:///test/dir/b.txt", Destination: filepath.Join(localDir, "wrong-checksum.txt"), Checksum: "md5:5d41402abc4b2a76b9719d911017c592", Size: -1},
+		{Source: "osdf:///test/missing.txt", Destination: filepath.Join(localDir, "missing.txt"), Size: -1},
+	}
+	report := DoManifest(entries, false, false, 2)
+	require.Len(t, report.Transfers, len(entries))
+	assert.Equal(t, 2, report.Succeeded)
+	assert.Equal(t, 3, report.Failed)
+	assert.False(t, report.End.Before(report.Start))
+
+	for idx, result := range report.Transfers {
+		assert.Equal(t, entries[idx].Source, result.Source)
+		assert.Equal(t, entries[idx].Destination, result.Destination)
+	}
+	assert.True(t, report.Transfers[0].Success)
+	assert.Equal(t, int64(5), report.Transfers[0].TransferredBytes)
+	assert.Equal(t, 1, report.Transfers[0].Attempts)
+	assert.NotEmpty(t, report.Transfers[0].Server)
+	assert.True(t, report.Transfers[1].Success)
+	contents, err := os.ReadFile(filepath.Join(localDir, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(contents))
+
+	assert.Contains(t, report.Transfers[2].Error, "expected 3")
+	assert.Contains(t, report.Transfers[3].Error, "does not match the manifest")
+	// A download that doesn't match its checksum isn't kept
+	_, err = os.Stat(filepath.Join(localDir, "wrong-checksum.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, report.Transfers[4].Success)
+	assert.Equal(t, string(ErrorCategoryNotFound), report.Transfers[4].ErrorCategory)
+	assert.NotEmpty(t, report.Transfers[4].Error)
+}
+
+func TestDoManifestUploadChecksum(t *testing.T) {
+	localFile := filepath.Join(t.TempDir(), "upload.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("goodbye"), 0644))
+
+	// A file that doesn't match the manifest isn't uploaded at all
+	report := DoManifest([]ManifestEntry{
+		{Source: localFile, Destination: "osdf:///test/upload.txt", Checksum: "md5:5d41402abc4b2a76b9719d911017c592", Size: -1},
+	}, true, false, 1)
+	require.Len(t, report.Transfers, 1)
+	assert.False(t, report.Transfers[0].Success)
+	assert.Zero(t, report.Transfers[0].Attempts)
+	assert.Contains(t, report.Transfers[0].Error, "does not match the manifest")
+	assert.Equal(t, 1, report.Failed)
+}
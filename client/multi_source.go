@@ -0,0 +1,494 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// A half-open range of bytes, [start, end), of the object being downloaded
+	byteRange struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	}
+
+	// The ranges of an object that still need to be fetched. Workers block in
+	// pop while other workers hold ranges, since a range held by a slow source
+	// may be handed back for someone else to finish.
+	rangeQueue struct {
+		mutex       sync.Mutex
+		cond        *sync.Cond
+		ranges      []byteRange
+		outstanding int
+	}
+
+	// Per-source state for the stopped and slow transfer detection, which
+	// mirrors that of DownloadHTTP
+	sourceSpeed struct {
+		started         time.Time
+		startBelowLimit time.Time
+	}
+
+	multiSourceDownload struct {
+		ctx           context.Context
+		file          *os.File
+		token         string
+		queue         *rangeQueue
+		state         *partialState
+		downloadLimit int64
+		progressBar   *mpb.Bar
+		// Shared by all the sources, so the object as a whole keeps to the limit
+		limiter *bandwidthLimiter
+	}
+)
+
+var (
+	// Size of the byte ranges handed out to each source
+	multiSourceRangeSize int64 = 16 * 1024 * 1024
+
+	// The object can't (or shouldn't) be downloaded from several sources at
+	// once; the caller should fall back to a single-source download
+	errMultiSourceUnsupported = errors.New("object cannot be downloaded from multiple sources")
+
+	// How often the speed of each source is checked
+	speedCheckInterval = 5 * time.Second
+)
+
+func newRangeQueue(ranges []byteRange) *rangeQueue {
+	queue := &rangeQueue{ranges: ranges}
+	queue.cond = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+// Take the next range to download. Returns false once there is nothing left
+// to hand out.
+func (q *rangeQueue) pop() (byteRange, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.ranges) == 0 && q.outstanding > 0 {
+		q.cond.Wait()
+	}
+	if len(q.ranges) == 0 {
+		return byteRange{}, false
+	}
+	r := q.ranges[0]
+	q.ranges = q.ranges[1:]
+	q.outstanding++
+	return r, true
+}
+
+func (q *rangeQueue) complete() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.outstanding--
+	q.cond.Broadcast()
+}
+
+// Hand back the unfinished part of a range so another source can fetch it
+func (q *rangeQueue) requeue(r byteRange) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.outstanding--
+	if r.Start < r.End {
+		q.ranges = append(q.ranges, r)
+	}
+	q.cond.Broadcast()
+}
+
+func (q *rangeQueue) remaining() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.ranges) + q.outstanding
+}
+
+// Pick (at most maxSources) transfers to distinct caches, in order of preference
+func selectSources(transfers []TransferDetails, maxSources int) []TransferDetails {
+	sources := make([]TransferDetails, 0, maxSources)
+	seen := make(map[string]bool)
+	for _, transfer := range transfers {
+		if len(sources) == maxSources {
+			break
+		}
+		if seen[transfer.Url.Hostname()] {
+			continue
+		}
+		seen[transfer.Url.Hostname()] = true
+		sources = append(sources, transfer)
+	}
+	return sources
+}
+
+func sourceClient(ctx context.Context, transfer TransferDetails) *http.Client {
+	return &http.Client{Transport: transferClientFromContext(ctx).engine.getTransport(transfer.Proxy)}
+}
+
+// Ask the sources, in order, about the object until one of them answers
+func headObject(ctx context.Context, sources []TransferDetails, token string) (objectInfo, error) {
+	var lastErr error
+	for _, source := range sources {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, source.Url.String(), nil)
+		if err != nil {
+			return objectInfo{}, errors.Wrap(err, "Failed to create HEAD request")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		checksumTypes, _ := requestedChecksums(ctx)
+		setWantDigest(req.Header, checksumTypes)
+		resp, err := sourceClient(ctx, source).Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			lastErr = errors.Errorf("HEAD request to %s failed (HTTP status %d)", source.Url.Host, resp.StatusCode)
+			continue
+		}
+		if resp.ContentLength < 0 {
+			lastErr = errors.Errorf("%s did not report the size of the object", source.Url.Host)
+			continue
+		}
+		info := objectInfoFromResponse(resp, resp.ContentLength)
+		info.server = source.Url.Host
+		return info, nil
+	}
+	return objectInfo{}, lastErr
+}
+
+// Download the object from several of the caches in transfers at once, each
+// fetching byte ranges of the object until none remain. Sources that are too
+// slow (per Client.MinimumDownloadSpeed and friends) or that fail have their
+// unfinished range handed to the remaining sources.
+//
+// Returns errMultiSourceUnsupported if the download should be done the usual way
+// instead: there aren't enough caches, the object is too small, or it needs unpacking.
+func downloadMultiSource(ctx context.Context, transfers []TransferDetails, dest string, token string) (downloaded int64, err error) {
+	tc := transferClientFromContext(ctx)
+	maxSources := param.Client_MaximumDownloadSources.GetInt()
+	if maxSources < 2 || len(transfers) == 0 || transfers[0].PackOption != "" {
+		return 0, errMultiSourceUnsupported
+	}
+	sources := selectSources(transfers, maxSources)
+	if len(sources) < 2 {
+		return 0, errMultiSourceUnsupported
+	}
+
+	info, err := headObject(ctx, sources, token)
+	if err != nil {
+		log.Debugln("Unable to determine object size for a multi-source download:", err)
+		return 0, errMultiSourceUnsupported
+	}
+	size := info.size
+	if size < int64(param.Client_MultiSourceMinimumSize.GetInt()) || size <= multiSourceRangeSize {
+		return 0, errMultiSourceUnsupported
+	}
+	start := time.Now()
+	defer func() {
+		attempt := TransferAttempt{Start: start, End: time.Now(), TransferredBytes: downloaded, Error: err}
+		hosts := make([]string, len(sources))
+		for idx, source := range sources {
+			hosts[idx] = source.Url.Host
+			attempt.Proxy = attempt.Proxy || source.Proxy
+		}
+		attempt.Server = strings.Join(hosts, ",")
+		tc.recordAttempt(attempt)
+	}()
+
+	if fileInfo, err := os.Stat(dest); err == nil && fileInfo.IsDir() {
+		dest = path.Join(dest, path.Base(sources[0].Url.Path))
+	}
+	// Pick up any earlier attempt where it left off, as long as the object hasn't changed
+	flags := os.O_CREATE | os.O_WRONLY
+	state := loadPartialState(dest)
+	if state != nil && state.matches(sources[0].Url, info) {
+		log.Infof("Resuming the download of %s; %d of %d bytes are already complete", dest, state.completedBytes(), size)
+	} else {
+		discardPartial(dest)
+		state = newPartialState(dest, sources[0].Url, info)
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partialPath(dest), flags, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to open destination file")
+	}
+	defer file.Close()
+	if err = file.Truncate(size); err != nil {
+		return 0, errors.Wrap(err, "Failed to allocate destination file")
+	}
+	if err = state.save(); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	download := &multiSourceDownload{
+		ctx:   ctx,
+		file:  file,
+		token: token,
+		queue: newRangeQueue(state.missing(multiSourceRangeSize)),
+		state: state,
+		// Each source only needs to carry its share of the minimum speed
+		downloadLimit: int64(tc.minimumDownloadSpeed(len(sources))),
+		limiter:       tc.newBandwidthLimiter(),
+	}
+	if tc.options.ProgressBars {
+		download.progressBar = getProgressContainer().AddBar(size,
+			mpb.PrependDecorators(
+				decor.Name(path.Base(dest), decor.WCSyncSpaceR),
+				decor.CountersKibiByte("% .2f / % .2f"),
+			),
+			mpb.AppendDecorators(
+				decor.OnComplete(decor.EwmaETA(decor.ET_STYLE_GO, 90), ""),
+				decor.OnComplete(decor.Name(" ] "), ""),
+				decor.OnComplete(decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 5), "Done!"),
+			),
+		)
+		download.progressBar.SetCurrent(state.completedBytes())
+	}
+
+	log.Debugf("Downloading %d bytes from %d sources", size-state.completedBytes(), len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for idx, source := range sources {
+		wg.Add(1)
+		go func(idx int, source TransferDetails) {
+			defer wg.Done()
+			errs[idx] = download.worker(source)
+		}(idx, source)
+	}
+	wg.Wait()
+
+	if download.queue.remaining() > 0 {
+		if download.progressBar != nil {
+			download.progressBar.Abort(true)
+			download.progressBar.Wait()
+		}
+		for idx, err := range errs {
+			if err != nil {
+				tc.errors.add(&FileDownloadError{"Failed to download from " + sources[idx].Url.Host + ": " + err.Error(), err})
+			}
+		}
+		// The partial download is left in place for the next attempt
+		return 0, errors.New("all sources failed during the multi-source download")
+	}
+	file.Close()
+	checksumTypes, required := requestedChecksums(ctx)
+	verified, err := verifyFileChecksums(partialPath(dest), info.digests, checksumTypes, required)
+	if err != nil {
+		// There's no telling which range was bad, so none of it can be trusted
+		discardPartial(dest)
+		tc.errors.add(&FileDownloadError{"Failed to verify multi-source download: " + err.Error(), err})
+		return 0, err
+	}
+	if err = finishPartial(dest); err != nil {
+		return 0, err
+	}
+	recordChecksums(ctx, dest, verified)
+	// The validators are from before any of the data was fetched; if the
+	// object changed since, a cached copy just fails its next validation
+	tc.recordDownload(dest, info)
+
+	if download.progressBar != nil {
+		download.progressBar.SetTotal(size, true)
+		if tc.options.Recursive {
+			download.progressBar.Wait()
+		} else {
+			getProgressContainer().Wait()
+		}
+	}
+	return size, nil
+}
+
+// Fetch ranges from a single source until there are none left or the source
+// fails, in which case its current range is handed back to the queue
+func (ms *multiSourceDownload) worker(source TransferDetails) error {
+	client := sourceClient(ms.ctx, source)
+	speed := &sourceSpeed{started: time.Now()}
+	for {
+		r, ok := ms.queue.pop()
+		if !ok {
+			return nil
+		}
+		written, err := ms.fetchRange(client, source, r, speed)
+		if saveErr := ms.state.addCompleted(byteRange{r.Start, r.Start + written}); saveErr != nil {
+			log.Warningln("Failed to save the progress of the download:", saveErr)
+		}
+		if err != nil {
+			log.Debugf("Giving up on %s after %d bytes of range %d-%d: %v", source.Url.Host, written, r.Start, r.End, err)
+			ms.queue.requeue(byteRange{r.Start + written, r.End})
+			return err
+		}
+		ms.queue.complete()
+	}
+}
+
+type countingReader struct {
+	reader      io.Reader
+	count       *atomic.Int64
+	progressBar *mpb.Bar
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.count.Add(int64(n))
+	if cr.progressBar != nil {
+		cr.progressBar.IncrBy(n)
+	}
+	return n, err
+}
+
+// Download a single range from a source into the destination file. Returns
+// the number of bytes written from the start of the range.
+func (ms *multiSourceDownload) fetchRange(client *http.Client, source TransferDetails, r byteRange, speed *sourceSpeed) (int64, error) {
+	ctx, cancel := context.WithCancel(ms.ctx)
+	defer cancel()
+
+	// The watchdog covers the whole request, as a stalled source may never
+	// even send the response headers
+	var transferred atomic.Int64
+	var slowErr error
+	fetchDone := make(chan struct{})
+	watchdogDone := make(chan struct{})
+	go func() {
+		defer close(watchdogDone)
+		slowErr = speed.watch(&transferred, ms.downloadLimit, fetchDone, cancel)
+	}()
+
+	written, err := ms.doFetchRange(ctx, client, source, r, &transferred)
+	close(fetchDone)
+	<-watchdogDone
+	// If the watchdog gave up on the source, that's the more useful error
+	if err != nil && slowErr != nil {
+		return written, slowErr
+	}
+	return written, err
+}
+
+func (ms *multiSourceDownload) doFetchRange(ctx context.Context, client *http.Client, source TransferDetails, r byteRange, transferred *atomic.Int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.Url.String(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create new download request")
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1))
+	if ms.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ms.token)
+	}
+	req.Header.Set("X-Transfer-Status", "true")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, &ConnectionSetupError{URL: source.Url.String(), Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, &HttpErrResp{resp.StatusCode, fmt.Sprintf("Range request failed (HTTP status %d)", resp.StatusCode)}
+	}
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		expected := "bytes " + strconv.FormatInt(r.Start, 10) + "-" + strconv.FormatInt(r.End-1, 10) + "/"
+		if !strings.HasPrefix(contentRange, expected) {
+			return 0, errors.Errorf("Server returned the wrong range (%s) for a request of bytes %d-%d", contentRange, r.Start, r.End-1)
+		}
+	}
+
+	reader := &countingReader{reader: ms.limiter.reader(ctx, resp.Body), count: transferred, progressBar: ms.progressBar}
+	written, err := io.Copy(io.NewOffsetWriter(ms.file, r.Start), reader)
+	if err != nil {
+		return written, err
+	}
+	if written != r.End-r.Start {
+		return written, errors.Wrapf(io.ErrUnexpectedEOF, "received %d of %d bytes", written, r.End-r.Start)
+	}
+	if errorStatus := resp.Trailer.Get("X-Transfer-Status"); errorStatus != "" {
+		statusCode, statusText := parseTransferStatus(errorStatus)
+		if statusCode != 200 {
+			return 0, errors.New("transfer error: " + statusText)
+		}
+	}
+	return written, nil
+}
+
+// Cancel the transfer if it has made no progress for longer than
+// Client.StoppedTransferTimeout or, once it has had Client.SlowTransferRampupTime
+// to get going, if it has been below minimumSpeed for longer than
+// Client.SlowTransferWindow
+func (speed *sourceSpeed) watch(transferred *atomic.Int64, minimumSpeed int64, done <-chan struct{}, cancel context.CancelFunc) error {
+	rampupTime := time.Duration(param.Client_SlowTransferRampupTime.GetInt()) * time.Second
+	slowTransferWindow := time.Duration(param.Client_SlowTransferWindow.GetInt()) * time.Second
+	stoppedTransferTimeout := time.Duration(param.Client_StoppedTransferTimeout.GetInt()) * time.Second
+	ticker := time.NewTicker(speedCheckInterval)
+	defer ticker.Stop()
+	lastTransferred := int64(0)
+	lastCheck := time.Now()
+	lastProgress := lastCheck
+	for {
+		select {
+		case <-done:
+			return nil
+		case now := <-ticker.C:
+			current := transferred.Load()
+			bytesPerSecond := float64(current-lastTransferred) / now.Sub(lastCheck).Seconds()
+			if current != lastTransferred {
+				lastProgress = now
+			} else if stoppedTransferTimeout > 0 && now.Sub(lastProgress) > stoppedTransferTimeout {
+				cancel()
+				return &StoppedTransferError{
+					Err: "No progress for more than " + now.Sub(lastProgress).Truncate(time.Millisecond).String(),
+				}
+			}
+			lastTransferred = current
+			lastCheck = now
+			if bytesPerSecond >= float64(minimumSpeed) || now.Sub(speed.started) < rampupTime {
+				speed.startBelowLimit = time.Time{}
+				continue
+			}
+			if speed.startBelowLimit.IsZero() {
+				speed.startBelowLimit = now
+				continue
+			} else if now.Sub(speed.startBelowLimit) < slowTransferWindow {
+				continue
+			}
+			cancel()
+			return &SlowTransferError{
+				BytesTransferred: current,
+				BytesPerSecond:   int64(bytesPerSecond),
+				Duration:         now.Sub(speed.started),
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Start a cache serving `contents` at any path, counting the range requests it serves.
+// Slow caches stall before answering range requests until the test is done. Since
+// sources are told apart by hostname, each cache in a test needs a distinct alias
+// for the loopback address.
+func startRangeCache(t *testing.T, hostname string, contents []byte, slow bool) (TransferDetails, *atomic.Int32) {
+	ranges := &atomic.Int32{}
+	stall := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+			if slow {
+				select {
+				case <-stall:
+				case <-r.Context().Done():
+				}
+				return
+			}
+		}
+		w.Header().Set("ETag", `"test-object"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	t.Cleanup(func() {
+		close(stall)
+		srv.Close()
+	})
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	srvURL.Host = hostname + ":" + srvURL.Port()
+	srvURL.Path = "/test/object"
+	return TransferDetails{Url: *srvURL}, ranges
+}
+
+func TestMultiSourceDownload(t *testing.T) {
+	oldRangeSize, oldInterval := multiSourceRangeSize, speedCheckInterval
+	multiSourceRangeSize = 1024
+	speedCheckInterval = 100 * time.Millisecond
+	viper.Set("Client.MaximumDownloadSources", 3)
+	viper.Set("Client.MultiSourceMinimumSize", 0)
+	viper.Set("Client.MinimumDownloadSpeed", 1024)
+	viper.Set("Client.SlowTransferRampupTime", 0)
+	viper.Set("Client.SlowTransferWindow", 0)
+	t.Cleanup(func() {
+		multiSourceRangeSize, speedCheckInterval = oldRangeSize, oldInterval
+		viper.Reset()
+	})
+
+	contents := make([]byte, 10*1024+17)
+	_, err := rand.Read(contents)
+	require.NoError(t, err)
+
+	t.Run("fetches-from-all-sources", func(t *testing.T) {
+		cache1, ranges1 := startRangeCache(t, "127.0.0.1", contents, false)
+		cache2, ranges2 := startRangeCache(t, "localhost", contents, false)
+		dest := filepath.Join(t.TempDir(), "object")
+
+		downloaded, err := downloadMultiSource(context.Background(), []TransferDetails{cache1, cache2}, dest, "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents)), downloaded)
+		result, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, contents, result)
+		assert.Equal(t, int32(11), ranges1.Load()+ranges2.Load())
+	})
+
+	t.Run("slow-source-ranges-are-reassigned", func(t *testing.T) {
+		fastCache, _ := startRangeCache(t, "127.0.0.1", contents, false)
+		slowCache, slowRanges := startRangeCache(t, "localhost", contents, true)
+		dest := t.TempDir()
+
+		downloaded, err := downloadMultiSource(context.Background(), []TransferDetails{slowCache, fastCache}, dest, "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents)), downloaded)
+		result, err := os.ReadFile(filepath.Join(dest, "object"))
+		require.NoError(t, err)
+		assert.Equal(t, contents, result)
+		// The slow cache is abandoned after its first range
+		assert.Equal(t, int32(1), slowRanges.Load())
+	})
+
+	t.Run("all-sources-fail", func(t *testing.T) {
+		slowCache1, _ := startRangeCache(t, "127.0.0.1", contents, true)
+		slowCache2, _ := startRangeCache(t, "localhost", contents, true)
+		dest := filepath.Join(t.TempDir(), "object")
+
+		_, err := downloadMultiSource(context.Background(), []TransferDetails{slowCache1, slowCache2}, dest, "")
+		assert.Error(t, err)
+		assert.NoFileExists(t, dest)
+		// The partial download is kept around to be resumed
+		assert.FileExists(t, partialPath(dest))
+		assert.FileExists(t, dest+partialStateSuffix)
+	})
+
+	t.Run("resumes-partial-download", func(t *testing.T) {
+		cache1, ranges1 := startRangeCache(t, "127.0.0.1", contents, false)
+		cache2, ranges2 := startRangeCache(t, "localhost", contents, false)
+		dest := filepath.Join(t.TempDir(), "object")
+
+		// An earlier attempt got the first 3 and last ranges
+		partial := make([]byte, len(contents))
+		copy(partial[:3072], contents)
+		copy(partial[10240:], contents[10240:])
+		require.NoError(t, os.WriteFile(partialPath(dest), partial, 0644))
+		state := newPartialState(dest, cache1.Url, objectInfo{size: int64(len(contents)), etag: `"test-object"`})
+		require.NoError(t, state.addCompleted(byteRange{0, 3072}))
+		require.NoError(t, state.addCompleted(byteRange{10240, int64(len(contents))}))
+
+		_, err := downloadMultiSource(context.Background(), []TransferDetails{cache1, cache2}, dest, "")
+		require.NoError(t, err)
+		result, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, contents, result)
+		assert.Equal(t, int32(7), ranges1.Load()+ranges2.Load())
+		assert.NoFileExists(t, dest+partialStateSuffix)
+	})
+
+	t.Run("single-source-unsupported", func(t *testing.T) {
+		cache, _ := startRangeCache(t, "127.0.0.1", contents, false)
+		sameHost := cache
+		sameHost.Proxy = true
+		_, err := downloadMultiSource(context.Background(), []TransferDetails{cache, sameHost}, filepath.Join(t.TempDir(), "object"), "")
+		assert.ErrorIs(t, err, errMultiSourceUnsupported)
+	})
+}
@@ -0,0 +1,219 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/studio-b12/gowebdav"
+
+	"github.com/pelicanplatform/pelican/namespaces"
+)
+
+// ObjectInfo describes an object or collection in the federation, as
+// reported by `pelican object ls` and `pelican object stat`
+type ObjectInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime,omitempty"`
+	IsCollection bool      `json:"isCollection"`
+	// Checksums in the form "<algorithm>:<hex value>"
+	Checksums []string `json:"checksums,omitempty"`
+	// The cache or origin that provided the information
+	Server string `json:"server"`
+}
+
+// An object in a federation, resolved to its namespace
+type remoteTarget struct {
+	objectPath string
+	tokenName  string
+	ns         namespaces.Namespace
+	// The token for reading the object, if the namespace requires one
+	token string
+}
+
+// Look up the namespace of a remote object along with the token to use for
+// it, returning the context to make requests about the object with
+func (tc *TransferClient) resolveRemoteObject(ctx context.Context, remoteObject string) (context.Context, *remoteTarget, error) {
+	remoteObjectUrl, objectPath, err := parseRemoteObject(remoteObject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tc, err = tc.forTransfer(remoteObjectUrl, false); err != nil {
+		return nil, nil, err
+	}
+	ctx = tc.withContext(ctx)
+
+	target := &remoteTarget{objectPath: objectPath}
+	_, target.tokenName = getTokenName(remoteObjectUrl)
+	target.ns, err = getNamespaceInfo(ctx, objectPath, tc.directorUrl, false)
+	if err != nil {
+		log.Errorln(err)
+		return nil, nil, errors.New("Failed to get namespace information from source")
+	}
+
+	if target.ns.UseTokenOnRead {
+		target.token, err = getToken(ctx, &url.URL{Path: objectPath}, target.ns, false, target.tokenName)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to get token though required to read from this namespace")
+		}
+	}
+	return ctx, target, nil
+}
+
+func objectInfoFromFileInfo(dir string, fileInfo os.FileInfo, server string) ObjectInfo {
+	return ObjectInfo{
+		Name:         path.Join(dir, fileInfo.Name()),
+		Size:         fileInfo.Size(),
+		ModTime:      fileInfo.ModTime(),
+		IsCollection: fileInfo.IsDir(),
+		Server:       server,
+	}
+}
+
+func listCollection(c *gowebdav.Client, dir string, recursive bool, server string) ([]ObjectInfo, error) {
+	entries, err := c.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list %s", dir)
+	}
+	var infos []ObjectInfo
+	for _, entry := range entries {
+		info := objectInfoFromFileInfo(dir, entry, server)
+		infos = append(infos, info)
+		if recursive && info.IsCollection {
+			children, err := listCollection(c, info.Name, recursive, server)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, children...)
+		}
+	}
+	return infos, nil
+}
+
+// DoList lists the contents of a collection in the federation, using the
+// directory listing host of its namespace. Listing an object rather than a
+// collection returns just that object.
+func DoList(remoteObject string, recursive bool) ([]ObjectInfo, error) {
+	return defaultTransferClient().List(context.Background(), remoteObject, recursive)
+}
+
+func dirListServer(ns namespaces.Namespace) string {
+	if dirListUrl, err := url.Parse(ns.DirListHost); err == nil {
+		return dirListUrl.Host
+	}
+	return ns.DirListHost
+}
+
+func listObjects(ctx context.Context, target *remoteTarget, recursive bool) ([]ObjectInfo, error) {
+	objectPath := target.objectPath
+	c, err := newDirListClient(ctx, target.ns, target.token)
+	if err != nil {
+		return nil, err
+	}
+	server := dirListServer(target.ns)
+
+	fileInfo, err := c.Stat(objectPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to stat %s", objectPath)
+	}
+	if !fileInfo.IsDir() {
+		info := objectInfoFromFileInfo(path.Dir(objectPath), fileInfo, server)
+		info.Name = objectPath
+		return []ObjectInfo{info}, nil
+	}
+	return listCollection(c, objectPath, recursive, server)
+}
+
+// DoStat gets the size, modification time and, where the server provides
+// them, checksums of an object in the federation. The caches for the object
+// are asked first; collections, and objects no cache could answer for, are
+// looked up at the namespace's directory listing host.
+func DoStat(remoteObject string) (*ObjectInfo, error) {
+	return defaultTransferClient().Stat(context.Background(), remoteObject)
+}
+
+// Stat an object, also returning the digests the server reported for it
+func statObject(ctx context.Context, target *remoteTarget) (*ObjectInfo, map[ChecksumType][]byte, error) {
+	tc := transferClientFromContext(ctx)
+	objectPath, ns, token := target.objectPath, target.ns, target.token
+	caches, err := tc.cachesForNamespace(ns, tc.directorUrl != "")
+	if err != nil {
+		log.Debugln("Failed to get namespaced caches (treated as non-fatal):", err)
+	}
+	cachesToTry := tc.cachesToTry
+	if cachesToTry > len(caches) {
+		cachesToTry = len(caches)
+	}
+	var transfers []TransferDetails
+	for _, cache := range caches[:cachesToTry] {
+		td := TransferDetailsOptions{
+			NeedsToken: ns.ReadHTTPS || ns.UseTokenOnRead,
+		}
+		for _, transfer := range GenerateTransferDetailsUsingCache(cache, td) {
+			transfer.Url.Path = objectPath
+			transfers = append(transfers, transfer)
+		}
+	}
+
+	var headErr error
+	if len(transfers) > 0 {
+		var info objectInfo
+		if info, headErr = headObject(ctx, transfers, token); headErr == nil {
+			objInfo := &ObjectInfo{
+				Name:   objectPath,
+				Size:   info.size,
+				Server: info.server,
+			}
+			if modTime, err := http.ParseTime(info.lastModified); err == nil {
+				objInfo.ModTime = modTime
+			}
+			// Report the checksums in our order of preference
+			for _, checksumType := range defaultChecksums {
+				if value, ok := info.digests[checksumType]; ok {
+					objInfo.Checksums = append(objInfo.Checksums, ChecksumInfo{Algorithm: checksumType, Value: value}.String())
+				}
+			}
+			return objInfo, info.digests, nil
+		}
+		log.Debugln("Caches could not stat", objectPath, "; trying the directory listing host:", headErr)
+	}
+
+	c, err := newDirListClient(ctx, ns, token)
+	if err != nil {
+		if headErr != nil {
+			return nil, nil, errors.Wrapf(headErr, "Failed to stat %s", objectPath)
+		}
+		return nil, nil, err
+	}
+	fileInfo, err := c.Stat(objectPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to stat %s", objectPath)
+	}
+	objInfo := objectInfoFromFileInfo(path.Dir(objectPath), fileInfo, dirListServer(ns))
+	objInfo.Name = objectPath
+	return &objInfo, nil, nil
+}
@@ -0,0 +1,124 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// Start a server that acts as both the director and, via WebDAV, the cache
+// and collections host for the /test namespace
+func startListingFederation(t *testing.T) *httptest.Server {
+	fs := webdav.NewMemFS()
+	ctx := context.Background()
+	require.NoError(t, fs.Mkdir(ctx, "/test", 0755))
+	require.NoError(t, fs.Mkdir(ctx, "/test/dir", 0755))
+	for name, contents := range map[string]string{"/test/a.txt": "hello", "/test/dir/b.txt": "hello world"} {
+		file, err := fs.OpenFile(ctx, name, os.O_CREATE|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+	davHandler := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if objectPath, found := strings.CutPrefix(r.URL.Path, "/director"); found {
+			w.Header().Set("Link", "<"+server.URL+">; rel=\"duplicate\"; pri=1")
+			w.Header().Set("X-Pelican-Namespace", "namespace=/test, require-token=false, collections-url="+server.URL)
+			w.Header().Set("Location", server.URL+objectPath)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		if r.Method == http.MethodHead && r.URL.Path == "/test/a.txt" {
+			// crc32c of "hello"
+			w.Header().Set("Digest", "crc32c=9a71bb4c")
+		}
+		davHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	viper.Reset()
+	viper.Set("Federation.DirectorUrl", server.URL+"/director")
+	t.Cleanup(viper.Reset)
+	return server
+}
+
+func TestDoList(t *testing.T) {
+	server := startListingFederation(t)
+	serverUrl, _ := url.Parse(server.URL)
+
+	infos, err := DoList("/test", false)
+	require.NoError(t, err)
+	names := make(map[string]ObjectInfo)
+	for _, info := range infos {
+		names[info.Name] = info
+	}
+	assert.Len(t, names, 2)
+	assert.Equal(t, int64(5), names["/test/a.txt"].Size)
+	assert.False(t, names["/test/a.txt"].IsCollection)
+	assert.True(t, names["/test/dir"].IsCollection)
+	assert.Equal(t, serverUrl.Host, names["/test/a.txt"].Server)
+
+	infos, err = DoList("/test", true)
+	require.NoError(t, err)
+	names = make(map[string]ObjectInfo)
+	for _, info := range infos {
+		names[info.Name] = info
+	}
+	assert.Len(t, names, 3)
+	assert.Equal(t, int64(11), names["/test/dir/b.txt"].Size)
+
+	// Listing an object gives just that object
+	infos, err = DoList("/test/dir/b.txt", false)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "/test/dir/b.txt", infos[0].Name)
+}
+
+func TestDoStat(t *testing.T) {
+	server := startListingFederation(t)
+	serverUrl, _ := url.Parse(server.URL)
+
+	info, err := DoStat("/test/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "/test/a.txt", info.Name)
+	assert.Equal(t, int64(5), info.Size)
+	assert.False(t, info.ModTime.IsZero())
+	assert.Equal(t, []string{"crc32c:9a71bb4c"}, info.Checksums)
+	assert.Equal(t, serverUrl.Host, info.Server)
+
+	info, err = DoStat("/test/dir")
+	require.NoError(t, err)
+	assert.True(t, info.IsCollection)
+
+	_, err = DoStat("/test/missing.txt")
+	assert.Error(t, err)
+}
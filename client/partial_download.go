@@ -0,0 +1,261 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Downloads are written next to their destination and only renamed into place
+// once complete. The state file records enough to resume an interrupted
+// download, in this process or a later one.
+const (
+	partialSuffix      = ".pelican-partial"
+	partialStateSuffix = ".pelican-partial.state"
+)
+
+type (
+	// What the server told us about the object, used to make sure it hasn't
+	// changed before we resume a download
+	objectInfo struct {
+		size         int64
+		etag         string
+		lastModified string
+		digests      map[ChecksumType][]byte
+		// The host that answered
+		server string
+	}
+
+	partialState struct {
+		SourceURL    string      `json:"sourceUrl"`
+		Size         int64       `json:"size"`
+		ETag         string      `json:"etag,omitempty"`
+		LastModified string      `json:"lastModified,omitempty"`
+		Completed    []byteRange `json:"completed"`
+
+		dest  string
+		mutex sync.Mutex
+	}
+)
+
+func partialPath(dest string) string {
+	return dest + partialSuffix
+}
+
+func objectInfoFromResponse(resp *http.Response, size int64) objectInfo {
+	return objectInfo{
+		size:         size,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		digests:      parseDigests(resp.Header),
+	}
+}
+
+func newPartialState(dest string, sourceURL url.URL, info objectInfo) *partialState {
+	return &partialState{
+		SourceURL:    sourceURL.String(),
+		Size:         info.size,
+		ETag:         info.etag,
+		LastModified: info.lastModified,
+		dest:         dest,
+	}
+}
+
+// Load the state of an earlier, interrupted download of dest. Returns nil if
+// there is nothing to resume.
+func loadPartialState(dest string) *partialState {
+	contents, err := os.ReadFile(dest + partialStateSuffix)
+	if err != nil {
+		return nil
+	}
+	state := &partialState{dest: dest}
+	if err = json.Unmarshal(contents, state); err != nil {
+		log.Debugf("Ignoring corrupt partial download state for %s: %v", dest, err)
+		return nil
+	}
+	if _, err = os.Stat(partialPath(dest)); err != nil {
+		return nil
+	}
+	return state
+}
+
+// Whether the partial download is of the same object, unchanged. Caches
+// may differ in how they form the ETag, so the object only needs to be at the
+// same path; objects without any validators are never resumed.
+func (ps *partialState) matches(sourceURL url.URL, info objectInfo) bool {
+	prevURL, err := url.Parse(ps.SourceURL)
+	if err != nil || prevURL.Path != sourceURL.Path || ps.Size != info.size {
+		return false
+	}
+	if ps.ETag != "" && info.etag != "" {
+		return ps.ETag == info.etag
+	}
+	if ps.LastModified != "" && info.lastModified != "" {
+		return ps.LastModified == info.lastModified
+	}
+	return false
+}
+
+// Write the state out atomically, so an interrupted save can't lose it
+func (ps *partialState) save() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	contents, err := json.Marshal(ps)
+	if err != nil {
+		return errors.Wrap(err, "Failed to serialize partial download state")
+	}
+	tmpFile := ps.dest + partialStateSuffix + ".tmp"
+	if err = os.WriteFile(tmpFile, contents, 0644); err != nil {
+		return errors.Wrap(err, "Failed to write partial download state")
+	}
+	return errors.Wrap(os.Rename(tmpFile, ps.dest+partialStateSuffix), "Failed to write partial download state")
+}
+
+// Record a range as downloaded, merging it with its neighbors, and save the state
+func (ps *partialState) addCompleted(r byteRange) error {
+	if r.Start >= r.End {
+		return nil
+	}
+	ps.mutex.Lock()
+	ranges := append(ps.Completed, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End {
+			if next.End > last.End {
+				last.End = next.End
+			}
+		} else {
+			merged = append(merged, next)
+		}
+	}
+	ps.Completed = merged
+	ps.mutex.Unlock()
+	return ps.save()
+}
+
+// Record that the first `size` bytes of the object have been downloaded, as
+// is the case for sequential downloads
+func (ps *partialState) setCompletedPrefix(size int64) error {
+	ps.mutex.Lock()
+	ps.Completed = nil
+	if size > 0 {
+		ps.Completed = []byteRange{{0, size}}
+	}
+	ps.mutex.Unlock()
+	return ps.save()
+}
+
+// The number of bytes downloaded from the start of the object onwards
+func (ps *partialState) completedPrefix() int64 {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if len(ps.Completed) == 0 || ps.Completed[0].Start != 0 {
+		return 0
+	}
+	return ps.Completed[0].End
+}
+
+func (ps *partialState) completedBytes() (total int64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for _, r := range ps.Completed {
+		total += r.End - r.Start
+	}
+	return
+}
+
+// The parts of the object still to be downloaded, split into ranges of at most rangeSize
+func (ps *partialState) missing(rangeSize int64) (ranges []byteRange) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	appendSplit := func(start, end int64) {
+		for ; start < end; start += rangeSize {
+			rangeEnd := start + rangeSize
+			if rangeEnd > end {
+				rangeEnd = end
+			}
+			ranges = append(ranges, byteRange{start, rangeEnd})
+		}
+	}
+	next := int64(0)
+	for _, r := range ps.Completed {
+		appendSplit(next, r.Start)
+		next = r.End
+	}
+	appendSplit(next, ps.Size)
+	return
+}
+
+// Get the partial download of dest ready for a sequential download to pick up
+// where it left off, after checking the object hasn't changed. Returns nil
+// (having cleaned up) if the download has to start from scratch.
+func resumeSequential(ctx context.Context, dest string, transfer TransferDetails, token string) *partialState {
+	state := loadPartialState(dest)
+	if state == nil {
+		discardPartial(dest)
+		return nil
+	}
+	info, err := headObject(ctx, []TransferDetails{transfer}, token)
+	if err != nil || !state.matches(transfer.Url, info) {
+		log.Debugf("Not resuming the download of %s; the object can't be validated or has changed", dest)
+		discardPartial(dest)
+		return nil
+	}
+	// Only the completed prefix is of use to a sequential download
+	prefix := state.completedPrefix()
+	if err = os.Truncate(partialPath(dest), prefix); err != nil {
+		discardPartial(dest)
+		return nil
+	}
+	if err = state.setCompletedPrefix(prefix); err != nil {
+		log.Warningln("Failed to update partial download state:", err)
+	}
+	log.Infof("Resuming the download of %s from byte %d", dest, prefix)
+	return state
+}
+
+// Move the completed download into place
+func finishPartial(dest string) error {
+	if err := os.Rename(partialPath(dest), dest); err != nil {
+		return errors.Wrap(err, "Failed to move the completed download into place")
+	}
+	if err := os.Remove(dest + partialStateSuffix); err != nil && !os.IsNotExist(err) {
+		log.Warningln("Failed to remove partial download state:", err)
+	}
+	return nil
+}
+
+func discardPartial(dest string) {
+	for _, file := range []string{partialPath(dest), dest + partialStateSuffix} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Warningf("Failed to remove %s: %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialStateRanges(t *testing.T) {
+	state := newPartialState(filepath.Join(t.TempDir(), "object"), url.URL{Path: "/foo"}, objectInfo{size: 100, etag: `"abc"`})
+	assert.Equal(t, []byteRange{{0, 40}, {40, 80}, {80, 100}}, state.missing(40))
+
+	require.NoError(t, state.addCompleted(byteRange{50, 60}))
+	require.NoError(t, state.addCompleted(byteRange{10, 20}))
+	require.NoError(t, state.addCompleted(byteRange{20, 30}))
+	assert.Equal(t, []byteRange{{10, 30}, {50, 60}}, state.Completed)
+	assert.Equal(t, int64(0), state.completedPrefix())
+	assert.Equal(t, int64(30), state.completedBytes())
+	assert.Equal(t, []byteRange{{0, 10}, {30, 50}, {60, 100}}, state.missing(40))
+
+	require.NoError(t, state.addCompleted(byteRange{0, 10}))
+	assert.Equal(t, int64(30), state.completedPrefix())
+
+	// The state survives a trip through the sidecar file
+	reloaded := loadPartialState(state.dest)
+	assert.Nil(t, reloaded, "There's no partial file to go with the state")
+	require.NoError(t, os.WriteFile(partialPath(state.dest), nil, 0644))
+	reloaded = loadPartialState(state.dest)
+	require.NotNil(t, reloaded)
+	assert.Equal(t, state.Completed, reloaded.Completed)
+
+	assert.True(t, reloaded.matches(url.URL{Host: "other-cache", Path: "/foo"}, objectInfo{size: 100, etag: `"abc"`}))
+	assert.False(t, reloaded.matches(url.URL{Path: "/foo"}, objectInfo{size: 100, etag: `"def"`}))
+	assert.False(t, reloaded.matches(url.URL{Path: "/foo"}, objectInfo{size: 101, etag: `"abc"`}))
+	assert.False(t, reloaded.matches(url.URL{Path: "/bar"}, objectInfo{size: 100, etag: `"abc"`}))
+	assert.False(t, reloaded.matches(url.URL{Path: "/foo"}, objectInfo{size: 100}), "Objects without validators can't be resumed")
+}
+
+func TestResumeDownload(t *testing.T) {
+	contents := make([]byte, 4096)
+	_, err := rand.Read(contents)
+	require.NoError(t, err)
+
+	var rangeRequested string
+	etag := `"version-1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			rangeRequested = r.Header.Get("Range")
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	srvURL.Path = "/test/object"
+	transfer := TransferDetails{Url: *srvURL}
+
+	// Set up the aftermath of a download that was interrupted after 1000 bytes
+	interrupt := func(t *testing.T, dest string) {
+		state := newPartialState(dest, *srvURL, objectInfo{size: int64(len(contents)), etag: `"version-1"`})
+		require.NoError(t, os.WriteFile(partialPath(dest), contents[:1500], 0644))
+		require.NoError(t, state.setCompletedPrefix(1000))
+	}
+
+	t.Run("resumes-unchanged-object", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "object")
+		interrupt(t, dest)
+
+		downloaded, err := DownloadHTTP(transfer, dest, "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents)), downloaded)
+		assert.Equal(t, "bytes=1000-", rangeRequested)
+		result, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, contents, result)
+		assert.NoFileExists(t, partialPath(dest))
+		assert.NoFileExists(t, dest+partialStateSuffix)
+	})
+
+	t.Run("restarts-changed-object", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "object")
+		interrupt(t, dest)
+		etag = `"version-2"`
+		rangeRequested = ""
+
+		_, err := DownloadHTTP(transfer, dest, "")
+		require.NoError(t, err)
+		assert.Empty(t, rangeRequested)
+		result, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, contents, result)
+	})
+}
@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"net/url"
 	"strings"
 
@@ -26,7 +27,6 @@ import (
 	"github.com/pelicanplatform/pelican/param"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 func getDirectorFromUrl(objectUrl *url.URL) (string, error) {
@@ -43,12 +43,11 @@ func getDirectorFromUrl(objectUrl *url.URL) (string, error) {
 				Scheme: "https",
 				Host:   objectUrl.Host,
 			}
-			viper.Set("Federation.DirectorUrl", "")
-			viper.Set("Federation.DiscoveryUrl", discoveryUrl.String())
-			if err := config.DiscoverFederation(); err != nil {
+			metadata, err := config.DiscoverUrlFederation(discoveryUrl.String())
+			if err != nil {
 				return "", errors.Wrapf(err, "Failed to discover location of the director for the federation %s", objectUrl.Host)
 			}
-			if directorUrl = param.Federation_DirectorUrl.GetString(); directorUrl == "" {
+			if directorUrl = metadata.DirectorEndpoint; directorUrl == "" {
 				return "", errors.Errorf("Director for the federation %s not discovered", objectUrl.Host)
 			}
 		}
@@ -57,11 +56,11 @@ func getDirectorFromUrl(objectUrl *url.URL) (string, error) {
 			objectUrl.Path = "/" + objectUrl.Host + objectUrl.Path
 			objectUrl.Host = ""
 		}
-		viper.Set("Federation.DiscoveryUrl", "https://osg-htc.org")
-		if err := config.DiscoverFederation(); err != nil {
+		metadata, err := config.DiscoverUrlFederation("https://osg-htc.org")
+		if err != nil {
 			return "", errors.Wrap(err, "Failed to discover director for the OSDF")
 		}
-		if directorUrl = param.Federation_DirectorUrl.GetString(); directorUrl == "" {
+		if directorUrl = metadata.DirectorEndpoint; directorUrl == "" {
 			return "", errors.Errorf("Director for the OSDF not discovered")
 		}
 	} else if objectUrl.Scheme == "" {
@@ -84,7 +83,7 @@ func CreateSharingUrl(objectUrl *url.URL, isWrite bool) (string, error) {
 	objectUrl.Path = "/" + strings.TrimPrefix(objectUrl.Path, "/")
 
 	log.Debugln("Will query director for path", objectUrl.Path)
-	dirResp, err := queryDirector("GET", objectUrl.Path, directorUrl)
+	dirResp, err := queryDirector(context.Background(), "GET", objectUrl.Path, directorUrl)
 	if err != nil {
 		log.Errorln("Error while querying the Director:", err)
 		return "", errors.Wrapf(err, "Error while querying the director at %s", directorUrl)
@@ -0,0 +1,187 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// Counts the bytes written through it, so a failed download knows
+	// whether any of the object was already streamed out
+	countingWriter struct {
+		writer  io.Writer
+		written int64
+	}
+)
+
+// StreamPath names stdin as the source of an upload, or stdout as the
+// destination of a download
+const StreamPath = "-"
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// StreamSize returns the size of the data to be read from file, or -1 if
+// it's a pipe or terminal whose size can't be known in advance
+func StreamSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return -1
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return info.Size() - offset
+}
+
+// GetToWriter downloads remoteObject, writing it to output as it arrives.
+// Another cache is only tried if the one before failed before writing
+// anything, as what's been written can't be taken back.
+func (tc *TransferClient) GetToWriter(ctx context.Context, remoteObject string, output io.Writer) (result TransferResult, err error) {
+	result = TransferResult{Source: remoteObject, Destination: StreamPath, Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+		result.Error = err
+		result.Attempts = tc.transferAttempts()
+	}()
+	defer tc.recoverTransfer("GetToWriter", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	remoteObjectUrl, remoteObject, err := parseRemoteObject(remoteObject)
+	if err != nil {
+		return
+	}
+	if remoteObjectUrl.Query().Get("pack") != "" {
+		err = errors.New("Objects can't be unpacked when streamed")
+		return
+	}
+	if tc, err = tc.forTransfer(remoteObjectUrl, false); err != nil {
+		return
+	}
+	ctx = tc.withContext(ctx)
+
+	ns, err := getNamespaceInfo(ctx, remoteObject, tc.directorUrl, false)
+	if err != nil {
+		log.Errorln(err)
+		err = errors.New("Failed to get namespace information from source")
+		return
+	}
+	var token string
+	if ns.UseTokenOnRead {
+		_, tokenName := getTokenName(remoteObjectUrl)
+		if token, err = getToken(ctx, &url.URL{Path: remoteObjectUrl.Path}, ns, false, tokenName); err != nil {
+			log.Errorln("Failed to get token though required to read from this namespace:", err)
+			return
+		}
+	}
+	transfers, err := cacheTransfers(ctx, ns, "")
+	if err != nil {
+		return
+	}
+
+	written := &countingWriter{writer: output}
+	for _, transfer := range transfers {
+		transfer.Url.Path = remoteObjectUrl.Path
+		if _, err = downloadHTTPTo(ctx, transfer, "", written, token); err == nil {
+			break
+		}
+		log.Debugln("Failed to stream from", transfer.Url.Host, ":", err)
+		tc.errors.add(&FileDownloadError{"Failed to download from " + transfer.Url.Host + ": " + err.Error(), err})
+		if written.written > 0 {
+			break
+		}
+	}
+	result.TransferredBytes = written.written
+	if err != nil {
+		log.Error("Http GET failed! Unable to download file.")
+		err = errors.New("failed to download file")
+	}
+	return
+}
+
+// PutFromReader uploads what's read from input to remoteDestination.  If
+// size is negative, the size isn't known in advance and the data is sent
+// with chunked transfer encoding.
+func (tc *TransferClient) PutFromReader(ctx context.Context, input io.Reader, size int64, remoteDestination string) (result TransferResult, err error) {
+	result = TransferResult{Source: StreamPath, Destination: remoteDestination, Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+		result.Error = err
+		result.Attempts = tc.transferAttempts()
+	}()
+	defer tc.recoverTransfer("PutFromReader", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	remoteDestUrl, remoteDestination, err := parseRemoteObject(remoteDestination)
+	if err != nil {
+		return
+	}
+	if remoteDestUrl.Query().Get("pack") != "" {
+		err = errors.New("Only directories can be packed, not streams")
+		return
+	}
+	if tc, err = tc.forTransfer(remoteDestUrl, false); err != nil {
+		return
+	}
+	ctx = tc.withContext(ctx)
+
+	ns, err := getNamespaceInfo(ctx, remoteDestination, tc.directorUrl, true)
+	if err != nil {
+		log.Errorln(err)
+		err = errors.New("Failed to get namespace information from source")
+		return
+	}
+	token, err := getToken(ctx, remoteDestUrl, ns, true, "")
+	if err != nil {
+		return
+	}
+	result.TransferredBytes, result.Checksums, err = uploadStream(ctx, input, size, remoteDestUrl, token, ns)
+	if err != nil {
+		tc.errors.add(err)
+	}
+	return
+}
+
+// DoGetToWriter downloads remoteObject to output, returning the number of
+// bytes written
+func DoGetToWriter(remoteObject string, output io.Writer) (bytesTransferred int64, err error) {
+	result, err := defaultTransferClient().GetToWriter(context.Background(), remoteObject, output)
+	return result.TransferredBytes, err
+}
+
+// DoPutFromReader uploads what's read from input to remoteDestination; size
+// is negative if it isn't known in advance
+func DoPutFromReader(input io.Reader, size int64, remoteDestination string) (bytesTransferred int64, err error) {
+	result, err := defaultTransferClient().PutFromReader(context.Background(), input, size, remoteDestination)
+	return result.TransferredBytes, err
+}
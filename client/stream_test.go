@@ -0,0 +1,142 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pelicanplatform/pelican/namespaces"
+)
+
+func TestDoGetToWriter(t *testing.T) {
+	startListingFederation(t)
+
+	var output bytes.Buffer
+	downloaded, err := DoGetToWriter("osdf:///test/dir/b.txt", &output)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), downloaded)
+	assert.Equal(t, "hello world", output.String())
+
+	// Objects streamed one after the other are concatenated
+	downloaded, err = DoGetToWriter("/test/a.txt", &output)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), downloaded)
+	assert.Equal(t, "hello worldhello", output.String())
+
+	output.Reset()
+	_, err = DoGetToWriter("osdf:///test/missing.txt", &output)
+	assert.Error(t, err)
+	assert.Zero(t, output.Len())
+	_, err = DoGetToWriter("osdf:///test/dir?pack=auto", &output)
+	assert.Error(t, err)
+}
+
+func TestUploadStream(t *testing.T) {
+	var received []byte
+	var transferEncoding []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var err error
+			received, err = io.ReadAll(r.Body)
+			if r.URL.Path == "/test/stalled.txt" {
+				// The client gives up partway through
+				return
+			}
+			assert.NoError(t, err)
+			transferEncoding = r.TransferEncoding
+			// crc32c of "hello"
+			w.Header().Set("Digest", "crc32c=9a71bb4c")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	te := NewTransferEngine(context.Background())
+	defer te.Close()
+	te.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	tc, err := te.NewClient()
+	require.NoError(t, err)
+	ctx := tc.withContext(context.Background())
+	dest := &url.URL{Path: "/test/upload.txt"}
+	namespace := namespaces.Namespace{WriteBackHost: server.URL}
+
+	// A pipe's size isn't known, so it's sent chunked
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("hel"))
+		_, _ = writer.Write([]byte("lo"))
+		writer.Close()
+	}()
+	uploaded, verified, err := uploadStream(ctx, reader, -1, dest, "", namespace)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), uploaded)
+	assert.Equal(t, "hello", string(received))
+	assert.Equal(t, []string{"chunked"}, transferEncoding)
+	require.Len(t, verified, 1)
+	assert.Equal(t, ChecksumCRC32C, verified[0].Algorithm)
+
+	// What the origin received has to match what was sent
+	_, _, err = uploadStream(ctx, strings.NewReader("goodbye"), 7, dest, "", namespace)
+	assert.Error(t, err)
+	assert.Equal(t, "goodbye", string(received))
+
+	// A stream isn't held to a minimum speed, but one that stops is given up on
+	oldTimeout := uploadStallTimeout
+	uploadStallTimeout = 200 * time.Millisecond
+	defer func() { uploadStallTimeout = oldTimeout }()
+	reader, writer = io.Pipe()
+	defer writer.Close()
+	go func() {
+		_, _ = writer.Write([]byte("hel"))
+	}()
+	_, _, err = uploadStream(ctx, reader, -1, &url.URL{Path: "/test/stalled.txt"}, "", namespace)
+	var ste *StoppedTransferError
+	assert.ErrorAs(t, err, &ste)
+}
+
+func TestStreamSize(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "stream"))
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("hello world")
+	require.NoError(t, err)
+	_, err = file.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), StreamSize(file))
+
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	defer reader.Close()
+	defer writer.Close()
+	assert.Equal(t, int64(-1), StreamSize(reader))
+}
@@ -0,0 +1,411 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/studio-b12/gowebdav"
+)
+
+type (
+	SyncOp string
+
+	// A single step of synchronizing two trees
+	SyncAction struct {
+		Op          SyncOp `json:"op"`
+		Source      string `json:"source,omitempty"`
+		Destination string `json:"destination"`
+		Size        int64  `json:"size"`
+		// Why the file is transferred or deleted
+		Reason string `json:"reason"`
+	}
+
+	SyncOptions struct {
+		// Remove files at the destination that aren't at the source
+		Delete bool
+		// Compare checksums, when the server provides them, rather than
+		// modification times for files of the same size
+		Checksum bool
+		// Only work out what needs doing
+		DryRun bool
+		// The number of transfers to run at once
+		Workers int
+	}
+
+	// A file in one of the trees being synchronized, keyed by its path
+	// relative to the root of the tree
+	syncFile struct {
+		size    int64
+		modTime time.Time
+	}
+
+	// The remote side of a sync
+	syncRemote struct {
+		*remoteTarget
+		// The context the remote tree was resolved with, which carries the
+		// client to transfer with
+		ctx context.Context
+		// Whether the remote side is the destination
+		upload bool
+	}
+)
+
+const (
+	SyncUpload   SyncOp = "upload"
+	SyncDownload SyncOp = "download"
+	SyncDelete   SyncOp = "delete"
+)
+
+// Whether the argument names an object in a federation rather than a local file
+func isRemoteObject(object string) bool {
+	object, scheme := correctURLWithUnderscore(object)
+	objectUrl, err := url.Parse(object)
+	if err != nil {
+		return false
+	}
+	objectUrl.Scheme = scheme
+	scheme, _ = getTokenName(objectUrl)
+	return scheme == "osdf" || scheme == "pelican"
+}
+
+// The URL to transfer a single object of the remote tree with. The federation
+// has already been discovered for pelican:// URLs, so osdf:// is used, which
+// keeps concurrent transfers from discovering it over again.
+func (sr *syncRemote) objectUrl(relPath string) string {
+	scheme := "osdf"
+	if sr.tokenName != "" {
+		scheme = sr.tokenName + "+" + scheme
+	}
+	return scheme + "://" + path.Join(sr.objectPath, relPath)
+}
+
+// The files in the remote tree. A destination that doesn't exist yet is empty,
+// but a missing source is an error: taking it for an empty tree would have
+// --delete remove everything at the destination.
+func (sr *syncRemote) files() (map[string]syncFile, error) {
+	infos, err := listObjects(sr.ctx, sr.remoteTarget, true)
+	if err != nil {
+		if sr.upload && gowebdav.IsErrNotFound(errors.Cause(err)) {
+			return map[string]syncFile{}, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to list %s", sr.objectPath)
+	}
+	files := make(map[string]syncFile)
+	for _, info := range infos {
+		if info.IsCollection {
+			continue
+		}
+		relPath := strings.TrimPrefix(info.Name, strings.TrimSuffix(sr.objectPath, "/")+"/")
+		if relPath == info.Name {
+			return nil, errors.Errorf("%s is not a collection", sr.objectPath)
+		}
+		files[relPath] = syncFile{size: info.Size, modTime: info.ModTime}
+	}
+	return files, nil
+}
+
+func localFiles(root string) (map[string]syncFile, error) {
+	files := make(map[string]syncFile)
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == root {
+				return nil
+			}
+			return err
+		}
+		// Skip the leftovers of interrupted downloads
+		if entry.IsDir() || strings.Contains(entry.Name(), partialSuffix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = syncFile{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list %s", root)
+	}
+	return files, nil
+}
+
+// Whether the local copy of a file differs from the remote one, per their
+// checksums, and whether that could be determined
+func (sr *syncRemote) checksumsDiffer(localPath string, relPath string) (differ bool, known bool) {
+	target := *sr.remoteTarget
+	target.objectPath = path.Join(sr.objectPath, relPath)
+	_, digests, err := statObject(sr.ctx, &target)
+	if err != nil || len(digests) == 0 {
+		return false, false
+	}
+	_, err = verifyFileChecksums(localPath, digests, defaultChecksums, true)
+	var mismatch *ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		return true, true
+	}
+	return false, err == nil
+}
+
+// Work out what has to be done to make dest match src. Uploads and downloads
+// take the time of the transfer as the modification time of the copy, so a
+// file has changed if it is newer than its copy.
+func planSync(srcFiles, destFiles map[string]syncFile, op SyncOp, checksumsDiffer func(relPath string) (bool, bool), opts SyncOptions) []SyncAction {
+	var actions []SyncAction
+	for relPath, srcFile := range srcFiles {
+		destFile, exists := destFiles[relPath]
+		reason := ""
+		if !exists {
+			reason = "new"
+		} else if srcFile.size != destFile.size {
+			reason = "size changed"
+		} else {
+			checked := false
+			if opts.Checksum {
+				var differ bool
+				if differ, checked = checksumsDiffer(relPath); differ {
+					reason = "checksum changed"
+				}
+			}
+			if !checked && srcFile.modTime.After(destFile.modTime) {
+				reason = "modified"
+			}
+		}
+		if reason != "" {
+			actions = append(actions, SyncAction{Op: op, Source: relPath, Destination: relPath, Size: srcFile.size, Reason: reason})
+		}
+	}
+	if opts.Delete {
+		for relPath, destFile := range destFiles {
+			if _, exists := srcFiles[relPath]; !exists {
+				actions = append(actions, SyncAction{Op: SyncDelete, Destination: relPath, Size: destFile.size, Reason: "not at source"})
+			}
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Op != actions[j].Op {
+			return actions[i].Op > actions[j].Op
+		}
+		return actions[i].Destination < actions[j].Destination
+	})
+	return actions
+}
+
+// A WebDAV client for changing the remote tree
+func (sr *syncRemote) writeClient() (*gowebdav.Client, error) {
+	writeToken, err := getToken(sr.ctx, &url.URL{Path: sr.objectPath}, sr.ns, true, sr.tokenName)
+	if err != nil {
+		return nil, err
+	}
+	return newDirListClient(sr.ctx, sr.ns, writeToken)
+}
+
+// Create the remote collections the uploads will go in
+func (sr *syncRemote) makeCollections(actions []SyncAction) error {
+	collections := make(map[string]bool)
+	for _, action := range actions {
+		if action.Op == SyncUpload {
+			collections[path.Dir(path.Join(sr.objectPath, action.Destination))] = true
+		}
+	}
+	if len(collections) == 0 {
+		return nil
+	}
+	c, err := sr.writeClient()
+	if err != nil {
+		return err
+	}
+	for collection := range collections {
+		log.Debugln("Creating directory:", collection)
+		if err = c.MkdirAll(collection, 0755); err != nil {
+			return errors.Wrapf(err, "Failed to create %s", collection)
+		}
+	}
+	return nil
+}
+
+// Carry out a single step of the sync
+func (sr *syncRemote) apply(action SyncAction, localRoot string) (int64, error) {
+	localPath := filepath.Join(localRoot, filepath.FromSlash(action.Destination))
+	tc := transferClientFromContext(sr.ctx)
+	switch action.Op {
+	case SyncDownload:
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return 0, errors.Wrapf(err, "Failed to create the directory for %s", localPath)
+		}
+		result, err := tc.Get(sr.ctx, sr.objectUrl(action.Source), localPath, false)
+		return result.TransferredBytes, err
+	case SyncUpload:
+		result, err := tc.Put(sr.ctx, filepath.Join(localRoot, filepath.FromSlash(action.Source)), sr.objectUrl(action.Destination), false)
+		return result.TransferredBytes, err
+	case SyncDelete:
+		if !sr.upload {
+			return 0, errors.Wrapf(os.Remove(localPath), "Failed to delete %s", localPath)
+		}
+		c, err := sr.writeClient()
+		if err != nil {
+			return 0, err
+		}
+		return 0, errors.Wrapf(c.Remove(path.Join(sr.objectPath, action.Destination)), "Failed to delete %s", action.Destination)
+	}
+	return 0, errors.Errorf("unknown sync operation %s", action.Op)
+}
+
+// DoSync makes the destination tree match the source tree, transferring only
+// the files that are new or have changed (by size, and then by modification
+// time or checksum). Exactly one of source and destination must be a remote
+// (osdf:// or pelican://) URL. Returns the actions taken, or with DryRun set,
+// the ones that would be.
+func DoSync(source string, destination string, opts SyncOptions) (actions []SyncAction, bytesTransferred int64, err error) {
+	return defaultTransferClient().Sync(context.Background(), source, destination, opts)
+}
+
+// Sync is DoSync with the client's options
+func (tc *TransferClient) Sync(ctx context.Context, source string, destination string, opts SyncOptions) (actions []SyncAction, bytesTransferred int64, err error) {
+	upload := isRemoteObject(destination)
+	if upload == isRemoteObject(source) {
+		return nil, 0, errors.New("Exactly one of the source and destination must be an osdf:// or pelican:// URL")
+	}
+	remoteObject, localRoot := source, destination
+	if upload {
+		remoteObject, localRoot = destination, source
+	}
+
+	ctx, target, err := tc.resolveRemoteObject(ctx, remoteObject)
+	if err != nil {
+		return nil, 0, err
+	}
+	remote := &syncRemote{remoteTarget: target, ctx: ctx, upload: upload}
+	remoteFiles, err := remote.files()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if upload {
+		if info, err := os.Stat(localRoot); err != nil || !info.IsDir() {
+			return nil, 0, errors.Errorf("%s is not a directory", localRoot)
+		}
+	}
+	localTree, err := localFiles(localRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	checksumsDiffer := func(relPath string) (bool, bool) {
+		return remote.checksumsDiffer(filepath.Join(localRoot, filepath.FromSlash(relPath)), relPath)
+	}
+	if upload {
+		actions = planSync(localTree, remoteFiles, SyncUpload, checksumsDiffer, opts)
+	} else {
+		actions = planSync(remoteFiles, localTree, SyncDownload, checksumsDiffer, opts)
+	}
+	if opts.DryRun || len(actions) == 0 {
+		return actions, 0, nil
+	}
+	if err = remote.makeCollections(actions); err != nil {
+		return actions, 0, err
+	}
+
+	// Like rsync, only delete once everything else has been transferred, and
+	// not at all if anything failed to be
+	var transfers, deletes []SyncAction
+	for _, action := range actions {
+		if action.Op == SyncDelete {
+			deletes = append(deletes, action)
+		} else {
+			transfers = append(transfers, action)
+		}
+	}
+	bytesTransferred, failed, firstError := remote.applyAll(transfers, localRoot, opts.Workers)
+	if failed > 0 {
+		if len(deletes) > 0 {
+			log.Warningf("Not deleting %d files because of the failed transfers", len(deletes))
+		}
+		return actions, bytesTransferred, errors.Wrapf(firstError, "%d of %d transfers failed", failed, len(transfers))
+	}
+	_, failed, firstError = remote.applyAll(deletes, localRoot, opts.Workers)
+	if failed > 0 {
+		return actions, bytesTransferred, errors.Wrapf(firstError, "%d of %d deletions failed", failed, len(deletes))
+	}
+	return actions, bytesTransferred, nil
+}
+
+// Carry out the actions with the given number of workers, returning the bytes
+// transferred, the number of actions that failed and the first error
+func (sr *syncRemote) applyAll(actions []SyncAction, localRoot string, workers int) (bytesTransferred int64, failed int, firstError error) {
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	work := make(chan SyncAction)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for action := range work {
+				transferred, err := sr.apply(action, localRoot)
+				mutex.Lock()
+				bytesTransferred += transferred
+				if err != nil {
+					log.Errorf("Failed to %s %s: %v", action.Op, action.Destination, err)
+					failed++
+					if firstError == nil {
+						firstError = err
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, action := range actions {
+		work <- action
+	}
+	close(work)
+	wg.Wait()
+	return
+}
+
+// Describe an action for the --dry-run plan
+func (action SyncAction) String() string {
+	switch action.Op {
+	case SyncDelete:
+		return string(action.Op) + " " + action.Destination + " (" + action.Reason + ")"
+	default:
+		return string(action.Op) + " " + action.Source + " (" + action.Reason + ", " + ByteCountSI(action.Size) + ")"
+	}
+}
@@ -0,0 +1,124 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanSync(t *testing.T) {
+	now := time.Now()
+	src := map[string]syncFile{
+		"new.txt":       {size: 1, modTime: now},
+		"resized.txt":   {size: 2, modTime: now},
+		"modified.txt":  {size: 3, modTime: now},
+		"unchanged.txt": {size: 4, modTime: now.Add(-time.Hour)},
+	}
+	dest := map[string]syncFile{
+		"resized.txt":   {size: 1, modTime: now},
+		"modified.txt":  {size: 3, modTime: now.Add(-time.Hour)},
+		"unchanged.txt": {size: 4, modTime: now},
+		"extra.txt":     {size: 5, modTime: now},
+	}
+	noChecksums := func(string) (bool, bool) { return false, false }
+
+	actions := planSync(src, dest, SyncUpload, noChecksums, SyncOptions{})
+	assert.Equal(t, []SyncAction{
+		{Op: SyncUpload, Source: "modified.txt", Destination: "modified.txt", Size: 3, Reason: "modified"},
+		{Op: SyncUpload, Source: "new.txt", Destination: "new.txt", Size: 1, Reason: "new"},
+		{Op: SyncUpload, Source: "resized.txt", Destination: "resized.txt", Size: 2, Reason: "size changed"},
+	}, actions)
+
+	actions = planSync(src, dest, SyncUpload, noChecksums, SyncOptions{Delete: true})
+	require.Len(t, actions, 4)
+	assert.Equal(t, SyncAction{Op: SyncDelete, Destination: "extra.txt", Size: 5, Reason: "not at source"}, actions[3])
+
+	// Checksums, when known, take precedence over modification times
+	differ := func(relPath string) (bool, bool) { return relPath == "unchanged.txt", true }
+	actions = planSync(src, dest, SyncDownload, differ, SyncOptions{Checksum: true})
+	require.Len(t, actions, 3)
+	assert.Equal(t, "new.txt", actions[0].Destination)
+	assert.Equal(t, "resized.txt", actions[1].Destination)
+	assert.Equal(t, SyncAction{Op: SyncDownload, Source: "unchanged.txt", Destination: "unchanged.txt", Size: 4, Reason: "checksum changed"}, actions[2])
+}
+
+func TestDoSyncDownload(t *testing.T) {
+	startListingFederation(t)
+	localDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "extra.txt"), []byte("extra"), 0644))
+
+	// A dry run only reports the plan
+	actions, transferred, err := DoSync("osdf:///test", localDir, SyncOptions{DryRun: true, Delete: true})
+	require.NoError(t, err)
+	assert.Zero(t, transferred)
+	require.Len(t, actions, 3)
+	assert.Equal(t, "a.txt", actions[0].Source)
+	assert.Equal(t, "dir/b.txt", actions[1].Source)
+	assert.Equal(t, SyncDelete, actions[2].Op)
+	_, err = os.Stat(filepath.Join(localDir, "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	actions, transferred, err = DoSync("osdf:///test", localDir, SyncOptions{Delete: true, Workers: 2})
+	require.NoError(t, err)
+	assert.Len(t, actions, 3)
+	assert.Equal(t, int64(16), transferred)
+	contents, err := os.ReadFile(filepath.Join(localDir, "dir", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(contents))
+	_, err = os.Stat(filepath.Join(localDir, "extra.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Nothing has changed, so there's nothing more to do
+	actions, _, err = DoSync("osdf:///test", localDir, SyncOptions{Delete: true})
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
+
+func TestDoSyncDeletesSafely(t *testing.T) {
+	startListingFederation(t)
+	localDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "keep.txt"), []byte("keep"), 0644))
+
+	// A source that doesn't exist isn't an empty tree
+	_, _, err := DoSync("osdf:///test/typo", localDir, SyncOptions{Delete: true})
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(localDir, "keep.txt"))
+
+	// Nothing is deleted when a transfer fails, here because a file is in the
+	// way of the directory a download goes in
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "dir"), []byte("in the way"), 0644))
+	actions, _, err := DoSync("osdf:///test", localDir, SyncOptions{Delete: true, Workers: 2})
+	assert.Error(t, err)
+	assert.Contains(t, actions, SyncAction{Op: SyncDelete, Destination: "keep.txt", Size: 4, Reason: "not at source"})
+	assert.FileExists(t, filepath.Join(localDir, "keep.txt"))
+	assert.FileExists(t, filepath.Join(localDir, "dir"))
+}
+
+func TestDoSyncRequiresOneRemote(t *testing.T) {
+	_, _, err := DoSync(t.TempDir(), t.TempDir(), SyncOptions{})
+	assert.Error(t, err)
+	_, _, err = DoSync("osdf:///a", "osdf:///b", SyncOptions{})
+	assert.Error(t, err)
+}
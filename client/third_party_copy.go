@@ -0,0 +1,352 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// The destination origin can't pull the object itself, so it has to be
+// streamed through the client instead
+var errThirdPartyCopyUnsupported = errors.New("destination does not support third-party copies")
+
+// Copy copies remoteSource to remoteDestination, both objects in a
+// federation.  The destination's origin is asked to pull each object from
+// a cache itself with an HTTP third-party copy (a WebDAV COPY with a Source
+// header), being passed the token to read it with.  Only if the origin
+// can't do that is the object streamed through this client.
+func (tc *TransferClient) Copy(ctx context.Context, remoteSource string, remoteDestination string, recursive bool) (result TransferResult, err error) {
+	result = TransferResult{Source: remoteSource, Destination: remoteDestination, Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+		result.Error = err
+		result.Attempts = append(tc.transferAttempts(), result.Attempts...)
+	}()
+	defer tc.recoverTransfer("Copy", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	sourceUrl, _, err := parseRemoteObject(remoteSource)
+	if err != nil {
+		return
+	}
+	sourceCtx, source, err := tc.resolveRemoteObject(ctx, remoteSource)
+	if err != nil {
+		return
+	}
+
+	destUrl, destPath, err := parseRemoteObject(remoteDestination)
+	if err != nil {
+		return
+	}
+	if tc, err = tc.forTransfer(destUrl, recursive); err != nil {
+		return
+	}
+	ctx = tc.withContext(ctx)
+	destNs, err := getNamespaceInfo(ctx, destPath, tc.directorUrl, true)
+	if err != nil {
+		log.Errorln(err)
+		err = errors.New("Failed to get namespace information for destination")
+		return
+	}
+	destToken, err := getToken(ctx, destUrl, destNs, true, "")
+	if err != nil {
+		return
+	}
+
+	// A recursive copy copies each object in the collection on its own
+	sourcePaths, destPaths := []string{source.objectPath}, []string{destPath}
+	if recursive {
+		var infos []ObjectInfo
+		if infos, err = listObjects(sourceCtx, source, true); err != nil {
+			return
+		}
+		sourcePaths, destPaths = nil, nil
+		for _, info := range infos {
+			if !info.IsCollection {
+				sourcePaths = append(sourcePaths, info.Name)
+				destPaths = append(destPaths, path.Join(destPath, strings.TrimPrefix(info.Name, source.objectPath)))
+			}
+		}
+	}
+
+	for idx, sourcePath := range sourcePaths {
+		objectDestPath := destPaths[idx]
+		var copied int64
+		dest, urlErr := writebackUrl(destNs, &url.URL{Path: objectDestPath})
+		if urlErr != nil {
+			err = urlErr
+			return
+		}
+		copied, err = thirdPartyCopy(ctx, sourceCtx, source, sourcePath, dest, destToken)
+		if errors.Is(err, errThirdPartyCopyUnsupported) {
+			log.Infoln("Destination origin can't do third-party copies; copying", sourcePath, "through the client")
+			var streamed TransferResult
+			streamed, err = tc.streamCopy(ctx, federationObjectUrl(sourceUrl, sourcePath), federationObjectUrl(destUrl, objectDestPath))
+			copied = streamed.TransferredBytes
+			result.Attempts = append(result.Attempts, streamed.Attempts...)
+		}
+		result.TransferredBytes += copied
+		if err != nil {
+			tc.errors.add(err)
+			return
+		}
+	}
+	return
+}
+
+// The URL of objectPath in the same federation as remoteObjectUrl
+func federationObjectUrl(remoteObjectUrl *url.URL, objectPath string) string {
+	if scheme, _ := getTokenName(remoteObjectUrl); scheme == "pelican" {
+		return (&url.URL{Scheme: remoteObjectUrl.Scheme, Host: remoteObjectUrl.Host, Path: objectPath}).String()
+	}
+	return (&url.URL{Scheme: remoteObjectUrl.Scheme, Path: objectPath}).String()
+}
+
+// Ask the origin at dest to pull sourcePath from one of the source's caches,
+// trying each cache until one works.  Returns errThirdPartyCopyUnsupported
+// if the origin doesn't do third-party copies at all.
+func thirdPartyCopy(ctx context.Context, sourceCtx context.Context, source *remoteTarget, sourcePath string, dest *url.URL, destToken string) (copied int64, err error) {
+	tc := transferClientFromContext(ctx)
+	transfers, err := cacheTransfers(sourceCtx, source.ns, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, transfer := range transfers {
+		transfer.Url.Path = sourcePath
+		start := time.Now()
+		copied, err = requestThirdPartyCopy(ctx, transfer.Url.String(), source.token, dest, destToken)
+		tc.recordAttempt(TransferAttempt{
+			Server:           dest.Host,
+			Proxy:            IsProxyEnabled(),
+			Start:            start,
+			End:              time.Now(),
+			TransferredBytes: copied,
+			Error:            err,
+		})
+		if err == nil {
+			return copied, verifyThirdPartyCopy(ctx, sourceCtx, transfer, source.token, dest, destToken)
+		}
+		if errors.Is(err, errThirdPartyCopyUnsupported) || ctx.Err() != nil {
+			return 0, err
+		}
+		log.Debugln("Third-party copy from", transfer.Url.Host, "failed:", err)
+		tc.errors.add(&FileDownloadError{"Third-party copy from " + transfer.Url.Host + " failed: " + err.Error(), err})
+	}
+	return 0, err
+}
+
+// Send the COPY request and follow the performance markers the origin sends
+// back as it pulls the object, until it reports success or failure
+func requestThirdPartyCopy(ctx context.Context, source string, sourceToken string, dest *url.URL, destToken string) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "COPY", dest.String(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create third-party copy request")
+	}
+	req.Header.Set("Source", source)
+	req.Header.Set("Overwrite", "T")
+	req.Header.Set("Authorization", "Bearer "+destToken)
+	// The origin isn't delegated any credentials of ours; it's just handed
+	// the header to send to the source
+	req.Header.Set("Credential", "none")
+	if sourceToken != "" {
+		req.Header.Set("TransferHeaderAuthorization", "Bearer "+sourceToken)
+	}
+
+	// The performance markers are the only sign of progress, so the copy is
+	// only given up on if they stop coming; its speed is up to the origin
+	var transferred atomic.Int64
+	done := make(chan struct{})
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- (&sourceSpeed{started: time.Now()}).watch(&transferred, 0, done, cancel)
+	}()
+	copied, err := followThirdPartyCopy(ctx, req, &transferred)
+	close(done)
+	if stoppedErr := <-watchErr; stoppedErr != nil && err != nil {
+		return copied, stoppedErr
+	}
+	if err != nil {
+		return copied, err
+	}
+	log.Debugf("Origin %s copied %d bytes from %s", dest.Host, copied, source)
+	return copied, nil
+}
+
+func followThirdPartyCopy(ctx context.Context, req *http.Request, transferred *atomic.Int64) (int64, error) {
+	tc := transferClientFromContext(ctx)
+	client := &http.Client{Transport: tc.engine.getTransport(true)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, &ConnectionSetupError{URL: req.URL.String(), Err: err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return 0, errThirdPartyCopyUnsupported
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, &HttpErrResp{resp.StatusCode, fmt.Sprintf("Third-party copy request failed (HTTP status %d): %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))}
+	}
+
+	var progressBar *mpb.Bar
+	if tc.options.ProgressBars {
+		progressBar = getProgressContainer().AddBar(0,
+			mpb.PrependDecorators(
+				decor.Name(path.Base(req.URL.Path), decor.WCSyncSpaceR),
+				decor.CountersKibiByte("% .2f"),
+			),
+			mpb.AppendDecorators(
+				decor.OnComplete(decor.EwmaSpeed(decor.SizeB1024(0), "% .2f", 5), "Done!"),
+			),
+		)
+	}
+	finish := func(err error) {
+		if progressBar == nil {
+			return
+		}
+		if err == nil {
+			progressBar.SetTotal(-1, true)
+		} else {
+			progressBar.Abort(true)
+		}
+		progressBar.Wait()
+	}
+
+	// The body is a series of performance markers, then a line of either
+	// "success: <message>" or "failure: <message>"
+	sawMarker := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(key) {
+		case "perf marker":
+			sawMarker = true
+		case "stripe bytes transferred":
+			if bytes, err := strconv.ParseInt(value, 10, 64); err == nil {
+				transferred.Store(bytes)
+				if progressBar != nil {
+					progressBar.SetCurrent(bytes)
+				}
+			}
+		case "success":
+			finish(nil)
+			return transferred.Load(), nil
+		case "failure":
+			err := errors.Errorf("third-party copy failed: %s", value)
+			finish(err)
+			return transferred.Load(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		finish(err)
+		return transferred.Load(), errors.Wrap(err, "Lost the connection to the origin during the third-party copy")
+	}
+	// Without markers, the copy finished before the origin responded
+	if !sawMarker && resp.StatusCode == http.StatusCreated {
+		finish(nil)
+		return transferred.Load(), nil
+	}
+	err = errors.New("third-party copy ended without the origin reporting success")
+	finish(err)
+	return transferred.Load(), err
+}
+
+// Compare the copy against the source: the sizes must match, as must the
+// checksums both of them report
+func verifyThirdPartyCopy(ctx context.Context, sourceCtx context.Context, source TransferDetails, sourceToken string, dest *url.URL, destToken string) error {
+	checksumTypes, required := requestedChecksums(ctx)
+	sourceInfo, err := headObject(sourceCtx, []TransferDetails{source}, sourceToken)
+	if err != nil {
+		if required {
+			return errors.Wrap(err, "Failed to get the checksum of the source object")
+		}
+		log.Debugln("Unable to check the copy against its source:", err)
+		return nil
+	}
+	destInfo, err := headObject(ctx, []TransferDetails{{Url: *dest, Proxy: true}}, destToken)
+	if err != nil {
+		return errors.Wrap(err, "Failed to check the copied object")
+	}
+	if sourceInfo.size != destInfo.size {
+		return errors.Errorf("copied object is %d bytes but the source is %d", destInfo.size, sourceInfo.size)
+	}
+	var sourceChecksums []ChecksumInfo
+	for _, checksumType := range checksumTypes {
+		if value, ok := sourceInfo.digests[checksumType]; ok {
+			sourceChecksums = append(sourceChecksums, ChecksumInfo{Algorithm: checksumType, Value: value})
+		}
+	}
+	if len(sourceChecksums) == 0 && required {
+		return &MissingChecksumError{Requested: checksumTypes}
+	}
+	_, err = compareChecksums(sourceChecksums, destInfo.digests, required)
+	return err
+}
+
+// Copy an object by streaming it from the source's caches through the
+// client to the destination's origin
+func (tc *TransferClient) streamCopy(ctx context.Context, remoteSource string, remoteDestination string) (result TransferResult, err error) {
+	reader, writer := io.Pipe()
+	getResult := make(chan TransferResult, 1)
+	go func() {
+		result, err := tc.GetToWriter(ctx, remoteSource, writer)
+		writer.CloseWithError(err)
+		getResult <- result
+	}()
+	result, err = tc.PutFromReader(ctx, reader, -1, remoteDestination)
+	// If the upload gave up first, the download has to be stopped too
+	reader.CloseWithError(errors.New("upload of the copy ended"))
+	fromSource := <-getResult
+	result.Attempts = append(fromSource.Attempts, result.Attempts...)
+	// A failed download fails the upload too, but its error says why
+	if fromSource.Error != nil {
+		err = fromSource.Error
+	}
+	return
+}
+
+// DoCopy copies remoteSource to remoteDestination, both objects in a
+// federation, with a third-party copy where the destination allows it
+func DoCopy(remoteSource string, remoteDestination string, recursive bool) (bytesTransferred int64, err error) {
+	result, err := defaultTransferClient().Copy(context.Background(), remoteSource, remoteDestination, recursive)
+	return result.TransferredBytes, err
+}
@@ -0,0 +1,171 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A federation of one server, which is its own director, cache and origin.
+// Unless tpc is set, it refuses third-party copies.
+type copyFederation struct {
+	server  *httptest.Server
+	mutex   sync.Mutex
+	objects map[string][]byte
+	tpc     bool
+	copies  []*http.Request
+}
+
+func (cf *copyFederation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if objectPath, found := strings.CutPrefix(r.URL.Path, "/director"); found {
+		w.Header().Set("Link", "<"+cf.server.URL+">; rel=\"duplicate\"; pri=1")
+		w.Header().Set("X-Pelican-Namespace", "namespace=/test, require-token=true")
+		w.Header().Set("Location", cf.server.URL+objectPath)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+	// The body of a PUT may be streamed from a GET of this server, so it's
+	// read before taking the lock
+	var body []byte
+	if r.Method == http.MethodPut {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	cf.mutex.Lock()
+	defer cf.mutex.Unlock()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		object, ok := cf.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object)
+		}
+	case http.MethodPut:
+		cf.objects[r.URL.Path] = body
+		w.WriteHeader(http.StatusOK)
+	case "COPY":
+		if !cf.tpc {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cf.copies = append(cf.copies, r)
+		source, err := url.Parse(r.Header.Get("Source"))
+		object, ok := cf.objects[source.Path]
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("failure: source not found\n"))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprintf(w, "Perf Marker\nTimestamp: 1\nStripe Index: 0\nStripe Bytes Transferred: %d\nTotal Stripe Count: 1\nEnd\n", len(object))
+		cf.objects[r.URL.Path] = object
+		_, _ = w.Write([]byte("success: Created\n"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func startCopyFederation(t *testing.T, tpc bool) (*copyFederation, *TransferClient) {
+	federation := &copyFederation{objects: map[string][]byte{"/test/a.txt": []byte("hello")}, tpc: tpc}
+	federation.server = httptest.NewTLSServer(federation)
+	t.Cleanup(federation.server.Close)
+
+	te := NewTransferEngine(context.Background())
+	t.Cleanup(te.Close)
+	te.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	tc, err := te.NewClient(
+		WithDirectorUrl(federation.server.URL+"/director"),
+		WithTokenSource(func(_ *url.URL, isWrite bool) (string, error) {
+			if isWrite {
+				return "write-token", nil
+			}
+			return "read-token", nil
+		}),
+	)
+	require.NoError(t, err)
+	return federation, tc
+}
+
+func TestThirdPartyCopy(t *testing.T) {
+	federation, tc := startCopyFederation(t, true)
+
+	result, err := tc.Copy(context.Background(), "osdf:///test/a.txt", "osdf:///test/copy.txt", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.TransferredBytes)
+	assert.Equal(t, []byte("hello"), federation.objects["/test/copy.txt"])
+
+	// The origin is told where to pull from, with the token to read it
+	require.Len(t, federation.copies, 1)
+	request := federation.copies[0]
+	assert.Equal(t, federation.server.URL+"/test/a.txt", request.Header.Get("Source"))
+	assert.Equal(t, "Bearer write-token", request.Header.Get("Authorization"))
+	assert.Equal(t, "Bearer read-token", request.Header.Get("TransferHeaderAuthorization"))
+	assert.Equal(t, "none", request.Header.Get("Credential"))
+	require.NotEmpty(t, result.Attempts)
+	assert.NoError(t, result.Attempts[0].Error)
+
+	// The origin's failures are reported
+	_, err = tc.Copy(context.Background(), "osdf:///test/missing.txt", "osdf:///test/copy2.txt", false)
+	assert.ErrorContains(t, err, "source not found")
+	assert.NotContains(t, federation.objects, "/test/copy2.txt")
+}
+
+func TestThirdPartyCopyFallback(t *testing.T) {
+	// An origin that can't pull the object is sent it through the client
+	federation, tc := startCopyFederation(t, false)
+
+	result, err := tc.Copy(context.Background(), "osdf:///test/a.txt", "osdf:///test/copy.txt", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.TransferredBytes)
+	assert.Equal(t, []byte("hello"), federation.objects["/test/copy.txt"])
+	assert.Empty(t, federation.copies)
+}
+
+func TestFederationObjectUrl(t *testing.T) {
+	for remoteObject, expected := range map[string]string{
+		"osdf:///test/a.txt":               "osdf:///test/b.txt",
+		"pelican://example.com/test/a.txt": "pelican://example.com/test/b.txt",
+		"/test/a.txt":                      "/test/b.txt",
+	} {
+		remoteObjectUrl, err := url.Parse(remoteObject)
+		require.NoError(t, err)
+		assert.Equal(t, expected, federationObjectUrl(remoteObjectUrl, "/test/b.txt"))
+	}
+}
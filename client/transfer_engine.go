@@ -0,0 +1,769 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/namespaces"
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// TransferEngine holds the resources shared by the transfer clients made
+	// from it, such as the HTTP transport. Closing the engine cancels any
+	// transfers still in progress.
+	TransferEngine struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		transport        *http.Transport
+		noProxyTransport *http.Transport
+		noProxyOnce      sync.Once
+
+		// Federation discovery results, by discovery URL
+		federations      map[string]config.FederationDiscovery
+		federationsMutex sync.Mutex
+
+		// The caches nearest this machine per the GeoIP service, by cache list
+		// name, for federations without a director
+		nearestCaches      map[string][]string
+		nearestCachesMutex sync.Mutex
+
+		// The namespaces the directors have told us about, by director URL,
+		// verb and the directory of the object they were asked about, so other
+		// objects in the directory don't need another query
+		namespaces      map[string]namespaces.Namespace
+		namespacesMutex sync.Mutex
+
+		// The token bucket shared by all the engine's transfers, if their
+		// bandwidth is limited
+		bandwidth *rate.Limiter
+	}
+
+	// TransferClient makes transfers against a single federation with its own
+	// settings, tokens and error history, so several can be used at once
+	// within a process without interfering with each other.
+	TransferClient struct {
+		engine      *TransferEngine
+		directorUrl string
+		options     OptionsStruct
+		tokenSource TokenSource
+		cachesToTry int
+		// The cache to download through in place of those the director or
+		// topology would give, if any
+		cache string
+		// The number of files of a recursive transfer to move at once
+		workers int
+		// The speeds in bytes per second below which downloads and uploads are
+		// given up as too slow, or 0 to not check
+		minDownloadSpeed int64
+		minUploadSpeed   int64
+		errors           *errorAccumulator
+		// Where copies of downloaded objects are kept for reuse, if anywhere
+		localCache *LocalCache
+		// The attempts made by the transfer in progress
+		attempts *attemptLog
+		// The checksums verified by the transfer in progress, by local path
+		checksums *checksumLog
+		// The objects downloaded by the transfer in progress, by local path
+		downloads *downloadLog
+	}
+
+	// A TokenSource provides the token for a transfer of the object at
+	// objectUrl, or an empty string to fall back to the usual token discovery
+	TokenSource func(objectUrl *url.URL, isWrite bool) (string, error)
+
+	TransferOption func(*TransferClient) error
+
+	// The outcome of a single call to TransferClient.Get or TransferClient.Put
+	TransferResult struct {
+		Source           string
+		Destination      string
+		TransferredBytes int64
+		// The checksums verified for the transfer of a single object
+		Checksums []ChecksumInfo
+		Start     time.Time
+		End       time.Time
+		Error     error
+		// Each try against a cache or origin, in the order they were made
+		Attempts []TransferAttempt
+	}
+
+	// A single try at transferring an object from or to one server
+	TransferAttempt struct {
+		// The host and port of the cache or origin; for downloads from several
+		// caches at once, all of them, separated by commas
+		Server string
+		// Whether the request went through an HTTP proxy
+		Proxy bool
+		Start time.Time
+		End   time.Time
+		// How long the server took to start responding
+		TimeToFirstByte  time.Duration
+		TransferredBytes int64
+		Error            error
+	}
+
+	attemptLog struct {
+		attempts []TransferAttempt
+		mutex    sync.Mutex
+	}
+
+	checksumLog struct {
+		checksums map[string][]ChecksumInfo
+		mutex     sync.Mutex
+	}
+
+	downloadLog struct {
+		objects map[string]objectInfo
+		mutex   sync.Mutex
+	}
+
+	transferClientKey struct{}
+)
+
+// NewTransferEngine creates an engine whose transfers are cancelled when ctx is done
+func NewTransferEngine(ctx context.Context) *TransferEngine {
+	ctx, cancel := context.WithCancel(ctx)
+	return &TransferEngine{
+		ctx:         ctx,
+		cancel:      cancel,
+		transport:   config.GetTransport().Clone(),
+		federations: make(map[string]config.FederationDiscovery),
+		namespaces:  make(map[string]namespaces.Namespace),
+		bandwidth:   sharedBandwidthLimit(int64(param.Client_MaxBandwidth.GetInt())),
+	}
+}
+
+// SetMaxBandwidth limits the engine's transfers to bytesPerSecond between
+// them, in place of Client.MaxBandwidth; 0 removes the limit. It should be
+// called before any transfers are started.
+func (te *TransferEngine) SetMaxBandwidth(bytesPerSecond int64) {
+	te.bandwidth = newTokenBucket(bytesPerSecond)
+}
+
+// Close cancels the engine's outstanding transfers and releases its connections
+func (te *TransferEngine) Close() {
+	te.cancel()
+	te.transport.CloseIdleConnections()
+	if te.noProxyTransport != nil {
+		te.noProxyTransport.CloseIdleConnections()
+	}
+}
+
+// The transport to use for a transfer, optionally bypassing the HTTP proxy
+func (te *TransferEngine) getTransport(useProxy bool) *http.Transport {
+	if useProxy {
+		return te.transport
+	}
+	te.noProxyOnce.Do(func() {
+		te.noProxyTransport = te.transport.Clone()
+		te.noProxyTransport.Proxy = nil
+	})
+	return te.noProxyTransport
+}
+
+// Look up the services of a federation, remembering the result
+func (te *TransferEngine) discoverFederation(discoveryUrl string) (config.FederationDiscovery, error) {
+	te.federationsMutex.Lock()
+	defer te.federationsMutex.Unlock()
+	if metadata, ok := te.federations[discoveryUrl]; ok {
+		return metadata, nil
+	}
+	metadata, err := config.DiscoverUrlFederation(discoveryUrl)
+	if err != nil {
+		return metadata, err
+	}
+	te.federations[discoveryUrl] = metadata
+	return metadata, nil
+}
+
+// The key of the director's response for an object. Only objects in the same
+// directory share a response: one in a subdirectory may be in a namespace of
+// its own.
+func namespaceCacheKey(directorUrl string, verb string, objectPath string) string {
+	return verb + " " + directorUrl + " " + path.Dir(path.Clean("/"+objectPath))
+}
+
+// The caches nearest this machine from the list with the given name, asking
+// the GeoIP service the first time each list is needed
+func (te *TransferEngine) getNearestCaches(cacheListName string) ([]string, error) {
+	te.nearestCachesMutex.Lock()
+	defer te.nearestCachesMutex.Unlock()
+	if caches, ok := te.nearestCaches[cacheListName]; ok {
+		return caches, nil
+	}
+	caches, err := GetBestCache(cacheListName)
+	if err != nil {
+		return nil, err
+	}
+	if te.nearestCaches == nil {
+		te.nearestCaches = make(map[string][]string)
+	}
+	te.nearestCaches[cacheListName] = caches
+	return caches, nil
+}
+
+// Find the namespace of an object from an earlier response of the director
+func (te *TransferEngine) cachedNamespace(directorUrl string, verb string, objectPath string) (namespaces.Namespace, bool) {
+	te.namespacesMutex.Lock()
+	defer te.namespacesMutex.Unlock()
+	ns, found := te.namespaces[namespaceCacheKey(directorUrl, verb, objectPath)]
+	return ns, found
+}
+
+// Remember the namespace the director told us an object is in
+func (te *TransferEngine) cacheNamespace(directorUrl string, verb string, objectPath string, ns namespaces.Namespace) {
+	if ns.Path == "" {
+		return
+	}
+	te.namespacesMutex.Lock()
+	defer te.namespacesMutex.Unlock()
+	if te.namespaces == nil {
+		te.namespaces = make(map[string]namespaces.Namespace)
+	}
+	te.namespaces[namespaceCacheKey(directorUrl, verb, objectPath)] = ns
+}
+
+// Derive a context for a transfer that is also cancelled when the engine is closed
+func (te *TransferEngine) transferContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-te.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// NewClient creates a client for the federation given by the options; by
+// default, that's the one in the configuration
+func (te *TransferEngine) NewClient(options ...TransferOption) (*TransferClient, error) {
+	tc := &TransferClient{
+		engine:           te,
+		directorUrl:      param.Federation_DirectorUrl.GetString(),
+		cachesToTry:      DefaultCachesToTry,
+		workers:          param.Client_WorkerCount.GetInt(),
+		minDownloadSpeed: int64(param.Client_MinimumDownloadSpeed.GetInt()),
+		minUploadSpeed:   int64(param.Client_MinimumUploadSpeed.GetInt()),
+		errors:           newErrorAccumulator(),
+		localCache:       configuredLocalCache(),
+	}
+	for _, option := range options {
+		if err := option(tc); err != nil {
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// WithDirectorUrl sets the director the client asks where to find objects
+func WithDirectorUrl(directorUrl string) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.directorUrl = directorUrl
+		return nil
+	}
+}
+
+// WithFederation discovers the director of the federation at discoveryUrl
+func WithFederation(discoveryUrl string) TransferOption {
+	return func(tc *TransferClient) error {
+		metadata, err := tc.engine.discoverFederation(discoveryUrl)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to discover the federation at %s", discoveryUrl)
+		}
+		tc.directorUrl = metadata.DirectorEndpoint
+		return nil
+	}
+}
+
+// WithToken uses the given token for every transfer
+func WithToken(token string) TransferOption {
+	return WithTokenSource(func(*url.URL, bool) (string, error) {
+		return token, nil
+	})
+}
+
+// WithTokenLocation reads the token for every transfer from the given file
+func WithTokenLocation(location string) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.options.Token = location
+		return nil
+	}
+}
+
+// WithTokenSource asks source for the token of each transfer
+func WithTokenSource(source TokenSource) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.tokenSource = source
+		return nil
+	}
+}
+
+// WithPluginMode marks the client as running as an HTCondor file transfer
+// plugin, where there's no user to generate tokens for
+func WithPluginMode() TransferOption {
+	return func(tc *TransferClient) error {
+		tc.options.Plugin = true
+		return nil
+	}
+}
+
+// WithChecksums requires every transfer to be verified with one of the given checksums
+func WithChecksums(checksums ...ChecksumType) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.options.Checksums = checksums
+		return nil
+	}
+}
+
+// WithCachesToTry sets how many caches a download may be attempted from
+func WithCachesToTry(cachesToTry int) TransferOption {
+	return func(tc *TransferClient) error {
+		if cachesToTry < 1 {
+			return errors.New("at least one cache must be tried")
+		}
+		tc.cachesToTry = cachesToTry
+		return nil
+	}
+}
+
+// WithCache downloads through the cache at the given host (and port), in
+// place of those the director or topology would give
+func WithCache(cache string) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.cache = cache
+		return nil
+	}
+}
+
+// WithProgressBars shows the progress of each transfer
+func WithProgressBars() TransferOption {
+	return func(tc *TransferClient) error {
+		tc.options.ProgressBars = true
+		return nil
+	}
+}
+
+// WithWorkerCount sets how many files of a recursive transfer are moved at
+// once, in place of Client.WorkerCount
+func WithWorkerCount(workers int) TransferOption {
+	return func(tc *TransferClient) error {
+		if workers < 1 {
+			return errors.New("at least one file must be transferred at a time")
+		}
+		tc.workers = workers
+		return nil
+	}
+}
+
+// WithMinimumSpeeds sets the speeds in bytes per second below which downloads
+// and uploads are given up as too slow, in place of Client.MinimumDownloadSpeed
+// and Client.MinimumUploadSpeed; 0 disables the check
+func WithMinimumSpeeds(download int64, upload int64) TransferOption {
+	return func(tc *TransferClient) error {
+		if download < 0 || upload < 0 {
+			return errors.New("the minimum speeds can't be negative")
+		}
+		tc.minDownloadSpeed = download
+		tc.minUploadSpeed = upload
+		return nil
+	}
+}
+
+// WithRateLimit limits each transfer of the client to bytesPerSecond; 0
+// removes the limit. The engine's limit on all its transfers still applies.
+func WithRateLimit(bytesPerSecond int64) TransferOption {
+	return func(tc *TransferClient) error {
+		if bytesPerSecond < 0 {
+			return errors.New("the rate limit can't be negative")
+		}
+		tc.options.LimitRate = bytesPerSecond
+		return nil
+	}
+}
+
+// WithLocalCache keeps copies of downloaded objects in lc, in place of the
+// configured local cache; nil disables it
+func WithLocalCache(lc *LocalCache) TransferOption {
+	return func(tc *TransferClient) error {
+		tc.localCache = lc
+		return nil
+	}
+}
+
+// The client for transfers made through the package-level functions, which
+// takes its settings from the configuration
+func defaultTransferClient() *TransferClient {
+	return &TransferClient{
+		engine: &TransferEngine{
+			ctx:         context.Background(),
+			cancel:      func() {},
+			transport:   config.GetTransport(),
+			federations: make(map[string]config.FederationDiscovery),
+			bandwidth:   sharedBandwidthLimit(int64(param.Client_MaxBandwidth.GetInt())),
+		},
+		directorUrl:      param.Federation_DirectorUrl.GetString(),
+		cachesToTry:      DefaultCachesToTry,
+		workers:          param.Client_WorkerCount.GetInt(),
+		minDownloadSpeed: int64(param.Client_MinimumDownloadSpeed.GetInt()),
+		minUploadSpeed:   int64(param.Client_MinimumUploadSpeed.GetInt()),
+		errors:           defaultErrors,
+		localCache:       configuredLocalCache(),
+	}
+}
+
+// The client a transfer is being made with
+func transferClientFromContext(ctx context.Context) *TransferClient {
+	if tc, ok := ctx.Value(transferClientKey{}).(*TransferClient); ok {
+		return tc
+	}
+	return defaultTransferClient()
+}
+
+func (tc *TransferClient) withContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, transferClientKey{}, tc)
+}
+
+// A copy of the client for a single transfer, for any settings that only
+// apply to it
+func (tc *TransferClient) forTransfer(remoteObjectUrl *url.URL, recursive bool) (*TransferClient, error) {
+	transferTc := *tc
+	transferTc.options.Recursive = recursive
+	transferTc.attempts = &attemptLog{}
+	transferTc.checksums = &checksumLog{checksums: make(map[string][]ChecksumInfo)}
+	transferTc.downloads = &downloadLog{objects: make(map[string]objectInfo)}
+	// pelican:// URLs name the federation to use
+	if remoteObjectUrl.Scheme == "pelican" && remoteObjectUrl.Host != "" {
+		federationUrl := url.URL{Scheme: "https", Host: remoteObjectUrl.Host}
+		metadata, err := tc.engine.discoverFederation(federationUrl.String())
+		if err != nil {
+			return nil, err
+		}
+		transferTc.directorUrl = metadata.DirectorEndpoint
+	}
+	return &transferTc, nil
+}
+
+// The number of files of a recursive transfer to move at once
+func (tc *TransferClient) workerCount() int {
+	if tc.workers > 0 {
+		return tc.workers
+	}
+	return 1
+}
+
+// The speed below which a download, split into shares running at once, is
+// given up as too slow. Transfers whose bandwidth is limited are slow on
+// purpose, so they're left to the stopped transfer timeout.
+func (tc *TransferClient) minimumDownloadSpeed(shares int) int {
+	if tc.engine.bandwidth != nil || tc.options.LimitRate > 0 {
+		return 0
+	}
+	return int(tc.minDownloadSpeed) / shares
+}
+
+// The minimum speed for an upload, which is likewise unchecked when the
+// bandwidth is limited
+func (tc *TransferClient) minimumUploadSpeed() int64 {
+	if tc.engine.bandwidth != nil || tc.options.LimitRate > 0 {
+		return 0
+	}
+	return tc.minUploadSpeed
+}
+
+// Note an attempt of the transfer in progress
+func (tc *TransferClient) recordAttempt(attempt TransferAttempt) {
+	if tc.attempts == nil {
+		return
+	}
+	tc.attempts.mutex.Lock()
+	defer tc.attempts.mutex.Unlock()
+	tc.attempts.attempts = append(tc.attempts.attempts, attempt)
+}
+
+// The attempts made by the transfer in progress so far
+func (tc *TransferClient) transferAttempts() []TransferAttempt {
+	if tc.attempts == nil {
+		return nil
+	}
+	tc.attempts.mutex.Lock()
+	defer tc.attempts.mutex.Unlock()
+	return append([]TransferAttempt(nil), tc.attempts.attempts...)
+}
+
+// Note the checksums verified for the file at localPath by the transfer in progress
+func (tc *TransferClient) recordChecksums(localPath string, checksums []ChecksumInfo) {
+	if tc.checksums == nil {
+		return
+	}
+	tc.checksums.mutex.Lock()
+	defer tc.checksums.mutex.Unlock()
+	if len(checksums) == 0 {
+		delete(tc.checksums.checksums, localPath)
+		return
+	}
+	tc.checksums.checksums[localPath] = checksums
+}
+
+// The checksums verified for the file at localPath by the transfer in progress, if any
+func (tc *TransferClient) verifiedChecksums(localPath string) []ChecksumInfo {
+	if tc.checksums == nil {
+		return nil
+	}
+	tc.checksums.mutex.Lock()
+	defer tc.checksums.mutex.Unlock()
+	return tc.checksums.checksums[localPath]
+}
+
+// Note the size and validators of the object downloaded to localPath, as
+// given with the data itself
+func (tc *TransferClient) recordDownload(localPath string, info objectInfo) {
+	if tc.downloads == nil {
+		return
+	}
+	tc.downloads.mutex.Lock()
+	defer tc.downloads.mutex.Unlock()
+	tc.downloads.objects[localPath] = info
+}
+
+// The size and validators of the object downloaded to localPath by the
+// transfer in progress, if it was
+func (tc *TransferClient) downloadedObject(localPath string) (objectInfo, bool) {
+	if tc.downloads == nil {
+		return objectInfo{}, false
+	}
+	tc.downloads.mutex.Lock()
+	defer tc.downloads.mutex.Unlock()
+	info, ok := tc.downloads.objects[localPath]
+	return info, ok
+}
+
+// Throughput is the average rate of the transfer, in bytes per second
+func (result TransferResult) Throughput() float64 {
+	elapsed := result.End.Sub(result.Start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(result.TransferredBytes) / elapsed
+}
+
+// GetErrors describes the errors hit by the client's transfers so far
+func (tc *TransferClient) GetErrors() string {
+	return tc.errors.get()
+}
+
+// Errors returns the errors hit by the client's transfers so far, oldest first
+func (tc *TransferClient) Errors() []TimestampedError {
+	return tc.errors.list()
+}
+
+// ErrorsRetryable returns whether all the errors hit by the client's
+// transfers so far may succeed on a later attempt
+func (tc *TransferClient) ErrorsRetryable() bool {
+	return tc.errors.retryable()
+}
+
+// ClearErrors forgets the errors hit by the client's transfers so far
+func (tc *TransferClient) ClearErrors() {
+	tc.errors.clear()
+}
+
+// Turn a panic in a transfer into an error, rather than taking down the process
+func (tc *TransferClient) recoverTransfer(operation string, err *error) {
+	if r := recover(); r != nil {
+		log.Debugf("Panic captured while attempting to perform transfer (%s): %v", operation, r)
+		log.Debugln("Panic caused by the following", string(debug.Stack()))
+		*err = fmt.Errorf("Unrecoverable error (panic) captured in %s: %v", operation, r)
+
+		// Attempt to add the panic to the error accumulator
+		tc.errors.add(*err)
+	}
+}
+
+// Get downloads remoteObject (an osdf:// or pelican:// URL, or a path in the
+// client's federation) to localDestination. If localDestination is an
+// existing directory, the object is downloaded into it.
+func (tc *TransferClient) Get(ctx context.Context, remoteObject string, localDestination string, recursive bool) (result TransferResult, err error) {
+	result = TransferResult{Source: remoteObject, Destination: localDestination, Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+		result.Error = err
+		result.Attempts = tc.transferAttempts()
+	}()
+	defer tc.recoverTransfer("Get", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	remoteObjectUrl, remoteObject, err := parseRemoteObject(remoteObject)
+	if err != nil {
+		return
+	}
+	if tc, err = tc.forTransfer(remoteObjectUrl, recursive); err != nil {
+		return
+	}
+	ctx = tc.withContext(ctx)
+
+	ns, err := getNamespaceInfo(ctx, remoteObject, tc.directorUrl, false)
+	if err != nil {
+		log.Errorln(err)
+		err = errors.New("Failed to get namespace information from source")
+		return
+	}
+
+	// get absolute path
+	localDestPath, _ := filepath.Abs(localDestination)
+
+	//Check if path exists or if its in a folder
+	if destStat, statErr := os.Stat(localDestPath); os.IsNotExist(statErr) {
+		localDestination = localDestPath
+	} else if destStat.IsDir() && remoteObjectUrl.Query().Get("pack") == "" {
+		// If we have an auto-pack request, it's OK for the destination to be a directory
+		// Otherwise, get the base name of the source and append it to the destination dir.
+		remoteObjectFilename := path.Base(remoteObject)
+		localDestination = path.Join(localDestPath, remoteObjectFilename)
+	}
+	result.Destination = localDestination
+
+	payload := payloadStruct{}
+	payload.version = version
+
+	//Fill out the payload as much as possible
+	payload.filename = remoteObjectUrl.Path
+
+	parse_job_ad(payload)
+
+	payload.start1 = time.Now().Unix()
+
+	_, token_name := getTokenName(remoteObjectUrl)
+
+	result.TransferredBytes, err = download_http(ctx, remoteObjectUrl, localDestination, &payload, ns, recursive, token_name)
+
+	payload.end1 = time.Now().Unix()
+
+	payload.timestamp = payload.end1
+	payload.downloadTime = (payload.end1 - payload.start1)
+
+	if err != nil {
+		log.Error("Http GET failed! Unable to download file.")
+		payload.status = "Fail"
+		err = errors.New("failed to download file")
+		return
+	}
+	payload.status = "Success"
+
+	// Get the final size of the download file
+	payload.fileSize = result.TransferredBytes
+	payload.downloadSize = result.TransferredBytes
+	if !recursive {
+		result.Checksums = tc.verifiedChecksums(localDestination)
+	}
+	return
+}
+
+// Put uploads localObject to remoteDestination (an osdf:// or pelican:// URL,
+// or a path in the client's federation)
+func (tc *TransferClient) Put(ctx context.Context, localObject string, remoteDestination string, recursive bool) (result TransferResult, err error) {
+	result = TransferResult{Source: localObject, Destination: remoteDestination, Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+		result.Error = err
+		result.Attempts = tc.transferAttempts()
+	}()
+	defer tc.recoverTransfer("Put", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	// Parse the source and destination with URL parse
+	localObjectUrl, err := url.Parse(localObject)
+	if err != nil {
+		log.Errorln("Failed to parse source URL:", err)
+		return
+	}
+
+	remoteDestUrl, remoteDestination, err := parseRemoteObject(remoteDestination)
+	if err != nil {
+		return
+	}
+	if tc, err = tc.forTransfer(remoteDestUrl, recursive); err != nil {
+		return
+	}
+	ctx = tc.withContext(ctx)
+
+	// Get the namespace of the remote filesystem
+	// For write back, it will be the destination
+	ns, err := getNamespaceInfo(ctx, remoteDestination, tc.directorUrl, true)
+	if err != nil {
+		log.Errorln(err)
+		err = errors.New("Failed to get namespace information from source")
+		return
+	}
+	result.TransferredBytes, err = doWriteBack(ctx, localObjectUrl.Path, remoteDestUrl, ns, recursive)
+	if err != nil {
+		tc.errors.add(err)
+	} else if !recursive {
+		result.Checksums = tc.verifiedChecksums(localObjectUrl.Path)
+	}
+	return
+}
+
+// Stat gets the size, modification time and, where available, checksums of an object
+func (tc *TransferClient) Stat(ctx context.Context, remoteObject string) (info *ObjectInfo, err error) {
+	defer tc.recoverTransfer("Stat", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	ctx, target, err := tc.resolveRemoteObject(ctx, remoteObject)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err = statObject(ctx, target)
+	return
+}
+
+// List lists the contents of a collection, and with recursive set, those of
+// the collections within it
+func (tc *TransferClient) List(ctx context.Context, remoteObject string, recursive bool) (infos []ObjectInfo, err error) {
+	defer tc.recoverTransfer("List", &err)
+	ctx, cancel := tc.engine.transferContext(ctx)
+	defer cancel()
+
+	ctx, target, err := tc.resolveRemoteObject(ctx, remoteObject)
+	if err != nil {
+		return nil, err
+	}
+	return listObjects(ctx, target, recursive)
+}
@@ -0,0 +1,168 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferClientFederations(t *testing.T) {
+	server1 := startListingFederation(t)
+	server2 := startListingFederation(t)
+	// The clients must not depend on the configuration
+	viper.Reset()
+
+	engine := NewTransferEngine(context.Background())
+	defer engine.Close()
+	var clients []*TransferClient
+	for _, server := range []string{server1.URL, server2.URL} {
+		tc, err := engine.NewClient(WithDirectorUrl(server+"/director"), WithChecksums(ChecksumCRC32C))
+		require.NoError(t, err)
+		clients = append(clients, tc)
+	}
+
+	dest := t.TempDir()
+	var wg sync.WaitGroup
+	results := make([]TransferResult, len(clients))
+	for idx, tc := range clients {
+		wg.Add(1)
+		go func(idx int, tc *TransferClient) {
+			defer wg.Done()
+			results[idx], _ = tc.Get(context.Background(), "/test/a.txt", filepath.Join(dest, string(rune('0'+idx))), false)
+		}(idx, tc)
+	}
+	wg.Wait()
+	for idx, result := range results {
+		require.NoError(t, result.Error)
+		assert.Equal(t, int64(5), result.TransferredBytes)
+		assert.False(t, result.End.Before(result.Start))
+		require.Len(t, result.Attempts, 1)
+		assert.NoError(t, result.Attempts[0].Error)
+		assert.Equal(t, int64(5), result.Attempts[0].TransferredBytes)
+		contents, err := os.ReadFile(result.Destination)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(contents))
+		assert.Empty(t, clients[idx].GetErrors())
+	}
+
+	info, err := clients[0].Stat(context.Background(), "/test/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	infos, err := clients[1].List(context.Background(), "/test", true)
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+}
+
+func TestTransferClientCancel(t *testing.T) {
+	server := startListingFederation(t)
+
+	t.Run("cancelled-context", func(t *testing.T) {
+		engine := NewTransferEngine(context.Background())
+		defer engine.Close()
+		tc, err := engine.NewClient(WithDirectorUrl(server.URL + "/director"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		result, err := tc.Get(ctx, "/test/a.txt", filepath.Join(t.TempDir(), "a.txt"), false)
+		assert.Error(t, err)
+		assert.Equal(t, err, result.Error)
+		_, err = tc.Stat(ctx, "/test/a.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("closed-engine", func(t *testing.T) {
+		engine := NewTransferEngine(context.Background())
+		tc, err := engine.NewClient(WithDirectorUrl(server.URL + "/director"))
+		require.NoError(t, err)
+		engine.Close()
+
+		_, err = tc.List(context.Background(), "/test", false)
+		assert.Error(t, err)
+	})
+
+	t.Run("bad-option", func(t *testing.T) {
+		engine := NewTransferEngine(context.Background())
+		defer engine.Close()
+		_, err := engine.NewClient(WithCachesToTry(0))
+		assert.Error(t, err)
+	})
+}
+
+func TestNestedNamespaceResponses(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		queries []string
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		objectPath, _ := strings.CutPrefix(r.URL.Path, "/director")
+		mutex.Lock()
+		queries = append(queries, objectPath)
+		mutex.Unlock()
+		// /foo/bar is a namespace of its own inside /foo
+		namespace := "/foo"
+		if strings.HasPrefix(objectPath, "/foo/bar/") {
+			namespace = "/foo/bar"
+		}
+		if strings.HasPrefix(objectPath, "/foo/stat/") {
+			w.Header().Set("X-Pelican-Object-Specific", "true")
+		}
+		w.Header().Set("Link", "<"+server.URL+namespace+">; rel=\"duplicate\"; pri=1")
+		w.Header().Set("X-Pelican-Namespace", "namespace="+namespace+", require-token=false")
+		w.Header().Set("Location", server.URL+objectPath)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	engine := NewTransferEngine(context.Background())
+	defer engine.Close()
+	tc, err := engine.NewClient(WithDirectorUrl(server.URL + "/director"))
+	require.NoError(t, err)
+	ctx := tc.withContext(context.Background())
+	namespaceOf := func(objectPath string) string {
+		ns, err := getNamespaceInfo(ctx, objectPath, server.URL+"/director", false)
+		require.NoError(t, err)
+		return ns.Path
+	}
+
+	assert.Equal(t, "/foo", namespaceOf("/foo/a"))
+	assert.Equal(t, "/foo/bar", namespaceOf("/foo/bar/x"))
+	// Only objects in the same directory reuse a response
+	assert.Equal(t, "/foo", namespaceOf("/foo/b"))
+	assert.Equal(t, "/foo/bar", namespaceOf("/foo/bar/y"))
+	// Responses ordered by which servers have the object aren't reused
+	assert.Equal(t, "/foo", namespaceOf("/foo/stat/a"))
+	assert.Equal(t, "/foo", namespaceOf("/foo/stat/b"))
+	assert.Equal(t, []string{"/foo/a", "/foo/bar/x", "/foo/stat/a", "/foo/stat/b"}, queries)
+}
@@ -18,7 +18,13 @@
 
 package client
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 func ByteCountSI(b int64) string {
 	const unit = 1000
@@ -33,3 +39,38 @@ func ByteCountSI(b int64) string {
 	return fmt.Sprintf("%.1f %cB",
 		float64(b)/float64(div), "kMGTPE"[exp])
 }
+
+// ParseByteRate parses a rate in bytes per second, such as "500k", "10MB/s" or
+// "1.5GiB". The SI prefixes (k, M, G, T) are powers of 1000 and the binary
+// ones (Ki, Mi, Gi, Ti) powers of 1024.
+func ParseByteRate(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	trimmed = strings.TrimSuffix(strings.TrimSuffix(trimmed, "/s"), "B")
+	multiplier := 1.0
+	if idx := strings.IndexAny(trimmed, "kKmMgGtT"); idx >= 0 {
+		prefix := strings.ToLower(trimmed[idx:])
+		trimmed = trimmed[:idx]
+		base := 1000.0
+		if strings.HasSuffix(prefix, "i") {
+			base = 1024
+			prefix = strings.TrimSuffix(prefix, "i")
+		}
+		switch prefix {
+		case "k":
+			multiplier = base
+		case "m":
+			multiplier = base * base
+		case "g":
+			multiplier = base * base * base
+		case "t":
+			multiplier = base * base * base * base
+		default:
+			return 0, errors.Errorf("invalid rate %q", value)
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(trimmed), 64)
+	if err != nil || number < 0 {
+		return 0, errors.Errorf("invalid rate %q", value)
+	}
+	return int64(number * multiplier), nil
+}
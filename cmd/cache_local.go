@@ -0,0 +1,126 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/pelicanplatform/pelican/client"
+	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/param"
+)
+
+var (
+	cacheLocalCmd = &cobra.Command{
+		Use:   "cache-local",
+		Short: "Manage the client's local cache of downloaded objects",
+		Long: `Manage the client's local cache of downloaded objects, which is kept in the
+directory given by Client.LocalCacheLocation.`,
+	}
+
+	cacheLocalLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List the objects in the local cache, most recently used first",
+		Args:  cobra.NoArgs,
+		RunE:  cacheLocalLsMain,
+	}
+
+	cacheLocalGcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the least recently used objects from the local cache",
+		Long: `Remove the least recently used objects from the local cache until it is no
+larger than Client.LocalCacheSize, or the size given by --max-size.`,
+		Args: cobra.NoArgs,
+		RunE: cacheLocalGcMain,
+	}
+)
+
+func init() {
+	cacheLocalLsCmd.Flags().BoolP("json", "j", false, "Print the listing as JSON")
+	cacheLocalGcCmd.Flags().Int64("max-size", -1, "The size, in bytes, to shrink the cache to; 0 empties it")
+	cacheLocalCmd.AddCommand(cacheLocalLsCmd)
+	cacheLocalCmd.AddCommand(cacheLocalGcCmd)
+}
+
+func openLocalCache() (*client.LocalCache, error) {
+	if err := config.InitClient(); err != nil {
+		return nil, errors.Wrap(err, "Failed to initialize the client")
+	}
+	lc, err := client.ConfiguredLocalCache()
+	if err != nil {
+		return nil, err
+	}
+	if lc == nil {
+		return nil, errors.New("No local cache is configured; set Client.LocalCacheLocation")
+	}
+	return lc, nil
+}
+
+func cacheLocalLsMain(cmd *cobra.Command, args []string) error {
+	lc, err := openLocalCache()
+	if err != nil {
+		return err
+	}
+	entries, err := lc.Entries()
+	if err != nil {
+		return err
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		if entries == nil {
+			entries = []client.LocalCacheEntry{}
+		}
+		output, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "Failed to format the listing as JSON")
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	var total int64
+	for _, entry := range entries {
+		fmt.Printf("%12d %s %s\n", entry.Size, entry.LastUsed.Local().Format(time.RFC3339), entry.ObjectPath)
+		total += entry.Size
+	}
+	fmt.Printf("%d objects, %s\n", len(entries), client.ByteCountSI(total))
+	return nil
+}
+
+func cacheLocalGcMain(cmd *cobra.Command, args []string) error {
+	lc, err := openLocalCache()
+	if err != nil {
+		return err
+	}
+	maxSize, _ := cmd.Flags().GetInt64("max-size")
+	if maxSize < 0 {
+		maxSize = int64(param.Client_LocalCacheSize.GetInt())
+	}
+	removed, freed, err := lc.GC(maxSize)
+	if err != nil {
+		return err
+	}
+	log.Infof("Removed %d objects (%s) from the local cache", removed, client.ByteCountSI(freed))
+	return nil
+}
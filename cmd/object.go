@@ -19,7 +19,15 @@
 package main
 
 import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pelicanplatform/pelican/client"
+	"github.com/pelicanplatform/pelican/param"
 )
 
 var (
@@ -28,3 +36,74 @@ var (
 		Short: "Interact with objects in the federation",
 	}
 )
+
+const checksumFlagUsage = "Comma-separated checksum algorithms (crc32c, md5, sha-256) the transfer must be verified with. " +
+	"Without this flag, any checksum the server offers is verified"
+
+// Add the flags limiting the bandwidth of the transfer commands
+func addBandwidthFlags(flagSet *pflag.FlagSet) {
+	flagSet.String("limit-rate", "", "The most bandwidth each transfer may use per second, e.g. 500k, 10MB or 1GiB")
+	flagSet.String("max-bandwidth", "", "The most bandwidth all the transfers may use per second between them; "+
+		"overrides Client.MaxBandwidth")
+}
+
+// Make the client for an object command from its flags: --token, --checksum,
+// --cache (or $NEAREST_CACHE), --limit-rate, --max-bandwidth and --parallel,
+// for those of them the command has. Progress bars are shown if progressOutput
+// is a terminal and the logs aren't going to a file.
+func newObjectClient(cmd *cobra.Command, progressOutput *os.File) (*client.TransferClient, error) {
+	engine := client.NewTransferEngine(context.Background())
+	var options []client.TransferOption
+	flags := cmd.Flags()
+
+	if token, _ := flags.GetString("token"); token != "" {
+		options = append(options, client.WithTokenLocation(token))
+	}
+	if value, _ := flags.GetString("checksum"); value != "" {
+		checksums, err := client.ParseChecksumTypes(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid --checksum")
+		}
+		options = append(options, client.WithChecksums(checksums...))
+	}
+	if flags.Lookup("cache") != nil {
+		// Check for manually entered cache to use
+		if nearestCache, ok := os.LookupEnv("NEAREST_CACHE"); ok {
+			options = append(options, client.WithCache(nearestCache))
+		} else if cache, _ := flags.GetString("cache"); cache != "" {
+			options = append(options, client.WithCache(cache))
+		}
+	}
+	if value, _ := flags.GetString("limit-rate"); value != "" {
+		limitRate, err := client.ParseByteRate(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid --limit-rate")
+		}
+		options = append(options, client.WithRateLimit(limitRate))
+	}
+	if value, _ := flags.GetString("max-bandwidth"); value != "" {
+		maxBandwidth, err := client.ParseByteRate(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid --max-bandwidth")
+		}
+		engine.SetMaxBandwidth(maxBandwidth)
+	}
+	if flag := flags.Lookup("parallel"); flag != nil && flag.Changed {
+		workers, _ := flags.GetInt("parallel")
+		options = append(options, client.WithWorkerCount(workers))
+	}
+
+	// Check if the program was executed from a terminal
+	// https://rosettacode.org/wiki/Check_output_device_is_a_terminal#Go
+	if progressOutput != nil && param.Logging_LogLocation.GetString() == "" {
+		if fileInfo, err := progressOutput.Stat(); err == nil && (fileInfo.Mode()&os.ModeCharDevice) != 0 {
+			options = append(options, client.WithProgressBars())
+		}
+	}
+
+	tc, err := engine.NewClient(options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid options")
+	}
+	return tc, nil
+}
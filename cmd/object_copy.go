@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,7 +28,6 @@ import (
 	"github.com/pelicanplatform/pelican/client"
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/namespaces"
-	"github.com/pelicanplatform/pelican/param"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -38,7 +38,14 @@ var (
 	copyCmd = &cobra.Command{
 		Use:   "copy {source ...} {destination}",
 		Short: "Copy a file to/from a Pelican federation",
-		Run:   copyMain,
+		Long: `Copy a file to/from a Pelican federation.
+
+When both the source and destination are federation objects, the destination's
+origin is asked to pull the object directly from a cache with an HTTP
+third-party copy, so the data never passes through this machine.  If the origin
+doesn't support third-party copies, the object is streamed through the client
+instead.`,
+		Run: copyMain,
 	}
 )
 
@@ -51,6 +58,7 @@ func init() {
 	flagSet := copyCmd.Flags()
 	flagSet.StringP("cache", "c", "", "Cache to use")
 	flagSet.StringP("token", "t", "", "Token file to use for transfer")
+	flagSet.String("checksum", "", checksumFlagUsage)
 	flagSet.BoolP("recursive", "r", false, "Recursively copy a directory.  Forces methods to only be http to get the freshest directory contents")
 	flagSet.StringP("cache-list-name", "n", "xroot", "(Deprecated) Cache list to use, currently either xroot or xroots; may be ignored")
 	flagSet.Lookup("cache-list-name").Hidden = true
@@ -79,8 +87,6 @@ func init() {
 
 func copyMain(cmd *cobra.Command, args []string) {
 
-	client.ObjectClientOptions.Version = config.PelicanVersion
-
 	// Need to check just stashcp since it does not go through root, the other modes get checked there
 	if strings.HasPrefix(execName, "stashcp") {
 		if val, err := cmd.Flags().GetBool("debug"); err == nil && val {
@@ -104,15 +110,10 @@ func copyMain(cmd *cobra.Command, args []string) {
 		os.Exit(0)
 	}
 
-	// Set the progress bars to the command line option
-	client.ObjectClientOptions.Token, _ = cmd.Flags().GetString("token")
-
-	// Check if the program was executed from a terminal and does not specify a log location
-	// https://rosettacode.org/wiki/Check_output_device_is_a_terminal#Go
-	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode()&os.ModeCharDevice) != 0 && param.Logging_LogLocation.GetString() == "" {
-		client.ObjectClientOptions.ProgressBars = true
-	} else {
-		client.ObjectClientOptions.ProgressBars = false
+	tc, err := newObjectClient(cmd, os.Stdout)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(1)
 	}
 
 	if val, err := cmd.Flags().GetBool("namespaces"); err == nil && val {
@@ -168,25 +169,13 @@ func copyMain(cmd *cobra.Command, args []string) {
 	log.Debugln("Sources:", source)
 	log.Debugln("Destination:", dest)
 
-	// Check for manually entered cache to use ??
-	nearestCache, nearestCacheIsPresent := os.LookupEnv("NEAREST_CACHE")
-
-	if nearestCacheIsPresent {
-		client.NearestCache = nearestCache
-		client.NearestCacheList = append(client.NearestCacheList, client.NearestCache)
-		client.CacheOverride = true
-	} else if cache, _ := cmd.Flags().GetString("cache"); cache != "" {
-		client.NearestCache = cache
-		client.NearestCacheList = append(client.NearestCacheList, cache)
-		client.CacheOverride = true
-	}
-
 	// Convert the methods
 	methodNames, _ := cmd.Flags().GetString("methods")
 	splitMethods := strings.Split(methodNames, ",")
 
 	// If the user overrides the cache, then only use HTTP
-	if client.CacheOverride {
+	cache, _ := cmd.Flags().GetString("cache")
+	if _, nearestCacheIsPresent := os.LookupEnv("NEAREST_CACHE"); nearestCacheIsPresent || cache != "" {
 		splitMethods = []string{"http"}
 	}
 
@@ -203,26 +192,25 @@ func copyMain(cmd *cobra.Command, args []string) {
 	for _, src := range source {
 		var tmpDownloaded int64
 		isRecursive, _ := cmd.Flags().GetBool("recursive")
-		client.ObjectClientOptions.Recursive = isRecursive
-		tmpDownloaded, result = client.DoStashCPSingle(src, dest, splitMethods, isRecursive)
+		tmpDownloaded, result = tc.Transfer(context.Background(), src, dest, splitMethods, isRecursive)
 		downloaded += tmpDownloaded
 		if result != nil {
 			lastSrc = src
 			break
 		} else {
-			client.ClearErrors()
+			tc.ClearErrors()
 		}
 	}
 
 	// Exit with failure
 	if result != nil {
 		// Print the list of errors
-		errMsg := client.GetErrors()
+		errMsg := tc.GetErrors()
 		if errMsg == "" {
 			errMsg = result.Error()
 		}
 		log.Errorln("Failure transferring " + lastSrc + ": " + errMsg)
-		if client.ErrorsRetryable() {
+		if tc.ErrorsRetryable() {
 			log.Errorln("Errors are retryable")
 			os.Exit(11)
 		}
@@ -19,11 +19,11 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/pelicanplatform/pelican/client"
 	"github.com/pelicanplatform/pelican/config"
-	"github.com/pelicanplatform/pelican/param"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -32,7 +32,10 @@ var (
 	getCmd = &cobra.Command{
 		Use:   "get {source ...} {destination}",
 		Short: "Get a file from a Pelican federation",
-		Run:   getMain,
+		Long: `Get a file from a Pelican federation.
+
+A destination of "-" writes the objects to stdout, one after the other.`,
+		Run: getMain,
 	}
 )
 
@@ -40,32 +43,40 @@ func init() {
 	flagSet := getCmd.Flags()
 	flagSet.StringP("cache", "c", "", "Cache to use")
 	flagSet.StringP("token", "t", "", "Token file to use for transfer")
+	flagSet.String("checksum", "", checksumFlagUsage)
 	flagSet.BoolP("recursive", "r", false, "Recursively download a directory.  Forces methods to only be http to get the freshest directory contents")
 	flagSet.StringP("cache-list-name", "n", "xroot", "(Deprecated) Cache list to use, currently either xroot or xroots; may be ignored")
 	flagSet.Lookup("cache-list-name").Hidden = true
 	flagSet.String("caches", "", "A JSON file containing the list of caches")
+	addManifestFlags(flagSet)
+	addBandwidthFlags(flagSet)
 	objectCmd.AddCommand(getCmd)
 }
 
 func getMain(cmd *cobra.Command, args []string) {
 
-	client.ObjectClientOptions.Version = version
-
 	err := config.InitClient()
 	if err != nil {
 		log.Errorln(err)
 		os.Exit(1)
 	}
 
-	// Set the progress bars to the command line option
-	client.ObjectClientOptions.Token, _ = cmd.Flags().GetString("token")
+	// When streaming to stdout, the progress bars go to stderr instead
+	progressOutput := os.Stdout
+	if len(args) > 0 && args[len(args)-1] == client.StreamPath {
+		progressOutput = os.Stderr
+		client.SetProgressOutput(os.Stderr)
+	}
+
+	tc, err := newObjectClient(cmd, progressOutput)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(1)
+	}
 
-	// Check if the program was executed from a terminal
-	// https://rosettacode.org/wiki/Check_output_device_is_a_terminal#Go
-	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode()&os.ModeCharDevice) != 0 && param.Logging_LogLocation.GetString() == "" {
-		client.ObjectClientOptions.ProgressBars = true
-	} else {
-		client.ObjectClientOptions.ProgressBars = false
+	if manifestFile, _ := cmd.Flags().GetString("manifest"); manifestFile != "" {
+		manifestMain(cmd, tc, args, false)
+		return
 	}
 
 	log.Debugln("Len of source:", len(args))
@@ -83,20 +94,14 @@ func getMain(cmd *cobra.Command, args []string) {
 	log.Debugln("Sources:", source)
 	log.Debugln("Destination:", dest)
 
-	// Check for manually entered cache to use ??
-	nearestCache, nearestCacheIsPresent := os.LookupEnv("NEAREST_CACHE")
-
-	if nearestCacheIsPresent {
-		client.NearestCache = nearestCache
-		client.NearestCacheList = append(client.NearestCacheList, client.NearestCache)
-		client.CacheOverride = true
-	} else if cache, _ := cmd.Flags().GetString("cache"); cache != "" {
-		client.NearestCache = cache
-		client.NearestCacheList = append(client.NearestCacheList, cache)
-		client.CacheOverride = true
-	}
-
-	if len(source) > 1 {
+	isRecursive, _ := cmd.Flags().GetBool("recursive")
+	if dest == client.StreamPath {
+		// Several sources are written to stdout one after the other
+		if isRecursive {
+			log.Errorln("A recursive download can't be written to stdout")
+			os.Exit(1)
+		}
+	} else if len(source) > 1 {
 		if destStat, err := os.Stat(dest); err != nil && destStat.IsDir() {
 			log.Errorln("Destination is not a directory")
 			os.Exit(1)
@@ -107,28 +112,30 @@ func getMain(cmd *cobra.Command, args []string) {
 	var downloaded int64 = 0
 	lastSrc := ""
 	for _, src := range source {
-		var tmpDownloaded int64
-		isRecursive, _ := cmd.Flags().GetBool("recursive")
-		client.ObjectClientOptions.Recursive = isRecursive
-		tmpDownloaded, result = client.DoGet(src, dest, isRecursive)
-		downloaded += tmpDownloaded
+		var transferResult client.TransferResult
+		if dest == client.StreamPath {
+			transferResult, result = tc.GetToWriter(context.Background(), src, os.Stdout)
+		} else {
+			transferResult, result = tc.Get(context.Background(), src, dest, isRecursive)
+		}
+		downloaded += transferResult.TransferredBytes
 		if result != nil {
 			lastSrc = src
 			break
 		} else {
-			client.ClearErrors()
+			tc.ClearErrors()
 		}
 	}
 
 	// Exit with failure
 	if result != nil {
 		// Print the list of errors
-		errMsg := client.GetErrors()
+		errMsg := tc.GetErrors()
 		if errMsg == "" {
 			errMsg = result.Error()
 		}
 		log.Errorln("Failure getting " + lastSrc + ": " + errMsg)
-		if client.ErrorsRetryable() {
+		if tc.ErrorsRetryable() {
 			log.Errorln("Errors are retryable")
 			os.Exit(11)
 		}
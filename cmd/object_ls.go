@@ -0,0 +1,103 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pelicanplatform/pelican/client"
+	"github.com/pelicanplatform/pelican/config"
+)
+
+var (
+	lsCmd = &cobra.Command{
+		Use:   "ls {URL}",
+		Short: "List the objects in a collection of a Pelican federation",
+		Args:  cobra.ExactArgs(1),
+		RunE:  lsMain,
+	}
+)
+
+func init() {
+	flagSet := lsCmd.Flags()
+	flagSet.StringP("token", "t", "", "Token file to use for the listing")
+	flagSet.BoolP("long", "l", false, "Include the type, size and modification time of each object")
+	flagSet.BoolP("recursive", "R", false, "List the contents of collections recursively")
+	flagSet.BoolP("json", "j", false, "Print the listing as JSON")
+	objectCmd.AddCommand(lsCmd)
+}
+
+// Format an object the way `ls -l` would: type, size, modification time, name
+func formatLongListing(info client.ObjectInfo) string {
+	objectType := "-"
+	if info.IsCollection {
+		objectType = "d"
+	}
+	modTime := "-"
+	if !info.ModTime.IsZero() {
+		modTime = info.ModTime.Local().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s %12d %s %s", objectType, info.Size, modTime, info.Name)
+}
+
+func lsMain(cmd *cobra.Command, args []string) error {
+	err := config.InitClient()
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize the client")
+	}
+
+	tc, err := newObjectClient(cmd, nil)
+	if err != nil {
+		return err
+	}
+	long, _ := cmd.Flags().GetBool("long")
+	recursive, _ := cmd.Flags().GetBool("recursive")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	infos, err := tc.List(context.Background(), args[0], recursive)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list %s", args[0])
+	}
+
+	if asJSON {
+		if infos == nil {
+			infos = []client.ObjectInfo{}
+		}
+		output, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "Failed to format the listing as JSON")
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+	for _, info := range infos {
+		if long {
+			fmt.Println(formatLongListing(info))
+		} else {
+			fmt.Println(info.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pelicanplatform/pelican/client"
+)
+
+// Add the flags for transferring the entries of a manifest rather than the
+// sources on the command line
+func addManifestFlags(flagSet *pflag.FlagSet) {
+	flagSet.String("manifest", "", "A file listing the transfers to make: a JSON list of objects with source, destination, "+
+		"and optionally checksum and size; a CSV file of source,destination[,checksum[,size]]; or lines of "+
+		"\"source destination [checksum] [size]\".  Checksums are given as algorithm:value, e.g. md5:<hex>")
+	flagSet.String("report", "", "With --manifest, where to write a JSON report of every transfer; by default, stdout")
+	flagSet.IntP("parallel", "p", 0, "The number of files to transfer at once, for --manifest and recursive transfers; "+
+		"overrides Client.WorkerCount")
+}
+
+// Make the transfers listed in the --manifest file, write the report and exit
+func manifestMain(cmd *cobra.Command, tc *client.TransferClient, args []string, upload bool) {
+	if len(args) > 0 {
+		log.Errorln("Sources and destinations can't be given on the command line with --manifest")
+		os.Exit(1)
+	}
+	manifestFile, _ := cmd.Flags().GetString("manifest")
+	entries, err := client.ReadManifest(manifestFile)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(1)
+	}
+	isRecursive, _ := cmd.Flags().GetBool("recursive")
+	log.Debugf("Making %d transfers from %s", len(entries), manifestFile)
+
+	// With no worker count given, the client's (from --parallel or
+	// Client.WorkerCount) is used
+	report := tc.TransferManifest(context.Background(), entries, upload, isRecursive, 0)
+
+	reportFile, _ := cmd.Flags().GetString("report")
+	if err = writeManifestReport(report, reportFile); err != nil {
+		log.Errorln("Failed to write the report:", err)
+		os.Exit(1)
+	}
+
+	if report.Failed == 0 {
+		log.Infof("Made all %d transfers (%s)", report.Succeeded, client.ByteCountSI(report.TransferredBytes))
+		return
+	}
+	log.Errorf("%d of %d transfers failed", report.Failed, len(report.Transfers))
+	for _, result := range report.Transfers {
+		if !result.Success && !result.Retryable {
+			os.Exit(1)
+		}
+	}
+	log.Errorln("Errors are retryable")
+	os.Exit(11)
+}
+
+// Write the report as JSON to reportFile, or to stdout if it's empty
+func writeManifestReport(report client.ManifestReport, reportFile string) error {
+	output := os.Stdout
+	if reportFile != "" {
+		file, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -19,11 +19,11 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/pelicanplatform/pelican/client"
 	"github.com/pelicanplatform/pelican/config"
-	"github.com/pelicanplatform/pelican/param"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -32,36 +32,40 @@ var (
 	putCmd = &cobra.Command{
 		Use:   "put {source ...} {destination}",
 		Short: "Send a file to a Pelican federation",
-		Run:   putMain,
+		Long: `Send a file to a Pelican federation.
+
+A source of "-" uploads what's read from stdin.`,
+		Run: putMain,
 	}
 )
 
 func init() {
 	flagSet := putCmd.Flags()
 	flagSet.StringP("token", "t", "", "Token file to use for transfer")
+	flagSet.String("checksum", "", checksumFlagUsage)
 	flagSet.BoolP("recursive", "r", false, "Recursively upload a directory.  Forces methods to only be http to get the freshest directory contents")
+	addManifestFlags(flagSet)
+	addBandwidthFlags(flagSet)
 	objectCmd.AddCommand(putCmd)
 }
 
 func putMain(cmd *cobra.Command, args []string) {
 
-	client.ObjectClientOptions.Version = version
-
 	err := config.InitClient()
 	if err != nil {
 		log.Errorln(err)
 		os.Exit(1)
 	}
 
-	// Set the progress bars to the command line option
-	client.ObjectClientOptions.Token, _ = cmd.Flags().GetString("token")
+	tc, err := newObjectClient(cmd, os.Stdout)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(1)
+	}
 
-	// Check if the program was executed from a terminal
-	// https://rosettacode.org/wiki/Check_output_device_is_a_terminal#Go
-	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode()&os.ModeCharDevice) != 0 && param.Logging_LogLocation.GetString() == "" {
-		client.ObjectClientOptions.ProgressBars = true
-	} else {
-		client.ObjectClientOptions.ProgressBars = false
+	if manifestFile, _ := cmd.Flags().GetString("manifest"); manifestFile != "" {
+		manifestMain(cmd, tc, args, true)
+		return
 	}
 
 	log.Debugln("Len of source:", len(args))
@@ -79,6 +83,13 @@ func putMain(cmd *cobra.Command, args []string) {
 	log.Debugln("Sources:", source)
 	log.Debugln("Destination:", dest)
 
+	isRecursive, _ := cmd.Flags().GetBool("recursive")
+	for _, src := range source {
+		if src == client.StreamPath && (len(source) > 1 || isRecursive) {
+			log.Errorln("Stdin can only be uploaded on its own, and not recursively")
+			os.Exit(1)
+		}
+	}
 	if len(source) > 1 {
 		if destStat, err := os.Stat(dest); err != nil && destStat.IsDir() {
 			log.Errorln("Destination is not a directory")
@@ -90,28 +101,30 @@ func putMain(cmd *cobra.Command, args []string) {
 	var downloaded int64 = 0
 	lastSrc := ""
 	for _, src := range source {
-		var tmpDownloaded int64
-		isRecursive, _ := cmd.Flags().GetBool("recursive")
-		client.ObjectClientOptions.Recursive = isRecursive
-		tmpDownloaded, result = client.DoPut(src, dest, isRecursive)
-		downloaded += tmpDownloaded
+		var transferResult client.TransferResult
+		if src == client.StreamPath {
+			transferResult, result = tc.PutFromReader(context.Background(), os.Stdin, client.StreamSize(os.Stdin), dest)
+		} else {
+			transferResult, result = tc.Put(context.Background(), src, dest, isRecursive)
+		}
+		downloaded += transferResult.TransferredBytes
 		if result != nil {
 			lastSrc = src
 			break
 		} else {
-			client.ClearErrors()
+			tc.ClearErrors()
 		}
 	}
 
 	// Exit with failure
 	if result != nil {
 		// Print the list of errors
-		errMsg := client.GetErrors()
+		errMsg := tc.GetErrors()
 		if errMsg == "" {
 			errMsg = result.Error()
 		}
 		log.Errorln("Failure putting " + lastSrc + ": " + errMsg)
-		if client.ErrorsRetryable() {
+		if tc.ErrorsRetryable() {
 			log.Errorln("Errors are retryable")
 			os.Exit(11)
 		}
@@ -0,0 +1,91 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/pelicanplatform/pelican/config"
+)
+
+var (
+	statCmd = &cobra.Command{
+		Use:   "stat {URL}",
+		Short: "Show the size, modification time and checksums of an object in a Pelican federation",
+		Args:  cobra.ExactArgs(1),
+		RunE:  statMain,
+	}
+)
+
+func init() {
+	flagSet := statCmd.Flags()
+	flagSet.StringP("token", "t", "", "Token file to use")
+	flagSet.BoolP("json", "j", false, "Print the object's information as JSON")
+	objectCmd.AddCommand(statCmd)
+}
+
+func statMain(cmd *cobra.Command, args []string) error {
+	err := config.InitClient()
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize the client")
+	}
+
+	tc, err := newObjectClient(cmd, nil)
+	if err != nil {
+		return err
+	}
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	info, err := tc.Stat(context.Background(), args[0])
+	if err != nil {
+		return errors.Wrapf(err, "Failed to stat %s", args[0])
+	}
+
+	if asJSON {
+		output, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "Failed to format the object's information as JSON")
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	objectType := "object"
+	if info.IsCollection {
+		objectType = "collection"
+	}
+	fmt.Println("Name:     ", info.Name)
+	fmt.Println("Type:     ", objectType)
+	fmt.Println("Size:     ", info.Size)
+	if !info.ModTime.IsZero() {
+		fmt.Println("Modified: ", info.ModTime.Local().Format(time.RFC3339))
+	}
+	if len(info.Checksums) > 0 {
+		fmt.Println("Checksums:", strings.Join(info.Checksums, ", "))
+	}
+	fmt.Println("Server:   ", info.Server)
+	return nil
+}
@@ -0,0 +1,87 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/pelicanplatform/pelican/client"
+	"github.com/pelicanplatform/pelican/config"
+)
+
+var (
+	syncCmd = &cobra.Command{
+		Use:   "sync {source} {destination}",
+		Short: "Synchronize a local directory with a collection in a Pelican federation",
+		Long: `Synchronize a local directory with a collection in a Pelican federation,
+transferring only the files that are new or have changed. One of the source
+and destination must be an osdf:// or pelican:// URL; the other is local.`,
+		Args: cobra.ExactArgs(2),
+		RunE: syncMain,
+	}
+)
+
+func init() {
+	flagSet := syncCmd.Flags()
+	flagSet.StringP("token", "t", "", "Token file to use for transfer")
+	flagSet.Bool("delete", false, "Delete files at the destination that are not at the source")
+	flagSet.Bool("checksum", false, "Compare files of the same size by checksum, when the server provides one, rather than by modification time")
+	flagSet.Bool("dry-run", false, "Print what would be transferred or deleted without doing it")
+	flagSet.IntP("parallel", "p", 5, "Number of files to transfer at once")
+	addBandwidthFlags(flagSet)
+	objectCmd.AddCommand(syncCmd)
+}
+
+func syncMain(cmd *cobra.Command, args []string) error {
+	err := config.InitClient()
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize the client")
+	}
+
+	tc, err := newObjectClient(cmd, nil)
+	if err != nil {
+		return err
+	}
+	opts := client.SyncOptions{}
+	opts.Delete, _ = cmd.Flags().GetBool("delete")
+	opts.Checksum, _ = cmd.Flags().GetBool("checksum")
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	opts.Workers, _ = cmd.Flags().GetInt("parallel")
+
+	actions, transferred, err := tc.Sync(context.Background(), args[0], args[1], opts)
+	if opts.DryRun {
+		for _, action := range actions {
+			fmt.Println(action.String())
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to synchronize %s to %s", args[0], args[1])
+	}
+	if len(actions) == 0 {
+		log.Infoln("Already in sync; nothing to do")
+	} else if !opts.DryRun {
+		log.Infof("Synchronized %d files (%s transferred)", len(actions), client.ByteCountSI(transferred))
+	}
+	return nil
+}
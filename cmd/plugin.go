@@ -20,18 +20,21 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/pelicanplatform/pelican/classads"
 	"github.com/pelicanplatform/pelican/client"
 	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/param"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -79,12 +82,6 @@ func stashPluginMain(args []string) {
 
 	// Parse command line arguments
 	var upload bool = false
-	// Set the options
-	client.ObjectClientOptions.Recursive = false
-	client.ObjectClientOptions.ProgressBars = false
-	client.ObjectClientOptions.Version = version
-	client.ObjectClientOptions.Plugin = true
-	methods := []string{"http"}
 	var infile, outfile, testCachePath string
 	var useOutFile bool = false
 	var getCaches bool = false
@@ -145,7 +142,7 @@ func stashPluginMain(args []string) {
 			os.Exit(1)
 		}
 
-		cachesToTry := client.CachesToTry
+		cachesToTry := client.DefaultCachesToTry
 		if cachesToTry > len(urls) {
 			cachesToTry = len(urls)
 		}
@@ -158,8 +155,6 @@ func stashPluginMain(args []string) {
 
 	var source []string
 	var dest string
-	var result error
-	//var downloaded int64 = 0
 	var transfers []Transfer
 
 	if len(args) == 0 && (infile == "" || outfile == "") {
@@ -209,81 +204,12 @@ func stashPluginMain(args []string) {
 		defer outputFile.Close()
 	}
 
-	var resultAds []*classads.ClassAd
-	retryable := false
-	for _, transfer := range transfers {
-
-		var tmpDownloaded int64
-		if upload {
-			source = append(source, transfer.localFile)
-			log.Debugln("Uploading:", transfer.localFile, "to", transfer.url)
-			tmpDownloaded, result = client.DoStashCPSingle(transfer.localFile, transfer.url, methods, false)
-		} else {
-			source = append(source, transfer.url)
-			log.Debugln("Downloading:", transfer.url, "to", transfer.localFile)
-
-			// When we want to auto-unpack files, we should do this to the containing directory, not the destination
-			// file which HTCondor prepares
-			url, err := url.Parse(transfer.url)
-			if err != nil {
-				result = errors.Wrap(err, "Unable to parse transfer source as a URL")
-			} else {
-				localFile := transfer.localFile
-				if url.Query().Get("pack") != "" {
-					localFile = filepath.Dir(localFile)
-				}
-				tmpDownloaded, result = client.DoStashCPSingle(transfer.url, localFile, methods, false)
-			}
-		}
-		startTime := time.Now().Unix()
-		resultAd := classads.NewClassAd()
-		resultAd.Set("TransferStartTime", startTime)
-		resultAd.Set("TransferEndTime", time.Now().Unix())
-		hostname, _ := os.Hostname()
-		resultAd.Set("TransferLocalMachineName", hostname)
-		resultAd.Set("TransferProtocol", "stash")
-		resultAd.Set("TransferUrl", transfer.url)
-		resultAd.Set("TransferFileName", transfer.localFile)
-		if upload {
-			resultAd.Set("TransferType", "upload")
-		} else {
-			resultAd.Set("TransferType", "download")
-		}
-		if result == nil {
-			resultAd.Set("TransferSuccess", true)
-			resultAd.Set("TransferFileBytes", tmpDownloaded)
-			resultAd.Set("TransferTotalBytes", tmpDownloaded)
-		} else {
-			resultAd.Set("TransferSuccess", false)
-			if client.GetErrors() == "" {
-				resultAd.Set("TransferError", result.Error())
-			} else {
-				errMsg := " Failure "
-				if upload {
-					errMsg += "uploading "
-				} else {
-					errMsg += "downloading "
-				}
-				errMsg += transfer.url + ": " + client.GetErrors()
-				resultAd.Set("TransferError", errMsg)
-				client.ClearErrors()
-			}
-			resultAd.Set("TransferFileBytes", 0)
-			resultAd.Set("TransferTotalBytes", 0)
-			if client.ErrorsRetryable() {
-				resultAd.Set("TransferRetryable", true)
-				retryable = true
-			} else {
-				resultAd.Set("TransferRetryable", false)
-				retryable = false
-
-			}
-		}
-		resultAds = append(resultAds, resultAd)
-
-	}
+	engine := client.NewTransferEngine(context.Background())
+	resultAds := runPluginTransfers(engine, transfers, upload, param.Client_WorkerCount.GetInt())
+	engine.Close()
 
 	success := true
+	retryable := false
 	for _, resultAd := range resultAds {
 		_, err := outputFile.WriteString(resultAd.String() + "\n")
 		if err != nil {
@@ -296,6 +222,9 @@ func stashPluginMain(args []string) {
 			success = false
 		}
 		success = success && transferSuccess.(bool)
+		if transferRetryable, err := resultAd.Get("TransferRetryable"); err == nil {
+			retryable = transferRetryable.(bool)
+		}
 	}
 	if err = outputFile.Sync(); err != nil {
 		var perr *fs.PathError
@@ -324,6 +253,159 @@ func stashPluginMain(args []string) {
 	}
 }
 
+// Run the transfers with a pool of workers, returning the result ad of each
+// in the order the transfers were given
+func runPluginTransfers(engine *client.TransferEngine, transfers []Transfer, upload bool, workers int) []*classads.ClassAd {
+	if workers < 1 {
+		workers = 1
+	}
+	resultAds := make([]*classads.ClassAd, len(transfers))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(transfers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				resultAds[idx] = runPluginTransfer(engine, transfers[idx], upload)
+			}
+		}()
+	}
+	for idx := range transfers {
+		work <- idx
+	}
+	close(work)
+	wg.Wait()
+	return resultAds
+}
+
+// Make a single transfer, with a client of its own so that only its errors
+// are reported in its result ad
+func runPluginTransfer(engine *client.TransferEngine, transfer Transfer, upload bool) *classads.ClassAd {
+	var result client.TransferResult
+	tc, err := engine.NewClient(client.WithPluginMode())
+	if err != nil {
+		result = client.TransferResult{Start: time.Now(), End: time.Now(), Error: err}
+	} else if upload {
+		log.Debugln("Uploading:", transfer.localFile, "to", transfer.url)
+		result, _ = tc.Put(context.Background(), transfer.localFile, transfer.url, false)
+	} else {
+		log.Debugln("Downloading:", transfer.url, "to", transfer.localFile)
+
+		// When we want to auto-unpack files, we should do this to the containing directory, not the destination
+		// file which HTCondor prepares
+		url, err := url.Parse(transfer.url)
+		if err != nil {
+			result = client.TransferResult{Start: time.Now(), End: time.Now(), Error: errors.Wrap(err, "Unable to parse transfer source as a URL")}
+		} else {
+			localFile := transfer.localFile
+			if url.Query().Get("pack") != "" {
+				localFile = filepath.Dir(localFile)
+			}
+			result, _ = tc.Get(context.Background(), transfer.url, localFile, false)
+		}
+	}
+
+	resultAd := classads.NewClassAd()
+	resultAd.Set("TransferStartTime", result.Start.Unix())
+	resultAd.Set("TransferEndTime", result.End.Unix())
+	hostname, _ := os.Hostname()
+	resultAd.Set("TransferLocalMachineName", hostname)
+	resultAd.Set("TransferProtocol", "stash")
+	resultAd.Set("TransferUrl", transfer.url)
+	resultAd.Set("TransferFileName", transfer.localFile)
+	if upload {
+		resultAd.Set("TransferType", "upload")
+	} else {
+		resultAd.Set("TransferType", "download")
+	}
+	resultAd.Set("TransferAttempts", len(result.Attempts))
+	if len(result.Attempts) > 0 {
+		// The last attempt is the one that succeeded, if any did
+		lastAttempt := result.Attempts[len(result.Attempts)-1]
+		resultAd.Set("TransferServer", lastAttempt.Server)
+		resultAd.Set("TransferProxyUsed", lastAttempt.Proxy)
+		resultAd.Set("TransferTimeToFirstByte", lastAttempt.TimeToFirstByte.Seconds())
+	}
+	var transferErrors []client.TimestampedError
+	if tc != nil {
+		transferErrors = tc.Errors()
+	}
+	resultAd.Set("DeveloperData", pluginDeveloperData(result, transferErrors))
+	if result.Error == nil {
+		resultAd.Set("TransferSuccess", true)
+		resultAd.Set("TransferFileBytes", result.TransferredBytes)
+		resultAd.Set("TransferTotalBytes", result.TransferredBytes)
+		resultAd.Set("TransferThroughput", result.Throughput())
+		if len(result.Checksums) > 0 {
+			checksumStrs := make([]string, len(result.Checksums))
+			for idx, checksum := range result.Checksums {
+				checksumStrs[idx] = checksum.String()
+			}
+			resultAd.Set("TransferChecksums", strings.Join(checksumStrs, ","))
+		}
+	} else {
+		resultAd.Set("TransferSuccess", false)
+		if tc == nil || tc.GetErrors() == "" {
+			resultAd.Set("TransferError", result.Error.Error())
+		} else {
+			errMsg := " Failure "
+			if upload {
+				errMsg += "uploading "
+			} else {
+				errMsg += "downloading "
+			}
+			errMsg += transfer.url + ": " + tc.GetErrors()
+			resultAd.Set("TransferError", errMsg)
+		}
+		resultAd.Set("TransferFileBytes", 0)
+		resultAd.Set("TransferTotalBytes", 0)
+		resultAd.Set("TransferRetryable", tc != nil && tc.ErrorsRetryable())
+		// The most recent error says the most about why we gave up
+		categorized := result.Error
+		if len(transferErrors) > 0 {
+			categorized = transferErrors[len(transferErrors)-1].Err()
+		}
+		resultAd.Set("TransferErrorCategory", string(client.CategorizeError(categorized)))
+	}
+	return resultAd
+}
+
+// Describe each attempt of a transfer, and each error it hit, for debugging
+// failures from the job's ads
+func pluginDeveloperData(result client.TransferResult, transferErrors []client.TimestampedError) *classads.ClassAd {
+	attemptAds := make([]*classads.ClassAd, len(result.Attempts))
+	for idx, attempt := range result.Attempts {
+		attemptAd := classads.NewClassAd()
+		attemptAd.Set("AttemptNumber", idx+1)
+		attemptAd.Set("Server", attempt.Server)
+		attemptAd.Set("ProxyUsed", attempt.Proxy)
+		attemptAd.Set("StartTime", attempt.Start.Unix())
+		attemptAd.Set("EndTime", attempt.End.Unix())
+		attemptAd.Set("TimeToFirstByte", attempt.TimeToFirstByte.Seconds())
+		attemptAd.Set("TransferredBytes", attempt.TransferredBytes)
+		if attempt.Error != nil {
+			attemptAd.Set("Error", attempt.Error.Error())
+			attemptAd.Set("ErrorCategory", string(client.CategorizeError(attempt.Error)))
+		}
+		attemptAds[idx] = attemptAd
+	}
+	errorAds := make([]*classads.ClassAd, len(transferErrors))
+	for idx, transferError := range transferErrors {
+		errorAd := classads.NewClassAd()
+		errorAd.Set("Error", transferError.Err().Error())
+		errorAd.Set("ErrorCategory", string(client.CategorizeError(transferError.Err())))
+		errorAd.Set("Time", transferError.Timestamp().Unix())
+		errorAds[idx] = errorAd
+	}
+
+	developerData := classads.NewClassAd()
+	developerData.Set("PelicanClientVersion", version)
+	developerData.Set("Attempts", attemptAds)
+	developerData.Set("Errors", errorAds)
+	return developerData
+}
+
 // readMultiTransfers reads the transfers from a Reader, such as stdin
 func readMultiTransfers(stdin bufio.Reader) (transfers []Transfer, err error) {
 	// Check stdin for a list of transfers
@@ -343,7 +425,15 @@ func readMultiTransfers(stdin bufio.Reader) (transfers []Transfer, err error) {
 		if err != nil {
 			return nil, err
 		}
-		transfers = append(transfers, Transfer{url: url.(string), localFile: destination.(string)})
+		urlStr, ok := url.(string)
+		if !ok {
+			return nil, errors.Errorf("Url of transfer is not a string: %v", url)
+		}
+		destinationStr, ok := destination.(string)
+		if !ok {
+			return nil, errors.Errorf("LocalFileName of transfer is not a string: %v", destination)
+		}
+		transfers = append(transfers, Transfer{url: urlStr, localFile: destinationStr})
 	}
 
 	return transfers, nil
@@ -19,6 +19,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"os"
@@ -115,16 +116,20 @@ func stagePluginMain(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Set the progress bars to the command line option
-	client.ObjectClientOptions.Token = param.Plugin_Token.GetString()
-	client.ObjectClientOptions.Plugin = true
+	options := []client.TransferOption{client.WithPluginMode()}
+	if token := param.Plugin_Token.GetString(); token != "" {
+		options = append(options, client.WithTokenLocation(token))
+	}
 
 	// Check if the program was executed from a terminal
 	// https://rosettacode.org/wiki/Check_output_device_is_a_terminal#Go
 	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
-		client.ObjectClientOptions.ProgressBars = true
-	} else {
-		client.ObjectClientOptions.ProgressBars = false
+		options = append(options, client.WithProgressBars())
+	}
+	tc, err := client.NewTransferEngine(context.Background()).NewClient(options...)
+	if err != nil {
+		log.Errorln("Failed to create the transfer client:", err)
+		os.Exit(1)
 	}
 
 	var sources []string
@@ -187,7 +192,7 @@ func stagePluginMain(cmd *cobra.Command, args []string) {
 	var result error
 	var xformSources []string
 	for _, src := range sources {
-		_, newSource, result := client.DoShadowIngest(src, mountPrefixStr, shadowOriginPrefixStr)
+		_, newSource, result := tc.ShadowIngest(context.Background(), src, mountPrefixStr, shadowOriginPrefixStr)
 		if result != nil {
 			// What's the correct behavior on failure?  For now, we silently put the transfer
 			// back on the original list.  This is arguably the wrong approach as it might
@@ -204,8 +209,8 @@ func stagePluginMain(cmd *cobra.Command, args []string) {
 	// Exit with failure
 	if result != nil {
 		// Print the list of errors
-		log.Errorln(client.GetErrors())
-		if client.ErrorsRetryable() {
+		log.Errorln(tc.GetErrors())
+		if tc.ErrorsRetryable() {
 			log.Errorln("Errors are retryable")
 			os.Exit(11)
 		}
@@ -21,15 +21,25 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pelicanplatform/pelican/client"
 	"github.com/pelicanplatform/pelican/config"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestReadMultiTransfer test if we can read multiple transfers from stdin
@@ -55,6 +65,18 @@ func TestReadMultiTransfer(t *testing.T) {
 	assert.Equal(t, 1, len(transfers))
 	assert.Equal(t, "url://server/some/directory//blah", transfers[0].url)
 	assert.Equal(t, "/path/to/local/copy/of/blah", transfers[0].localFile)
+
+	// Test with attributes that are expressions rather than literals
+	stdin = "[ Base = \"url://server/some/directory/\"; LocalFileName = \"/path/to/local/copy/of/blah\"; Url = strcat(Base, \"blah\") ]"
+	transfers, err = readMultiTransfers(*bufio.NewReader(strings.NewReader(stdin)))
+	assert.NoError(t, err)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, "url://server/some/directory/blah", transfers[0].url)
+
+	// Test with a Url that isn't a string
+	stdin = "[ LocalFileName = \"/path/to/local/copy/of/blah\"; Url = 42 ]"
+	_, err = readMultiTransfers(*bufio.NewReader(strings.NewReader(stdin)))
+	assert.Error(t, err)
 }
 
 func TestStashPluginMain(t *testing.T) {
@@ -92,3 +114,86 @@ func TestStashPluginMain(t *testing.T) {
 	expectedOutput := "Downloading: pelican://pelican.example.com/osgconnect/public/osg/testfile.txt to " + tempDir
 	assert.Contains(t, output, expectedOutput)
 }
+
+// TestRunPluginTransfers checks that concurrent transfers share the director's
+// responses and report their results in the order they were given
+func TestRunPluginTransfers(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var directorQueries atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if objectPath, found := strings.CutPrefix(r.URL.Path, "/director"); found {
+			directorQueries.Add(1)
+			w.Header().Set("Link", "<"+server.URL+">; rel=\"duplicate\"; pri=1")
+			w.Header().Set("X-Pelican-Namespace", "namespace=/test, require-token=false")
+			w.Header().Set("Location", server.URL+objectPath)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		if r.URL.Path == "/test/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader("contents of "+r.URL.Path))
+	}))
+	defer server.Close()
+	serverUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	viper.Set("Federation.DirectorUrl", server.URL+"/director")
+
+	dest := t.TempDir()
+	var transfers []Transfer
+	for idx := 0; idx < 10; idx++ {
+		transfers = append(transfers, Transfer{
+			url:       fmt.Sprintf("osdf:///test/file%d", idx),
+			localFile: filepath.Join(dest, fmt.Sprintf("file%d", idx)),
+		})
+	}
+	transfers = append(transfers, Transfer{url: "osdf:///test/missing", localFile: filepath.Join(dest, "missing")})
+
+	engine := client.NewTransferEngine(context.Background())
+	defer engine.Close()
+	workers := 3
+	resultAds := runPluginTransfers(engine, transfers, false, workers)
+	require.Len(t, resultAds, len(transfers))
+
+	for idx, resultAd := range resultAds {
+		transferUrl, err := resultAd.Get("TransferUrl")
+		require.NoError(t, err)
+		assert.Equal(t, transfers[idx].url, transferUrl)
+		start, err := resultAd.Get("TransferStartTime")
+		require.NoError(t, err)
+		end, err := resultAd.Get("TransferEndTime")
+		require.NoError(t, err)
+		assert.LessOrEqual(t, start, end)
+
+		server, err := resultAd.Get("TransferServer")
+		require.NoError(t, err)
+		assert.Equal(t, serverUrl.Host, server)
+		attempts, err := resultAd.Get("TransferAttempts")
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempts)
+		assert.Contains(t, resultAd.String(), "DeveloperData = [")
+
+		success, err := resultAd.Get("TransferSuccess")
+		require.NoError(t, err)
+		if idx == len(transfers)-1 {
+			assert.Equal(t, false, success)
+			category, err := resultAd.Get("TransferErrorCategory")
+			require.NoError(t, err)
+			assert.Equal(t, "not-found", category)
+			continue
+		}
+		assert.Equal(t, true, success)
+		_, err = resultAd.Get("TransferThroughput")
+		require.NoError(t, err)
+		contents, err := os.ReadFile(transfers[idx].localFile)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("contents of /test/file%d", idx), string(contents))
+	}
+
+	// Only the transfers started before the first response could need to ask
+	assert.LessOrEqual(t, int(directorQueries.Load()), workers)
+}
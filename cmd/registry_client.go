@@ -28,6 +28,7 @@
 package main
 
 import (
+	"crypto/elliptic"
 	"net/url"
 	"os"
 
@@ -46,6 +47,8 @@ import (
 var withIdentity bool
 var prefix string
 var pubkeyPath string
+var newPrivkeyPath string
+var overlap string
 
 func getNamespaceEndpoint() (string, error) {
 	namespaceEndpoint := param.Federation_RegistryUrl.GetString()
@@ -154,6 +157,62 @@ func deleteANamespace(cmd *cobra.Command, args []string) {
 	}
 }
 
+func rotateANamespaceKey(cmd *cobra.Command, args []string) {
+	err := config.InitClient()
+	if err != nil {
+		log.Errorln("Failed to initialize the client: ", err)
+		os.Exit(1)
+	}
+
+	namespaceEndpoint, err := getNamespaceEndpoint()
+	if err != nil {
+		log.Errorln("Failed to get RegistryUrl from config: ", err)
+		os.Exit(1)
+	}
+
+	rotationEndpointURL, err := url.JoinPath(namespaceEndpoint, "api", "v1.0", "registry", "rotateKey")
+	if err != nil {
+		log.Errorf("Failed to construction key rotation endpoint URL: %v", err)
+	}
+	if prefix == "" {
+		log.Error("Error: prefix is required")
+		os.Exit(1)
+	}
+	if newPrivkeyPath == "" {
+		log.Error("Error: new-privkey is required")
+		os.Exit(1)
+	}
+
+	currentKey, err := config.GetIssuerPrivateJWK()
+	if err != nil {
+		log.Error("Failed to load the current private key: ", err)
+		os.Exit(1)
+	}
+
+	// Generate the new key if there isn't one at the path yet
+	if err = config.GeneratePrivateKey(newPrivkeyPath, elliptic.P256()); err != nil {
+		log.Error("Failed to generate the new private key: ", err)
+		os.Exit(1)
+	}
+	newKeyRaw, err := config.LoadPrivateKey(newPrivkeyPath)
+	if err != nil || newKeyRaw == nil {
+		log.Errorf("Failed to load the new private key from %s: %v", newPrivkeyPath, err)
+		os.Exit(1)
+	}
+	newKey, err := jwk.FromRaw(newKeyRaw)
+	if err != nil {
+		log.Error("Failed to create JWK private key", err)
+		os.Exit(1)
+	}
+
+	err = registry.NamespaceRotateKey(currentKey, newKey, rotationEndpointURL, prefix, overlap)
+	if err != nil {
+		log.Errorf("Failed to rotate the key of prefix %s: %v", prefix, err)
+		os.Exit(1)
+	}
+	log.Infof("Set IssuerKey to %s before the current key is retired", newPrivkeyPath)
+}
+
 func listAllNamespaces(cmd *cobra.Command, args []string) {
 	err := config.InitClient()
 	if err != nil {
@@ -224,6 +283,18 @@ var deleteCmd = &cobra.Command{
 	Run:   deleteANamespace,
 }
 
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Add a new key to a namespace and retire the current one",
+	Long: `Add a new key to a namespace and retire the current one.
+
+The request is signed with the current key (IssuerKey, or --privkey), and the
+new key at --new-privkey, which is generated if the file doesn't exist.  Both
+keys are valid until the end of the overlap, after which the registry retires
+the current key; switch the namespace's servers to the new key before then.`,
+	Run: rotateANamespaceKey,
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all namespaces",
@@ -243,6 +314,9 @@ func init() {
 	//getCmd.Flags().StringVar(&prefix, "prefix", "", "prefix for get namespace")
 	//getCmd.Flags().BoolVar(&jwks, "jwks", false, "Get the jwks of the namespace")
 	deleteCmd.Flags().StringVar(&prefix, "prefix", "", "prefix for delete namespace")
+	rotateKeyCmd.Flags().StringVar(&prefix, "prefix", "", "prefix for rotating namespace key")
+	rotateKeyCmd.Flags().StringVar(&newPrivkeyPath, "new-privkey", "", "Path to the new private key, generated if it doesn't exist")
+	rotateKeyCmd.Flags().StringVar(&overlap, "overlap", "", "How long the current key stays valid, e.g. 72h; by default, the registry's Registry.KeyRotationOverlap")
 
 	namespaceCmd.PersistentFlags().String("namespace-url", "", "Endpoint for the namespace registry")
 	// Don't override Federation.RegistryUrl if the flag value is empty
@@ -264,6 +338,7 @@ func init() {
 	namespaceCmd.AddCommand(registerCmd)
 	namespaceCmd.AddCommand(deleteCmd)
 	namespaceCmd.AddCommand(listCmd)
+	namespaceCmd.AddCommand(rotateKeyCmd)
 	// Commenting until we use -- JH
 	//namespaceCmd.AddCommand(getCmd)
 }
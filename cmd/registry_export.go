@@ -0,0 +1,186 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/registry"
+)
+
+var (
+	registryExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export every namespace in the registry to a signed file",
+		Long: `Export every namespace in the registry, with its keys, identity and admin
+metadata, to a JSON or YAML file signed with the registry's issuer key.
+The export can be imported into another registry with "pelican registry import".`,
+		Args:         cobra.NoArgs,
+		RunE:         registryExportMain,
+		SilenceUsage: true,
+	}
+
+	registryImportCmd = &cobra.Command{
+		Use:   "import {file}",
+		Short: "Import the namespaces of an export into the registry",
+		Long: `Import the namespaces of a file written by "pelican registry export" into
+the registry.  The export must be signed by this registry's issuer key or by
+the key given with --public-key.  Each namespace is validated like a new
+registration; namespaces already registered are reported as unchanged, or as
+conflicts if they differ from the export, and are never overwritten.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         registryImportMain,
+		SilenceUsage: true,
+	}
+
+	registryRestoreCmd = &cobra.Command{
+		Use:   "restore {backup}",
+		Short: "Replace the registry database with a backup",
+		Long: `Replace the registry database with a backup downloaded from the registry's
+/api/v1.0/registry_ui/backup endpoint.  The registry must be stopped.  The
+current database is kept next to it, and the backup is migrated to the latest
+schema when the registry next starts.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         registryRestoreMain,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	registryExportCmd.Flags().StringP("output", "o", "", "Where to write the export; by default, stdout")
+	registryExportCmd.Flags().String("format", "", "The format of the export, json or yaml; by default, from the extension of --output, or json")
+	registryImportCmd.Flags().String("public-key", "", "A JWKS file with the public key of the registry the export came from, "+
+		"if it's not this registry")
+
+	registryCmd.AddCommand(registryExportCmd)
+	registryCmd.AddCommand(registryImportCmd)
+	registryCmd.AddCommand(registryRestoreCmd)
+}
+
+func registryExportMain(cmd *cobra.Command, args []string) error {
+	outputFile, _ := cmd.Flags().GetString("output")
+	formatStr, _ := cmd.Flags().GetString("format")
+	format := registry.ExportFormatOf(outputFile)
+	if formatStr != "" {
+		format = registry.ExportFormat(formatStr)
+		if format != registry.ExportJSON && format != registry.ExportYAML {
+			return errors.Errorf("Unknown export format %s; use json or yaml", formatStr)
+		}
+	}
+
+	if err := config.InitServer(cmd.Context(), config.RegistryType); err != nil {
+		return errors.Wrap(err, "Failed to initialize configuration")
+	}
+	if err := registry.InitializeDB(cmd.Context()); err != nil {
+		return errors.Wrap(err, "Failed to open the registry database")
+	}
+	defer registry.ShutdownDB()
+
+	export, err := registry.ExportNamespaces()
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if outputFile != "" {
+		file, err := os.Create(outputFile)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create the export file")
+		}
+		defer file.Close()
+		output = file
+	}
+	if err = registry.WriteExport(output, export, format); err != nil {
+		return errors.Wrap(err, "Failed to write the export")
+	}
+	log.Infof("Exported %d namespaces", len(export.Namespaces))
+	return nil
+}
+
+func registryImportMain(cmd *cobra.Command, args []string) error {
+	file, err := os.Open(args[0])
+	if err != nil {
+		return errors.Wrap(err, "Failed to open the export")
+	}
+	defer file.Close()
+	export, err := registry.ReadExport(file)
+	if err != nil {
+		return err
+	}
+
+	if err = config.InitServer(cmd.Context(), config.RegistryType); err != nil {
+		return errors.Wrap(err, "Failed to initialize configuration")
+	}
+
+	var trustedKeys jwk.Set
+	if publicKeyFile, _ := cmd.Flags().GetString("public-key"); publicKeyFile != "" {
+		if trustedKeys, err = jwk.ReadFile(publicKeyFile); err != nil {
+			return errors.Wrapf(err, "Failed to read the public key from %s", publicKeyFile)
+		}
+	} else if trustedKeys, err = config.GetIssuerPublicJWKS(); err != nil {
+		return errors.Wrap(err, "Failed to load the registry's public key")
+	}
+
+	if err = registry.InitializeDB(cmd.Context()); err != nil {
+		return errors.Wrap(err, "Failed to open the registry database")
+	}
+	defer registry.ShutdownDB()
+
+	importedBy := "cli"
+	if currentUser, err := user.Current(); err == nil {
+		importedBy = "cli:" + currentUser.Username
+	}
+	report, err := registry.ImportNamespaces(export, trustedKeys, importedBy)
+	for _, result := range report.Namespaces {
+		if result.Reason != "" {
+			fmt.Printf("%s: %s (%s)\n", result.Prefix, result.Result, result.Reason)
+		} else {
+			fmt.Printf("%s: %s\n", result.Prefix, result.Result)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Imported %d namespaces; %d unchanged, %d conflicts, %d invalid",
+		report.Imported, report.Unchanged, report.Conflicts, report.Invalid)
+	if report.Conflicts > 0 || report.Invalid > 0 {
+		return errors.Errorf("%d namespaces weren't imported", report.Conflicts+report.Invalid)
+	}
+	return nil
+}
+
+func registryRestoreMain(cmd *cobra.Command, args []string) error {
+	if err := config.InitServer(cmd.Context(), config.RegistryType); err != nil {
+		return errors.Wrap(err, "Failed to initialize configuration")
+	}
+	if err := registry.RestoreDB(args[0]); err != nil {
+		return err
+	}
+	log.Infoln("Restored the registry database from", args[0])
+	return nil
+}
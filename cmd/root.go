@@ -98,6 +98,7 @@ func init() {
 	rootCmd.AddCommand(registryCmd)
 	rootCmd.AddCommand(originCmd)
 	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(cacheLocalCmd)
 	rootCmd.AddCommand(namespaceCmd)
 	rootCmd.AddCommand(rootConfigCmd)
 	rootCmd.AddCommand(rootPluginCmd)
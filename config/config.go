@@ -277,42 +277,14 @@ func GetAllPrefixes() []string {
 	return prefixes
 }
 
-func DiscoverFederation() error {
-	federationStr := param.Federation_DiscoveryUrl.GetString()
-	externalUrlStr := param.Server_ExternalWebUrl.GetString()
-	defer func() {
-		// Set default guesses if these values are still unset.
-		if param.Federation_DirectorUrl.GetString() == "" && enabledServers.IsEnabled(DirectorType) {
-			viper.Set("Federation.DirectorUrl", externalUrlStr)
-		}
-		if param.Federation_RegistryUrl.GetString() == "" && enabledServers.IsEnabled(RegistryType) {
-			viper.Set("Federation.RegistryUrl", externalUrlStr)
-		}
-		if param.Federation_JwkUrl.GetString() == "" && enabledServers.IsEnabled(DirectorType) {
-			viper.Set("Federation.JwkUrl", externalUrlStr+"/.well-known/issuer.jwks")
-		}
-	}()
-	if len(federationStr) == 0 {
-		log.Debugln("Federation URL is unset; skipping discovery")
-		return nil
-	}
-	if federationStr == externalUrlStr {
-		log.Debugln("Current web engine hosts the federation; skipping auto-discovery of services")
-		return nil
-	}
-
-	log.Debugln("Federation URL:", federationStr)
-	curDirectorURL := param.Federation_DirectorUrl.GetString()
-	curRegistryURL := param.Federation_RegistryUrl.GetString()
-	curFederationJwkURL := param.Federation_JwkUrl.GetString()
-	if len(curDirectorURL) != 0 && len(curRegistryURL) != 0 && len(curFederationJwkURL) != 0 {
-		return nil
-	}
-
+// DiscoverUrlFederation looks up the services of the federation at the given
+// discovery URL, without changing the configuration
+func DiscoverUrlFederation(federationStr string) (metadata FederationDiscovery, err error) {
 	log.Debugln("Performing federation service discovery against endpoint", federationStr)
 	federationUrl, err := url.Parse(federationStr)
 	if err != nil {
-		return errors.Wrapf(err, "Invalid federation value %s:", federationStr)
+		err = errors.Wrapf(err, "Invalid federation value %s:", federationStr)
+		return
 	}
 	federationUrl.Scheme = "https"
 	if len(federationUrl.Path) > 0 && len(federationUrl.Host) == 0 {
@@ -323,7 +295,8 @@ func DiscoverFederation() error {
 	discoveryUrl, _ := url.Parse(federationUrl.String())
 	discoveryUrl.Path, err = url.JoinPath(federationUrl.Path, ".well-known/pelican-configuration")
 	if err != nil {
-		return errors.Wrap(err, "Unable to parse federation url because of invalid path")
+		err = errors.Wrap(err, "Unable to parse federation url because of invalid path")
+		return
 	}
 
 	httpClient := http.Client{
@@ -332,13 +305,15 @@ func DiscoverFederation() error {
 	}
 	req, err := http.NewRequest(http.MethodGet, discoveryUrl.String(), nil)
 	if err != nil {
-		return errors.Wrapf(err, "Failure when doing federation metadata request creation for %s", discoveryUrl)
+		err = errors.Wrapf(err, "Failure when doing federation metadata request creation for %s", discoveryUrl)
+		return
 	}
 	req.Header.Set("User-Agent", "pelican/7")
 
 	result, err := httpClient.Do(req)
 	if err != nil {
-		return errors.Wrapf(err, "Failure when doing federation metadata lookup to %s", discoveryUrl)
+		err = errors.Wrapf(err, "Failure when doing federation metadata lookup to %s", discoveryUrl)
+		return
 	}
 
 	if result.Body != nil {
@@ -347,13 +322,52 @@ func DiscoverFederation() error {
 
 	body, err := io.ReadAll(result.Body)
 	if err != nil {
-		return errors.Wrapf(err, "Failure when doing federation metadata read to %s", discoveryUrl)
+		err = errors.Wrapf(err, "Failure when doing federation metadata read to %s", discoveryUrl)
+		return
 	}
 
-	metadata := FederationDiscovery{}
 	err = json.Unmarshal(body, &metadata)
 	if err != nil {
-		return errors.Wrapf(err, "Failure when parsing federation metadata at %s", discoveryUrl)
+		err = errors.Wrapf(err, "Failure when parsing federation metadata at %s", discoveryUrl)
+	}
+	return
+}
+
+func DiscoverFederation() error {
+	federationStr := param.Federation_DiscoveryUrl.GetString()
+	externalUrlStr := param.Server_ExternalWebUrl.GetString()
+	defer func() {
+		// Set default guesses if these values are still unset.
+		if param.Federation_DirectorUrl.GetString() == "" && enabledServers.IsEnabled(DirectorType) {
+			viper.Set("Federation.DirectorUrl", externalUrlStr)
+		}
+		if param.Federation_RegistryUrl.GetString() == "" && enabledServers.IsEnabled(RegistryType) {
+			viper.Set("Federation.RegistryUrl", externalUrlStr)
+		}
+		if param.Federation_JwkUrl.GetString() == "" && enabledServers.IsEnabled(DirectorType) {
+			viper.Set("Federation.JwkUrl", externalUrlStr+"/.well-known/issuer.jwks")
+		}
+	}()
+	if len(federationStr) == 0 {
+		log.Debugln("Federation URL is unset; skipping discovery")
+		return nil
+	}
+	if federationStr == externalUrlStr {
+		log.Debugln("Current web engine hosts the federation; skipping auto-discovery of services")
+		return nil
+	}
+
+	log.Debugln("Federation URL:", federationStr)
+	curDirectorURL := param.Federation_DirectorUrl.GetString()
+	curRegistryURL := param.Federation_RegistryUrl.GetString()
+	curFederationJwkURL := param.Federation_JwkUrl.GetString()
+	if len(curDirectorURL) != 0 && len(curRegistryURL) != 0 && len(curFederationJwkURL) != 0 {
+		return nil
+	}
+
+	metadata, err := DiscoverUrlFederation(federationStr)
+	if err != nil {
+		return err
 	}
 	if curDirectorURL == "" {
 		log.Debugln("Federation service discovery resulted in director URL", metadata.DirectorEndpoint)
@@ -651,10 +665,12 @@ func InitServer(ctx context.Context, currentServers ServerType) error {
 		viper.SetDefault("Origin.Multiuser", true)
 		viper.SetDefault("Director.GeoIPLocation", "/var/cache/pelican/maxmind/GeoLite2-City.mmdb")
 		viper.SetDefault("Registry.DbLocation", "/var/lib/pelican/registry.sqlite")
+		viper.SetDefault("Director.DbLocation", "/var/lib/pelican/director.sqlite")
 		viper.SetDefault("Monitoring.DataLocation", "/var/lib/pelican/monitoring/data")
 	} else {
 		viper.SetDefault("Director.GeoIPLocation", filepath.Join(configDir, "maxmind", "GeoLite2-City.mmdb"))
 		viper.SetDefault("Registry.DbLocation", filepath.Join(configDir, "ns-registry.sqlite"))
+		viper.SetDefault("Director.DbLocation", filepath.Join(configDir, "director.sqlite"))
 		viper.SetDefault("Monitoring.DataLocation", filepath.Join(configDir, "monitoring/data"))
 
 		if userRuntimeDir := os.Getenv("XDG_RUNTIME_DIR"); userRuntimeDir != "" {
@@ -784,6 +800,12 @@ func InitClient() error {
 	viper.SetDefault("Client.StoppedTransferTimeout", 100)
 	viper.SetDefault("Client.SlowTransferRampupTime", 100)
 	viper.SetDefault("Client.SlowTransferWindow", 30)
+	viper.SetDefault("Client.MaximumDownloadSources", 3)
+	viper.SetDefault("Client.MultiSourceMinimumSize", 104857600)
+	viper.SetDefault("Client.WorkerCount", 5)
+	viper.SetDefault("Client.LocalCacheSize", 10737418240)
+	viper.SetDefault("Client.MinimumUploadSpeed", 102400)
+	viper.SetDefault("Client.UploadChunkRetries", 3)
 
 	if upper_prefix == "OSDF" || upper_prefix == "STASH" {
 		viper.SetDefault("Federation.TopologyNamespaceURL", "https://topology.opensciencegrid.org/osdf/namespaces")
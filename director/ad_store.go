@@ -0,0 +1,314 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	// commented sqlite driver requires CGO
+	// _ "github.com/mattn/go-sqlite3" // SQLite driver
+	_ "modernc.org/sqlite"
+
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// An AdStore persists the server ads recorded by the director so that
+	// they survive a restart. The in-memory serverAds cache remains the
+	// source of truth for redirects; the store is written through on every
+	// RecordAd and read back once at startup.
+	AdStore interface {
+		// Save (or replace) the ad along with its namespaces and the time it expires
+		Save(ad ServerAd, namespaceAds []NamespaceAd, expiresAt time.Time) error
+		// Remove the ad from the store, if present
+		Delete(ad ServerAd) error
+		// Return all ads in the store that have not yet expired
+		Load() ([]StoredAd, error)
+		Close() error
+	}
+
+	StoredAd struct {
+		ServerAd     ServerAd
+		NamespaceAds []NamespaceAd
+		ExpiresAt    time.Time
+	}
+
+	// The on-disk representation of a ServerAd. URLs are stored as strings so
+	// that the ad round-trips to an identical key in the serverAds cache.
+	storedServerAd struct {
+		Name               string     `json:"name"`
+		AuthURL            string     `json:"authUrl"`
+		URL                string     `json:"url"`
+		WebURL             string     `json:"webUrl"`
+		Type               ServerType `json:"type"`
+		Latitude           float64    `json:"latitude"`
+		Longitude          float64    `json:"longitude"`
+		EnableWrite        bool       `json:"enableWrite"`
+		EnableFallbackRead bool       `json:"enableFallbackRead"`
+	}
+
+	// The default store, which keeps nothing across restarts
+	memoryAdStore struct{}
+
+	sqliteAdStore struct {
+		db *sql.DB
+	}
+)
+
+const (
+	MemoryAdStoreType = "memory"
+	SQLiteAdStoreType = "sqlite"
+)
+
+var adStore AdStore = memoryAdStore{}
+
+func (memoryAdStore) Save(ServerAd, []NamespaceAd, time.Time) error { return nil }
+func (memoryAdStore) Delete(ServerAd) error                         { return nil }
+func (memoryAdStore) Load() ([]StoredAd, error)                     { return nil, nil }
+func (memoryAdStore) Close() error                                  { return nil }
+
+func toStoredServerAd(ad ServerAd) storedServerAd {
+	return storedServerAd{
+		Name:               ad.Name,
+		AuthURL:            ad.AuthURL.String(),
+		URL:                ad.URL.String(),
+		WebURL:             ad.WebURL.String(),
+		Type:               ad.Type,
+		Latitude:           ad.Latitude,
+		Longitude:          ad.Longitude,
+		EnableWrite:        ad.EnableWrite,
+		EnableFallbackRead: ad.EnableFallbackRead,
+	}
+}
+
+func (sAd storedServerAd) toServerAd() (ServerAd, error) {
+	ad := ServerAd{
+		Name:               sAd.Name,
+		Type:               sAd.Type,
+		Latitude:           sAd.Latitude,
+		Longitude:          sAd.Longitude,
+		EnableWrite:        sAd.EnableWrite,
+		EnableFallbackRead: sAd.EnableFallbackRead,
+	}
+	for _, pair := range []struct {
+		raw string
+		dst *url.URL
+	}{{sAd.AuthURL, &ad.AuthURL}, {sAd.URL, &ad.URL}, {sAd.WebURL, &ad.WebURL}} {
+		parsed, err := url.Parse(pair.raw)
+		if err != nil {
+			return ad, errors.Wrapf(err, "Invalid URL %s in stored server ad %s", pair.raw, sAd.Name)
+		}
+		*pair.dst = *parsed
+	}
+	return ad, nil
+}
+
+// The key of an ad in the store is its serialized form; two ads are the
+// same row exactly when they are the same key in the serverAds cache.
+func adStoreKey(ad ServerAd) (string, error) {
+	keyBytes, err := json.Marshal(toStoredServerAd(ad))
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to marshal server ad")
+	}
+	return string(keyBytes), nil
+}
+
+func NewSQLiteAdStore(dbPath string) (AdStore, error) {
+	if dbPath == "" {
+		return nil, errors.New("Could not get path for the director database.")
+	}
+
+	// Before attempting to create the database, the path
+	// must exist or sql.Open will panic.
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, errors.Wrap(err, "Failed to create directory for director database")
+	}
+
+	dbName := "file:" + dbPath + "?_busy_timeout=5000&_journal_mode=WAL"
+	log.Debugln("Opening connection to sqlite DB", dbName)
+	db, err := sql.Open("sqlite", dbName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the database with path: %s", dbPath)
+	}
+
+	query := `
+    CREATE TABLE IF NOT EXISTS server_ads (
+        server_ad TEXT PRIMARY KEY,
+        namespace_ads TEXT NOT NULL,
+        expires_at INTEGER NOT NULL
+    );`
+	if _, err := db.Exec(query); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "Failed to create server_ads table")
+	}
+	return &sqliteAdStore{db: db}, nil
+}
+
+func (s *sqliteAdStore) Save(ad ServerAd, namespaceAds []NamespaceAd, expiresAt time.Time) error {
+	key, err := adStoreKey(ad)
+	if err != nil {
+		return err
+	}
+	nsBytes, err := json.Marshal(namespaceAds)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal namespace ads")
+	}
+	query := `INSERT OR REPLACE INTO server_ads (server_ad, namespace_ads, expires_at) VALUES (?, ?, ?)`
+	if _, err := s.db.Exec(query, key, string(nsBytes), expiresAt.UnixMilli()); err != nil {
+		return errors.Wrap(err, "Failed to save server ad")
+	}
+	return nil
+}
+
+func (s *sqliteAdStore) Delete(ad ServerAd) error {
+	key, err := adStoreKey(ad)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM server_ads WHERE server_ad = ?`, key); err != nil {
+		return errors.Wrap(err, "Failed to delete server ad")
+	}
+	return nil
+}
+
+func (s *sqliteAdStore) Load() ([]StoredAd, error) {
+	now := time.Now().UnixMilli()
+	// Anything that expired while the director was down is dead weight
+	if _, err := s.db.Exec(`DELETE FROM server_ads WHERE expires_at <= ?`, now); err != nil {
+		return nil, errors.Wrap(err, "Failed to prune expired server ads")
+	}
+
+	rows, err := s.db.Query(`SELECT server_ad, namespace_ads, expires_at FROM server_ads`)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to query server ads")
+	}
+	defer rows.Close()
+
+	ads := make([]StoredAd, 0)
+	for rows.Next() {
+		var adStr, nsStr string
+		var expiresAt int64
+		if err := rows.Scan(&adStr, &nsStr, &expiresAt); err != nil {
+			return nil, err
+		}
+		sAd := storedServerAd{}
+		if err := json.Unmarshal([]byte(adStr), &sAd); err != nil {
+			log.Warningln("Skipping unparseable server ad in director database:", err)
+			continue
+		}
+		ad, err := sAd.toServerAd()
+		if err != nil {
+			log.Warningln("Skipping invalid server ad in director database:", err)
+			continue
+		}
+		stored := StoredAd{ServerAd: ad, ExpiresAt: time.UnixMilli(expiresAt)}
+		if err := json.Unmarshal([]byte(nsStr), &stored.NamespaceAds); err != nil {
+			log.Warningf("Skipping server ad %s with unparseable namespaces in director database: %v", ad.Name, err)
+			continue
+		}
+		ads = append(ads, stored)
+	}
+	return ads, rows.Err()
+}
+
+func (s *sqliteAdStore) Close() error {
+	return s.db.Close()
+}
+
+// Put the ads from the store back into the serverAds cache with whatever
+// is left of their TTL, and resume health tests for the origins among them
+func restoreAds(store AdStore) error {
+	storedAds, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	serverAdMutex.Lock()
+	defer serverAdMutex.Unlock()
+	healthTestCancelFuncsMutex.Lock()
+	defer healthTestCancelFuncsMutex.Unlock()
+	for _, stored := range storedAds {
+		ttl := time.Until(stored.ExpiresAt)
+		if ttl <= 0 {
+			continue
+		}
+		setServerAd(stored.ServerAd, stored.NamespaceAds, ttl)
+		if stored.ServerAd.Type == OriginType && stored.ServerAd.WebURL.String() != "" {
+			if _, exists := healthTestCancelFuncs[stored.ServerAd]; !exists {
+				ctx, cancel := context.WithCancel(context.Background())
+				healthTestCancelFuncs[stored.ServerAd] = cancel
+				LaunchPeriodicDirectorTest(ctx, stored.ServerAd)
+			}
+		}
+	}
+	log.Infof("Restored %d server ads from the director database", len(storedAds))
+	return nil
+}
+
+// Set up the ad store selected by Director.AdStore and restore any ads that
+// were persisted by a previous run of the director. The store is closed
+// when ctx is cancelled.
+func InitializeAdStore(ctx context.Context) error {
+	storeType := param.Director_AdStore.GetString()
+	switch storeType {
+	case "", MemoryAdStoreType:
+		adStore = memoryAdStore{}
+		return nil
+	case SQLiteAdStoreType:
+		store, err := NewSQLiteAdStore(param.Director_DbLocation.GetString())
+		if err != nil {
+			return err
+		}
+		if err := restoreAds(store); err != nil {
+			store.Close()
+			return errors.Wrap(err, "Failed to restore server ads from the director database")
+		}
+		adStore = store
+		go func() {
+			<-ctx.Done()
+			if err := store.Close(); err != nil {
+				log.Errorln("Failure when shutting down the director database:", err)
+			}
+		}()
+		return nil
+	default:
+		return errors.Errorf("Unknown Director.AdStore %q; must be either %q or %q", storeType, MemoryAdStoreType, SQLiteAdStoreType)
+	}
+}
+
+// Write the ad through to the persistent store. Failures are logged rather
+// than returned as the ad is already live in memory.
+func persistAd(item *ttlcache.Item[ServerAd, []NamespaceAd]) {
+	if item == nil {
+		return
+	}
+	if err := adStore.Save(item.Key(), item.Value(), item.ExpiresAt()); err != nil {
+		log.Warningf("Failed to persist server ad for %s: %v", item.Key().Name, err)
+	}
+}
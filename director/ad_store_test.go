@@ -0,0 +1,108 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteAdStore(t *testing.T) {
+	mockAd := ServerAd{
+		Name:        "test-cache-server",
+		AuthURL:     url.URL{Scheme: "https", Host: "cache.example.com:8443"},
+		URL:         url.URL{Scheme: "https", Host: "cache.example.com:8443"},
+		WebURL:      url.URL{Scheme: "https", Host: "cache.example.com:8444"},
+		Type:        CacheType,
+		Latitude:    45.67,
+		Longitude:   123.05,
+		EnableWrite: true,
+	}
+	mockNs := mockNamespaceAds(3, "cache1")
+
+	t.Run("round-trip", func(t *testing.T) {
+		store, err := NewSQLiteAdStore(filepath.Join(t.TempDir(), "director.sqlite"))
+		require.NoError(t, err)
+		defer store.Close()
+
+		expiry := time.Now().Add(10 * time.Minute).Truncate(time.Millisecond)
+		require.NoError(t, store.Save(mockAd, mockNs, expiry))
+		// Saving the same ad again replaces the existing row
+		require.NoError(t, store.Save(mockAd, mockNs, expiry))
+
+		ads, err := store.Load()
+		require.NoError(t, err)
+		require.Len(t, ads, 1)
+		assert.True(t, ads[0].ServerAd == mockAd, "Stored ad doesn't round-trip to the same cache key")
+		assert.Equal(t, mockNs, ads[0].NamespaceAds)
+		assert.True(t, expiry.Equal(ads[0].ExpiresAt))
+
+		require.NoError(t, store.Delete(mockAd))
+		ads, err = store.Load()
+		require.NoError(t, err)
+		assert.Len(t, ads, 0)
+	})
+
+	t.Run("expired-ads-are-pruned", func(t *testing.T) {
+		store, err := NewSQLiteAdStore(filepath.Join(t.TempDir(), "director.sqlite"))
+		require.NoError(t, err)
+		defer store.Close()
+
+		require.NoError(t, store.Save(mockAd, mockNs, time.Now().Add(-time.Minute)))
+		ads, err := store.Load()
+		require.NoError(t, err)
+		assert.Len(t, ads, 0)
+	})
+
+	t.Run("restore-preserves-remaining-ttl", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "director.sqlite")
+		store, err := NewSQLiteAdStore(dbPath)
+		require.NoError(t, err)
+		expiry := time.Now().Add(5 * time.Minute)
+		require.NoError(t, store.Save(mockAd, mockNs, expiry))
+		require.NoError(t, store.Close())
+
+		// Simulate a director restart by re-opening the database
+		store, err = NewSQLiteAdStore(dbPath)
+		require.NoError(t, err)
+		defer store.Close()
+
+		func() {
+			serverAdMutex.Lock()
+			defer serverAdMutex.Unlock()
+			serverAds.DeleteAll()
+		}()
+		require.NoError(t, restoreAds(store))
+
+		serverAdMutex.RLock()
+		defer serverAdMutex.RUnlock()
+		item := serverAds.Get(mockAd, ttlcache.WithDisableTouchOnHit[ServerAd, []NamespaceAd]())
+		require.NotNil(t, item, "Stored ad wasn't restored into the cache")
+		assert.Equal(t, mockNs, item.Value())
+		// The restored item should expire when the original would have, not a
+		// full TTL after the restart
+		assert.WithinDuration(t, expiry, item.ExpiresAt(), time.Second)
+	})
+}
@@ -74,15 +74,41 @@ const (
 var (
 	serverAds     = ttlcache.New[ServerAd, []NamespaceAd](ttlcache.WithTTL[ServerAd, []NamespaceAd](15 * time.Minute))
 	serverAdMutex = sync.RWMutex{}
+	// Longest-prefix index over the namespaces in serverAds, guarded by serverAdMutex
+	namespaceIndex = newNamespaceTrie()
 )
 
 func RecordAd(ad ServerAd, namespaceAds *[]NamespaceAd) {
 	if err := UpdateLatLong(&ad); err != nil {
 		log.Debugln("Failed to lookup GeoIP coordinates for host", ad.URL.Host)
 	}
+	recordAdWithLocation(ad, namespaceAds)
+}
+
+// Record an ad whose coordinates have already been looked up
+func recordAdWithLocation(ad ServerAd, namespaceAds *[]NamespaceAd) {
+	serverAdMutex.Lock()
+	defer serverAdMutex.Unlock()
+	persistAd(setServerAd(ad, *namespaceAds, ttlcache.DefaultTTL))
+}
+
+// Add the ad to serverAds and the namespace index. The caller must hold
+// serverAdMutex for writing.
+func setServerAd(ad ServerAd, namespaceAds []NamespaceAd, ttl time.Duration) *ttlcache.Item[ServerAd, []NamespaceAd] {
+	item := serverAds.Set(ad, namespaceAds, ttl)
+	namespaceIndex.Insert(ad, namespaceAds)
+	return item
+}
+
+// Drop an evicted ad from the namespace index, unless it has been
+// re-advertised in the meantime
+func removeFromNamespaceIndex(ad ServerAd) {
 	serverAdMutex.Lock()
 	defer serverAdMutex.Unlock()
-	serverAds.Set(ad, *namespaceAds, ttlcache.DefaultTTL)
+	if serverAds.Has(ad) {
+		return
+	}
+	namespaceIndex.Remove(ad)
 }
 
 func UpdateLatLong(ad *ServerAd) error {
@@ -110,91 +136,36 @@ func UpdateLatLong(ad *ServerAd) error {
 	return nil
 }
 
-func matchesPrefix(reqPath string, namespaceAds []NamespaceAd) *NamespaceAd {
-	var best *NamespaceAd
-
-	for _, namespace := range namespaceAds {
-		serverPath := namespace.Path
-		if strings.Compare(serverPath, reqPath) == 0 {
-			return &namespace
-		}
-
-		// Some namespaces in Topology already have the trailing /, some don't
-		// Perhaps this should be standardized, but in case it isn't we need to
-		// handle it throughout this function. Note that reqPath already has the
-		// tail from being called by GetAdsForPath
-		if serverPath[len(serverPath)-1:] != "/" {
-			serverPath += "/"
-		}
-
-		// The assignment of best doesn't account for the trailing / that we need to consider
-		// Account for that by setting up a tmpBest string that adds the / if needed
-		var tmpBest string
-		if best != nil {
-			tmpBest = best.Path
-			if tmpBest[len(tmpBest)-1:] != "/" {
-				tmpBest += "/"
-			}
-		}
-
-		// Make the len comparison with tmpBest, because serverPath is one char longer now
-		if strings.HasPrefix(reqPath, serverPath) && len(serverPath) > len(tmpBest) {
-			if best == nil {
-				best = new(NamespaceAd)
-			}
-			*best = namespace
-		}
-	}
-	return best
-}
-
 func GetAdsForPath(reqPath string) (originNamespace NamespaceAd, originAds []ServerAd, cacheAds []ServerAd) {
 	serverAdMutex.RLock()
 	defer serverAdMutex.RUnlock()
 
-	// Clean the path, but re-append a trailing / to deal with some namespaces
-	// from topo that have a trailing /
-	reqPath = path.Clean(reqPath)
-	reqPath += "/"
-
-	// Iterate through all of the server ads. For each "item", the key
-	// is the server ad itself (either cache or origin), and the value
-	// is a slice of namespace prefixes are supported by that server
-	var best *NamespaceAd
-	for _, item := range serverAds.Items() {
-		if item == nil {
-			continue
-		}
-		serverAd := item.Key()
-		if serverAd.Type == OriginType {
-			if ns := matchesPrefix(reqPath, item.Value()); ns != nil {
-				if best == nil || len(ns.Path) > len(best.Path) {
-					best = ns
-					// If anything was previously set by a namespace that constituted a shorter
-					// prefix, we overwrite that here because we found a better ns. We also clear
-					// the other slice of server ads, because we know those aren't good anymore
-					originAds = append(originAds[:0], serverAd)
-					cacheAds = []ServerAd{}
-				} else if ns.Path == best.Path {
-					originAds = append(originAds, serverAd)
-				}
+	// The index may briefly hold ads that have expired or been deleted from
+	// the cache before the eviction callback catches up, so only consider
+	// ads that are still live
+	entries := namespaceIndex.LongestPrefix(path.Clean(reqPath), serverAds.Has)
+
+	var bestOrigin, bestCache *NamespaceAd
+	for idx := range entries {
+		entry := &entries[idx]
+		if entry.serverAd.Type == OriginType {
+			originAds = append(originAds, entry.serverAd)
+			if bestOrigin == nil {
+				bestOrigin = &entry.namespaceAd
 			}
-			continue
-		} else if serverAd.Type == CacheType {
-			if ns := matchesPrefix(reqPath, item.Value()); ns != nil {
-				if best == nil || len(ns.Path) > len(best.Path) {
-					best = ns
-					cacheAds = append(cacheAds[:0], serverAd)
-					originAds = []ServerAd{}
-				} else if ns.Path == best.Path {
-					cacheAds = append(cacheAds, serverAd)
-				}
+		} else if entry.serverAd.Type == CacheType {
+			cacheAds = append(cacheAds, entry.serverAd)
+			if bestCache == nil {
+				bestCache = &entry.namespaceAd
 			}
 		}
 	}
 
-	if best != nil {
-		originNamespace = *best
+	// Prefer the origin's view of the namespace as it's authoritative
+	if bestOrigin != nil {
+		originNamespace = *bestOrigin
+	} else if bestCache != nil {
+		originNamespace = *bestCache
 	}
 	return
 }
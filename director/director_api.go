@@ -187,9 +187,22 @@ func CreateDirectorScrapeToken() (string, error) {
 func ConfigTTLCache(ctx context.Context, egrp *errgroup.Group) {
 	// Start automatic expired item deletion
 	go serverAds.Start()
+	go serverLoads.Start()
+	go objectPresenceCache.Start()
 	go namespaceKeys.Start()
 
 	serverAds.OnEviction(func(ctx context.Context, er ttlcache.EvictionReason, i *ttlcache.Item[ServerAd, []NamespaceAd]) {
+		removeFromNamespaceIndex(i.Key())
+		clearServerStatus(i.Key())
+
+		// Only drop the ad from the persistent store when it has actually expired;
+		// the cache is emptied on shutdown and those ads must survive a restart
+		if er == ttlcache.EvictionReasonExpired {
+			if err := adStore.Delete(i.Key()); err != nil {
+				log.Warningf("Failed to remove expired server ad for %s from the ad store: %v", i.Key().Name, err)
+			}
+		}
+
 		healthTestCancelFuncsMutex.Lock()
 		defer healthTestCancelFuncsMutex.Unlock()
 		if cancelFunc, exists := healthTestCancelFuncs[i.Key()]; exists {
@@ -207,6 +220,10 @@ func ConfigTTLCache(ctx context.Context, egrp *errgroup.Group) {
 		log.Info("Gracefully stopping TTL cache eviction...")
 		serverAds.DeleteAll()
 		serverAds.Stop()
+		serverLoads.DeleteAll()
+		serverLoads.Stop()
+		objectPresenceCache.DeleteAll()
+		objectPresenceCache.Stop()
 		namespaceKeys.DeleteAll()
 		namespaceKeys.Stop()
 		return nil
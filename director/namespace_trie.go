@@ -0,0 +1,163 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"strings"
+)
+
+type (
+	// A prefix tree over namespace paths, with one node per path component.
+	// Each node holds the servers that advertised exactly that namespace, so
+	// finding the longest matching namespace for an object is a walk down the
+	// tree that costs O(path depth) instead of a scan over every ad.
+	namespaceTrie struct {
+		root *namespaceTrieNode
+		// The paths each server is currently indexed under, so that the server
+		// can be removed without walking the whole tree
+		serverPaths map[ServerAd][]string
+	}
+
+	namespaceTrieNode struct {
+		children map[string]*namespaceTrieNode
+		entries  []namespaceTrieEntry
+	}
+
+	namespaceTrieEntry struct {
+		serverAd    ServerAd
+		namespaceAd NamespaceAd
+	}
+)
+
+func newNamespaceTrie() *namespaceTrie {
+	return &namespaceTrie{
+		root:        &namespaceTrieNode{},
+		serverPaths: make(map[ServerAd][]string),
+	}
+}
+
+// Split a path into its components. Some namespaces in Topology have a
+// trailing / and some don't, so "/foo" and "/foo/" are the same node.
+func namespacePathComponents(nsPath string) []string {
+	components := make([]string, 0, strings.Count(nsPath, "/"))
+	for _, component := range strings.Split(nsPath, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// Index the namespaces of a server, replacing whatever was previously
+// indexed for it
+func (t *namespaceTrie) Insert(ad ServerAd, namespaceAds []NamespaceAd) {
+	t.Remove(ad)
+	paths := make([]string, 0, len(namespaceAds))
+	for _, nsAd := range namespaceAds {
+		node := t.root
+		for _, component := range namespacePathComponents(nsAd.Path) {
+			child, ok := node.children[component]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*namespaceTrieNode)
+				}
+				child = &namespaceTrieNode{}
+				node.children[component] = child
+			}
+			node = child
+		}
+		// A server may list the same namespace twice (e.g. with and without a
+		// trailing /); only the first one is kept, as with a linear scan
+		duplicate := false
+		for _, entry := range node.entries {
+			if entry.serverAd == ad {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		node.entries = append(node.entries, namespaceTrieEntry{serverAd: ad, namespaceAd: nsAd})
+		paths = append(paths, nsAd.Path)
+	}
+	if len(paths) > 0 {
+		t.serverPaths[ad] = paths
+	}
+}
+
+// Drop a server from the index, pruning any nodes left empty
+func (t *namespaceTrie) Remove(ad ServerAd) {
+	paths, ok := t.serverPaths[ad]
+	if !ok {
+		return
+	}
+	delete(t.serverPaths, ad)
+	for _, nsPath := range paths {
+		t.root.remove(namespacePathComponents(nsPath), ad)
+	}
+}
+
+// Remove the server's entry from the node at the end of components and
+// report whether this node is now empty and can be pruned by its parent
+func (n *namespaceTrieNode) remove(components []string, ad ServerAd) bool {
+	if len(components) == 0 {
+		for idx, entry := range n.entries {
+			if entry.serverAd == ad {
+				n.entries = append(n.entries[:idx], n.entries[idx+1:]...)
+				break
+			}
+		}
+	} else if child, ok := n.children[components[0]]; ok {
+		if child.remove(components[1:], ad) {
+			delete(n.children, components[0])
+		}
+	}
+	return len(n.entries) == 0 && len(n.children) == 0
+}
+
+// Walk the tree along reqPath and return the entries of the deepest node
+// that has at least one entry accepted by the filter
+func (t *namespaceTrie) LongestPrefix(reqPath string, live func(ServerAd) bool) []namespaceTrieEntry {
+	var best []namespaceTrieEntry
+	node := t.root
+	components := namespacePathComponents(reqPath)
+	for idx := 0; ; idx++ {
+		if len(node.entries) > 0 {
+			matches := make([]namespaceTrieEntry, 0, len(node.entries))
+			for _, entry := range node.entries {
+				if live == nil || live(entry.serverAd) {
+					matches = append(matches, entry)
+				}
+			}
+			if len(matches) > 0 {
+				best = matches
+			}
+		}
+		if idx >= len(components) {
+			break
+		}
+		child, ok := node.children[components[idx]]
+		if !ok {
+			break
+		}
+		node = child
+	}
+	return best
+}
@@ -0,0 +1,168 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceTrie(t *testing.T) {
+	origin := ServerAd{Name: "origin", Type: OriginType}
+	cache := ServerAd{Name: "cache", Type: CacheType}
+
+	trie := newNamespaceTrie()
+	trie.Insert(origin, []NamespaceAd{{Path: "/foo"}, {Path: "/foo/bar/"}})
+	trie.Insert(cache, []NamespaceAd{{Path: "/foo"}})
+
+	t.Run("longest-prefix-wins", func(t *testing.T) {
+		entries := trie.LongestPrefix("/foo/bar/baz.txt", nil)
+		require.Len(t, entries, 1)
+		assert.Equal(t, origin, entries[0].serverAd)
+		assert.Equal(t, "/foo/bar/", entries[0].namespaceAd.Path)
+
+		entries = trie.LongestPrefix("/foo/barbaz", nil)
+		assert.Len(t, entries, 2)
+
+		assert.Len(t, trie.LongestPrefix("/does/not/exist", nil), 0)
+	})
+
+	t.Run("dead-entries-are-skipped", func(t *testing.T) {
+		entries := trie.LongestPrefix("/foo/bar/baz.txt", func(ad ServerAd) bool { return ad != origin })
+		require.Len(t, entries, 1)
+		assert.Equal(t, cache, entries[0].serverAd)
+	})
+
+	t.Run("reinsert-replaces-namespaces", func(t *testing.T) {
+		trie.Insert(origin, []NamespaceAd{{Path: "/foo"}})
+		entries := trie.LongestPrefix("/foo/bar/baz.txt", nil)
+		assert.Len(t, entries, 2)
+		assert.Len(t, trie.root.children["foo"].children, 0, "Empty nodes should be pruned")
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		trie.Remove(origin)
+		trie.Remove(cache)
+		assert.Len(t, trie.LongestPrefix("/foo/bar/baz.txt", nil), 0)
+		assert.Len(t, trie.root.children, 0)
+		assert.Len(t, trie.serverPaths, 0)
+	})
+}
+
+// The linear scan that GetAdsForPath used before the namespace index,
+// kept here as a baseline for the benchmarks
+func linearMatchesPrefix(reqPath string, namespaceAds []NamespaceAd) *NamespaceAd {
+	var best *NamespaceAd
+	for _, namespace := range namespaceAds {
+		serverPath := namespace.Path
+		if serverPath == reqPath {
+			return &namespace
+		}
+		if !strings.HasSuffix(serverPath, "/") {
+			serverPath += "/"
+		}
+		var tmpBest string
+		if best != nil {
+			tmpBest = best.Path
+			if !strings.HasSuffix(tmpBest, "/") {
+				tmpBest += "/"
+			}
+		}
+		if strings.HasPrefix(reqPath, serverPath) && len(serverPath) > len(tmpBest) {
+			if best == nil {
+				best = new(NamespaceAd)
+			}
+			*best = namespace
+		}
+	}
+	return best
+}
+
+func linearGetAdsForPath(reqPath string, ads map[ServerAd][]NamespaceAd) (best *NamespaceAd, originAds []ServerAd, cacheAds []ServerAd) {
+	reqPath = path.Clean(reqPath) + "/"
+	for serverAd, namespaceAds := range ads {
+		ns := linearMatchesPrefix(reqPath, namespaceAds)
+		if ns == nil {
+			continue
+		}
+		if best == nil || len(ns.Path) > len(best.Path) {
+			best = ns
+			originAds = originAds[:0]
+			cacheAds = cacheAds[:0]
+		} else if ns.Path != best.Path {
+			continue
+		}
+		if serverAd.Type == OriginType {
+			originAds = append(originAds, serverAd)
+		} else {
+			cacheAds = append(cacheAds, serverAd)
+		}
+	}
+	return
+}
+
+// Build a federation with the given number of namespaces, each exported by
+// its own origin and all served by a handful of caches
+func mockFederation(numNamespaces int) map[ServerAd][]NamespaceAd {
+	ads := make(map[ServerAd][]NamespaceAd)
+	allNamespaces := make([]NamespaceAd, 0, numNamespaces)
+	for i := 0; i < numNamespaces; i++ {
+		nsAd := NamespaceAd{Path: fmt.Sprintf("/vo%d/project%d/data", i%50, i)}
+		allNamespaces = append(allNamespaces, nsAd)
+		ads[ServerAd{Name: fmt.Sprintf("origin%d", i), Type: OriginType}] = []NamespaceAd{nsAd}
+	}
+	for i := 0; i < 10; i++ {
+		ads[ServerAd{Name: fmt.Sprintf("cache%d", i), Type: CacheType}] = allNamespaces
+	}
+	return ads
+}
+
+func BenchmarkGetAdsForPath(b *testing.B) {
+	for _, numNamespaces := range []int{100, 1000, 5000} {
+		ads := mockFederation(numNamespaces)
+		reqPath := fmt.Sprintf("/vo%d/project%d/data/some/object.root", (numNamespaces-1)%50, numNamespaces-1)
+
+		b.Run(fmt.Sprintf("linear-%d", numNamespaces), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				best, _, _ := linearGetAdsForPath(reqPath, ads)
+				if best == nil {
+					b.Fatal("No namespace found")
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("trie-%d", numNamespaces), func(b *testing.B) {
+			trie := newNamespaceTrie()
+			for ad, namespaceAds := range ads {
+				trie.Insert(ad, namespaceAds)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(trie.LongestPrefix(reqPath, nil)) == 0 {
+					b.Fatal("No namespace found")
+				}
+			}
+		})
+	}
+}
@@ -47,6 +47,7 @@ type (
 		Namespaces         []NamespaceAd `json:"namespaces"`
 		EnableWrite        bool          `json:"enablewrite"`
 		EnableFallbackRead bool          `json:"enable-fallback-read"` // True if the origin will allow direct client reads when no caches are available
+		Load               *ServerLoad   `json:"load,omitempty"`       // Load signals used by the director to choose between caches
 	}
 )
 
@@ -131,6 +131,7 @@ func LaunchPeriodicDirectorTest(ctx context.Context, originAd ServerAd) {
 				log.Debug(fmt.Sprintf("Starting a new Director test cycle for origin: %s at %s", originName, originUrl))
 				fileTests := utils.TestFileTransferImpl{}
 				ok, err := fileTests.RunTests(ctx, originUrl, "", utils.DirectorFileTest)
+				recordDirectorTestResult(originAd, ok && err == nil)
 				if ok && err == nil {
 					log.Debugln("Director file transfer test cycle succeeded at", time.Now().Format(time.UnixDate), " for origin: ", originUrl)
 					if err := reportStatusToOrigin(ctx, originWebUrl, "ok", "Director test cycle succeeded at "+time.Now().Format(time.RFC3339)); err != nil {
@@ -73,6 +73,22 @@ func getRedirectURL(reqPath string, ad ServerAd, requiresAuth bool) (redirectURL
 	return
 }
 
+// Build a metalink-style `Link` header listing each of the servers, in order of preference
+func getLinkHeader(reqPath string, ads []ServerAd, requiresAuth bool) string {
+	linkHeader := ""
+	first := true
+	for idx, ad := range ads {
+		if first {
+			first = false
+		} else {
+			linkHeader += ", "
+		}
+		redirectURL := getRedirectURL(reqPath, ad, requiresAuth)
+		linkHeader += fmt.Sprintf(`<%s>; rel="duplicate"; pri=%d`, redirectURL.String(), idx+1)
+	}
+	return linkHeader
+}
+
 func getRealIP(ginCtx *gin.Context) (ipAddr netip.Addr, err error) {
 	ip_addr_list := ginCtx.Request.Header["X-Real-Ip"]
 	if len(ip_addr_list) == 0 {
@@ -161,6 +177,14 @@ func versionCompatCheck(ginCtx *gin.Context) error {
 	return nil
 }
 
+// Tell the client the origins in the response were ordered by which hold the
+// object, so it can't reuse the response for other objects in the namespace
+func markObjectSpecific(ginCtx *gin.Context) {
+	if param.Director_EnableStat.GetBool() {
+		ginCtx.Writer.Header().Set("X-Pelican-Object-Specific", "true")
+	}
+}
+
 func RedirectToCache(ginCtx *gin.Context) {
 	err := versionCompatCheck(ginCtx)
 	if err != nil {
@@ -187,20 +211,36 @@ func RedirectToCache(ginCtx *gin.Context) {
 		ginCtx.String(404, "No namespace found for path. Either it doesn't exist, or the Director is experiencing problems\n")
 		return
 	}
+	// With Director.EnableStat, make sure some origin actually has the object before
+	// sending the client off to a cache that would just report a miss
+	originAds, found := preferOriginsWithObject(ginCtx.Request.Context(), originAds, reqPath, namespaceAd.RequireToken, authzBearerEscaped)
+	if !found {
+		ginCtx.String(http.StatusNotFound, "Object not found at any origin exporting the namespace\n")
+		return
+	}
 	// If the namespace prefix DOES exist, then it makes sense to say we couldn't find a valid cache.
 	if len(cacheAds) == 0 {
+		// Only consider origins that allow fallback reads, so a healthy origin
+		// that doesn't can't push out an unhealthy one that does
+		fallbackAds := make([]ServerAd, 0, len(originAds))
 		for _, originAd := range originAds {
 			if originAd.EnableFallbackRead {
-				cacheAds = append(cacheAds, originAd)
-				break
+				fallbackAds = append(fallbackAds, originAd)
 			}
 		}
-		if len(cacheAds) == 0 {
+		if len(fallbackAds) == 0 {
 			ginCtx.String(http.StatusNotFound, "No cache found for path")
 			return
 		}
+		fallbackAds, err = sortOrigins(ipAddr, fallbackAds)
+		if err != nil {
+			ginCtx.String(http.StatusInternalServerError, "Failed to determine origin ordering")
+			return
+		}
+		cacheAds = append(cacheAds, fallbackAds[0])
+		markObjectSpecific(ginCtx)
 	} else {
-		cacheAds, err = SortServers(ipAddr, cacheAds)
+		cacheAds, err = sortCaches(ipAddr, cacheAds)
 		if err != nil {
 			ginCtx.String(http.StatusInternalServerError, "Failed to determine server ordering")
 			return
@@ -208,18 +248,7 @@ func RedirectToCache(ginCtx *gin.Context) {
 	}
 	redirectURL := getRedirectURL(reqPath, cacheAds[0], namespaceAd.RequireToken)
 
-	linkHeader := ""
-	first := true
-	for idx, ad := range cacheAds {
-		if first {
-			first = false
-		} else {
-			linkHeader += ", "
-		}
-		redirectURL := getRedirectURL(reqPath, ad, namespaceAd.RequireToken)
-		linkHeader += fmt.Sprintf(`<%s>; rel="duplicate"; pri=%d`, redirectURL.String(), idx+1)
-	}
-	ginCtx.Writer.Header()["Link"] = []string{linkHeader}
+	ginCtx.Writer.Header()["Link"] = []string{getLinkHeader(reqPath, cacheAds, namespaceAd.RequireToken)}
 	if namespaceAd.Issuer.Host != "" {
 		ginCtx.Writer.Header()["X-Pelican-Authorization"] = []string{"issuer=" + namespaceAd.Issuer.String()}
 
@@ -286,7 +315,23 @@ func RedirectToOrigin(ginCtx *gin.Context) {
 		return
 	}
 
-	originAds, err = SortServers(ipAddr, originAds)
+	// If we are doing a PUT, check to see if any origins are writeable. This
+	// happens before sorting so that health is only weighed among writeable origins.
+	if ginCtx.Request.Method == "PUT" {
+		writeableAds := make([]ServerAd, 0, len(originAds))
+		for _, ad := range originAds {
+			if ad.EnableWrite {
+				writeableAds = append(writeableAds, ad)
+			}
+		}
+		if len(writeableAds) == 0 {
+			ginCtx.String(http.StatusMethodNotAllowed, "No origins on specified endpoint are writeable\n")
+			return
+		}
+		originAds = writeableAds
+	}
+
+	originAds, err = sortOrigins(ipAddr, originAds)
 	if err != nil {
 		ginCtx.String(http.StatusInternalServerError, "Failed to determine origin ordering")
 		return
@@ -294,24 +339,23 @@ func RedirectToOrigin(ginCtx *gin.Context) {
 	ginCtx.Writer.Header()["X-Pelican-Namespace"] = []string{fmt.Sprintf("namespace=%s, require-token=%v, collections-url=%s",
 		namespaceAd.Path, namespaceAd.RequireToken, namespaceAd.DirlistHost)}
 
-	var redirectURL url.URL
-	// If we are doing a PUT, check to see if any origins are writeable
-	if ginCtx.Request.Method == "PUT" {
-		for idx, ad := range originAds {
-			if ad.EnableWrite {
-				redirectURL = getRedirectURL(reqPath, originAds[idx], namespaceAd.RequireToken)
-				ginCtx.Redirect(http.StatusTemporaryRedirect, getFinalRedirectURL(redirectURL, authzBearerEscaped))
-				return
-			}
+	if ginCtx.Request.Method != "PUT" {
+		var found bool
+		originAds, found = preferOriginsWithObject(ginCtx.Request.Context(), originAds, reqPath, namespaceAd.RequireToken, authzBearerEscaped)
+		if !found {
+			ginCtx.String(http.StatusNotFound, "Object not found at any origin exporting the namespace\n")
+			return
 		}
-		ginCtx.String(http.StatusMethodNotAllowed, "No origins on specified endpoint are writeable\n")
-		return
-	} else { // Otherwise, we are doing a GET
-		redirectURL := getRedirectURL(reqPath, originAds[0], namespaceAd.RequireToken)
-		// See note in RedirectToCache as to why we only add the authz query parameter to this URL,
-		// not those in the `Link`.
-		ginCtx.Redirect(http.StatusTemporaryRedirect, getFinalRedirectURL(redirectURL, authzBearerEscaped))
+		markObjectSpecific(ginCtx)
 	}
+
+	// As with caches, the remaining origins are offered as alternatives so clients
+	// can fail over to a replica if the first origin doesn't work out
+	ginCtx.Writer.Header()["Link"] = []string{getLinkHeader(reqPath, originAds, namespaceAd.RequireToken)}
+	redirectURL := getRedirectURL(reqPath, originAds[0], namespaceAd.RequireToken)
+	// See note in RedirectToCache as to why we only add the authz query parameter to this URL,
+	// not those in the `Link`.
+	ginCtx.Redirect(http.StatusTemporaryRedirect, getFinalRedirectURL(redirectURL, authzBearerEscaped))
 }
 
 func checkHostnameRedirects(c *gin.Context, incomingHost string) {
@@ -480,8 +524,14 @@ func registerServeAd(engineCtx context.Context, ctx *gin.Context, sType ServerTy
 		EnableFallbackRead: ad.EnableFallbackRead,
 	}
 
+	// Look up the coordinates up front so that sAd matches the key RecordAd
+	// uses in serverAds; the load and health test are tracked by that key
+	if err := UpdateLatLong(&sAd); err != nil {
+		log.Debugln("Failed to lookup GeoIP coordinates for host", sAd.URL.Host)
+	}
 	hasOriginAdInCache := serverAds.Has(sAd)
-	RecordAd(sAd, &ad.Namespaces)
+	recordAdWithLocation(sAd, &ad.Namespaces)
+	recordServerLoad(sAd, ad.Load)
 
 	// Start director periodic test of origin's health status if origin AD
 	// has WebURL field AND it's not already been registered
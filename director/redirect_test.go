@@ -711,3 +711,103 @@ func TestRedirects(t *testing.T) {
 		viper.Reset()
 	})
 }
+
+func TestRedirectToOriginFailover(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		serverAdMutex.Lock()
+		defer serverAdMutex.Unlock()
+		serverAds.DeleteAll()
+		namespaceIndex = newNamespaceTrie()
+		directorTestRecordsMutex.Lock()
+		defer directorTestRecordsMutex.Unlock()
+		directorTestRecords = make(map[ServerAd]DirectorTestRecord)
+	})
+
+	healthyOrigin := ServerAd{Name: "healthy", URL: url.URL{Scheme: "https", Host: "healthy.example.com:8443"}, Type: OriginType, EnableWrite: true}
+	failingOrigin := ServerAd{Name: "failing", URL: url.URL{Scheme: "https", Host: "failing.example.com:8443"}, Type: OriginType, EnableWrite: true}
+	readOnlyOrigin := ServerAd{Name: "read-only", URL: url.URL{Scheme: "https", Host: "read-only.example.com:8443"}, Type: OriginType}
+	namespaceAd := NamespaceAd{Path: "/foo"}
+
+	func() {
+		serverAdMutex.Lock()
+		defer serverAdMutex.Unlock()
+		for _, ad := range []ServerAd{healthyOrigin, failingOrigin, readOnlyOrigin} {
+			setServerAd(ad, []NamespaceAd{namespaceAd}, ttlcache.DefaultTTL)
+		}
+	}()
+	recordDirectorTestResult(healthyOrigin, true)
+	recordDirectorTestResult(failingOrigin, false)
+
+	doRequest := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/api/v1.0/director/origin/foo/bar", nil)
+		c.Request.Header.Set("User-Agent", "pelican-client/7.0.0")
+		c.Request.RemoteAddr = "0.0.0.0:12345"
+		RedirectToOrigin(c)
+		// Bodiless responses (e.g. redirects for a PUT) are only flushed by gin on completion
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+
+	t.Run("get-skips-failing-origins", func(t *testing.T) {
+		w := doRequest(http.MethodGet)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		location := w.Header().Get("Location")
+		assert.NotContains(t, location, "failing.example.com")
+
+		link := w.Header().Get("Link")
+		assert.Contains(t, link, `<http://healthy.example.com:8443/foo/bar>; rel="duplicate"`)
+		assert.Contains(t, link, `<http://read-only.example.com:8443/foo/bar>; rel="duplicate"`)
+		assert.NotContains(t, link, "failing.example.com")
+		assert.Empty(t, w.Header().Get("X-Pelican-Object-Specific"))
+	})
+
+	t.Run("stat-ordering-is-object-specific", func(t *testing.T) {
+		viper.Set("Director.EnableStat", true)
+		viper.Set("Director.StatTimeout", "100ms")
+		defer viper.Set("Director.EnableStat", false)
+		t.Cleanup(objectPresenceCache.DeleteAll)
+
+		w := doRequest(http.MethodGet)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "true", w.Header().Get("X-Pelican-Object-Specific"))
+		w = doRequest(http.MethodPut)
+		assert.Empty(t, w.Header().Get("X-Pelican-Object-Specific"))
+	})
+
+	t.Run("put-only-offers-writeable-origins", func(t *testing.T) {
+		w := doRequest(http.MethodPut)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "http://healthy.example.com:8443/foo/bar", w.Header().Get("Location"))
+		assert.Equal(t, `<http://healthy.example.com:8443/foo/bar>; rel="duplicate"; pri=1`, w.Header().Get("Link"))
+	})
+
+	t.Run("all-origins-failing", func(t *testing.T) {
+		recordDirectorTestResult(healthyOrigin, false)
+		recordDirectorTestResult(readOnlyOrigin, false)
+		recordDirectorTestResult(readOnlyOrigin, false)
+		recordDirectorTestResult(failingOrigin, false)
+		recordDirectorTestResult(failingOrigin, false)
+		recordDirectorTestResult(failingOrigin, false)
+
+		w := doRequest(http.MethodGet)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		// Rather than giving up, the origin with the fewest failures is tried first
+		assert.Equal(t, "http://healthy.example.com:8443/foo/bar", w.Header().Get("Location"))
+		assert.Contains(t, w.Header().Get("Link"), "failing.example.com")
+	})
+
+	t.Run("put-to-failing-writeable-origins", func(t *testing.T) {
+		// A healthy read-only origin mustn't crowd out the failing writeable ones
+		recordDirectorTestResult(readOnlyOrigin, true)
+
+		w := doRequest(http.MethodPut)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "http://healthy.example.com:8443/foo/bar", w.Header().Get("Location"))
+		assert.NotContains(t, w.Header().Get("Link"), "read-only.example.com")
+	})
+}
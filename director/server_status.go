@@ -0,0 +1,106 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+type (
+	// Load signals a server reports with its advertisement. Zero values mean
+	// the server didn't know (or didn't report) that particular signal.
+	ServerLoad struct {
+		ActiveConnections int64   `json:"activeConnections"`
+		BytesPerSecond    float64 `json:"bytesPerSecond"`
+		FreeDiskBytes     uint64  `json:"freeDiskBytes"`
+		TotalDiskBytes    uint64  `json:"totalDiskBytes"`
+	}
+
+	// The outcome of the director's periodic file transfer tests against a server
+	DirectorTestRecord struct {
+		LastStatus          string    `json:"lastStatus"` // "ok" or "error", matching what we report to the origin
+		LastTested          time.Time `json:"lastTested"`
+		ConsecutiveFailures int       `json:"consecutiveFailures"`
+	}
+)
+
+var (
+	// The load reported with each server's most recent advertisement. Ads are
+	// the key of serverAds, so the (ever-changing) load can't live in the ad itself.
+	serverLoads = ttlcache.New[ServerAd, ServerLoad](ttlcache.WithTTL[ServerAd, ServerLoad](15 * time.Minute))
+
+	directorTestRecords      = make(map[ServerAd]DirectorTestRecord)
+	directorTestRecordsMutex = sync.RWMutex{}
+)
+
+func recordServerLoad(ad ServerAd, load *ServerLoad) {
+	if load == nil {
+		serverLoads.Delete(ad)
+		return
+	}
+	serverLoads.Set(ad, *load, ttlcache.DefaultTTL)
+}
+
+// Get the most recently reported load for the server, if any
+func getServerLoad(ad ServerAd) (load ServerLoad, ok bool) {
+	item := serverLoads.Get(ad, ttlcache.WithDisableTouchOnHit[ServerAd, ServerLoad]())
+	if item == nil {
+		return
+	}
+	return item.Value(), true
+}
+
+func recordDirectorTestResult(ad ServerAd, succeeded bool) {
+	directorTestRecordsMutex.Lock()
+	defer directorTestRecordsMutex.Unlock()
+	record := directorTestRecords[ad]
+	record.LastTested = time.Now()
+	if succeeded {
+		record.LastStatus = "ok"
+		record.ConsecutiveFailures = 0
+	} else {
+		record.LastStatus = "error"
+		record.ConsecutiveFailures++
+	}
+	directorTestRecords[ad] = record
+}
+
+// Get the result of the director tests against the server. Servers that were
+// never tested (e.g. those from topology) return ok == false.
+func getDirectorTestRecord(ad ServerAd) (record DirectorTestRecord, ok bool) {
+	directorTestRecordsMutex.RLock()
+	defer directorTestRecordsMutex.RUnlock()
+	record, ok = directorTestRecords[ad]
+	return
+}
+
+// Forget everything we know about the status of an evicted server, unless
+// it has been re-advertised in the meantime
+func clearServerStatus(ad ServerAd) {
+	if serverAds.Has(ad) {
+		return
+	}
+	serverLoads.Delete(ad)
+	directorTestRecordsMutex.Lock()
+	defer directorTestRecordsMutex.Unlock()
+	delete(directorTestRecords, ad)
+}
@@ -0,0 +1,239 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"math"
+	"math/rand"
+	"net/netip"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// A ServerSorter orders a set of servers from most to least preferred
+	// for a client at the given address
+	ServerSorter interface {
+		Sort(addr netip.Addr, ads []ServerAd) ([]ServerAd, error)
+	}
+
+	CacheSortMethod string
+
+	distanceSorter struct{}
+	randomSorter   struct{}
+	loadSorter     struct{}
+	adaptiveSorter struct{}
+)
+
+const (
+	DistanceSort CacheSortMethod = "distance"
+	RandomSort   CacheSortMethod = "random"
+	AdaptiveSort CacheSortMethod = "adaptive"
+	LoadSort     CacheSortMethod = "load"
+)
+
+// Relative weights of the signals the adaptive sort considers. Director
+// tests only run against origins (see LaunchPeriodicDirectorTest), so there
+// are no test results to weigh for caches.
+const (
+	adaptiveDistanceWeight = 0.6
+	adaptiveLoadWeight     = 0.4
+
+	// After this many consecutive director test failures an origin gets the
+	// full health penalty
+	maxPenalizedFailures = 5
+)
+
+func (distanceSorter) Sort(addr netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	return SortServers(addr, ads)
+}
+
+func (randomSorter) Sort(_ netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	resultAds := make([]ServerAd, len(ads))
+	copy(resultAds, ads)
+	rand.Shuffle(len(resultAds), func(i, j int) {
+		resultAds[i], resultAds[j] = resultAds[j], resultAds[i]
+	})
+	return resultAds, nil
+}
+
+// Sort by load, using distance only to break ties between equally-loaded servers
+func (loadSorter) Sort(addr netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	loads := loadScores(ads)
+	distances := distanceScores(addr, ads)
+	scores := make(SwapMaps, len(ads))
+	for idx := range ads {
+		// Distance scores are < 2, so scaling the load keeps it dominant
+		scores[idx] = SwapMap{loads[idx]*10 + distances[idx], idx}
+	}
+	return applyOrdering(ads, scores), nil
+}
+
+// Weigh distance and load together
+func (adaptiveSorter) Sort(addr netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	loads := loadScores(ads)
+	distances := distanceScores(addr, ads)
+	scores := make(SwapMaps, len(ads))
+	for idx := range ads {
+		scores[idx] = SwapMap{adaptiveDistanceWeight*distances[idx] + adaptiveLoadWeight*loads[idx], idx}
+	}
+	return applyOrdering(ads, scores), nil
+}
+
+func applyOrdering(ads []ServerAd, scores SwapMaps) []ServerAd {
+	sort.Stable(scores)
+	resultAds := make([]ServerAd, len(ads))
+	for idx, score := range scores {
+		resultAds[idx] = ads[score.Index]
+	}
+	return resultAds
+}
+
+// Normalized distance from the client to each server, between 0 (same place)
+// and 1 (antipodal). Servers we can't locate get a random score between 1 and
+// 2 so they sort after every server we can.
+func distanceScores(addr netip.Addr, ads []ServerAd) []float64 {
+	scores := make([]float64, len(ads))
+	lat, long, err := GetLatLong(addr)
+	isInvalid := (err != nil || (lat == 0 && long == 0))
+	for idx, ad := range ads {
+		if isInvalid || (ad.Latitude == 0 && ad.Longitude == 0) {
+			scores[idx] = 1 + rand.Float64()
+		} else {
+			scores[idx] = distanceOnSphere(lat, long, ad.Latitude, ad.Longitude) / math.Pi
+		}
+	}
+	return scores
+}
+
+// Relative load of each server in the set, between 0 (idle) and 1 (the
+// busiest of the set on every signal). Connections and throughput are
+// normalized against the busiest server in the set; disk is the fraction used.
+// Servers that didn't report a load are assumed to be middling.
+func loadScores(ads []ServerAd) []float64 {
+	loads := make([]*ServerLoad, len(ads))
+	var maxConns int64
+	var maxRate float64
+	for idx, ad := range ads {
+		if load, ok := getServerLoad(ad); ok {
+			loads[idx] = &load
+			if load.ActiveConnections > maxConns {
+				maxConns = load.ActiveConnections
+			}
+			if load.BytesPerSecond > maxRate {
+				maxRate = load.BytesPerSecond
+			}
+		}
+	}
+
+	scores := make([]float64, len(ads))
+	for idx, load := range loads {
+		if load == nil {
+			scores[idx] = 0.5
+			continue
+		}
+		total := 0.0
+		signals := 0
+		if maxConns > 0 {
+			total += float64(load.ActiveConnections) / float64(maxConns)
+			signals++
+		}
+		if maxRate > 0 {
+			total += load.BytesPerSecond / maxRate
+			signals++
+		}
+		if load.TotalDiskBytes > 0 {
+			total += 1 - float64(load.FreeDiskBytes)/float64(load.TotalDiskBytes)
+			signals++
+		}
+		if signals == 0 {
+			scores[idx] = 0.5
+		} else {
+			scores[idx] = total / float64(signals)
+		}
+	}
+	return scores
+}
+
+// Penalty between 0 and 1 for origins that have recently failed director tests
+func healthScore(ad ServerAd) float64 {
+	record, ok := getDirectorTestRecord(ad)
+	if !ok {
+		return 0
+	}
+	failures := record.ConsecutiveFailures
+	if failures > maxPenalizedFailures {
+		failures = maxPenalizedFailures
+	}
+	return float64(failures) / maxPenalizedFailures
+}
+
+func GetServerSorter(method CacheSortMethod) (ServerSorter, error) {
+	switch method {
+	case DistanceSort, "":
+		return distanceSorter{}, nil
+	case RandomSort:
+		return randomSorter{}, nil
+	case LoadSort:
+		return loadSorter{}, nil
+	case AdaptiveSort:
+		return adaptiveSorter{}, nil
+	default:
+		return nil, errors.Errorf("Unknown cache sort method %q; must be one of %q, %q, %q or %q",
+			method, DistanceSort, RandomSort, AdaptiveSort, LoadSort)
+	}
+}
+
+// Order origins for a client by distance, skipping those whose most recent
+// director test failed. If every origin is failing, they're all returned with
+// the least-troubled first; a flaky test shouldn't take a namespace offline.
+func sortOrigins(addr netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	sorted, err := SortServers(addr, ads)
+	if err != nil {
+		return nil, err
+	}
+	healthy := make([]ServerAd, 0, len(sorted))
+	for _, ad := range sorted {
+		if record, ok := getDirectorTestRecord(ad); ok && record.LastStatus == "error" {
+			log.Debugf("Skipping origin %s, which failed its last director test", ad.Name)
+			continue
+		}
+		healthy = append(healthy, ad)
+	}
+	if len(healthy) > 0 {
+		return healthy, nil
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return healthScore(sorted[i]) < healthScore(sorted[j])
+	})
+	return sorted, nil
+}
+
+// Order caches for a client according to Director.CacheSortMethod
+func sortCaches(addr netip.Addr, ads []ServerAd) ([]ServerAd, error) {
+	sorter, err := GetServerSorter(CacheSortMethod(param.Director_CacheSortMethod.GetString()))
+	if err != nil {
+		return nil, err
+	}
+	return sorter.Sort(addr, ads)
+}
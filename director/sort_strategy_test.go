@@ -0,0 +1,122 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerSorters(t *testing.T) {
+	busyCache := ServerAd{Name: "busy-cache", Type: CacheType}
+	idleCache := ServerAd{Name: "idle-cache", Type: CacheType}
+	silentCache := ServerAd{Name: "silent-cache", Type: CacheType}
+	ads := []ServerAd{busyCache, silentCache, idleCache}
+	// The null address can't be located, so distance won't influence the order
+	addr := netip.MustParseAddr("0.0.0.0")
+
+	recordServerLoad(busyCache, &ServerLoad{ActiveConnections: 500, BytesPerSecond: 1e9, FreeDiskBytes: 10, TotalDiskBytes: 100})
+	recordServerLoad(idleCache, &ServerLoad{ActiveConnections: 5, BytesPerSecond: 1e6, FreeDiskBytes: 90, TotalDiskBytes: 100})
+	t.Cleanup(func() {
+		serverLoads.DeleteAll()
+		directorTestRecordsMutex.Lock()
+		defer directorTestRecordsMutex.Unlock()
+		directorTestRecords = make(map[ServerAd]DirectorTestRecord)
+	})
+
+	t.Run("unknown-method", func(t *testing.T) {
+		_, err := GetServerSorter("nearest-please")
+		assert.Error(t, err)
+	})
+
+	t.Run("random-is-a-permutation", func(t *testing.T) {
+		sorter, err := GetServerSorter(RandomSort)
+		require.NoError(t, err)
+		sorted, err := sorter.Sort(addr, ads)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, ads, sorted)
+	})
+
+	t.Run("load-prefers-idle-caches", func(t *testing.T) {
+		sorter, err := GetServerSorter(LoadSort)
+		require.NoError(t, err)
+		sorted, err := sorter.Sort(addr, ads)
+		require.NoError(t, err)
+		// Caches that didn't report a load land in the middle
+		assert.Equal(t, []ServerAd{idleCache, silentCache, busyCache}, sorted)
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		// Pin the client to Madison, WI so distances are deterministic
+		oldOverrides := geoIPOverrides
+		geoIPOverrides = []GeoIPOverride{{IP: "192.168.0.1", Coordinate: Coordinate{Lat: 43.07, Long: -89.4}}}
+		t.Cleanup(func() { geoIPOverrides = oldOverrides })
+		client := netip.MustParseAddr("192.168.0.1")
+
+		nearCache := ServerAd{Name: "near-cache", Type: CacheType, Latitude: 43.07, Longitude: -89.4}
+		midCache := ServerAd{Name: "mid-cache", Type: CacheType, Latitude: 41.88, Longitude: -87.63}
+		farCache := ServerAd{Name: "far-cache", Type: CacheType, Latitude: -31.95, Longitude: 115.86}
+		geoAds := []ServerAd{farCache, midCache, nearCache}
+
+		sorter, err := GetServerSorter(AdaptiveSort)
+		require.NoError(t, err)
+
+		// With no load or health signals, the adaptive sort falls back to distance
+		sorted, err := sorter.Sort(client, geoAds)
+		require.NoError(t, err)
+		assert.Equal(t, []ServerAd{nearCache, midCache, farCache}, sorted)
+
+		// A busy cache loses to a slightly farther idle one, but a cache on the
+		// other side of the world still isn't preferred
+		recordServerLoad(nearCache, &ServerLoad{ActiveConnections: 500, BytesPerSecond: 1e9, FreeDiskBytes: 10, TotalDiskBytes: 100})
+		recordServerLoad(midCache, &ServerLoad{ActiveConnections: 5, BytesPerSecond: 1e6, FreeDiskBytes: 90, TotalDiskBytes: 100})
+		sorted, err = sorter.Sort(client, geoAds)
+		require.NoError(t, err)
+		assert.Equal(t, []ServerAd{midCache, nearCache, farCache}, sorted)
+
+		// Once the near cache quiets down, it's preferred again
+		recordServerLoad(nearCache, &ServerLoad{ActiveConnections: 1, BytesPerSecond: 1e5, FreeDiskBytes: 90, TotalDiskBytes: 100})
+		sorted, err = sorter.Sort(client, geoAds)
+		require.NoError(t, err)
+		assert.Equal(t, []ServerAd{nearCache, midCache, farCache}, sorted)
+	})
+
+	t.Run("director-test-failures-are-penalized", func(t *testing.T) {
+		origin := ServerAd{Name: "origin", Type: OriginType}
+		assert.Equal(t, 0.0, healthScore(origin), "Untested servers shouldn't be penalized")
+
+		for i := 0; i < maxPenalizedFailures+2; i++ {
+			recordDirectorTestResult(origin, false)
+		}
+		record, ok := getDirectorTestRecord(origin)
+		require.True(t, ok)
+		assert.Equal(t, "error", record.LastStatus)
+		assert.Equal(t, maxPenalizedFailures+2, record.ConsecutiveFailures)
+		assert.Equal(t, 1.0, healthScore(origin))
+
+		recordDirectorTestResult(origin, true)
+		record, _ = getDirectorTestRecord(origin)
+		assert.Equal(t, "ok", record.LastStatus)
+		assert.Equal(t, 0, record.ConsecutiveFailures)
+		assert.Equal(t, 0.0, healthScore(origin))
+	})
+}
@@ -0,0 +1,170 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/jellydator/ttlcache/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	// Whether an origin holds an object, as far as the director can tell
+	objectPresence int
+
+	objectPresenceKey struct {
+		originURL  string
+		objectPath string
+		// Hash of the caller's token for namespaces that require one, so an
+		// answer obtained with one caller's credentials isn't given to another
+		authzHash string
+	}
+)
+
+const (
+	// The origin didn't give a definitive answer (auth failure, timeout, ...)
+	presenceUnknown objectPresence = iota
+	presenceFound
+	presenceNotFound
+)
+
+var (
+	// Definitive answers from origins about whether they hold an object.
+	// The TTL is set per-item from Director.StatCacheTTL.
+	objectPresenceCache = ttlcache.New[objectPresenceKey, objectPresence]()
+)
+
+// Ask a single origin whether it holds the object at objectPath. A HEAD is
+// tried first; origins that don't support it are asked with a PROPFIND.
+func statOrigin(ctx context.Context, originAd ServerAd, objectPath string, requiresAuth bool, token string) objectPresence {
+	statURL := getRedirectURL(objectPath, originAd, requiresAuth)
+	key := objectPresenceKey{originURL: statURL.Host, objectPath: statURL.Path}
+	if requiresAuth {
+		if token == "" {
+			// The origin will refuse an anonymous stat; don't bother asking
+			return presenceUnknown
+		}
+		tokenHash := sha256.Sum256([]byte(token))
+		key.authzHash = hex.EncodeToString(tokenHash[:])
+	}
+	if item := objectPresenceCache.Get(key); item != nil {
+		return item.Value()
+	}
+
+	client := http.Client{Transport: config.GetTransport()}
+	presence := presenceUnknown
+	for _, method := range []string{http.MethodHead, "PROPFIND"} {
+		req, err := http.NewRequestWithContext(ctx, method, statURL.String(), nil)
+		if err != nil {
+			log.Debugf("Failed to create %s request to stat %s: %v", method, statURL.String(), err)
+			return presenceUnknown
+		}
+		if method == "PROPFIND" {
+			req.Header.Set("Depth", "0")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("User-Agent", "pelican-director/"+config.PelicanVersion)
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Debugf("Failed to stat %s at origin %s: %v", objectPath, originAd.Name, err)
+			return presenceUnknown
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			continue
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			presence = presenceNotFound
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			presence = presenceFound
+		default:
+			// Don't cache anything that might depend on the client's credentials
+			log.Debugf("Origin %s responded %d to a stat of %s", originAd.Name, resp.StatusCode, objectPath)
+			return presenceUnknown
+		}
+		break
+	}
+
+	if presence != presenceUnknown {
+		objectPresenceCache.Set(key, presence, param.Director_StatCacheTTL.GetDuration())
+	}
+	return presence
+}
+
+// Stat the object at all the origins concurrently and split them by the
+// answer. Origins that didn't give a definitive answer are treated as possibly
+// holding the object, so a misbehaving origin can't turn into a spurious 404.
+func statOrigins(ctx context.Context, originAds []ServerAd, objectPath string, requiresAuth bool, token string) (found []ServerAd, unknown []ServerAd, notFound []ServerAd) {
+	ctx, cancel := context.WithTimeout(ctx, param.Director_StatTimeout.GetDuration())
+	defer cancel()
+
+	results := make([]objectPresence, len(originAds))
+	wg := sync.WaitGroup{}
+	for idx, originAd := range originAds {
+		wg.Add(1)
+		go func(idx int, originAd ServerAd) {
+			defer wg.Done()
+			results[idx] = statOrigin(ctx, originAd, objectPath, requiresAuth, token)
+		}(idx, originAd)
+	}
+	wg.Wait()
+
+	for idx, originAd := range originAds {
+		switch results[idx] {
+		case presenceFound:
+			found = append(found, originAd)
+		case presenceNotFound:
+			notFound = append(notFound, originAd)
+		default:
+			unknown = append(unknown, originAd)
+		}
+	}
+	return
+}
+
+// Reorder the (already sorted) origins so that those known to hold the object
+// come first and those known not to are dropped. Returns false if every
+// origin reported that the object doesn't exist.
+func preferOriginsWithObject(ctx context.Context, originAds []ServerAd, objectPath string, requiresAuth bool, authzEscaped string) ([]ServerAd, bool) {
+	if !param.Director_EnableStat.GetBool() || len(originAds) == 0 {
+		return originAds, true
+	}
+	token, err := url.QueryUnescape(authzEscaped)
+	if err != nil {
+		token = ""
+	}
+	found, unknown, _ := statOrigins(ctx, originAds, objectPath, requiresAuth, token)
+	if len(found) == 0 && len(unknown) == 0 {
+		return nil, false
+	}
+	return append(found, unknown...), true
+}
@@ -0,0 +1,125 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package director
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Start an origin that holds only the objects in `objects`. If headAllowed is
+// false, it only answers PROPFIND.
+func startStatOrigin(t *testing.T, name string, headAllowed bool, objects ...string) (ServerAd, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method == http.MethodHead && !headAllowed {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Method == "PROPFIND" {
+			assert.Equal(t, "0", r.Header.Get("Depth"))
+		}
+		for _, obj := range objects {
+			if r.URL.Path == obj {
+				if r.Method == "PROPFIND" {
+					w.WriteHeader(http.StatusMultiStatus)
+				}
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return ServerAd{Name: name, URL: *srvURL, Type: OriginType}, requests
+}
+
+func TestPreferOriginsWithObject(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		objectPresenceCache.DeleteAll()
+	})
+	viper.Set("Director.StatTimeout", "2s")
+	viper.Set("Director.StatCacheTTL", "1m")
+
+	hasObject, hasObjectRequests := startStatOrigin(t, "has-object", true, "/foo/bar")
+	noObject, _ := startStatOrigin(t, "no-object", true)
+	propfindOnly, _ := startStatOrigin(t, "propfind-only", false, "/foo/bar")
+	unreachable := ServerAd{Name: "unreachable", URL: url.URL{Host: "127.0.0.1:1"}, Type: OriginType}
+	ctx := context.Background()
+
+	t.Run("disabled-is-a-no-op", func(t *testing.T) {
+		ads := []ServerAd{noObject, hasObject}
+		sorted, found := preferOriginsWithObject(ctx, ads, "/foo/bar", false, "")
+		assert.True(t, found)
+		assert.Equal(t, ads, sorted)
+		assert.Equal(t, int32(0), hasObjectRequests.Load())
+	})
+
+	viper.Set("Director.EnableStat", true)
+
+	t.Run("origins-with-object-first", func(t *testing.T) {
+		sorted, found := preferOriginsWithObject(ctx, []ServerAd{unreachable, noObject, hasObject}, "/foo/bar", false, "")
+		assert.True(t, found)
+		// Unreachable origins might still hold the object
+		assert.Equal(t, []ServerAd{hasObject, unreachable}, sorted)
+	})
+
+	t.Run("propfind-fallback", func(t *testing.T) {
+		assert.Equal(t, presenceFound, statOrigin(ctx, propfindOnly, "/foo/bar", false, ""))
+		assert.Equal(t, presenceNotFound, statOrigin(ctx, propfindOnly, "/foo/baz", false, ""))
+	})
+
+	t.Run("missing-everywhere", func(t *testing.T) {
+		_, found := preferOriginsWithObject(ctx, []ServerAd{noObject, hasObject}, "/foo/missing", false, "")
+		assert.False(t, found)
+	})
+
+	t.Run("results-are-cached", func(t *testing.T) {
+		before := hasObjectRequests.Load()
+		assert.Equal(t, presenceFound, statOrigin(ctx, hasObject, "/foo/bar", false, ""))
+		assert.Equal(t, before, hasObjectRequests.Load())
+	})
+
+	t.Run("authorized-results-are-per-token", func(t *testing.T) {
+		authOrigin := ServerAd{Name: "auth-origin", AuthURL: url.URL{Host: "127.0.0.1:1"}, Type: OriginType}
+		tokenHash := sha256.Sum256([]byte("token-a"))
+		key := objectPresenceKey{originURL: "127.0.0.1:1", objectPath: "/foo/secret", authzHash: hex.EncodeToString(tokenHash[:])}
+		objectPresenceCache.Set(key, presenceFound, time.Minute)
+
+		assert.Equal(t, presenceFound, statOrigin(ctx, authOrigin, "/foo/secret", true, "token-a"))
+		// Neither another caller nor an anonymous one gets token-a's answer
+		assert.Equal(t, presenceUnknown, statOrigin(ctx, authOrigin, "/foo/secret", true, "token-b"))
+		assert.Equal(t, presenceUnknown, statOrigin(ctx, authOrigin, "/foo/secret", true, ""))
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/pelicanplatform/pelican/director"
 	"github.com/pelicanplatform/pelican/metrics"
 	"github.com/pelicanplatform/pelican/param"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
@@ -36,6 +37,11 @@ func DirectorServe(ctx context.Context, engine *gin.Engine, egrp *errgroup.Group
 	log.Info("Initializing Director GeoIP database...")
 	director.InitializeDB(ctx)
 
+	log.Info("Initializing Director ad store...")
+	if err := director.InitializeAdStore(ctx); err != nil {
+		return err
+	}
+
 	if config.GetPreferredPrefix() == "OSDF" {
 		metrics.SetComponentHealthStatus(metrics.DirectorRegistry_Topology, metrics.StatusWarning, "Start requesting from topology, status unknown")
 		log.Info("Generating/advertising server ads from OSG topology service...")
@@ -58,6 +64,11 @@ func DirectorServe(ctx context.Context, engine *gin.Engine, egrp *errgroup.Group
 			" but you provided %q. Was there a typo?", defaultResponse)
 	}
 	log.Debugf("The director will redirect to %ss by default", defaultResponse)
+	cacheSortMethod := param.Director_CacheSortMethod.GetString()
+	if _, err := director.GetServerSorter(director.CacheSortMethod(cacheSortMethod)); err != nil {
+		return errors.Wrap(err, "Invalid Director.CacheSortMethod")
+	}
+	log.Debugf("The director will sort caches by %s", cacheSortMethod)
 	rootGroup := engine.Group("/")
 	director.RegisterDirectorAuth(rootGroup)
 	director.RegisterDirectorWebAPI(rootGroup)
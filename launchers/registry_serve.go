@@ -53,6 +53,18 @@ func RegistryServe(ctx context.Context, engine *gin.Engine, egrp *errgroup.Group
 		go registry.PeriodicTopologyReload()
 	}
 
+	// Retires the keys of namespaces whose rotation overlap has ended
+	egrp.Go(func() error {
+		registry.PeriodicKeyRetirement(ctx)
+		return nil
+	})
+
+	// Removes namespace registrations left pending past Registry.PendingExpiration
+	egrp.Go(func() error {
+		registry.PeriodicPendingExpiration(ctx)
+		return nil
+	})
+
 	if param.Server_EnableUI.GetBool() {
 		if err := web_ui.ConfigOAuthClientAPIs(engine); err != nil {
 			return err
@@ -29,6 +29,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
@@ -191,6 +192,7 @@ type (
 	SummaryStat struct {
 		Id      SummaryStatType    `xml:"id,attr"`
 		Total   int                `xml:"tot"`
+		Current int                `xml:"num"`
 		In      int                `xml:"in"`
 		Out     int                `xml:"out"`
 		Threads int                `xml:"threads"`
@@ -200,6 +202,15 @@ type (
 		Memory  SummaryCacheMemory `xml:"mem"`
 	}
 
+	// A point-in-time view of the server's connections and traffic, taken
+	// from the most recent summary packet
+	LinkSnapshot struct {
+		Connections int
+		BytesIn     int
+		BytesOut    int
+		Timestamp   time.Time
+	}
+
 	SummaryStatistics struct {
 		Version string        `xml:"ver,attr"`
 		Program string        `xml:"pgm,attr"`
@@ -256,6 +267,11 @@ var (
 		Help: "Aggregate number of server connections",
 	})
 
+	CurrentConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "xrootd_server_current_connection_count",
+		Help: "Number of connections currently open to the server",
+	})
+
 	BytesXfer = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "xrootd_server_bytes",
 		Help: "Number of bytes read into the server",
@@ -268,6 +284,8 @@ var (
 
 	lastStats SummaryStat
 
+	lastLinkSnapshot atomic.Pointer[LinkSnapshot]
+
 	// Maps the connection identifier with a user record
 	sessions = ttlcache.New[UserId, UserRecord](ttlcache.WithTTL[UserId, UserRecord](24 * time.Hour))
 	// Maps a userid to a connection identifier.  NOTE: due to https://github.com/xrootd/xrootd/issues/2133,
@@ -960,6 +978,14 @@ func HandleSummaryPacket(packet []byte) error {
 			}
 			BytesXfer.With(prometheus.Labels{"direction": "tx"}).Add(incBy)
 			lastStats.Out = stat.Out
+
+			CurrentConnections.Set(float64(stat.Current))
+			lastLinkSnapshot.Store(&LinkSnapshot{
+				Connections: stat.Current,
+				BytesIn:     stat.In,
+				BytesOut:    stat.Out,
+				Timestamp:   time.Now(),
+			})
 		case SchedStat:
 			Threads.With(prometheus.Labels{"state": "idle"}).Set(float64(stat.Idle))
 			Threads.With(prometheus.Labels{"state": "running"}).Set(float64(stat.Threads -
@@ -983,3 +1009,13 @@ func HandleSummaryPacket(packet []byte) error {
 	}
 	return nil
 }
+
+// Get the link statistics from the most recent summary packet, if one
+// has been received
+func GetLinkSnapshot() (LinkSnapshot, bool) {
+	snapshot := lastLinkSnapshot.Load()
+	if snapshot == nil {
+		return LinkSnapshot{}, false
+	}
+	return *snapshot, true
+}
@@ -60,6 +60,10 @@ var (
 	Cache_DataLocation = StringParam{"Cache.DataLocation"}
 	Cache_ExportLocation = StringParam{"Cache.ExportLocation"}
 	Cache_XRootDPrefix = StringParam{"Cache.XRootDPrefix"}
+	Client_LocalCacheLocation = StringParam{"Client.LocalCacheLocation"}
+	Director_AdStore = StringParam{"Director.AdStore"}
+	Director_CacheSortMethod = StringParam{"Director.CacheSortMethod"}
+	Director_DbLocation = StringParam{"Director.DbLocation"}
 	Director_DefaultResponse = StringParam{"Director.DefaultResponse"}
 	Director_GeoIPLocation = StringParam{"Director.GeoIPLocation"}
 	Director_MaxMindKeyFile = StringParam{"Director.MaxMindKeyFile"}
@@ -105,6 +109,11 @@ var (
 	Origin_XRootDPrefix = StringParam{"Origin.XRootDPrefix"}
 	Plugin_Token = StringParam{"Plugin.Token"}
 	Registry_DbLocation = StringParam{"Registry.DbLocation"}
+	Registry_NotificationSender = StringParam{"Registry.NotificationSender"}
+	Registry_NotificationWebhookUrl = StringParam{"Registry.NotificationWebhookUrl"}
+	Registry_SMTPPasswordFile = StringParam{"Registry.SMTPPasswordFile"}
+	Registry_SMTPServer = StringParam{"Registry.SMTPServer"}
+	Registry_SMTPUsername = StringParam{"Registry.SMTPUsername"}
 	Server_ExternalWebUrl = StringParam{"Server.ExternalWebUrl"}
 	Server_Hostname = StringParam{"Server.Hostname"}
 	Server_IssuerHostname = StringParam{"Server.IssuerHostname"}
@@ -147,10 +156,18 @@ var (
 
 var (
 	Cache_Port = IntParam{"Cache.Port"}
+	Client_LocalCacheSize = IntParam{"Client.LocalCacheSize"}
+	Client_MaxBandwidth = IntParam{"Client.MaxBandwidth"}
+	Client_MaximumDownloadSources = IntParam{"Client.MaximumDownloadSources"}
 	Client_MinimumDownloadSpeed = IntParam{"Client.MinimumDownloadSpeed"}
+	Client_MinimumUploadSpeed = IntParam{"Client.MinimumUploadSpeed"}
+	Client_MultiSourceMinimumSize = IntParam{"Client.MultiSourceMinimumSize"}
 	Client_SlowTransferRampupTime = IntParam{"Client.SlowTransferRampupTime"}
 	Client_SlowTransferWindow = IntParam{"Client.SlowTransferWindow"}
 	Client_StoppedTransferTimeout = IntParam{"Client.StoppedTransferTimeout"}
+	Client_UploadChunkRetries = IntParam{"Client.UploadChunkRetries"}
+	Client_UploadChunkSize = IntParam{"Client.UploadChunkSize"}
+	Client_WorkerCount = IntParam{"Client.WorkerCount"}
 	MinimumDownloadSpeed = IntParam{"MinimumDownloadSpeed"}
 	Monitoring_PortHigher = IntParam{"Monitoring.PortHigher"}
 	Monitoring_PortLower = IntParam{"Monitoring.PortLower"}
@@ -165,6 +182,7 @@ var (
 	Client_DisableHttpProxy = BoolParam{"Client.DisableHttpProxy"}
 	Client_DisableProxyFallback = BoolParam{"Client.DisableProxyFallback"}
 	Debug = BoolParam{"Debug"}
+	Director_EnableStat = BoolParam{"Director.EnableStat"}
 	DisableHttpProxy = BoolParam{"DisableHttpProxy"}
 	DisableProxyFallback = BoolParam{"DisableProxyFallback"}
 	Monitoring_MetricAuthorization = BoolParam{"Monitoring.MetricAuthorization"}
@@ -185,9 +203,13 @@ var (
 )
 
 var (
+	Director_StatCacheTTL = DurationParam{"Director.StatCacheTTL"}
+	Director_StatTimeout = DurationParam{"Director.StatTimeout"}
 	Federation_TopologyReloadInterval = DurationParam{"Federation.TopologyReloadInterval"}
 	Monitoring_TokenExpiresIn = DurationParam{"Monitoring.TokenExpiresIn"}
 	Monitoring_TokenRefreshInterval = DurationParam{"Monitoring.TokenRefreshInterval"}
+	Registry_KeyRotationOverlap = DurationParam{"Registry.KeyRotationOverlap"}
+	Registry_PendingExpiration = DurationParam{"Registry.PendingExpiration"}
 	Transport_DialerKeepAlive = DurationParam{"Transport.DialerKeepAlive"}
 	Transport_DialerTimeout = DurationParam{"Transport.DialerTimeout"}
 	Transport_ExpectContinueTimeout = DurationParam{"Transport.ExpectContinueTimeout"}
@@ -201,4 +223,5 @@ var (
 	Issuer_AuthorizationTemplates = ObjectParam{"Issuer.AuthorizationTemplates"}
 	Issuer_OIDCAuthenticationRequirements = ObjectParam{"Issuer.OIDCAuthenticationRequirements"}
 	Registry_Institutions = ObjectParam{"Registry.Institutions"}
+	Registry_UserEmails = ObjectParam{"Registry.UserEmails"}
 )
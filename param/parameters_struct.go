@@ -17,19 +17,34 @@ type config struct {
 	Client struct {
 		DisableHttpProxy bool
 		DisableProxyFallback bool
+		LocalCacheLocation string
+		LocalCacheSize int
+		MaxBandwidth int
+		MaximumDownloadSources int
 		MinimumDownloadSpeed int
+		MinimumUploadSpeed int
+		MultiSourceMinimumSize int
 		SlowTransferRampupTime int
 		SlowTransferWindow int
 		StoppedTransferTimeout int
+		UploadChunkRetries int
+		UploadChunkSize int
+		WorkerCount int
 	}
 	ConfigDir string
 	Debug bool
 	Director struct {
+		AdStore string
 		CacheResponseHostnames []string
+		CacheSortMethod string
+		DbLocation string
 		DefaultResponse string
+		EnableStat bool
 		GeoIPLocation string
 		MaxMindKeyFile string
 		OriginResponseHostnames []string
+		StatCacheTTL time.Duration
+		StatTimeout time.Duration
 	}
 	DisableHttpProxy bool
 	DisableProxyFallback bool
@@ -115,7 +130,15 @@ type config struct {
 		AdminUsers []string
 		DbLocation string
 		Institutions interface{}
+		KeyRotationOverlap time.Duration
+		NotificationSender string
+		NotificationWebhookUrl string
+		PendingExpiration time.Duration
 		RequireKeyChaining bool
+		SMTPPasswordFile string
+		SMTPServer string
+		SMTPUsername string
+		UserEmails interface{}
 	}
 	Server struct {
 		EnableUI bool
@@ -181,19 +204,34 @@ type configWithType struct {
 	Client struct {
 		DisableHttpProxy struct { Type string; Value bool }
 		DisableProxyFallback struct { Type string; Value bool }
+		LocalCacheLocation struct { Type string; Value string }
+		LocalCacheSize struct { Type string; Value int }
+		MaxBandwidth struct { Type string; Value int }
+		MaximumDownloadSources struct { Type string; Value int }
 		MinimumDownloadSpeed struct { Type string; Value int }
+		MinimumUploadSpeed struct { Type string; Value int }
+		MultiSourceMinimumSize struct { Type string; Value int }
 		SlowTransferRampupTime struct { Type string; Value int }
 		SlowTransferWindow struct { Type string; Value int }
 		StoppedTransferTimeout struct { Type string; Value int }
+		UploadChunkRetries struct { Type string; Value int }
+		UploadChunkSize struct { Type string; Value int }
+		WorkerCount struct { Type string; Value int }
 	}
 	ConfigDir struct { Type string; Value string }
 	Debug struct { Type string; Value bool }
 	Director struct {
+		AdStore struct { Type string; Value string }
 		CacheResponseHostnames struct { Type string; Value []string }
+		CacheSortMethod struct { Type string; Value string }
+		DbLocation struct { Type string; Value string }
 		DefaultResponse struct { Type string; Value string }
+		EnableStat struct { Type string; Value bool }
 		GeoIPLocation struct { Type string; Value string }
 		MaxMindKeyFile struct { Type string; Value string }
 		OriginResponseHostnames struct { Type string; Value []string }
+		StatCacheTTL struct { Type string; Value time.Duration }
+		StatTimeout struct { Type string; Value time.Duration }
 	}
 	DisableHttpProxy struct { Type string; Value bool }
 	DisableProxyFallback struct { Type string; Value bool }
@@ -279,7 +317,15 @@ type configWithType struct {
 		AdminUsers struct { Type string; Value []string }
 		DbLocation struct { Type string; Value string }
 		Institutions struct { Type string; Value interface{} }
+		KeyRotationOverlap struct { Type string; Value time.Duration }
+		NotificationSender struct { Type string; Value string }
+		NotificationWebhookUrl struct { Type string; Value string }
+		PendingExpiration struct { Type string; Value time.Duration }
 		RequireKeyChaining struct { Type string; Value bool }
+		SMTPPasswordFile struct { Type string; Value string }
+		SMTPServer struct { Type string; Value string }
+		SMTPUsername struct { Type string; Value string }
+		UserEmails struct { Type string; Value interface{} }
 	}
 	Server struct {
 		EnableUI struct { Type string; Value bool }
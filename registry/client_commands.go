@@ -293,3 +293,84 @@ func NamespaceDelete(endpoint string, prefix string) error {
 	fmt.Println(string(respData))
 	return nil
 }
+
+// Create a short-lived token for the registry with the given scope, signed by key
+func namespaceKeyToken(key jwk.Key, prefix string, scope token_scopes.TokenScope) ([]byte, error) {
+	issuerURL, err := director.GetRegistryIssuerURL(prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to determine issuer URL for creating token")
+	}
+
+	now := time.Now()
+	tok, err := jwt.NewBuilder().
+		Issuer(issuerURL).
+		Claim("scope", scope.String()).
+		IssuedAt(now).
+		Expiration(now.Add(1 * time.Minute)).
+		NotBefore(now).
+		Subject("origin").
+		Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate token")
+	}
+
+	// Get/assign the kid, needed by the registry to find the key to verify with
+	if err = jwk.AssignKeyID(key); err != nil {
+		return nil, errors.Wrap(err, "Failed to assign kid to the token")
+	}
+	signed, err := jwt.Sign(tok, jwt.WithKey(jwa.ES256, key))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign the token")
+	}
+	return signed, nil
+}
+
+// Add newKey to the keys of the namespace at prefix, and have the registry
+// retire currentKey after the overlap (the registry's default if it's empty).
+// The request is signed by currentKey, and newKey signs a proof that we hold it.
+func NamespaceRotateKey(currentKey jwk.Key, newKey jwk.Key, endpoint string, prefix string, overlap string) error {
+	authToken, err := namespaceKeyToken(currentKey, prefix, token_scopes.Pelican_NamespaceRotateKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the key rotation token")
+	}
+	proof, err := namespaceKeyToken(newKey, prefix, token_scopes.Pelican_NamespaceRotateKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the new key's proof")
+	}
+
+	publicKey, err := newKey.PublicKey()
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate public key of the new key")
+	}
+	if err = publicKey.Set("alg", "ES256"); err != nil {
+		return errors.Wrap(err, "Failed to assign signature algorithm to public key")
+	}
+	keySet := jwk.NewSet()
+	if err = keySet.AddKey(publicKey); err != nil {
+		return errors.Wrap(err, "Failed to add public key to new JWKS")
+	}
+
+	data := map[string]interface{}{
+		"prefix":  prefix,
+		"pubkey":  keySet,
+		"proof":   string(proof),
+		"overlap": overlap,
+	}
+	authHeader := map[string]string{
+		"Authorization": "Bearer " + string(authToken),
+	}
+
+	resp, err := utils.MakeRequest(endpoint, "POST", data, authHeader)
+	var respData clientResponseData
+	if err != nil {
+		if unmarshalErr := json.Unmarshal(resp, &respData); unmarshalErr == nil { // Error creating json
+			return errors.Wrapf(err, "Failed to make request: %v", respData.Error)
+		}
+		return errors.Wrap(err, "Failed to make request")
+	}
+	if err = json.Unmarshal(resp, &respData); err != nil {
+		return errors.Wrap(err, "Failure when parsing JSON response from client")
+	}
+	fmt.Println(respData.Message)
+	return nil
+}
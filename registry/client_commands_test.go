@@ -20,12 +20,17 @@ package registry
 
 import (
 	"context"
+	"crypto"
+	"crypto/elliptic"
+	"encoding/base64"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/test_utils"
 	"github.com/spf13/viper"
@@ -257,3 +262,91 @@ func TestRegistryKeyChaining(t *testing.T) {
 
 	viper.Reset()
 }
+
+func TestNamespaceRotateKey(t *testing.T) {
+	ctx, cancel, egrp := test_utils.TestContext(context.Background(), t)
+	defer func() { require.NoError(t, egrp.Wait()) }()
+	defer cancel()
+
+	viper.Reset()
+
+	svr := registryMockup(ctx, t, "rotatekey")
+	defer func() {
+		err := ShutdownDB()
+		assert.NoError(t, err)
+		svr.CloseClientConnections()
+		svr.Close()
+	}()
+	rotateEndpoint := svr.URL + "/api/v1.0/registry/rotateKey"
+
+	_, err := config.GetIssuerPublicJWKS()
+	require.NoError(t, err)
+	oldKey, err := config.GetIssuerPrivateJWK()
+	require.NoError(t, err)
+	err = NamespaceRegister(oldKey, svr.URL+"/api/v1.0/registry", "", "/foo/bar")
+	require.NoError(t, err)
+
+	loadKey := func(name string) jwk.Key {
+		keyFile := filepath.Join(t.TempDir(), name)
+		require.NoError(t, config.GeneratePrivateKey(keyFile, elliptic.P256()))
+		rawKey, err := config.LoadPrivateKey(keyFile)
+		require.NoError(t, err)
+		key, err := jwk.FromRaw(rawKey)
+		require.NoError(t, err)
+		return key
+	}
+	newKey := loadKey("new.jwk")
+
+	// A key that isn't registered to the namespace can't rotate it
+	err = NamespaceRotateKey(loadKey("other.jwk"), newKey, rotateEndpoint, "/foo/bar", "")
+	require.Error(t, err)
+
+	// Both keys are valid during the overlap, and the old one is retiring
+	err = NamespaceRotateKey(oldKey, newKey, rotateEndpoint, "/foo/bar", "1h")
+	require.NoError(t, err)
+	ns, err := getNamespaceByPrefix("/foo/bar")
+	require.NoError(t, err)
+	keys, err := getNamespaceKeysById(ns.ID)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	retiring := map[string]bool{}
+	for _, key := range keys {
+		retiring[key.KeyID] = key.RetireAt != nil
+		if key.RetireAt != nil {
+			assert.WithinDuration(t, time.Now().Add(time.Hour), *key.RetireAt, time.Minute)
+		}
+	}
+	assert.Equal(t, map[string]bool{oldKey.KeyID(): true, newKey.KeyID(): false}, retiring)
+
+	// The same key can't be added twice
+	err = NamespaceRotateKey(oldKey, newKey, rotateEndpoint, "/foo/bar", "1h")
+	require.ErrorContains(t, err, "already registered")
+
+	// With no overlap, the new key takes over immediately
+	thirdKey := loadKey("third.jwk")
+	err = NamespaceRotateKey(newKey, thirdKey, rotateEndpoint, "/foo/bar", "0s")
+	require.NoError(t, err)
+	jwks, err := getNamespaceJwksByPrefix("/foo/bar", false)
+	require.NoError(t, err)
+	_, found := jwks.LookupKeyID(newKey.KeyID())
+	assert.False(t, found)
+	_, found = jwks.LookupKeyID(thirdKey.KeyID())
+	assert.True(t, found)
+
+	// The retired key can't be used again
+	err = NamespaceRotateKey(newKey, loadKey("fourth.jwk"), rotateEndpoint, "/foo/bar", "")
+	require.Error(t, err)
+
+	// Each change is audited as made by the key that signed it
+	entries, _, err := getAuditEntries(auditFilter{Prefix: "/foo/bar"}, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, AuditRotateKey, entries[0].Action)
+	assert.Equal(t, AuditCreate, entries[2].Action)
+	thumbprint, err := oldKey.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, "key:"+base64.RawURLEncoding.EncodeToString(thumbprint), entries[1].Actor)
+	assert.Equal(t, entries[1].Actor, entries[2].Actor)
+
+	viper.Reset()
+}
@@ -0,0 +1,9 @@
+-- We put a size limit on admin_metadata to guard against potentially future
+-- malicious large inserts
+CREATE TABLE IF NOT EXISTS namespace (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    prefix TEXT NOT NULL UNIQUE,
+    pubkey TEXT NOT NULL,
+    identity TEXT,
+    admin_metadata TEXT CHECK (length("admin_metadata") <= 4000)
+);
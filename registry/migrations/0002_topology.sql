@@ -0,0 +1,5 @@
+-- Namespace prefixes from topology, only populated for the OSDF
+CREATE TABLE IF NOT EXISTS topology (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    prefix TEXT NOT NULL UNIQUE
+);
@@ -0,0 +1,8 @@
+-- The keys of a namespace being rotated out, and the unix time at which
+-- they stop being valid
+CREATE TABLE IF NOT EXISTS key_retirement (
+    namespace_id INTEGER NOT NULL,
+    kid TEXT NOT NULL,
+    retire_at INTEGER NOT NULL,
+    PRIMARY KEY (namespace_id, kid)
+);
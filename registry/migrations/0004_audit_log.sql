@@ -0,0 +1,22 @@
+-- The audit log is append-only: the triggers refuse to change or remove entries
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    namespace_id INTEGER NOT NULL,
+    prefix TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    diff TEXT NOT NULL,
+    client_ip TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_prefix ON audit_log (prefix);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor);
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log (created_at);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -0,0 +1,33 @@
+-- Promote the fields of the admin_metadata JSON blob to their own columns so
+-- they can be indexed and filtered on.  Timestamps are RFC 3339 strings, and
+-- empty when unset.  Namespaces registered before admin_metadata existed
+-- keep empty fields.
+ALTER TABLE namespace ADD COLUMN user_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN description TEXT NOT NULL DEFAULT '' CHECK (length(description) <= 4000);
+ALTER TABLE namespace ADD COLUMN site_name TEXT NOT NULL DEFAULT '' CHECK (length(site_name) <= 4000);
+ALTER TABLE namespace ADD COLUMN institution TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN security_contact_user_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN status TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN approver_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN approved_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN created_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE namespace ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+
+UPDATE namespace SET
+    user_id = coalesce(json_extract(admin_metadata, '$.user_id'), ''),
+    description = coalesce(json_extract(admin_metadata, '$.description'), ''),
+    site_name = coalesce(json_extract(admin_metadata, '$.site_name'), ''),
+    institution = coalesce(json_extract(admin_metadata, '$.institution'), ''),
+    security_contact_user_id = coalesce(json_extract(admin_metadata, '$.security_contact_user_id'), ''),
+    status = coalesce(json_extract(admin_metadata, '$.status'), ''),
+    approver_id = coalesce(json_extract(admin_metadata, '$.approver_id'), ''),
+    approved_at = coalesce(nullif(json_extract(admin_metadata, '$.approved_at'), '0001-01-01T00:00:00Z'), ''),
+    created_at = coalesce(nullif(json_extract(admin_metadata, '$.created_at'), '0001-01-01T00:00:00Z'), ''),
+    updated_at = coalesce(nullif(json_extract(admin_metadata, '$.updated_at'), '0001-01-01T00:00:00Z'), '')
+WHERE admin_metadata IS NOT NULL AND admin_metadata != '';
+
+ALTER TABLE namespace DROP COLUMN admin_metadata;
+
+CREATE INDEX namespace_user_id ON namespace (user_id);
+CREATE INDEX namespace_institution ON namespace (institution);
+CREATE INDEX namespace_status ON namespace (status);
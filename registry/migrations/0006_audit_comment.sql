@@ -0,0 +1,3 @@
+-- The reason given for a change, like an admin's comment on approving or
+-- denying a registration
+ALTER TABLE audit_log ADD COLUMN comment TEXT NOT NULL DEFAULT '';
@@ -20,7 +20,7 @@
 //
 //   - It handles the logic to spin up a "registry" server for namespace management,
 //     including a web UI for interactive namespace registration, approval, and browsing.
-//   - It provides a CLI tool `./pelican namespace <command> <args>` to list, register, and delete a namespace
+//   - It provides a CLI tool `./pelican namespace <command> <args>` to list, register, delete, and rotate the key of a namespace
 //
 // To register a namespace, first spin up registry server by `./pelican registry serve -p <your-port-number>`, and then use either
 // the CLI tool or go to registry web UI at `https://localhost:<your-port-number>/view/`, and follow instructions for next steps.
@@ -32,6 +32,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -40,9 +41,11 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/pelicanplatform/pelican/config"
 	"github.com/pelicanplatform/pelican/oauth2"
@@ -96,6 +99,13 @@ type checkNamespaceExistsRes struct {
 	Error        string `json:"error"`
 }
 
+type rotateKeyReq struct {
+	Prefix  string          `json:"prefix"`
+	Pubkey  json.RawMessage `json:"pubkey"`  // A JWKS of the single key to add
+	Proof   string          `json:"proof"`   // A token signed by the key to add
+	Overlap string          `json:"overlap"` // How long the current key stays valid
+}
+
 // Various auxiliary functions used for client-server security handshakes
 type registrationData struct {
 	ClientNonce     string `json:"client_nonce"`
@@ -311,7 +321,7 @@ func keySignChallengeCommit(ctx *gin.Context, data *registrationData, action str
 				return sysErr
 			}
 
-			err = addNamespaceHandler(ctx, data)
+			err = addNamespaceHandler(ctx, data, key)
 			if err != nil {
 				ctx.JSON(500, gin.H{"error": "The server encountered an error while attempting to add the prefix to its database"})
 				return errors.Wrapf(err, "Failed while trying to add to database")
@@ -498,7 +508,13 @@ func cliRegisterNamespace(ctx *gin.Context) {
 	}
 }
 
-func addNamespaceHandler(ctx *gin.Context, data *registrationData) error {
+// Add the namespace registered by the holder of key
+func addNamespaceHandler(ctx *gin.Context, data *registrationData, key jwk.Key) error {
+	actor, err := keyActor(ctx, key)
+	if err != nil {
+		return err
+	}
+
 	var ns Namespace
 	ns.Prefix = data.Prefix
 
@@ -514,10 +530,11 @@ func addNamespaceHandler(ctx *gin.Context, data *registrationData) error {
 	// Overwrite status to Pending to filter malicious request
 	ns.AdminMetadata.Status = Pending
 
-	err = addNamespace(&ns)
+	err = addNamespace(&ns, actor)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to add prefix %s", ns.Prefix)
 	}
+	notify(newNotification(notifySubmitted, &ns, "", actor.ID))
 
 	ctx.JSON(http.StatusCreated, gin.H{"status": "success"})
 	return nil
@@ -580,7 +597,37 @@ func deleteNamespaceHandler(ctx *gin.Context) {
 	* NOTE: The validate function also handles checking `iat` and `exp` to make sure the token
 	*       remains valid.
 	 */
-	scopeValidator := jwt.ValidatorFunc(func(_ context.Context, tok jwt.Token) jwt.ValidationError {
+	if err = jwt.Validate(parsed, jwt.WithValidator(scopeValidator(token_scopes.Pelican_NamespaceDelete))); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server could not validate the provided deletion token"})
+		log.Errorf("Failed to validate the token: %v", err)
+		return
+	}
+
+	var actor auditActor
+	delKey, err := signingKey(delTokenStr, originJwks)
+	if err == nil {
+		actor, err = keyActor(ctx, delKey)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server could not identify the key that signed the deletion token"})
+		log.Errorf("Failed to identify the key of the token: %v", err)
+		return
+	}
+
+	// If we get to this point in the code, we've passed all the security checks and we're ready to delete
+	err = deleteNamespace(prefix, actor)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error deleting namespace from database"})
+		log.Errorf("Failed to delete namespace from database: %v", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// Check that a token has the given scope
+func scopeValidator(requiredScope token_scopes.TokenScope) jwt.Validator {
+	return jwt.ValidatorFunc(func(_ context.Context, tok jwt.Token) jwt.ValidationError {
 		scope_any, present := tok.Get("scope")
 		if !present {
 			return jwt.NewValidationError(errors.New("No scope is present; required for authorization"))
@@ -591,27 +638,129 @@ func deleteNamespaceHandler(ctx *gin.Context) {
 		}
 
 		for _, scope := range strings.Split(scope, " ") {
-			if scope == token_scopes.Pelican_NamespaceDelete.String() {
+			if scope == requiredScope.String() {
 				return nil
 			}
 		}
-		return jwt.NewValidationError(errors.New("Token does not contain namespace deletion authorization"))
+		return jwt.NewValidationError(errors.Errorf("Token does not contain %s authorization", requiredScope))
 	})
-	if err = jwt.Validate(parsed, jwt.WithValidator(scopeValidator)); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server could not validate the provided deletion token"})
-		log.Errorf("Failed to validate the token: %v", err)
+}
+
+// Find the key in keySet that signed a token that's already been verified
+func signingKey(tokenStr string, keySet jwk.Set) (jwk.Key, error) {
+	msg, err := jws.Parse([]byte(tokenStr))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the token's signature")
+	}
+	kid := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	key, found := keySet.LookupKeyID(kid)
+	if !found {
+		return nil, errors.Errorf("The key %s that signed the token is not in the keyset", kid)
+	}
+	return key, nil
+}
+
+// Verify a token is signed by one of the keys in keySet and has the given
+// scope, returning the key that signed it
+func verifyKeyToken(tokenStr string, keySet jwk.Set, requiredScope token_scopes.TokenScope) (jwk.Key, error) {
+	parsed, err := jwt.Parse([]byte(tokenStr), jwt.WithKeySet(keySet))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to verify the token")
+	}
+	if err = jwt.Validate(parsed, jwt.WithValidator(scopeValidator(requiredScope))); err != nil {
+		return nil, errors.Wrap(err, "Failed to validate the token")
+	}
+	return signingKey(tokenStr, keySet)
+}
+
+// Add a new key to a namespace and retire the key signing the request after an
+// overlap, during which both keys are valid.
+//
+// The request is authorized by a token signed with the namespace's current
+// key, and carries a proof token signed with the new key to show the caller
+// holds it.  The new key isn't subject to key chaining: its owner is the owner
+// of a namespace that already satisfied it.
+//
+// POST /rotateKey
+func rotateKeyHandler(ctx *gin.Context) {
+	req := rotateKeyReq{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse request body"})
+		return
+	}
+	if req.Prefix == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "prefix is required"})
+		return
+	}
+	ns, err := getNamespaceByPrefix(req.Prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("namespace prefix '%s', was not found", req.Prefix)})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error getting the namespace"})
+		log.Errorf("Failed to get namespace %s: %v", req.Prefix, err)
+		return
+	}
+	newKey, err := validateRotationKey(string(req.Pubkey))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("Error: Field validation for pubkey failed: ", err)})
+		return
+	}
+	overlap, err := validateRotationOverlap(req.Overlap)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("Error: Field validation for overlap failed: ", err)})
 		return
 	}
 
-	// If we get to this point in the code, we've passed all the security checks and we're ready to delete
-	err = deleteNamespace(prefix)
+	currentJwks, err := getNamespaceJwksById(ns.ID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error deleting namespace from database"})
-		log.Errorf("Failed to delete namespace from database: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error loading the prefix's stored jwks"})
+		log.Errorf("Failed to get prefix's stored jwks: %v", err)
+		return
+	}
+	authToken := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	retiringKey, err := verifyKeyToken(authToken, currentJwks, token_scopes.Pelican_NamespaceRotateKey)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "server could not verify the token was signed by a current key of the namespace"})
+		log.Errorf("Failed to verify key rotation token for %s: %v", req.Prefix, err)
+		return
+	}
+	retiringKid := retiringKey.KeyID()
+	actor, err := keyActor(ctx, retiringKey)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error identifying the key"})
+		log.Errorf("Failed to identify the key rotating %s: %v", req.Prefix, err)
+		return
+	}
+	newJwks := jwk.NewSet()
+	if err = newJwks.AddKey(newKey); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error loading the new key"})
+		log.Errorf("Failed to add the new key to a jwks: %v", err)
+		return
+	}
+	if _, err = verifyKeyToken(req.Proof, newJwks, token_scopes.Pelican_NamespaceRotateKey); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "server could not verify the proof was signed by the new key"})
+		log.Errorf("Failed to verify key rotation proof for %s: %v", req.Prefix, err)
+		return
+	}
+	if _, found := currentJwks.LookupKeyID(newKey.KeyID()); found {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("the key %s is already registered to the namespace", newKey.KeyID())})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+	retireAt := time.Now().Add(overlap)
+	if err = rotateNamespaceKey(ns.ID, newKey, []string{retiringKid}, retireAt, actor); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server encountered an error rotating the namespace's key"})
+		log.Errorf("Failed to rotate the key of %s: %v", req.Prefix, err)
+		return
+	}
+	log.Infof("Added key %s to namespace %s; key %s will be retired at %s", newKey.KeyID(), req.Prefix, retiringKid, retireAt)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"message":   fmt.Sprintf("Added key %s to %s; key %s will be retired at %s", newKey.KeyID(), req.Prefix, retiringKid, retireAt.Format(time.RFC3339)),
+		"retire_at": retireAt,
+	})
 }
 
 /**
@@ -766,6 +915,7 @@ func RegisterRegistryAPI(router *gin.RouterGroup) {
 		// Handle everything under "/" route with GET method
 		registryAPI.GET("/*wildcard", wildcardHandler)
 		registryAPI.POST("/checkNamespaceExists", checkNamespaceExistsHandler)
+		registryAPI.POST("/rotateKey", rotateKeyHandler)
 		registryAPI.DELETE("/*wildcard", deleteNamespaceHandler)
 	}
 }
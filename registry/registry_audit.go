@@ -0,0 +1,295 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"crypto"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/pkg/errors"
+)
+
+type AuditAction string
+
+// The AuditEntry is one change to a namespace in the registry's audit log.
+//
+// The Actor is the "sub" claim of the user who made the change from the web
+// UI, or "key:" followed by the base64url SHA-256 thumbprint of the key that
+// signed the request from the CLI. Changes the registry makes on its own, like
+// retiring rotated keys, have "registry" as their actor.
+//
+// The Diff maps each field that changed, with admin_metadata fields flattened
+// to "admin_metadata.<field>", to an object with its "before" and "after" values.
+// The Comment is the reason given for the change, if any.
+type AuditEntry struct {
+	ID          int             `json:"id"`
+	NamespaceID int             `json:"namespace_id"`
+	Prefix      string          `json:"prefix"`
+	Actor       string          `json:"actor"`
+	Action      AuditAction     `json:"action"`
+	Diff        json.RawMessage `json:"diff"`
+	Comment     string          `json:"comment,omitempty"`
+	ClientIP    string          `json:"client_ip"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Who made a change to the registry, and from where
+type auditActor struct {
+	ID       string
+	ClientIP string
+}
+
+// The filters for the audit log; empty fields match everything
+type auditFilter struct {
+	NamespaceID int
+	Prefix      string
+	Actor       string
+	Action      AuditAction
+	From        time.Time
+	To          time.Time
+}
+
+type auditFieldDiff struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+const (
+	AuditCreate     AuditAction = "create"
+	AuditUpdate     AuditAction = "update"
+	AuditApprove    AuditAction = "approve"
+	AuditDeny       AuditAction = "deny"
+	AuditSetStatus  AuditAction = "set_status"
+	AuditDelete     AuditAction = "delete"
+	AuditRotateKey  AuditAction = "rotate_key"
+	AuditRetireKeys AuditAction = "retire_keys"
+	AuditImport     AuditAction = "import"
+	AuditExpire     AuditAction = "expire"
+)
+
+// The actor for changes the registry makes on its own
+var registryActor = auditActor{ID: "registry"}
+
+func (a AuditAction) String() string {
+	return string(a)
+}
+
+// The actor for a change made by the logged-in user of the web UI
+func userActor(ctx *gin.Context) auditActor {
+	return auditActor{ID: ctx.GetString("User"), ClientIP: ctx.ClientIP()}
+}
+
+// The actor for a change requested with a signature from key
+func keyActor(ctx *gin.Context, key jwk.Key) (auditActor, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return auditActor{}, errors.Wrap(err, "Failed to compute the key's thumbprint")
+	}
+	return auditActor{
+		ID:       "key:" + base64.RawURLEncoding.EncodeToString(thumbprint),
+		ClientIP: ctx.ClientIP(),
+	}, nil
+}
+
+// Flatten a namespace into the fields compared by the audit diff
+func namespaceAuditFields(ns *Namespace) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if ns == nil {
+		return fields, nil
+	}
+	nsBytes, err := json.Marshal(ns)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(nsBytes, &fields); err != nil {
+		return nil, err
+	}
+	// The ID never changes and is recorded in its own column
+	delete(fields, "id")
+	if adminMetadata, ok := fields["admin_metadata"].(map[string]interface{}); ok {
+		delete(fields, "admin_metadata")
+		for name, value := range adminMetadata {
+			fields["admin_metadata."+name] = value
+		}
+	}
+	return fields, nil
+}
+
+// Compute the JSON diff of the fields that changed between before and after
+func auditDiff(before, after map[string]interface{}) (string, error) {
+	diff := make(map[string]auditFieldDiff)
+	for name, beforeValue := range before {
+		if afterValue, found := after[name]; !found || !reflect.DeepEqual(beforeValue, afterValue) {
+			diff[name] = auditFieldDiff{Before: beforeValue, After: after[name]}
+		}
+	}
+	for name, afterValue := range after {
+		if _, found := before[name]; !found {
+			diff[name] = auditFieldDiff{Before: nil, After: afterValue}
+		}
+	}
+	diffBytes, err := json.Marshal(diff)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to marshal the audit diff")
+	}
+	return string(diffBytes), nil
+}
+
+// Append an entry to the audit log as part of the transaction making the change
+func insertAuditEntry(tx *sql.Tx, namespaceId int, prefix string, action AuditAction, actor auditActor, comment string, before, after map[string]interface{}) error {
+	diff, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO audit_log (namespace_id, prefix, actor, action, diff, comment, client_ip, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, namespaceId, prefix, actor.ID, action.String(), diff, comment, actor.ClientIP, time.Now().Unix())
+	if err != nil {
+		return errors.Wrap(err, "Failed to write the audit log")
+	}
+	return nil
+}
+
+// Append an entry for a change to a namespace, from before to after; either may
+// be nil for a namespace that's created or deleted
+func auditNamespaceChange(tx *sql.Tx, namespaceId int, action AuditAction, actor auditActor, comment string, before, after *Namespace) error {
+	beforeFields, err := namespaceAuditFields(before)
+	if err != nil {
+		return errors.Wrap(err, "Failed to flatten the namespace for the audit log")
+	}
+	afterFields, err := namespaceAuditFields(after)
+	if err != nil {
+		return errors.Wrap(err, "Failed to flatten the namespace for the audit log")
+	}
+	prefix := ""
+	if after != nil {
+		prefix = after.Prefix
+	} else if before != nil {
+		prefix = before.Prefix
+	}
+	return insertAuditEntry(tx, namespaceId, prefix, action, actor, comment, beforeFields, afterFields)
+}
+
+// Get a page of the audit log matching filter, newest first, along with the
+// total number of matching entries. Pages start at 1.
+func getAuditEntries(filter auditFilter, page int, pageSize int) ([]AuditEntry, int, error) {
+	conditions := []string{"1=1"}
+	args := []interface{}{}
+	if filter.NamespaceID != 0 {
+		conditions = append(conditions, "namespace_id = ?")
+		args = append(args, filter.NamespaceID)
+	}
+	if filter.Prefix != "" {
+		conditions = append(conditions, "prefix = ?")
+		args = append(args, filter.Prefix)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action.String())
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.To.Unix())
+	}
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "Failed to count audit log entries")
+	}
+
+	query := `SELECT id, namespace_id, prefix, actor, action, diff, comment, client_ip, created_at FROM audit_log` +
+		where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Failed to query the audit log")
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		entry := AuditEntry{}
+		var diff string
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.NamespaceID, &entry.Prefix, &entry.Actor, &entry.Action, &diff, &entry.Comment, &entry.ClientIP, &createdAt); err != nil {
+			return nil, 0, errors.Wrap(err, "Failed to scan audit log entry")
+		}
+		entry.Diff = json.RawMessage(diff)
+		entry.CreatedAt = time.Unix(createdAt, 0)
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// A change to the status of a namespace, as shown to its owner
+type StatusChange struct {
+	Status    RegistrationStatus `json:"status"`
+	Actor     string             `json:"actor"`
+	Comment   string             `json:"comment,omitempty"`
+	ChangedAt time.Time          `json:"changed_at"`
+}
+
+// The history of the status of the namespace with the given id, oldest
+// first, from the entries of the audit log that changed it
+func getStatusHistory(id int) ([]StatusChange, error) {
+	query := `SELECT actor, diff, comment, created_at FROM audit_log WHERE namespace_id = ? ORDER BY id ASC`
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to query the audit log")
+	}
+	defer rows.Close()
+
+	history := make([]StatusChange, 0)
+	for rows.Next() {
+		change := StatusChange{}
+		var diffStr string
+		var createdAt int64
+		if err := rows.Scan(&change.Actor, &diffStr, &change.Comment, &createdAt); err != nil {
+			return nil, errors.Wrap(err, "Failed to scan audit log entry")
+		}
+		diff := map[string]auditFieldDiff{}
+		if err := json.Unmarshal([]byte(diffStr), &diff); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse the audit diff")
+		}
+		statusDiff, found := diff["admin_metadata.status"]
+		if !found {
+			continue
+		}
+		// A deleted namespace has no status after
+		status, _ := statusDiff.After.(string)
+		change.Status = RegistrationStatus(status)
+		change.ChangedAt = time.Unix(createdAt, 0)
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
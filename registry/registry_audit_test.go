@@ -0,0 +1,132 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	setupMockRegistryDB(t)
+	defer teardownMockNamespaceDB(t)
+
+	admin := auditActor{ID: "admin", ClientIP: "10.0.0.1"}
+	ns := mockNamespace("/test", "pubkey", "", AdminMetadata{Description: "before"})
+	require.NoError(t, addNamespace(&ns, mockActor))
+	got, err := getNamespaceByPrefix("/test")
+	require.NoError(t, err)
+	got.AdminMetadata.Description = "after"
+	require.NoError(t, updateNamespace(got, mockActor))
+	require.NoError(t, updateNamespaceStatusById(got.ID, Approved, "Looks good", admin))
+	require.NoError(t, deleteNamespace("/test", admin))
+
+	entries, total, err := getAuditEntries(auditFilter{}, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	require.Len(t, entries, 4)
+	actions := []AuditAction{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		assert.Equal(t, "/test", entry.Prefix)
+		assert.Equal(t, got.ID, entry.NamespaceID)
+		assert.WithinDuration(t, time.Now(), entry.CreatedAt, time.Minute)
+	}
+	// Newest first
+	assert.Equal(t, []AuditAction{AuditDelete, AuditApprove, AuditUpdate, AuditCreate}, actions)
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.Equal(t, "10.0.0.1", entries[0].ClientIP)
+
+	diff := map[string]auditFieldDiff{}
+	require.NoError(t, json.Unmarshal(entries[2].Diff, &diff))
+	assert.Equal(t, auditFieldDiff{Before: "before", After: "after"}, diff["admin_metadata.description"])
+	assert.NotContains(t, diff, "prefix")
+	require.NoError(t, json.Unmarshal(entries[1].Diff, &diff))
+	assert.Equal(t, auditFieldDiff{Before: "Pending", After: "Approved"}, diff["admin_metadata.status"])
+	assert.Equal(t, "Looks good", entries[1].Comment)
+	diff = map[string]auditFieldDiff{}
+	require.NoError(t, json.Unmarshal(entries[3].Diff, &diff))
+	assert.Equal(t, auditFieldDiff{Before: nil, After: "/test"}, diff["prefix"])
+	diff = map[string]auditFieldDiff{}
+	require.NoError(t, json.Unmarshal(entries[0].Diff, &diff))
+	assert.Equal(t, auditFieldDiff{Before: "pubkey", After: nil}, diff["pubkey"])
+
+	// Entries can't be changed or removed
+	_, err = db.Exec(`UPDATE audit_log SET actor = 'someone else'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(`DELETE FROM audit_log`)
+	assert.ErrorContains(t, err, "append-only")
+
+	t.Run("filters", func(t *testing.T) {
+		entries, total, err := getAuditEntries(auditFilter{Actor: "admin"}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Len(t, entries, 2)
+
+		entries, _, err = getAuditEntries(auditFilter{Prefix: "/test", Action: AuditUpdate}, 1, 10)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "tester", entries[0].Actor)
+
+		_, total, err = getAuditEntries(auditFilter{Prefix: "/other"}, 1, 10)
+		require.NoError(t, err)
+		assert.Zero(t, total)
+
+		_, total, err = getAuditEntries(auditFilter{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 4, total)
+		_, total, err = getAuditEntries(auditFilter{From: time.Now().Add(time.Hour)}, 1, 10)
+		require.NoError(t, err)
+		assert.Zero(t, total)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.GET("/audit", listAuditEntries)
+
+		query := url.Values{}
+		query.Set("page", "2")
+		query.Set("page_size", "3")
+		query.Set("from", time.Now().Add(-time.Hour).Format(time.RFC3339))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?"+query.Encode(), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		res := listAuditResponse{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, 4, res.Total)
+		assert.Equal(t, 2, res.Page)
+		require.Len(t, res.Entries, 1)
+		assert.Equal(t, AuditCreate, res.Entries[0].Action)
+
+		for _, badQuery := range []string{"page_size=1000", "page=-1", "from=yesterday"} {
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?"+badQuery, nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code, badQuery)
+		}
+	})
+}
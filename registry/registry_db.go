@@ -43,8 +43,8 @@ import (
 
 type RegistrationStatus string
 
-// The AdminMetadata is used in [Namespace] as a marshalled JSON string
-// to be stored in registry DB.
+// The AdminMetadata is used in [Namespace], with each field stored in its own
+// column of the registry DB.
 //
 // The *UserID are meant to correspond to the "sub" claim of the user token that
 // the OAuth client issues if the user is logged in using OAuth, or it should be
@@ -79,6 +79,14 @@ type Namespace struct {
 	AdminMetadata AdminMetadata `json:"admin_metadata"`
 }
 
+// A key registered to a namespace, and when it will be retired if it's been
+// rotated out
+type NamespaceKey struct {
+	KeyID    string     `json:"kid"`
+	Key      jwk.Key    `json:"key"`
+	RetireAt *time.Time `json:"retire_at,omitempty"`
+}
+
 type NamespaceWOPubkey struct {
 	ID            int           `json:"id"`
 	Prefix        string        `json:"prefix"`
@@ -132,35 +140,63 @@ func (a AdminMetadata) Equal(b AdminMetadata) bool {
 		a.UpdatedAt.Equal(b.UpdatedAt)
 }
 
-func createNamespaceTable() {
-	//We put a size limit on admin_metadata to guard against potentially future
-	//malicious large inserts
-	query := `
-    CREATE TABLE IF NOT EXISTS namespace (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        prefix TEXT NOT NULL UNIQUE,
-        pubkey TEXT NOT NULL,
-        identity TEXT,
-        admin_metadata TEXT CHECK (length("admin_metadata") <= 4000)
-    );`
+const (
+	// The admin metadata columns of the namespace table, in the order of
+	// namespaceAdminArgs
+	adminMetadataColumns = `user_id, description, site_name, institution, security_contact_user_id,
+	status, approver_id, approved_at, created_at, updated_at`
+	// The columns of the namespace table, in the order scanNamespace reads them
+	namespaceColumns = `id, prefix, pubkey, identity, ` + adminMetadataColumns
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Fatalf("Failed to create namespace table: %v", err)
+// Timestamps are stored as RFC 3339 strings, and as an empty string if unset
+func formatDBTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseDBTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
 	}
+	return time.Parse(time.RFC3339Nano, value)
 }
 
-func createTopologyTable() {
-	query := `
-    CREATE TABLE IF NOT EXISTS topology (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        prefix TEXT NOT NULL UNIQUE
-    );`
+// The values of the admin metadata columns of ns, in the order of adminMetadataColumns
+func namespaceAdminArgs(ns *Namespace) []interface{} {
+	admin := ns.AdminMetadata
+	return []interface{}{admin.UserID, admin.Description, admin.SiteName, admin.Institution, admin.SecurityContactUserID,
+		string(admin.Status), admin.ApproverID, formatDBTime(admin.ApprovedAt), formatDBTime(admin.CreatedAt), formatDBTime(admin.UpdatedAt)}
+}
 
-	_, err := db.Exec(query)
+// Read a namespace from a row selecting namespaceColumns
+func scanNamespace(row rowScanner) (*Namespace, error) {
+	ns := &Namespace{}
+	admin := &ns.AdminMetadata
+	var identity sql.NullString
+	var approvedAt, createdAt, updatedAt string
+	err := row.Scan(&ns.ID, &ns.Prefix, &ns.Pubkey, &identity, &admin.UserID, &admin.Description, &admin.SiteName,
+		&admin.Institution, &admin.SecurityContactUserID, &admin.Status, &admin.ApproverID, &approvedAt, &createdAt, &updatedAt)
 	if err != nil {
-		log.Fatalf("Failed to create topology table: %v", err)
+		return nil, err
+	}
+	ns.Identity = identity.String
+	if admin.ApprovedAt, err = parseDBTime(approvedAt); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse approved_at")
+	}
+	if admin.CreatedAt, err = parseDBTime(createdAt); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse created_at")
+	}
+	if admin.UpdatedAt, err = parseDBTime(updatedAt); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse updated_at")
 	}
+	return ns, nil
 }
 
 func namespaceExists(prefix string) (bool, error) {
@@ -288,32 +324,16 @@ func namespaceExistsByPrefix(prefix string) (bool, error) {
 }
 
 func namespaceBelongsToUserId(id int, userId string) (bool, error) {
-	query := `SELECT admin_metadata FROM namespace where id = ?`
-	rows, err := db.Query(query, id)
-	if err != nil {
+	query := `SELECT user_id FROM namespace where id = ?`
+	var owner string
+	err := db.QueryRow(query, id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		ns := &Namespace{}
-		adminMetadataStr := ""
-		if err := rows.Scan(&adminMetadataStr); err != nil {
-			return false, err
-		}
-		// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-		if adminMetadataStr != "" {
-			if err := json.Unmarshal([]byte(adminMetadataStr), &ns.AdminMetadata); err != nil {
-				return false, err
-			}
-		} else {
-			return false, nil // If adminMetadata is an empty string, no userId is present
-		}
-		if ns.AdminMetadata.UserID == userId {
-			return true, nil
-		}
-	}
-	return false, nil
+	// Namespaces registered before admin metadata existed have no owner
+	return owner != "" && owner == userId, nil
 }
 
 func getNamespaceJwksById(id int) (jwk.Set, error) {
@@ -332,35 +352,34 @@ func getNamespaceJwksById(id int) (jwk.Set, error) {
 		return nil, errors.Wrap(err, "Failed to parse pubkey as a jwks")
 	}
 
+	if err = removeRetiredKeys(set, id); err != nil {
+		return nil, err
+	}
 	return set, nil
 }
 
 func getNamespaceJwksByPrefix(prefix string, approvalRequired bool) (jwk.Set, error) {
 	var jwksQuery string
 	var pubkeyStr string
+	var id int
 	if strings.HasPrefix(prefix, "/caches/") && approvalRequired {
-		adminMetadataStr := ""
-		jwksQuery = `SELECT pubkey, admin_metadata FROM namespace WHERE prefix = ?`
-		err := db.QueryRow(jwksQuery, prefix).Scan(&pubkeyStr, &adminMetadataStr)
+		var status RegistrationStatus
+		jwksQuery = `SELECT id, pubkey, status FROM namespace WHERE prefix = ?`
+		err := db.QueryRow(jwksQuery, prefix).Scan(&id, &pubkeyStr, &status)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return nil, errors.New("prefix not found in database")
 			}
 			return nil, errors.Wrap(err, "error performing cache pubkey query")
 		}
-		if adminMetadataStr != "" { // Older version didn't have admin_metadata populated, skip checking
-			adminMetadata := AdminMetadata{}
-			if err = json.Unmarshal([]byte(adminMetadataStr), &adminMetadata); err != nil {
-				return nil, errors.Wrap(err, "Failed to unmarshall admin_metadata")
-			}
-			// TODO: Move this to upper functions that handles business logic to keep db access functions simple
-			if adminMetadata.Status != Approved {
-				return nil, serverCredsErr
-			}
+		// Older version didn't have admin metadata populated, skip checking
+		// TODO: Move this to upper functions that handles business logic to keep db access functions simple
+		if status != "" && status != Approved {
+			return nil, serverCredsErr
 		}
 	} else {
-		jwksQuery := `SELECT pubkey FROM namespace WHERE prefix = ?`
-		err := db.QueryRow(jwksQuery, prefix).Scan(&pubkeyStr)
+		jwksQuery := `SELECT id, pubkey FROM namespace WHERE prefix = ?`
+		err := db.QueryRow(jwksQuery, prefix).Scan(&id, &pubkeyStr)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return nil, errors.New("prefix not found in database")
@@ -374,74 +393,119 @@ func getNamespaceJwksByPrefix(prefix string, approvalRequired bool) (jwk.Set, er
 		return nil, errors.Wrap(err, "Failed to parse pubkey as a jwks")
 	}
 
+	if err = removeRetiredKeys(set, id); err != nil {
+		return nil, err
+	}
 	return set, nil
 }
 
+// Remove the keys of the namespace with the given id whose retirement deadline
+// has passed from its keyset.  They're only deleted from the database once
+// retireNamespaceKeys next runs, so this is what makes the deadline exact.
+func removeRetiredKeys(set jwk.Set, id int) error {
+	query := `SELECT kid FROM key_retirement WHERE namespace_id = ? AND retire_at <= ?`
+	rows, err := db.Query(query, id, time.Now().Unix())
+	if err != nil {
+		return errors.Wrap(err, "error performing retired key query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kid string
+		if err := rows.Scan(&kid); err != nil {
+			return errors.Wrap(err, "error scanning retired key")
+		}
+		if key, found := set.LookupKeyID(kid); found {
+			if err := set.RemoveKey(key); err != nil {
+				return errors.Wrapf(err, "Failed to remove retired key %s", kid)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// Get the retirement deadlines of the keys being rotated out of the namespace
+// with the given id, by key id
+func getKeyRetirementsById(id int) (map[string]time.Time, error) {
+	retireAt := make(map[string]time.Time)
+	query := `SELECT kid, retire_at FROM key_retirement WHERE namespace_id = ?`
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "error performing key retirement query")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kid string
+		var retireUnix int64
+		if err := rows.Scan(&kid, &retireUnix); err != nil {
+			return nil, errors.Wrap(err, "error scanning key retirement")
+		}
+		retireAt[kid] = time.Unix(retireUnix, 0)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return retireAt, nil
+}
+
+// Get the keys registered to the namespace with the given id, along with the
+// deadline of any that are being retired
+func getNamespaceKeysById(id int) ([]NamespaceKey, error) {
+	set, err := getNamespaceJwksById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	retireAt, err := getKeyRetirementsById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]NamespaceKey, 0, set.Len())
+	for idx := 0; idx < set.Len(); idx++ {
+		key, _ := set.Key(idx)
+		nsKey := NamespaceKey{KeyID: key.KeyID(), Key: key}
+		if deadline, found := retireAt[key.KeyID()]; found {
+			nsKey.RetireAt = &deadline
+		}
+		keys = append(keys, nsKey)
+	}
+	return keys, nil
+}
+
 func getNamespaceStatusById(id int) (RegistrationStatus, error) {
 	if id < 1 {
 		return "", errors.New("Invalid id. id must be a positive integer")
 	}
-	adminMetadata := AdminMetadata{}
-	adminMetadataStr := ""
-	query := `SELECT admin_metadata FROM namespace WHERE id = ?`
-	err := db.QueryRow(query, id).Scan(&adminMetadataStr)
+	var status RegistrationStatus
+	query := `SELECT status FROM namespace WHERE id = ?`
+	err := db.QueryRow(query, id).Scan(&status)
 	if err != nil {
 		return "", err
 	}
-	// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-	if adminMetadataStr != "" {
-		if err := json.Unmarshal([]byte(adminMetadataStr), &adminMetadata); err != nil {
-			return "", err
-		}
-		// This should never happen in non-testing environment, but if it does, we want to
-		// decode it to known enumeration for this field
-		if adminMetadata.Status == "" {
-			return Unknown, nil
-		}
-		return adminMetadata.Status, nil
-	} else {
+	// Namespaces registered before admin metadata existed have no status, and
+	// it should never be empty otherwise outside of testing, but if it is, we
+	// want to decode it to known enumeration for this field
+	if status == "" {
 		return Unknown, nil
 	}
+	return status, nil
 }
 
 func getNamespaceById(id int) (*Namespace, error) {
 	if id < 1 {
 		return nil, errors.New("Invalid id. id must be a positive number")
 	}
-	ns := &Namespace{}
-	adminMetadataStr := ""
-	query := `SELECT id, prefix, pubkey, identity, admin_metadata FROM namespace WHERE id = ?`
-	err := db.QueryRow(query, id).Scan(&ns.ID, &ns.Prefix, &ns.Pubkey, &ns.Identity, &adminMetadataStr)
-	if err != nil {
-		return nil, err
-	}
-	// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-	if adminMetadataStr != "" {
-		if err := json.Unmarshal([]byte(adminMetadataStr), &ns.AdminMetadata); err != nil {
-			return nil, err
-		}
-	}
-	return ns, nil
+	query := `SELECT ` + namespaceColumns + ` FROM namespace WHERE id = ?`
+	return scanNamespace(db.QueryRow(query, id))
 }
 
 func getNamespaceByPrefix(prefix string) (*Namespace, error) {
 	if prefix == "" {
 		return nil, errors.New("Invalid prefix. Prefix must not be empty")
 	}
-	ns := &Namespace{}
-	adminMetadataStr := ""
-	query := `SELECT id, prefix, pubkey, identity, admin_metadata FROM namespace WHERE prefix = ?`
-	err := db.QueryRow(query, prefix).Scan(&ns.ID, &ns.Prefix, &ns.Pubkey, &ns.Identity, &adminMetadataStr)
-	if err != nil {
-		return nil, err
-	}
-	// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-	if adminMetadataStr != "" {
-		if err := json.Unmarshal([]byte(adminMetadataStr), &ns.AdminMetadata); err != nil {
-			return nil, err
-		}
-	}
-	return ns, nil
+	query := `SELECT ` + namespaceColumns + ` FROM namespace WHERE prefix = ?`
+	return scanNamespace(db.QueryRow(query, prefix))
 }
 
 // Get a collection of namespaces by filtering against various non-default namespace fields
@@ -451,7 +515,8 @@ func getNamespaceByPrefix(prefix string) (*Namespace, error) {
 // the string will be matched using `strings.Contains`. This is too mimic a SQL style `like` match.
 // The rest of the AdminMetadata fields is matched by `==`
 func getNamespacesByFilter(filterNs Namespace, serverType ServerType) ([]*Namespace, error) {
-	query := `SELECT id, prefix, pubkey, identity, admin_metadata FROM namespace WHERE 1=1 `
+	query := `SELECT ` + namespaceColumns + ` FROM namespace WHERE 1=1 `
+	args := []interface{}{}
 	if serverType == CacheType {
 		// Refer to the cache prefix name in cmd/cache_serve
 		query += ` AND prefix LIKE '/caches/%'`
@@ -471,15 +536,48 @@ func getNamespacesByFilter(filterNs Namespace, serverType ServerType) ([]*Namesp
 		return nil, errors.New("Unsupported operation: Can't filter against Pubkey field.")
 	}
 	if filterNs.Prefix != "" {
-		query += fmt.Sprintf(" AND prefix like '%%%s%%' ", filterNs.Prefix)
+		query += ` AND prefix LIKE '%' || ? || '%'`
+		args = append(args, filterNs.Prefix)
 	}
 	if !filterNs.AdminMetadata.ApprovedAt.Equal(time.Time{}) || !filterNs.AdminMetadata.UpdatedAt.Equal(time.Time{}) || !filterNs.AdminMetadata.CreatedAt.Equal(time.Time{}) {
 		return nil, errors.New("Unsupported operation: Can't filter against date.")
 	}
+	admin := filterNs.AdminMetadata
+	if admin.UserID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, admin.UserID)
+	}
+	// instr rather than LIKE so the match is case-sensitive, like strings.Contains
+	if admin.Description != "" {
+		query += ` AND instr(description, ?) > 0`
+		args = append(args, admin.Description)
+	}
+	if admin.SiteName != "" {
+		query += ` AND instr(site_name, ?) > 0`
+		args = append(args, admin.SiteName)
+	}
+	if admin.Institution != "" {
+		query += ` AND institution = ?`
+		args = append(args, admin.Institution)
+	}
+	if admin.SecurityContactUserID != "" {
+		query += ` AND security_contact_user_id = ?`
+		args = append(args, admin.SecurityContactUserID)
+	}
+	if admin.Status == Unknown {
+		query += ` AND status IN ('', ?)`
+		args = append(args, Unknown)
+	} else if admin.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, admin.Status)
+	}
+	if admin.ApproverID != "" {
+		query += ` AND approver_id = ?`
+		args = append(args, admin.ApproverID)
+	}
 	// Always sort by id by default
 	query += " ORDER BY id ASC"
-	// For now, we need to execute the query first and manually filter out fields for AdminMetadata
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -487,58 +585,14 @@ func getNamespacesByFilter(filterNs Namespace, serverType ServerType) ([]*Namesp
 
 	namespaces := make([]*Namespace, 0)
 	for rows.Next() {
-		ns := &Namespace{}
-		adminMetadataStr := ""
-		if err := rows.Scan(&ns.ID, &ns.Prefix, &ns.Pubkey, &ns.Identity, &adminMetadataStr); err != nil {
+		ns, err := scanNamespace(rows)
+		if err != nil {
 			return nil, err
 		}
-		// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-		if adminMetadataStr == "" {
-			// If we apply any filter against the AdminMetadata field but the
-			// entry didn't populate this field, skip it
-			if !filterNs.AdminMetadata.Equal(AdminMetadata{}) {
-				continue
-			} else {
-				// If we don't filter against AdminMetadata, just add it to result
-				namespaces = append(namespaces, ns)
-			}
-		} else {
-			if err := json.Unmarshal([]byte(adminMetadataStr), &ns.AdminMetadata); err != nil {
-				return nil, err
-			}
-			if filterNs.AdminMetadata.UserID != "" && filterNs.AdminMetadata.UserID != ns.AdminMetadata.UserID {
-				continue
-			}
-			if filterNs.AdminMetadata.Description != "" && !strings.Contains(ns.AdminMetadata.Description, filterNs.AdminMetadata.Description) {
-				continue
-			}
-			if filterNs.AdminMetadata.SiteName != "" && !strings.Contains(ns.AdminMetadata.SiteName, filterNs.AdminMetadata.SiteName) {
-				continue
-			}
-			if filterNs.AdminMetadata.Institution != "" && filterNs.AdminMetadata.Institution != ns.AdminMetadata.Institution {
-				continue
-			}
-			if filterNs.AdminMetadata.SecurityContactUserID != "" && filterNs.AdminMetadata.SecurityContactUserID != ns.AdminMetadata.SecurityContactUserID {
-				continue
-			}
-			if filterNs.AdminMetadata.Status != "" {
-				if filterNs.AdminMetadata.Status == Unknown {
-					if ns.AdminMetadata.Status != "" && ns.AdminMetadata.Status != Unknown {
-						continue
-					}
-				} else if filterNs.AdminMetadata.Status != ns.AdminMetadata.Status {
-					continue
-				}
-			}
-			if filterNs.AdminMetadata.ApproverID != "" && filterNs.AdminMetadata.ApproverID != ns.AdminMetadata.ApproverID {
-				continue
-			}
-			// Congrats! You passed all the filter check and this namespace matches what you want
-			namespaces = append(namespaces, ns)
-		}
+		namespaces = append(namespaces, ns)
 	}
 
-	return namespaces, nil
+	return namespaces, rows.Err()
 }
 
 /*
@@ -547,13 +601,7 @@ used BY the registry (as opposed to the parallel
 functions) used by the client.
 */
 
-func addNamespace(ns *Namespace) error {
-	query := `INSERT INTO namespace (prefix, pubkey, identity, admin_metadata) VALUES (?, ?, ?, ?)`
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-
+func addNamespace(ns *Namespace, actor auditActor) error {
 	// Adding default values to the field. Note that you need to pass other fields
 	// including user_id before this function
 	ns.AdminMetadata.CreatedAt = time.Now()
@@ -564,12 +612,40 @@ func addNamespace(ns *Namespace) error {
 		ns.AdminMetadata.Status = Pending
 	}
 
-	strAdminMetadata, err := json.Marshal(ns.AdminMetadata)
+	return insertNamespace(ns, AuditCreate, actor)
+}
+
+// Insert ns into the registry exactly as given, recording it in the audit log
+// as the given action
+func insertNamespace(ns *Namespace, action AuditAction, actor auditActor) error {
+	return insertNamespaceRetiringKeys(ns, nil, action, actor)
+}
+
+// Insert a namespace whose keys with the ids in retireAt are being rotated
+// out, to be retired at the given deadlines
+func insertNamespaceRetiringKeys(ns *Namespace, retireAt map[string]time.Time, action AuditAction, actor auditActor) error {
+	query := `INSERT INTO namespace (prefix, pubkey, identity, ` + adminMetadataColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := db.Begin()
 	if err != nil {
-		return errors.Wrap(err, "Fail to marshall AdminMetadata")
+		return err
 	}
 
-	_, err = tx.Exec(query, ns.Prefix, ns.Pubkey, ns.Identity, strAdminMetadata)
+	args := append([]interface{}{ns.Prefix, ns.Pubkey, ns.Identity}, namespaceAdminArgs(ns)...)
+	result, err := tx.Exec(query, args...)
+	if err == nil {
+		var id int64
+		if id, err = result.LastInsertId(); err == nil {
+			ns.ID = int(id)
+			err = auditNamespaceChange(tx, ns.ID, action, actor, "", nil, ns)
+		}
+	}
+	for kid, deadline := range retireAt {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(`INSERT INTO key_retirement (namespace_id, kid, retire_at) VALUES (?, ?, ?)`, ns.ID, kid, deadline.Unix())
+	}
 	if err != nil {
 		if errRoll := tx.Rollback(); errRoll != nil {
 			log.Errorln("Failed to rollback transaction:", errRoll)
@@ -579,7 +655,7 @@ func addNamespace(ns *Namespace) error {
 	return tx.Commit()
 }
 
-func updateNamespace(ns *Namespace) error {
+func updateNamespace(ns *Namespace, actor auditActor) error {
 	existingNs, err := getNamespaceById(ns.ID)
 	if err != nil || existingNs == nil {
 		return errors.Wrap(err, "Failed to get namespace")
@@ -601,71 +677,267 @@ func updateNamespace(ns *Namespace) error {
 	ns.AdminMetadata.ApprovedAt = existingNsAdmin.ApprovedAt
 	ns.AdminMetadata.ApproverID = existingNsAdmin.ApproverID
 	ns.AdminMetadata.UpdatedAt = time.Now()
-	strAdminMetadata, err := json.Marshal(ns.AdminMetadata)
-	if err != nil {
-		return errors.Wrap(err, "Fail to marshall AdminMetadata")
-	}
 
 	// We intentionally exclude updating "identity" as this should only be updated
 	// when user registered through Pelican client with identity
-	query := `UPDATE namespace SET prefix = ?, pubkey = ?, admin_metadata = ? WHERE id = ?`
+	query := `UPDATE namespace SET prefix = ?, pubkey = ?, description = ?, site_name = ?, institution = ?,
+	security_contact_user_id = ?, updated_at = ? WHERE id = ?`
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(query, ns.Prefix, ns.Pubkey, strAdminMetadata, ns.ID)
+	admin := ns.AdminMetadata
+	_, err = tx.Exec(query, ns.Prefix, ns.Pubkey, admin.Description, admin.SiteName, admin.Institution,
+		admin.SecurityContactUserID, formatDBTime(admin.UpdatedAt), ns.ID)
 	if err != nil {
 		if errRoll := tx.Rollback(); errRoll != nil {
 			log.Errorln("Failed to rollback transaction:", errRoll)
 		}
 		return errors.Wrap(err, "Failed to execute update query")
 	}
+	// Identity isn't updated, so the audit log shouldn't show it as cleared
+	updatedNs := *ns
+	updatedNs.Identity = existingNs.Identity
+	if err = auditNamespaceChange(tx, ns.ID, AuditUpdate, actor, "", existingNs, &updatedNs); err != nil {
+		if errRoll := tx.Rollback(); errRoll != nil {
+			log.Errorln("Failed to rollback transaction:", errRoll)
+		}
+		return err
+	}
 	return tx.Commit()
 }
 
-func updateNamespaceStatusById(id int, status RegistrationStatus, approverId string) error {
-	ns, err := getNamespaceById(id)
+// Set the status of a namespace, with the reason for the audit log; the actor's
+// ID is the approver if it's approved
+func updateNamespaceStatusById(id int, status RegistrationStatus, comment string, actor auditActor) error {
+	existingNs, err := getNamespaceById(id)
 	if err != nil {
 		return errors.Wrap(err, "Error getting namespace by id")
 	}
+	ns := *existingNs
 
 	ns.AdminMetadata.Status = status
 	ns.AdminMetadata.UpdatedAt = time.Now()
-	if status == Approved {
-		if approverId == "" {
+	action := AuditSetStatus
+	switch status {
+	case Approved:
+		if actor.ID == "" {
 			return errors.New("approverId can't be empty to approve")
 		}
-		ns.AdminMetadata.ApproverID = approverId
+		ns.AdminMetadata.ApproverID = actor.ID
 		ns.AdminMetadata.ApprovedAt = time.Now()
+		action = AuditApprove
+	case Denied:
+		action = AuditDeny
 	}
 
-	adminMetadataByte, err := json.Marshal(ns.AdminMetadata)
+	query := `UPDATE namespace SET status = ?, approver_id = ?, approved_at = ?, updated_at = ? WHERE id = ?`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	admin := ns.AdminMetadata
+	_, err = tx.Exec(query, string(admin.Status), admin.ApproverID, formatDBTime(admin.ApprovedAt), formatDBTime(admin.UpdatedAt), ns.ID)
 	if err != nil {
-		return errors.Wrap(err, "Error marshalling admin metadata")
+		if errRoll := tx.Rollback(); errRoll != nil {
+			log.Errorln("Failed to rollback transaction:", errRoll)
+		}
+		return errors.Wrap(err, "Failed to execute update query")
+	}
+	if err = auditNamespaceChange(tx, ns.ID, action, actor, comment, existingNs, &ns); err != nil {
+		if errRoll := tx.Rollback(); errRoll != nil {
+			log.Errorln("Failed to rollback transaction:", errRoll)
+		}
+		return err
 	}
+	return tx.Commit()
+}
 
-	query := `UPDATE namespace SET admin_metadata = ? WHERE id = ?`
+// Add newKey to the keyset of the namespace with the given id, and retire the
+// keys with the IDs in retiring at retireAt.  A key that's already being
+// retired keeps the earlier of its two deadlines.
+func rotateNamespaceKey(id int, newKey jwk.Key, retiring []string, retireAt time.Time, actor auditActor) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(query, string(adminMetadataByte), ns.ID)
-	if err != nil {
+	rollback := func() {
 		if errRoll := tx.Rollback(); errRoll != nil {
 			log.Errorln("Failed to rollback transaction:", errRoll)
 		}
+	}
+
+	var prefix, pubkeyStr string
+	if err = tx.QueryRow(`SELECT prefix, pubkey FROM namespace WHERE id = ?`, id).Scan(&prefix, &pubkeyStr); err != nil {
+		rollback()
+		return errors.Wrap(err, "Failed to get the namespace's pubkey")
+	}
+	set, err := jwk.ParseString(pubkeyStr)
+	if err != nil {
+		rollback()
+		return errors.Wrap(err, "Failed to parse pubkey as a jwks")
+	}
+	// This includes keys whose deadline has passed but haven't been removed yet
+	if _, found := set.LookupKeyID(newKey.KeyID()); found {
+		rollback()
+		return errors.Errorf("The key %s is already registered to the namespace", newKey.KeyID())
+	}
+	if err = set.AddKey(newKey); err != nil {
+		rollback()
+		return errors.Wrap(err, "Failed to add the new key to the namespace's jwks")
+	}
+	pubkeyBytes, err := json.Marshal(set)
+	if err != nil {
+		rollback()
+		return errors.Wrap(err, "Failed to marshal the namespace's jwks")
+	}
+
+	if _, err = tx.Exec(`UPDATE namespace SET pubkey = ? WHERE id = ?`, string(pubkeyBytes), id); err != nil {
+		rollback()
 		return errors.Wrap(err, "Failed to execute update query")
 	}
+	retireQuery := `
+	INSERT INTO key_retirement (namespace_id, kid, retire_at) VALUES (?, ?, ?)
+	ON CONFLICT (namespace_id, kid) DO UPDATE SET retire_at = min(retire_at, excluded.retire_at)
+	`
+	for _, kid := range retiring {
+		if _, err = tx.Exec(retireQuery, id, kid, retireAt.Unix()); err != nil {
+			rollback()
+			return errors.Wrap(err, "Failed to execute key retirement query")
+		}
+	}
+	before := map[string]interface{}{"pubkey": pubkeyStr}
+	after := map[string]interface{}{"pubkey": string(pubkeyBytes), "retiring": retiring, "retire_at": retireAt.UTC().Format(time.RFC3339)}
+	if err = insertAuditEntry(tx, id, prefix, AuditRotateKey, actor, "", before, after); err != nil {
+		rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Permanently remove the keys whose retirement deadline has passed from
+// their namespaces' keysets
+func retireNamespaceKeys() error {
+	query := `SELECT namespace_id, kid FROM key_retirement WHERE retire_at <= ?`
+	rows, err := db.Query(query, time.Now().Unix())
+	if err != nil {
+		return errors.Wrap(err, "error performing retired key query")
+	}
+	retired := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var kid string
+		if err := rows.Scan(&id, &kid); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "error scanning retired key")
+		}
+		retired[id] = append(retired[id], kid)
+	}
+	rows.Close()
+
+	for id, kids := range retired {
+		if err := removeNamespaceKeys(id, kids); err != nil {
+			return errors.Wrapf(err, "Failed to retire keys of namespace %d", id)
+		}
+		log.Infof("Retired %d key(s) of namespace %d", len(kids), id)
+	}
+	return nil
+}
+
+func removeNamespaceKeys(id int, kids []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	rollback := func() {
+		if errRoll := tx.Rollback(); errRoll != nil {
+			log.Errorln("Failed to rollback transaction:", errRoll)
+		}
+	}
+
+	var prefix, pubkeyStr string
+	err = tx.QueryRow(`SELECT prefix, pubkey FROM namespace WHERE id = ?`, id).Scan(&prefix, &pubkeyStr)
+	if err != nil && err != sql.ErrNoRows {
+		rollback()
+		return err
+	}
+	// The namespace may have been deleted since its keys were rotated
+	if err == nil {
+		set, err := jwk.ParseString(pubkeyStr)
+		if err != nil {
+			rollback()
+			return errors.Wrap(err, "Failed to parse pubkey as a jwks")
+		}
+		for _, kid := range kids {
+			if key, found := set.LookupKeyID(kid); found {
+				if err = set.RemoveKey(key); err != nil {
+					rollback()
+					return err
+				}
+			}
+		}
+		pubkeyBytes, err := json.Marshal(set)
+		if err != nil {
+			rollback()
+			return errors.Wrap(err, "Failed to marshal the namespace's jwks")
+		}
+		if _, err = tx.Exec(`UPDATE namespace SET pubkey = ? WHERE id = ?`, string(pubkeyBytes), id); err != nil {
+			rollback()
+			return errors.Wrap(err, "Failed to execute update query")
+		}
+		before := map[string]interface{}{"pubkey": pubkeyStr}
+		after := map[string]interface{}{"pubkey": string(pubkeyBytes)}
+		if err = insertAuditEntry(tx, id, prefix, AuditRetireKeys, registryActor, "", before, after); err != nil {
+			rollback()
+			return err
+		}
+	}
+
+	for _, kid := range kids {
+		if _, err = tx.Exec(`DELETE FROM key_retirement WHERE namespace_id = ? AND kid = ?`, id, kid); err != nil {
+			rollback()
+			return errors.Wrap(err, "Failed to execute deletion query")
+		}
+	}
 	return tx.Commit()
 }
 
-func deleteNamespace(prefix string) error {
+// Retire rotated keys once a minute until ctx is cancelled
+func PeriodicKeyRetirement(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := retireNamespaceKeys(); err != nil {
+				log.Warningf("Failed to retire rotated namespace keys: %s. Will try again later", err)
+			}
+		}
+	}
+}
+
+func deleteNamespace(prefix string, actor auditActor) error {
+	existingNs, err := getNamespaceByPrefix(prefix)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrap(err, "Failed to get namespace")
+	}
+
 	deleteQuery := `DELETE FROM namespace WHERE prefix = ?`
+	retirementQuery := `DELETE FROM key_retirement WHERE namespace_id IN (SELECT id FROM namespace WHERE prefix = ?)`
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(deleteQuery, prefix)
+	_, err = tx.Exec(retirementQuery, prefix)
+	if err == nil {
+		_, err = tx.Exec(deleteQuery, prefix)
+	}
+	// Deleting a namespace that doesn't exist changes nothing to audit
+	if err == nil && existingNs != nil {
+		err = auditNamespaceChange(tx, existingNs.ID, AuditDelete, actor, "", existingNs, nil)
+	}
 	if err != nil {
 		if errRoll := tx.Rollback(); errRoll != nil {
 			log.Errorln("Failed to rollback transaction:", errRoll)
@@ -675,8 +947,42 @@ func deleteNamespace(prefix string) error {
 	return tx.Commit()
 }
 
+// Delete a namespace if it's still pending, with the reason for the audit log,
+// returning whether it was.  The status is checked as part of the deletion so
+// a registration approved in the meantime is never removed.
+func expirePendingNamespace(ns *Namespace, comment string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	rollback := func() {
+		if errRoll := tx.Rollback(); errRoll != nil {
+			log.Errorln("Failed to rollback transaction:", errRoll)
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM namespace WHERE id = ? AND status = ?`, ns.ID, string(Pending))
+	if err != nil {
+		rollback()
+		return false, errors.Wrap(err, "Failed to execute deletion query")
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		rollback()
+		return false, err
+	}
+	if _, err = tx.Exec(`DELETE FROM key_retirement WHERE namespace_id = ?`, ns.ID); err != nil {
+		rollback()
+		return false, errors.Wrap(err, "Failed to execute deletion query")
+	}
+	if err = auditNamespaceChange(tx, ns.ID, AuditExpire, registryActor, comment, ns, nil); err != nil {
+		rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
 func getAllNamespaces() ([]*Namespace, error) {
-	query := `SELECT id, prefix, pubkey, identity, admin_metadata FROM namespace ORDER BY id ASC`
+	query := `SELECT ` + namespaceColumns + ` FROM namespace ORDER BY id ASC`
 	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
@@ -685,42 +991,51 @@ func getAllNamespaces() ([]*Namespace, error) {
 
 	namespaces := make([]*Namespace, 0)
 	for rows.Next() {
-		ns := &Namespace{}
-		adminMetadataStr := ""
-		if err := rows.Scan(&ns.ID, &ns.Prefix, &ns.Pubkey, &ns.Identity, &adminMetadataStr); err != nil {
+		ns, err := scanNamespace(rows)
+		if err != nil {
 			return nil, err
 		}
-		// For backward compatibility, if adminMetadata is an empty string, don't unmarshall json
-		if adminMetadataStr != "" {
-			if err := json.Unmarshal([]byte(adminMetadataStr), &ns.AdminMetadata); err != nil {
-				return nil, err
-			}
-		}
 		namespaces = append(namespaces, ns)
 	}
 
-	return namespaces, nil
+	return namespaces, rows.Err()
 }
 
-func InitializeDB(ctx context.Context) error {
+// The path of the registry database file, from Registry.DbLocation
+func getDBPath() (string, error) {
 	dbPath := param.Registry_DbLocation.GetString()
 	if dbPath == "" {
-		err := errors.New("Could not get path for the namespace registry database.")
+		return "", errors.New("Could not get path for the namespace registry database.")
+	}
+	if len(filepath.Ext(dbPath)) == 0 { // No fp extension, let's add .sqlite so it's obvious what the file is
+		dbPath += ".sqlite"
+	}
+	return dbPath, nil
+}
+
+// Write a consistent copy of the registry database to backupPath, which
+// must not exist.  It's safe to do while the registry is serving requests.
+func backupDB(backupPath string) error {
+	if _, err := db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return errors.Wrapf(err, "Failed to back up the registry database to %s", backupPath)
+	}
+	return nil
+}
+
+func InitializeDB(ctx context.Context) error {
+	dbPath, err := getDBPath()
+	if err != nil {
 		log.Fatal(err)
 		return err
 	}
 
 	// Before attempting to create the database, the path
 	// must exist or sql.Open will panic.
-	err := os.MkdirAll(filepath.Dir(dbPath), 0755)
+	err = os.MkdirAll(filepath.Dir(dbPath), 0755)
 	if err != nil {
 		return errors.Wrap(err, "Failed to create directory for namespace registry database")
 	}
 
-	if len(filepath.Ext(dbPath)) == 0 { // No fp extension, let's add .sqlite so it's obvious what the file is
-		dbPath += ".sqlite"
-	}
-
 	dbName := "file:" + dbPath + "?_busy_timeout=5000&_journal_mode=WAL"
 	log.Debugln("Opening connection to sqlite DB", dbName)
 	db, err = sql.Open("sqlite", dbName)
@@ -728,7 +1043,9 @@ func InitializeDB(ctx context.Context) error {
 		return errors.Wrapf(err, "Failed to open the database with path: %s", dbPath)
 	}
 
-	createNamespaceTable()
+	if err = migrateDB(dbPath); err != nil {
+		return err
+	}
 	return db.Ping()
 }
 
@@ -779,10 +1096,7 @@ func modifyTopologyTable(prefixes []string, mode string) error {
 
 // Create a table in the registry to store namespace prefixes from topology
 func PopulateTopology() error {
-	// Create the toplogy table
-	createTopologyTable()
-
-	// The topology table may already exist from before, it may not. Because of this
+	// The topology table may already be populated from before, it may not. Because of this
 	// we need to add to the table any prefixes that are in topology, delete from the
 	// table any that aren't in topology, and skip any that exist in both.
 
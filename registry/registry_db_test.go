@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,7 +45,7 @@ func setupMockRegistryDB(t *testing.T) {
 	mockDB, err := sql.Open("sqlite", ":memory:")
 	db = mockDB
 	require.NoError(t, err, "Error setting up mock namespace DB")
-	createNamespaceTable()
+	require.NoError(t, migrateDB(""), "Error migrating mock namespace DB")
 }
 
 func resetNamespaceDB(t *testing.T) {
@@ -58,21 +59,16 @@ func teardownMockNamespaceDB(t *testing.T) {
 }
 
 func insertMockDBData(nss []Namespace) error {
-	query := `INSERT INTO namespace (prefix, pubkey, identity, admin_metadata) VALUES (?, ?, ?, ?)`
+	query := `INSERT INTO namespace (prefix, pubkey, identity, ` + adminMetadataColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	for _, ns := range nss {
-		adminMetaStr, err := json.Marshal(ns.AdminMetadata)
-		if err != nil {
-			if errRoll := tx.Rollback(); errRoll != nil {
-				return errors.Wrap(errRoll, "Failed to rollback transaction")
-			}
-			return err
-		}
-
-		_, err = tx.Exec(query, ns.Prefix, ns.Pubkey, ns.Identity, adminMetaStr)
+		ns := ns
+		args := append([]interface{}{ns.Prefix, ns.Pubkey, ns.Identity}, namespaceAdminArgs(&ns)...)
+		_, err = tx.Exec(query, args...)
 		if err != nil {
 			if errRoll := tx.Rollback(); errRoll != nil {
 				return errors.Wrap(errRoll, "Failed to rollback transaction")
@@ -143,6 +139,8 @@ func mockNamespace(prefix, pubkey, identity string, adminMetadata AdminMetadata)
 // Some genertic mock data function to be shared with other test
 // functinos in this package. Please treat them as "constants"
 var (
+	mockActor = auditActor{ID: "tester", ClientIP: "127.0.0.1"}
+
 	mockNssWithOrigins []Namespace = []Namespace{
 		mockNamespace("/test1", "pubkey1", "", AdminMetadata{Status: Approved}),
 		mockNamespace("/test2", "pubkey2", "", AdminMetadata{Status: Approved}),
@@ -280,7 +278,7 @@ func TestAddNamespace(t *testing.T) {
 	t.Run("set-default-fields", func(t *testing.T) {
 		defer resetNamespaceDB(t)
 		mockNs := mockNamespace("/test", "pubkey", "identity", AdminMetadata{UserID: "someone"})
-		err := addNamespace(&mockNs)
+		err := addNamespace(&mockNs, mockActor)
 		require.NoError(t, err)
 		got, err := getAllNamespaces()
 		require.NoError(t, err)
@@ -298,7 +296,7 @@ func TestAddNamespace(t *testing.T) {
 		mockCreateAt := time.Now().Add(time.Hour * 10)
 		mockUpdatedAt := time.Now().Add(time.Minute * 20)
 		mockNs := mockNamespace("/test", "pubkey", "identity", AdminMetadata{UserID: "someone", CreatedAt: mockCreateAt, UpdatedAt: mockUpdatedAt})
-		err := addNamespace(&mockNs)
+		err := addNamespace(&mockNs, mockActor)
 		require.NoError(t, err)
 		got, err := getAllNamespaces()
 		require.NoError(t, err)
@@ -317,7 +315,7 @@ func TestAddNamespace(t *testing.T) {
 	t.Run("insert-data-integrity", func(t *testing.T) {
 		defer resetNamespaceDB(t)
 		mockNs := mockNamespace("/test", "pubkey", "identity", AdminMetadata{UserID: "someone", Description: "Some description", SiteName: "OSG", SecurityContactUserID: "security-001"})
-		err := addNamespace(&mockNs)
+		err := addNamespace(&mockNs, mockActor)
 		require.NoError(t, err)
 		got, err := getAllNamespaces()
 		require.NoError(t, err)
@@ -338,7 +336,7 @@ func TestUpdateNamespace(t *testing.T) {
 	t.Run("update-on-dne-entry-returns-error", func(t *testing.T) {
 		defer resetNamespaceDB(t)
 		mockNs := mockNamespace("/test", "", "", AdminMetadata{})
-		err := updateNamespace(&mockNs)
+		err := updateNamespace(&mockNs, mockActor)
 		assert.Error(t, err)
 	})
 
@@ -358,7 +356,7 @@ func TestUpdateNamespace(t *testing.T) {
 		initialNs.AdminMetadata.Status = Approved
 		initialNs.AdminMetadata.ApproverID = "hacker"
 		initialNs.AdminMetadata.ApprovedAt = time.Now().Add(10 * time.Hour)
-		err = updateNamespace(initialNs)
+		err = updateNamespace(initialNs, mockActor)
 		require.NoError(t, err)
 		finalNss, err := getAllNamespaces()
 		require.NoError(t, err)
@@ -382,7 +380,7 @@ func TestUpdateNamespaceStatusById(t *testing.T) {
 		defer resetNamespaceDB(t)
 		err := insertMockDBData(mockNssWithOrigins)
 		require.NoError(t, err)
-		err = updateNamespaceStatusById(100, Approved, "random")
+		err = updateNamespaceStatusById(100, Approved, "", auditActor{ID: "random"})
 		assert.Error(t, err)
 	})
 
@@ -396,7 +394,7 @@ func TestUpdateNamespaceStatusById(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, 1, len(got))
 		assert.Equal(t, mockNs.Prefix, got[0].Prefix)
-		err = updateNamespaceStatusById(got[0].ID, Approved, "")
+		err = updateNamespaceStatusById(got[0].ID, Approved, "", auditActor{ID: ""})
 		assert.Error(t, err)
 	})
 
@@ -410,7 +408,7 @@ func TestUpdateNamespaceStatusById(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, 1, len(got))
 		assert.Equal(t, mockNs.Prefix, got[0].Prefix)
-		err = updateNamespaceStatusById(got[0].ID, Approved, "approver1")
+		err = updateNamespaceStatusById(got[0].ID, Approved, "", auditActor{ID: "approver1"})
 		assert.NoError(t, err)
 		got, err = getAllNamespaces()
 		assert.NoError(t, err)
@@ -431,7 +429,7 @@ func TestUpdateNamespaceStatusById(t *testing.T) {
 		assert.NoError(t, err)
 		require.Equal(t, 1, len(got))
 		assert.Equal(t, mockNs.Prefix, got[0].Prefix)
-		err = updateNamespaceStatusById(got[0].ID, Denied, "approver1")
+		err = updateNamespaceStatusById(got[0].ID, Denied, "", auditActor{ID: "approver1"})
 		assert.NoError(t, err)
 		got, err = getAllNamespaces()
 		assert.NoError(t, err)
@@ -768,7 +766,7 @@ func TestRegistryTopology(t *testing.T) {
 		Identity:      "",
 		AdminMetadata: AdminMetadata{},
 	}
-	err = addNamespace(&ns)
+	err = addNamespace(&ns, mockActor)
 	require.NoError(t, err)
 
 	// Check that the regular namespace exists
@@ -836,7 +834,7 @@ func TestCacheAdminTrue(t *testing.T) {
 
 	adminTester := func(ns Namespace) func(t *testing.T) {
 		return func(t *testing.T) {
-			err = addNamespace(&ns)
+			err = addNamespace(&ns, mockActor)
 
 			require.NoError(t, err, "error adding test cache to registry database")
 
@@ -892,7 +890,7 @@ func TestCacheAdminFalse(t *testing.T) {
 
 	adminTester := func(ns Namespace) func(t *testing.T) {
 		return func(t *testing.T) {
-			err = addNamespace(&ns)
+			err = addNamespace(&ns, mockActor)
 			require.NoError(t, err, "error adding test cache to registry database")
 
 			// This will return a serverCredsError if the admin_approval == false check is triggered, which we want to happen
@@ -918,3 +916,49 @@ func TestCacheAdminFalse(t *testing.T) {
 
 	viper.Reset()
 }
+
+func TestRetireNamespaceKeys(t *testing.T) {
+	setupMockRegistryDB(t)
+	defer teardownMockNamespaceDB(t)
+
+	generateKey := func() (string, jwk.Key) {
+		jwks, err := GenerateMockJWKS()
+		require.NoError(t, err)
+		key, err := validateRotationKey(jwks)
+		require.NoError(t, err)
+		return jwks, key
+	}
+	oldJwks, oldKey := generateKey()
+	_, newKey := generateKey()
+	_, thirdKey := generateKey()
+	require.NoError(t, insertMockDBData([]Namespace{mockNamespace("/test", oldJwks, "", AdminMetadata{})}))
+	id, err := getLastNamespaceId()
+	require.NoError(t, err)
+
+	require.NoError(t, rotateNamespaceKey(id, newKey, []string{oldKey.KeyID()}, time.Now().Add(time.Hour), mockActor))
+	set, err := getNamespaceJwksById(id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, set.Len())
+	assert.Error(t, rotateNamespaceKey(id, newKey, []string{oldKey.KeyID()}, time.Now().Add(time.Hour), mockActor))
+
+	// Retiring a key again only ever brings its deadline forward
+	require.NoError(t, rotateNamespaceKey(id, thirdKey, []string{oldKey.KeyID(), newKey.KeyID()}, time.Now().Add(-time.Second), mockActor))
+	_, fourthKey := generateKey()
+	require.NoError(t, rotateNamespaceKey(id, fourthKey, []string{oldKey.KeyID()}, time.Now().Add(time.Hour), mockActor))
+	set, err = getNamespaceJwksById(id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, set.Len())
+	_, found := set.LookupKeyID(oldKey.KeyID())
+	assert.False(t, found)
+
+	// Retirement removes the keys from the stored JWKS for good
+	require.NoError(t, retireNamespaceKeys())
+	ns, err := getNamespaceById(id)
+	require.NoError(t, err)
+	stored, err := jwk.ParseString(ns.Pubkey)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stored.Len())
+	var retirements int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM key_retirement`).Scan(&retirements))
+	assert.Zero(t, retirements)
+}
@@ -0,0 +1,476 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"bytes"
+	"crypto"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/pelicanplatform/pelican/config"
+)
+
+type (
+	// A dump of every namespace in the registry, signed by the registry's
+	// issuer key so an import can check where it came from and that it
+	// hasn't been modified
+	RegistryExport struct {
+		Version    int                 `json:"version"`
+		ExportedAt time.Time           `json:"exported_at"`
+		Namespaces []ExportedNamespace `json:"namespaces"`
+		// A JWS with a detached payload: the export as JSON without its signature
+		Signature string `json:"signature,omitempty"`
+	}
+
+	ExportedNamespace struct {
+		Prefix        string        `json:"prefix"`
+		Pubkey        string        `json:"pubkey"`
+		Identity      string        `json:"identity,omitempty"`
+		AdminMetadata AdminMetadata `json:"admin_metadata"`
+		// The deadlines of the keys being rotated out of the namespace, by key id
+		KeyRetirements map[string]time.Time `json:"key_retirements,omitempty"`
+	}
+
+	ExportFormat string
+
+	ImportResult string
+
+	NamespaceImport struct {
+		Prefix string       `json:"prefix"`
+		Result ImportResult `json:"result"`
+		Reason string       `json:"reason,omitempty"`
+	}
+
+	ImportReport struct {
+		Namespaces []NamespaceImport `json:"namespaces"`
+		Imported   int               `json:"imported"`
+		Unchanged  int               `json:"unchanged"`
+		Conflicts  int               `json:"conflicts"`
+		Invalid    int               `json:"invalid"`
+	}
+)
+
+const (
+	ExportJSON ExportFormat = "json"
+	ExportYAML ExportFormat = "yaml"
+
+	// The namespace was added to the registry
+	ImportImported ImportResult = "imported"
+	// The namespace is already in the registry, exactly as exported
+	ImportUnchanged ImportResult = "unchanged"
+	// The prefix is already registered with a different key or metadata
+	ImportConflict ImportResult = "conflict"
+	// The namespace failed validation
+	ImportInvalid ImportResult = "invalid"
+)
+
+// The version of the export format written by this version of Pelican
+const registryExportVersion = 1
+
+// The format of an export file, from its extension, or JSON if it doesn't have a known one
+func ExportFormatOf(filename string) ExportFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return ExportYAML
+	default:
+		return ExportJSON
+	}
+}
+
+// The bytes the export's signature is over
+func (export *RegistryExport) signedPayload() ([]byte, error) {
+	unsigned := *export
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// Dump every namespace in the registry, signed with the issuer key
+func ExportNamespaces() (*RegistryExport, error) {
+	namespaces, err := getAllNamespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the namespaces in the registry")
+	}
+	export := &RegistryExport{
+		Version:    registryExportVersion,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+		Namespaces: make([]ExportedNamespace, 0, len(namespaces)),
+	}
+	for _, ns := range namespaces {
+		retireAt, err := getKeyRetirementsById(ns.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get the key retirements of namespace %s", ns.Prefix)
+		}
+		exported := ExportedNamespace{
+			Prefix:        ns.Prefix,
+			Pubkey:        ns.Pubkey,
+			Identity:      ns.Identity,
+			AdminMetadata: ns.AdminMetadata,
+		}
+		if len(retireAt) > 0 {
+			exported.KeyRetirements = make(map[string]time.Time, len(retireAt))
+			for kid, deadline := range retireAt {
+				exported.KeyRetirements[kid] = deadline.UTC()
+			}
+		}
+		export.Namespaces = append(export.Namespaces, exported)
+	}
+
+	key, err := config.GetIssuerPrivateJWK()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load the key to sign the export with")
+	}
+	payload, err := export.signedPayload()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal the export")
+	}
+	signature, err := jws.Sign(nil, jws.WithKey(jwa.ES256, key), jws.WithDetachedPayload(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign the export")
+	}
+	export.Signature = string(signature)
+	return export, nil
+}
+
+// Check the export was signed by one of the trusted keys and hasn't been modified since
+func (export *RegistryExport) Verify(trustedKeys jwk.Set) error {
+	if export.Signature == "" {
+		return errors.New("The export isn't signed")
+	}
+	payload, err := export.signedPayload()
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the export")
+	}
+	_, err = jws.Verify([]byte(export.Signature), jws.WithKeySet(trustedKeys, jws.WithInferAlgorithmFromKey(true),
+		jws.WithRequireKid(false)), jws.WithDetachedPayload(payload))
+	if err != nil {
+		return errors.Wrap(err, "The export's signature isn't valid for any trusted key")
+	}
+	return nil
+}
+
+// Write the export as JSON or YAML
+func WriteExport(writer io.Writer, export *RegistryExport, format ExportFormat) error {
+	jsonBytes, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case ExportJSON:
+		_, err = writer.Write(append(jsonBytes, '\n'))
+		return err
+	case ExportYAML:
+		// Go through JSON so the YAML has the same field names, which the
+		// signature depends on
+		var generic interface{}
+		if err = json.Unmarshal(jsonBytes, &generic); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(generic); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return errors.Errorf("Unknown export format %s", format)
+	}
+}
+
+// Read an export written by WriteExport in either format
+func ReadExport(reader io.Reader) (*RegistryExport, error) {
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is a subset of YAML
+	var generic interface{}
+	if err = yaml.Unmarshal(contents, &generic); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the export")
+	}
+	jsonBytes, err := json.Marshal(generic)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the export")
+	}
+	export := &RegistryExport{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(export); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the export")
+	}
+	if export.Version < 1 || export.Version > registryExportVersion {
+		return nil, errors.Errorf("The export has format version %d, but this version of Pelican only supports up to version %d",
+			export.Version, registryExportVersion)
+	}
+	return export, nil
+}
+
+// The number of components of a prefix, so superspaces sort before their subspaces
+func prefixDepth(prefix string) int {
+	return len(strings.Split(strings.Trim(prefix, "/"), "/"))
+}
+
+// Add the namespaces of an export signed by one of the trusted keys to the
+// registry, with importedBy as the actor in the audit log.
+//
+// Each namespace is checked like a new registration, and superspaces are
+// imported before their subspaces so key chaining can be checked.  A
+// namespace whose prefix is already registered is left alone and reported
+// as unchanged if it matches the export, or as a conflict if it doesn't.
+// Namespaces are imported one at a time, so an error partway leaves those
+// before it imported.
+func ImportNamespaces(export *RegistryExport, trustedKeys jwk.Set, importedBy string) (ImportReport, error) {
+	report := ImportReport{Namespaces: []NamespaceImport{}}
+	if err := export.Verify(trustedKeys); err != nil {
+		return report, err
+	}
+	actor := auditActor{ID: importedBy}
+
+	namespaces := make([]ExportedNamespace, len(export.Namespaces))
+	copy(namespaces, export.Namespaces)
+	sort.SliceStable(namespaces, func(i, j int) bool {
+		return prefixDepth(namespaces[i].Prefix) < prefixDepth(namespaces[j].Prefix)
+	})
+
+	for _, exported := range namespaces {
+		result, reason, err := importNamespace(exported, actor)
+		if err != nil {
+			return report, errors.Wrapf(err, "Failed to import namespace %s", exported.Prefix)
+		}
+		report.Namespaces = append(report.Namespaces, NamespaceImport{Prefix: exported.Prefix, Result: result, Reason: reason})
+		switch result {
+		case ImportImported:
+			report.Imported++
+		case ImportUnchanged:
+			report.Unchanged++
+		case ImportConflict:
+			report.Conflicts++
+		case ImportInvalid:
+			report.Invalid++
+		}
+	}
+	return report, nil
+}
+
+// Import a single namespace, returning how it went and why, or an error if
+// the registry failed
+func importNamespace(exported ExportedNamespace, actor auditActor) (result ImportResult, reason string, err error) {
+	prefix, err := validatePrefix(exported.Prefix)
+	if err != nil {
+		return ImportInvalid, err.Error(), nil
+	}
+	if prefix != exported.Prefix {
+		return ImportInvalid, fmt.Sprintf("The prefix isn't in its canonical form %s", prefix), nil
+	}
+	keySet, err := jwk.ParseString(exported.Pubkey)
+	if err != nil {
+		return ImportInvalid, "Failed to parse the pubkey as a JWKS: " + err.Error(), nil
+	}
+	if keySet.Len() == 0 {
+		return ImportInvalid, "The pubkey has no keys", nil
+	}
+	for kid := range exported.KeyRetirements {
+		if _, found := keySet.LookupKeyID(kid); !found {
+			return ImportInvalid, fmt.Sprintf("The key %s being retired isn't in the pubkey", kid), nil
+		}
+	}
+
+	existing, err := getNamespaceByPrefix(prefix)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", "", err
+	}
+	if existing != nil {
+		differences := []string{}
+		if !sameKeySet(existing.Pubkey, keySet) {
+			differences = append(differences, "pubkey")
+		}
+		if existing.Identity != exported.Identity {
+			differences = append(differences, "identity")
+		}
+		if !existing.AdminMetadata.Equal(exported.AdminMetadata) {
+			differences = append(differences, "admin_metadata")
+		}
+		if len(differences) == 0 {
+			return ImportUnchanged, "", nil
+		}
+		return ImportConflict, "Already registered with a different " + strings.Join(differences, ", "), nil
+	}
+
+	// Any one of the namespace's keys may be the one that chains it to its superspace
+	var validationErr error
+	for idx := 0; idx < keySet.Len(); idx++ {
+		key, _ := keySet.Key(idx)
+		var serverErr error
+		validationErr, serverErr = validateKeyChaining(prefix, key)
+		if serverErr != nil {
+			return "", "", serverErr
+		}
+		if validationErr == nil {
+			break
+		}
+	}
+	if validationErr != nil {
+		return ImportInvalid, validationErr.Error(), nil
+	}
+
+	ns := Namespace{
+		Prefix:        prefix,
+		Pubkey:        exported.Pubkey,
+		Identity:      exported.Identity,
+		AdminMetadata: exported.AdminMetadata,
+	}
+	if ns.AdminMetadata.Status == "" {
+		ns.AdminMetadata.Status = Pending
+	}
+	if err = insertNamespaceRetiringKeys(&ns, exported.KeyRetirements, AuditImport, actor); err != nil {
+		return "", "", err
+	}
+	return ImportImported, "", nil
+}
+
+// Whether the JWKS stored for a namespace has the same keys as keySet
+func sameKeySet(pubkey string, keySet jwk.Set) bool {
+	existing, err := jwk.ParseString(pubkey)
+	if err != nil || existing.Len() != keySet.Len() {
+		return false
+	}
+	for idx := 0; idx < keySet.Len(); idx++ {
+		key, _ := keySet.Key(idx)
+		thumbprint, err := key.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return false
+		}
+		found := false
+		for existingIdx := 0; existingIdx < existing.Len(); existingIdx++ {
+			existingKey, _ := existing.Key(existingIdx)
+			existingThumbprint, err := existingKey.Thumbprint(crypto.SHA256)
+			if err == nil && bytes.Equal(thumbprint, existingThumbprint) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Replace the registry database with the backup at backupPath.  The registry
+// must not be running.  The current database, if any, is kept next to it as
+// <file>.pre-restore.<timestamp>.bak, and the backup is migrated to the
+// latest schema when the registry next starts.
+func RestoreDB(backupPath string) error {
+	backup, err := sql.Open("sqlite", "file:"+backupPath+"?mode=ro")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open the backup %s", backupPath)
+	}
+	defer backup.Close()
+
+	var tables int
+	if err = backup.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'namespace'`).Scan(&tables); err != nil {
+		return errors.Wrapf(err, "Failed to read the backup %s", backupPath)
+	}
+	if tables == 0 {
+		return errors.Errorf("%s isn't a backup of a registry database", backupPath)
+	}
+	// Databases from before migrations have no schema version
+	version := 0
+	err = backup.QueryRow(`SELECT coalesce(max(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil && !strings.Contains(err.Error(), "no such table") {
+		return errors.Wrapf(err, "Failed to get the schema version of the backup %s", backupPath)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return errors.Errorf("The backup has schema version %d, newer than version %d, the latest this version "+
+			"of Pelican supports", version, len(migrations))
+	}
+	backup.Close()
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return errors.Wrap(err, "Failed to create directory for namespace registry database")
+	}
+	// Copy the backup next to the database first, so a failed copy leaves
+	// the current database where it is
+	tmpFile, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return errors.Wrap(err, "Failed to create a temporary file for the restored database")
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+	source, err := os.Open(backupPath)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	defer source.Close()
+	if _, err = io.Copy(tmpFile, source); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "Failed to copy the backup")
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "Failed to copy the backup")
+	}
+	if err = tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "Failed to copy the backup")
+	}
+	if err = os.Chmod(tmpPath, 0644); err != nil {
+		return errors.Wrap(err, "Failed to set the permissions of the restored database")
+	}
+
+	if _, err = os.Stat(dbPath); err == nil {
+		previousPath := fmt.Sprintf("%s.pre-restore.%s.bak", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+		log.Infof("Moving the current registry database to %s", previousPath)
+		// The write-ahead log and its index belong to the current database,
+		// and would corrupt the restored one
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err = os.Rename(dbPath+suffix, previousPath+suffix); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "Failed to move the current registry database")
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "Failed to check for a current registry database")
+	}
+	if err = os.Rename(tmpPath, dbPath); err != nil {
+		return errors.Wrap(err, "Failed to move the restored database into place")
+	}
+	return nil
+}
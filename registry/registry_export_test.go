@@ -0,0 +1,194 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pelicanplatform/pelican/config"
+)
+
+func TestExportImport(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("IssuerKey", filepath.Join(t.TempDir(), "issuer.jwk"))
+	viper.Set("Registry.RequireKeyChaining", true)
+	trustedKeys, err := config.GetIssuerPublicJWKS()
+	require.NoError(t, err)
+
+	setupMockRegistryDB(t)
+	ownerKey, err := GenerateMockJWKS()
+	require.NoError(t, err)
+	otherKey, err := GenerateMockJWKS()
+	require.NoError(t, err)
+	approvedAt := time.Date(2023, 12, 1, 10, 30, 0, 0, time.UTC)
+	// The subspace is exported before its superspace, and the invalid prefix
+	// could only be there if it was written to the database directly
+	require.NoError(t, insertMockDBData([]Namespace{
+		mockNamespace("/foo/bar", ownerKey, "", AdminMetadata{Status: Pending}),
+		mockNamespace("/foo", ownerKey, "identity", AdminMetadata{UserID: "owner", Institution: "uw", Status: Approved,
+			ApproverID: "admin", ApprovedAt: approvedAt}),
+		mockNamespace("/caches/cache", ownerKey, "", AdminMetadata{Status: Approved}),
+		mockNamespace("/api/bad", otherKey, "", AdminMetadata{Status: Approved}),
+	}))
+	// The owner's key is being rotated out of /foo
+	fooNs, err := getNamespaceByPrefix("/foo")
+	require.NoError(t, err)
+	ownerSet, err := jwk.ParseString(ownerKey)
+	require.NoError(t, err)
+	ownerJWK, ok := ownerSet.Key(0)
+	require.True(t, ok)
+	retireAt := time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+	_, err = db.Exec(`INSERT INTO key_retirement (namespace_id, kid, retire_at) VALUES (?, ?, ?)`, fooNs.ID, ownerJWK.KeyID(), retireAt.Unix())
+	require.NoError(t, err)
+
+	export, err := ExportNamespaces()
+	require.NoError(t, err)
+	assert.Equal(t, registryExportVersion, export.Version)
+	require.Len(t, export.Namespaces, 4)
+	require.NoError(t, export.Verify(trustedKeys))
+
+	for _, format := range []ExportFormat{ExportJSON, ExportYAML} {
+		t.Run(string(format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, WriteExport(buf, export, format))
+			read, err := ReadExport(buf)
+			require.NoError(t, err)
+			assert.Equal(t, export.Namespaces[1].Prefix, read.Namespaces[1].Prefix)
+			assert.True(t, export.Namespaces[1].AdminMetadata.Equal(read.Namespaces[1].AdminMetadata))
+			assert.Equal(t, map[string]time.Time{ownerJWK.KeyID(): retireAt}, read.Namespaces[1].KeyRetirements)
+			assert.NoError(t, read.Verify(trustedKeys))
+
+			// Any change breaks the signature
+			read.Namespaces[0].AdminMetadata.Status = Approved
+			assert.Error(t, read.Verify(trustedKeys))
+		})
+	}
+
+	otherSet, err := jwk.ParseString(otherKey)
+	require.NoError(t, err)
+	assert.Error(t, export.Verify(otherSet))
+	_, err = ReadExport(bytes.NewBufferString(`{"version": 2, "namespaces": []}`))
+	assert.ErrorContains(t, err, "format version 2")
+
+	// Import into a new registry where the cache is already registered with another key
+	teardownMockNamespaceDB(t)
+	setupMockRegistryDB(t)
+	defer teardownMockNamespaceDB(t)
+	require.NoError(t, insertMockDBData([]Namespace{mockNamespace("/caches/cache", otherKey, "", AdminMetadata{Status: Approved})}))
+
+	_, err = ImportNamespaces(export, otherSet, "importer")
+	require.Error(t, err)
+	_, err = getNamespaceByPrefix("/foo")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	report, err := ImportNamespaces(export, trustedKeys, "importer")
+	require.NoError(t, err)
+	results := map[string]NamespaceImport{}
+	for _, result := range report.Namespaces {
+		results[result.Prefix] = result
+	}
+	assert.Equal(t, ImportImported, results["/foo"].Result)
+	assert.Equal(t, ImportImported, results["/foo/bar"].Result)
+	assert.Equal(t, ImportConflict, results["/caches/cache"].Result)
+	assert.Contains(t, results["/caches/cache"].Reason, "pubkey")
+	assert.Equal(t, ImportInvalid, results["/api/bad"].Result)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 1, report.Conflicts)
+	assert.Equal(t, 1, report.Invalid)
+
+	ns, err := getNamespaceByPrefix("/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "identity", ns.Identity)
+	assert.Equal(t, Approved, ns.AdminMetadata.Status)
+	assert.Equal(t, "owner", ns.AdminMetadata.UserID)
+	assert.True(t, approvedAt.Equal(ns.AdminMetadata.ApprovedAt))
+	retirements, err := getKeyRetirementsById(ns.ID)
+	require.NoError(t, err)
+	require.Contains(t, retirements, ownerJWK.KeyID())
+	assert.True(t, retireAt.Equal(retirements[ownerJWK.KeyID()]))
+	entries, _, err := getAuditEntries(auditFilter{Prefix: "/foo", Action: AuditImport}, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "importer", entries[0].Actor)
+
+	// Importing again changes nothing
+	report, err = ImportNamespaces(export, trustedKeys, "importer")
+	require.NoError(t, err)
+	assert.Zero(t, report.Imported)
+	assert.Equal(t, 2, report.Unchanged)
+}
+
+func TestBackupRestoreDB(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "registry.sqlite")
+	viper.Set("Registry.DbLocation", dbPath)
+
+	var err error
+	db, err = sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	require.NoError(t, migrateDB(dbPath))
+	require.NoError(t, insertMockDBData([]Namespace{mockNamespace("/backed-up", "pubkey", "", AdminMetadata{Status: Approved})}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/backup", backupRegistryDB)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/backup", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("SQLite format 3")))
+	backupPath := filepath.Join(dir, "backup.sqlite")
+	require.NoError(t, os.WriteFile(backupPath, w.Body.Bytes(), 0644))
+
+	require.NoError(t, insertMockDBData([]Namespace{mockNamespace("/not-backed-up", "pubkey", "", AdminMetadata{})}))
+	require.NoError(t, ShutdownDB())
+
+	notBackup := filepath.Join(dir, "not-a-backup.sqlite")
+	require.NoError(t, os.WriteFile(notBackup, []byte("hello"), 0644))
+	assert.Error(t, RestoreDB(notBackup))
+
+	require.NoError(t, RestoreDB(backupPath))
+	previous, err := filepath.Glob(dbPath + ".pre-restore.*.bak")
+	require.NoError(t, err)
+	assert.Len(t, previous, 1)
+
+	db, err = sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer teardownMockNamespaceDB(t)
+	namespaces, err := getAllNamespaces()
+	require.NoError(t, err)
+	require.Len(t, namespaces, 1)
+	assert.Equal(t, "/backed-up", namespaces[0].Prefix)
+}
@@ -0,0 +1,160 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The migrations of the registry database schema, named <version>_<name>.sql.
+// Versions start at 1 and have no gaps; a migration is never changed once
+// released, so any change to the schema is a new migration.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// Load the embedded migrations in order of version
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read the registry database migrations")
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil {
+			return nil, errors.Errorf("Registry database migration %s isn't named <version>_<name>.sql", entry.Name())
+		}
+		query, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read registry database migration %s", entry.Name())
+		}
+		migrations = append(migrations, migration{version: version, name: name, query: string(query)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for idx, m := range migrations {
+		if m.version != idx+1 {
+			return nil, errors.Errorf("Registry database migrations are missing version %d", idx+1)
+		}
+	}
+	return migrations, nil
+}
+
+// The version of the schema of the registry database, 0 if no migrations
+// have been applied
+func schemaVersion() (int, error) {
+	query := `
+    CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at INTEGER NOT NULL
+    );`
+	if _, err := db.Exec(query); err != nil {
+		return 0, errors.Wrap(err, "Failed to create schema version table")
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT coalesce(max(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "Failed to get the schema version of the registry database")
+	}
+	return version, nil
+}
+
+// Bring the schema of the registry database up to the latest version,
+// applying each migration in its own transaction.
+//
+// If dbPath isn't empty and the database already has tables, it's first
+// backed up to dbPath.v<version>.<timestamp>.bak.  A database with a newer
+// schema than this version of Pelican knows is refused rather than risk
+// reading or writing it wrongly.
+func migrateDB(dbPath string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	current, err := schemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := len(migrations)
+	if current > latest {
+		return errors.Errorf("The registry database has schema version %d, newer than version %d, the latest "+
+			"this version of Pelican supports. Upgrade Pelican or restore the database from a backup", current, latest)
+	}
+	if current == latest {
+		log.Debugln("Registry database schema is up to date at version", current)
+		return nil
+	}
+
+	if dbPath != "" {
+		var tables int
+		query := `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')`
+		if err = db.QueryRow(query).Scan(&tables); err != nil {
+			return errors.Wrap(err, "Failed to check for existing tables in the registry database")
+		}
+		// A new database has nothing to lose
+		if tables > 0 {
+			backupPath := fmt.Sprintf("%s.v%d.%s.bak", dbPath, current, time.Now().UTC().Format("20060102T150405Z"))
+			log.Infof("Backing up the registry database to %s before migrating it", backupPath)
+			if err = backupDB(backupPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range migrations[current:] {
+		log.Infof("Migrating the registry database to schema version %d (%s)", m.version, m.name)
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(m.query)
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.version, m.name, time.Now().Unix())
+		}
+		if err != nil {
+			if errRoll := tx.Rollback(); errRoll != nil {
+				log.Errorln("Failed to rollback transaction:", errRoll)
+			}
+			return errors.Wrapf(err, "Failed to migrate the registry database to schema version %d (%s)", m.version, m.name)
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for idx, m := range migrations {
+		assert.Equal(t, idx+1, m.version)
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.query)
+	}
+}
+
+func TestMigrateDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "registry.sqlite")
+	var err error
+	db, err = sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer teardownMockNamespaceDB(t)
+
+	// A database from before migrations, with the admin metadata as JSON
+	_, err = db.Exec(`
+    CREATE TABLE namespace (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        prefix TEXT NOT NULL UNIQUE,
+        pubkey TEXT NOT NULL,
+        identity TEXT,
+        admin_metadata TEXT CHECK (length("admin_metadata") <= 4000)
+    );`)
+	require.NoError(t, err)
+	approvedAt := time.Date(2023, 12, 1, 10, 30, 0, 123456789, time.UTC)
+	_, err = db.Exec(`INSERT INTO namespace (prefix, pubkey, identity, admin_metadata) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		"/approved", "pubkey1", "identity", fmt.Sprintf(`{"user_id": "owner", "description": "A namespace", "institution": "uw",
+		"status": "Approved", "approver_id": "admin", "approved_at": %q, "created_at": "0001-01-01T00:00:00Z"}`, approvedAt.Format(time.RFC3339Nano)),
+		"/legacy", "pubkey2", nil, "")
+	require.NoError(t, err)
+
+	require.NoError(t, migrateDB(dbPath))
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	version, err := schemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	ns, err := getNamespaceByPrefix("/approved")
+	require.NoError(t, err)
+	assert.Equal(t, "identity", ns.Identity)
+	assert.Equal(t, "owner", ns.AdminMetadata.UserID)
+	assert.Equal(t, "A namespace", ns.AdminMetadata.Description)
+	assert.Equal(t, "uw", ns.AdminMetadata.Institution)
+	assert.Equal(t, Approved, ns.AdminMetadata.Status)
+	assert.Equal(t, "admin", ns.AdminMetadata.ApproverID)
+	assert.True(t, approvedAt.Equal(ns.AdminMetadata.ApprovedAt))
+	assert.True(t, ns.AdminMetadata.CreatedAt.IsZero())
+
+	ns, err = getNamespaceByPrefix("/legacy")
+	require.NoError(t, err)
+	assert.Equal(t, AdminMetadata{}, ns.AdminMetadata)
+	status, err := getNamespaceStatusById(ns.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Unknown, status)
+
+	// The database was backed up as it was before migrating
+	backups, err := filepath.Glob(dbPath + ".v0.*.bak")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	backup, err := sql.Open("sqlite", backups[0])
+	require.NoError(t, err)
+	defer backup.Close()
+	var adminMetadata string
+	require.NoError(t, backup.QueryRow(`SELECT admin_metadata FROM namespace WHERE prefix = '/approved'`).Scan(&adminMetadata))
+	assert.Contains(t, adminMetadata, "owner")
+
+	// Migrating an up-to-date database does nothing
+	require.NoError(t, migrateDB(dbPath))
+	backups, err = filepath.Glob(dbPath + ".v*.bak")
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+
+	// A database from a newer version of Pelican is refused
+	_, err = db.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', 0)`, len(migrations)+1)
+	require.NoError(t, err)
+	err = migrateDB(dbPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newer than version")
+}
+
+func TestMigrateNewDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "registry.sqlite")
+	var err error
+	db, err = sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer teardownMockNamespaceDB(t)
+
+	require.NoError(t, migrateDB(dbPath))
+	// There's nothing to back up in a new database
+	backups, err := filepath.Glob(dbPath + ".v*.bak")
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	ns := mockNamespace("/test", "pubkey", "", AdminMetadata{UserID: "owner", Institution: "uw"})
+	require.NoError(t, addNamespace(&ns, mockActor))
+	nss, err := getNamespacesByFilter(Namespace{AdminMetadata: AdminMetadata{Institution: "uw", Status: Pending}}, "")
+	require.NoError(t, err)
+	require.Len(t, nss, 1)
+	assert.Equal(t, "owner", nss[0].AdminMetadata.UserID)
+	assert.WithinDuration(t, time.Now(), nss[0].AdminMetadata.CreatedAt, time.Minute)
+}
@@ -0,0 +1,284 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pelicanplatform/pelican/config"
+	"github.com/pelicanplatform/pelican/param"
+)
+
+type (
+	notificationEvent string
+
+	// A notification of a change to a namespace registration, sent to the
+	// webhook as JSON and rendered into the notification emails.  The
+	// Recipients are the "sub" claims of the users to notify.
+	registryNotification struct {
+		Event       notificationEvent  `json:"event"`
+		NamespaceID int                `json:"namespace_id"`
+		Prefix      string             `json:"prefix"`
+		Status      RegistrationStatus `json:"status"`
+		Comment     string             `json:"comment,omitempty"`
+		Actor       string             `json:"actor"`
+		Recipients  []string           `json:"recipients"`
+		Time        time.Time          `json:"time"`
+	}
+
+	userEmail struct {
+		ID    string `mapstructure:"id"`
+		Email string `mapstructure:"email"`
+	}
+)
+
+const (
+	notifySubmitted notificationEvent = "submitted"
+	notifyApproved  notificationEvent = "approved"
+	notifyDenied    notificationEvent = "denied"
+	notifyExpired   notificationEvent = "expired"
+)
+
+// Build the notification of event for ns, to its requester, its security
+// contact and the admins
+func newNotification(event notificationEvent, ns *Namespace, comment string, actor string) registryNotification {
+	recipients := []string{}
+	seen := map[string]bool{}
+	candidates := append([]string{ns.AdminMetadata.UserID, ns.AdminMetadata.SecurityContactUserID}, param.Registry_AdminUsers.GetStringSlice()...)
+	for _, candidate := range candidates {
+		if candidate != "" && !seen[candidate] {
+			seen[candidate] = true
+			recipients = append(recipients, candidate)
+		}
+	}
+	return registryNotification{
+		Event:       event,
+		NamespaceID: ns.ID,
+		Prefix:      ns.Prefix,
+		Status:      ns.AdminMetadata.Status,
+		Comment:     comment,
+		Actor:       actor,
+		Recipients:  recipients,
+		Time:        time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Send the notification in the background, so the request that caused it
+// isn't held up by a slow relay or webhook
+func notify(notification registryNotification) {
+	go func() {
+		if err := sendNotification(notification); err != nil {
+			log.Warningf("Failed to send the notification that namespace %s was %s: %v", notification.Prefix, notification.Event, err)
+		}
+	}()
+}
+
+// Send the notification by email and to the webhook, whichever are configured
+func sendNotification(notification registryNotification) error {
+	var errs []string
+	if param.Registry_SMTPServer.GetString() != "" {
+		if err := sendNotificationEmails(notification); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if param.Registry_NotificationWebhookUrl.GetString() != "" {
+		if err := sendNotificationWebhook(notification); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// The email address of a user, from Registry.UserEmails or their ID if it's
+// an address itself, or empty if they don't have one
+func emailAddressOf(userID string, userEmails []userEmail) string {
+	for _, entry := range userEmails {
+		if entry.ID == userID {
+			return entry.Email
+		}
+	}
+	if address, err := mail.ParseAddress(userID); err == nil && address.Address == userID {
+		return userID
+	}
+	return ""
+}
+
+func (notification registryNotification) subject() string {
+	switch notification.Event {
+	case notifySubmitted:
+		return fmt.Sprintf("Namespace %s is waiting for approval", notification.Prefix)
+	case notifyExpired:
+		return fmt.Sprintf("The registration of namespace %s expired", notification.Prefix)
+	default:
+		return fmt.Sprintf("Namespace %s was %s", notification.Prefix, notification.Event)
+	}
+}
+
+func (notification registryNotification) body() string {
+	registry := param.Server_ExternalWebUrl.GetString()
+	body := &strings.Builder{}
+	switch notification.Event {
+	case notifySubmitted:
+		fmt.Fprintf(body, "Namespace %s was registered at the Pelican registry %s and is waiting for an admin to approve it.\r\n",
+			notification.Prefix, registry)
+	case notifyExpired:
+		fmt.Fprintf(body, "The registration of namespace %s at the Pelican registry %s was removed because it wasn't approved in time.\r\n",
+			notification.Prefix, registry)
+	default:
+		fmt.Fprintf(body, "The registration of namespace %s at the Pelican registry %s was %s by %s.\r\n",
+			notification.Prefix, registry, notification.Event, notification.Actor)
+	}
+	if notification.Comment != "" {
+		fmt.Fprintf(body, "\r\nComment: %s\r\n", notification.Comment)
+	}
+	return body.String()
+}
+
+// Email the notification to each recipient with a known address, one message each
+// so that recipients don't see each other's addresses
+func sendNotificationEmails(notification registryNotification) error {
+	userEmails := []userEmail{}
+	if err := param.Registry_UserEmails.Unmarshal(&userEmails); err != nil {
+		return errors.Wrap(err, "Failed to parse Registry.UserEmails")
+	}
+	server := param.Registry_SMTPServer.GetString()
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid Registry.SMTPServer %s", server)
+	}
+	var auth smtp.Auth
+	if username := param.Registry_SMTPUsername.GetString(); username != "" {
+		password, err := os.ReadFile(param.Registry_SMTPPasswordFile.GetString())
+		if err != nil {
+			return errors.Wrap(err, "Failed to read Registry.SMTPPasswordFile")
+		}
+		auth = smtp.PlainAuth("", username, strings.TrimSpace(string(password)), host)
+	}
+	sender := param.Registry_NotificationSender.GetString()
+	if sender == "" {
+		sender = "pelican-registry@" + param.Server_Hostname.GetString()
+	}
+
+	var errs []string
+	for _, recipient := range notification.Recipients {
+		address := emailAddressOf(recipient, userEmails)
+		if address == "" {
+			log.Debugf("Not emailing %s about namespace %s: no email address", recipient, notification.Prefix)
+			continue
+		}
+		message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n"+
+			"Content-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+			sender, address, notification.subject(), notification.Time.Format(time.RFC1123Z), notification.body())
+		if err := smtp.SendMail(server, auth, sender, []string{address}, []byte(message)); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to email %s: %v", address, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// POST the notification as JSON to Registry.NotificationWebhookUrl
+func sendNotificationWebhook(notification registryNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the notification")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	webhookUrl := param.Registry_NotificationWebhookUrl.GetString()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Transport: config.GetTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Failed to call the notification webhook")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("The notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Remove the registrations that have been pending for longer than
+// Registry.PendingExpiration, notifying those involved
+func expirePendingNamespaces() error {
+	expiration := param.Registry_PendingExpiration.GetDuration()
+	if expiration <= 0 {
+		return nil
+	}
+	pending, err := getNamespacesByFilter(Namespace{AdminMetadata: AdminMetadata{Status: Pending}}, "")
+	if err != nil {
+		return errors.Wrap(err, "Failed to get the pending namespaces")
+	}
+	cutoff := time.Now().Add(-expiration)
+	for _, ns := range pending {
+		// Namespaces registered before admin metadata existed have no creation time
+		if ns.AdminMetadata.CreatedAt.IsZero() || ns.AdminMetadata.CreatedAt.After(cutoff) {
+			continue
+		}
+		comment := fmt.Sprintf("Pending for longer than %s", expiration)
+		expired, err := expirePendingNamespace(ns, comment)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to expire namespace %s", ns.Prefix)
+		}
+		if expired {
+			log.Infof("Removed namespace %s: its registration was pending for longer than %s", ns.Prefix, expiration)
+			notify(newNotification(notifyExpired, ns, comment, registryActor.ID))
+		}
+	}
+	return nil
+}
+
+// Expire stale pending registrations once an hour until ctx is cancelled
+func PeriodicPendingExpiration(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := expirePendingNamespaces(); err != nil {
+				log.Warningf("Failed to expire pending namespace registrations: %s. Will try again later", err)
+			}
+		}
+	}
+}
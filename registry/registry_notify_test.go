@@ -0,0 +1,305 @@
+/***************************************************************
+ *
+ * Copyright (C) 2023, Pelican Project, Morgridge Institute for Research
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you
+ * may not use this file except in compliance with the License.  You may
+ * obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ ***************************************************************/
+
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEmail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Start an SMTP server that accepts every message and sends it to the channel
+func startFakeSMTPServer(t *testing.T) (string, <-chan fakeEmail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	emails := make(chan fakeEmail, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSMTP(conn, emails)
+		}
+	}()
+	return listener.Addr().String(), emails
+}
+
+func serveFakeSMTP(conn net.Conn, emails chan<- fakeEmail) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 localhost fake SMTP server")
+	email := fakeEmail{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			email.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			email.To = append(email.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data := &strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			email.Data = data.String()
+			emails <- email
+			email = fakeEmail{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// Start a webhook that sends every notification it receives to the channel
+func startFakeWebhook(t *testing.T) <-chan registryNotification {
+	notifications := make(chan registryNotification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notification := registryNotification{}
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		notifications <- notification
+	}))
+	t.Cleanup(server.Close)
+	viper.Set("Registry.NotificationWebhookUrl", server.URL)
+	return notifications
+}
+
+func receiveNotification(t *testing.T, notifications <-chan registryNotification) registryNotification {
+	select {
+	case notification := <-notifications:
+		return notification
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "Timed out waiting for a notification")
+		return registryNotification{}
+	}
+}
+
+func TestSendNotificationEmails(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	server, emails := startFakeSMTPServer(t)
+	viper.Set("Registry.SMTPServer", server)
+	viper.Set("Registry.NotificationSender", "registry@example.com")
+	viper.Set("Registry.AdminUsers", []string{"admin-sub", "admin@example.com"})
+	viper.Set("Registry.UserEmails", []map[string]string{{"id": "admin-sub", "email": "boss@example.com"}})
+
+	ns := &Namespace{ID: 1, Prefix: "/foo", AdminMetadata: AdminMetadata{
+		UserID:                "http://cilogon.org/serverA/users/1",
+		SecurityContactUserID: "security@example.com",
+		Status:                Denied,
+	}}
+	notification := newNotification(notifyDenied, ns, "Not a real institution", "admin-sub")
+	assert.Equal(t, []string{"http://cilogon.org/serverA/users/1", "security@example.com", "admin-sub", "admin@example.com"},
+		notification.Recipients)
+	require.NoError(t, sendNotification(notification))
+
+	// The requester has no known address, and everyone else gets their own email
+	recipients := []string{}
+	for idx := 0; idx < 3; idx++ {
+		email := <-emails
+		assert.Equal(t, "registry@example.com", email.From)
+		require.Len(t, email.To, 1)
+		recipients = append(recipients, email.To[0])
+		assert.Contains(t, email.Data, "Subject: Namespace /foo was denied")
+		assert.Contains(t, email.Data, "Comment: Not a real institution")
+	}
+	assert.ElementsMatch(t, []string{"security@example.com", "boss@example.com", "admin@example.com"}, recipients)
+	assert.Empty(t, emails)
+}
+
+func TestNotificationWebhook(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	notifications := startFakeWebhook(t)
+
+	ns := &Namespace{ID: 2, Prefix: "/bar", AdminMetadata: AdminMetadata{UserID: "owner", Status: Pending}}
+	notify(newNotification(notifySubmitted, ns, "", "owner"))
+	notification := receiveNotification(t, notifications)
+	assert.Equal(t, notifySubmitted, notification.Event)
+	assert.Equal(t, 2, notification.NamespaceID)
+	assert.Equal(t, "/bar", notification.Prefix)
+	assert.Equal(t, Pending, notification.Status)
+	assert.Equal(t, []string{"owner"}, notification.Recipients)
+
+	// A webhook that fails is reported
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	viper.Set("Registry.NotificationWebhookUrl", failing.URL)
+	assert.Error(t, sendNotification(notification))
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	setupMockRegistryDB(t)
+	defer teardownMockNamespaceDB(t)
+	notifications := startFakeWebhook(t)
+
+	ns := mockNamespace("/foo", "pubkey", "", AdminMetadata{UserID: "owner", SecurityContactUserID: "security"})
+	require.NoError(t, addNamespace(&ns, auditActor{ID: "owner"}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Set("User", ctx.GetHeader("X-Test-User"))
+	})
+	router.PATCH("/namespaces/:id/approve", func(ctx *gin.Context) {
+		updateNamespaceStatus(ctx, Approved)
+	})
+	router.PATCH("/namespaces/:id/deny", func(ctx *gin.Context) {
+		updateNamespaceStatus(ctx, Denied)
+	})
+	router.GET("/namespaces/:id/history", getNamespaceStatusHistory)
+	request := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	basePath := fmt.Sprintf("/namespaces/%d", ns.ID)
+
+	// A decision needs a comment
+	w := request(http.MethodPatch, basePath+"/deny", "admin", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(http.MethodPatch, basePath+"/deny", "admin", `{"comment": "  "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(http.MethodPatch, basePath+"/deny", "admin", `{"comment": "Which institution is this?"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	notification := receiveNotification(t, notifications)
+	assert.Equal(t, notifyDenied, notification.Event)
+	assert.Equal(t, Denied, notification.Status)
+	assert.Equal(t, "Which institution is this?", notification.Comment)
+	assert.Equal(t, "admin", notification.Actor)
+	assert.Equal(t, []string{"owner", "security"}, notification.Recipients)
+
+	w = request(http.MethodPatch, basePath+"/approve", "admin", `{"comment": "Confirmed by email"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	notification = receiveNotification(t, notifications)
+	assert.Equal(t, notifyApproved, notification.Event)
+	assert.Equal(t, Approved, notification.Status)
+
+	// The requester can follow the decisions on their registration
+	w = request(http.MethodGet, basePath+"/history", "owner", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	history := []StatusChange{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 3)
+	assert.Equal(t, Pending, history[0].Status)
+	assert.Equal(t, "owner", history[0].Actor)
+	assert.Equal(t, Denied, history[1].Status)
+	assert.Equal(t, "Which institution is this?", history[1].Comment)
+	assert.Equal(t, Approved, history[2].Status)
+	assert.Equal(t, "Confirmed by email", history[2].Comment)
+
+	w = request(http.MethodGet, basePath+"/history", "someone-else", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestExpirePendingNamespaces(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	setupMockRegistryDB(t)
+	defer teardownMockNamespaceDB(t)
+	notifications := startFakeWebhook(t)
+	viper.Set("Registry.PendingExpiration", "24h")
+
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, insertMockDBData([]Namespace{
+		mockNamespace("/stale", "pubkey", "", AdminMetadata{UserID: "owner", Status: Pending, CreatedAt: old}),
+		mockNamespace("/recent", "pubkey", "", AdminMetadata{Status: Pending, CreatedAt: time.Now()}),
+		mockNamespace("/approved", "pubkey", "", AdminMetadata{Status: Approved, CreatedAt: old}),
+		mockNamespace("/legacy", "pubkey", "", AdminMetadata{}),
+	}))
+
+	require.NoError(t, expirePendingNamespaces())
+	namespaces, err := getAllNamespaces()
+	require.NoError(t, err)
+	prefixes := []string{}
+	for _, ns := range namespaces {
+		prefixes = append(prefixes, ns.Prefix)
+	}
+	assert.Equal(t, []string{"/recent", "/approved", "/legacy"}, prefixes)
+
+	entries, _, err := getAuditEntries(auditFilter{Action: AuditExpire}, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/stale", entries[0].Prefix)
+	assert.Equal(t, registryActor.ID, entries[0].Actor)
+	assert.Contains(t, entries[0].Comment, "24h")
+
+	notification := receiveNotification(t, notifications)
+	assert.Equal(t, notifyExpired, notification.Event)
+	assert.Equal(t, "/stale", notification.Prefix)
+	assert.Equal(t, []string{"owner"}, notification.Recipients)
+
+	// Expiration can be turned off
+	viper.Set("Registry.PendingExpiration", "0s")
+	require.NoError(t, insertMockDBData([]Namespace{mockNamespace("/stale", "pubkey", "", AdminMetadata{Status: Pending, CreatedAt: old})}))
+	require.NoError(t, expirePendingNamespaces())
+	_, err = getNamespaceByPrefix("/stale")
+	assert.NoError(t, err)
+}
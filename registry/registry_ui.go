@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -44,6 +46,32 @@ type (
 		Status string `form:"status"`
 	}
 
+	updateNamespaceStatusRequest struct {
+		Comment string `json:"comment"`
+	}
+
+	listAuditRequest struct {
+		Prefix   string    `form:"prefix"`
+		Actor    string    `form:"actor"`
+		Action   string    `form:"action"`
+		From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+		To       time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+		Page     int       `form:"page"`
+		PageSize int       `form:"page_size"`
+	}
+
+	listAuditResponse struct {
+		Entries  []AuditEntry `json:"entries"`
+		Total    int          `json:"total"`
+		Page     int          `json:"page"`
+		PageSize int          `json:"page_size"`
+	}
+
+	addNamespaceKeyRequest struct {
+		Pubkey  string `json:"pubkey" binding:"required"`
+		Overlap string `json:"overlap"`
+	}
+
 	registrationFieldType string
 	registrationField     struct {
 		Name     string                `json:"name"`
@@ -58,6 +86,11 @@ type (
 	}
 )
 
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
 const (
 	String   registrationFieldType = "string"
 	Int      registrationFieldType = "int"
@@ -355,11 +388,12 @@ func createUpdateNamespace(ctx *gin.Context, isUpdate bool) {
 		ns.AdminMetadata.UserID = user
 		// Overwrite status to Pending to filter malicious request
 		ns.AdminMetadata.Status = Pending
-		if err := addNamespace(&ns); err != nil {
+		if err := addNamespace(&ns, userActor(ctx)); err != nil {
 			log.Errorf("Failed to insert namespace with id %d. %v", ns.ID, err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Fail to insert namespace"})
 			return
 		}
+		notify(newNotification(notifySubmitted, &ns, "", user))
 		ctx.JSON(http.StatusOK, gin.H{"msg": "success"})
 	} else { // Update
 		// First check if the namespace exists
@@ -402,7 +436,7 @@ func createUpdateNamespace(ctx *gin.Context, isUpdate bool) {
 			}
 		}
 		// If the user has previlege to udpate, go ahead
-		if err := updateNamespace(&ns); err != nil {
+		if err := updateNamespace(&ns, userActor(ctx)); err != nil {
 			log.Errorf("Failed to update namespace with id %d. %v", ns.ID, err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Fail to update namespace"})
 			return
@@ -459,8 +493,9 @@ func getNamespace(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, ns)
 }
 
+// Approve or deny a namespace with a comment explaining why, and notify its
+// requester, security contact and the admins
 func updateNamespaceStatus(ctx *gin.Context, status RegistrationStatus) {
-	user := ctx.GetString("User")
 	idStr := ctx.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
@@ -468,6 +503,11 @@ func updateNamespaceStatus(ctx *gin.Context, status RegistrationStatus) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format. ID must a non-zero integer"})
 		return
 	}
+	reqBody := updateNamespaceStatusRequest{}
+	if err := ctx.ShouldBindJSON(&reqBody); err != nil || strings.TrimSpace(reqBody.Comment) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A comment explaining the decision is required"})
+		return
+	}
 	exists, err := namespaceExistsById(id)
 	if err != nil {
 		log.Error("Error checking if namespace exists: ", err)
@@ -480,14 +520,67 @@ func updateNamespaceStatus(ctx *gin.Context, status RegistrationStatus) {
 		return
 	}
 
-	if err = updateNamespaceStatusById(id, status, user); err != nil {
+	actor := userActor(ctx)
+	comment := strings.TrimSpace(reqBody.Comment)
+	if err = updateNamespaceStatusById(id, status, comment, actor); err != nil {
 		log.Error("Error updating namespace status by ID:", id, " to status:", status)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update namespace"})
 		return
 	}
+	if ns, err := getNamespaceById(id); err != nil {
+		log.Errorf("Failed to get namespace %d to notify of its new status: %v", id, err)
+	} else if status == Approved {
+		notify(newNotification(notifyApproved, ns, comment, actor.ID))
+	} else if status == Denied {
+		notify(newNotification(notifyDenied, ns, comment, actor.ID))
+	}
 	ctx.JSON(http.StatusOK, gin.H{"msg": "ok"})
 }
 
+// List the changes to the status of a namespace, for its owner or an admin
+func getNamespaceStatusHistory(ctx *gin.Context) {
+	user := ctx.GetString("User")
+	idStr := ctx.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		// Handle the error if id is not a valid integer
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format. ID must a non-zero integer"})
+		return
+	}
+	exists, err := namespaceExistsById(id)
+	if err != nil {
+		log.Error("Error checking if namespace exists: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if namespace exists"})
+		return
+	}
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+		return
+	}
+
+	isAdmin, _ := web_ui.CheckAdmin(user)
+	if !isAdmin {
+		found, err := namespaceBelongsToUserId(id, user)
+		if err != nil {
+			log.Error("Error checking if namespace belongs to the user: ", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if namespace belongs to the user"})
+			return
+		}
+		if !found {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Namespace not found. Check the id or if you own the namespace"})
+			return
+		}
+	}
+
+	history, err := getStatusHistory(id)
+	if err != nil {
+		log.Error("Error getting namespace status history: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting namespace status history"})
+		return
+	}
+	ctx.JSON(http.StatusOK, history)
+}
+
 func getNamespaceJWKS(ctx *gin.Context) {
 	idStr := ctx.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -521,6 +614,183 @@ func getNamespaceJWKS(ctx *gin.Context) {
 	ctx.Data(200, "application/json", jsonData)
 }
 
+// List the keys of a namespace, with the retirement deadline of any being
+// rotated out. Admin can see any namespace's keys while non-admin can only see
+// his/her namespace's
+//
+// GET /namespaces/:id/keys
+func listNamespaceKeys(ctx *gin.Context) {
+	user := ctx.GetString("User")
+	idStr := ctx.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		// Handle the error if id is not a valid integer
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format. ID must a non-zero integer"})
+		return
+	}
+	exists, err := namespaceExistsById(id)
+	if err != nil {
+		log.Error("Error checking if namespace exists: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if namespace exists"})
+		return
+	}
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+		return
+	}
+
+	isAdmin, _ := web_ui.CheckAdmin(user)
+	if !isAdmin {
+		found, err := namespaceBelongsToUserId(id, user)
+		if err != nil {
+			log.Error("Error checking if namespace belongs to the user: ", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if namespace belongs to the user"})
+			return
+		}
+		if !found {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Namespace not found. Check the id or if you own the namespace"})
+			return
+		}
+	}
+
+	keys, err := getNamespaceKeysById(id)
+	if err != nil {
+		log.Error("Error getting namespace keys: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting namespace keys"})
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// Add a key to a namespace and retire all of its current keys after the
+// overlap. With an overlap of "0s", a compromised key is retired immediately.
+//
+// POST /namespaces/:id/keys
+func addNamespaceKey(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		// Handle the error if id is not a valid integer
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format. ID must a non-zero integer"})
+		return
+	}
+	req := addNamespaceKeyRequest{}
+	if ctx.ShouldBindJSON(&req) != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid add namespace key request"})
+		return
+	}
+	newKey, err := validateRotationKey(req.Pubkey)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("Error: Field validation for pubkey failed: ", err)})
+		return
+	}
+	overlap, err := validateRotationOverlap(req.Overlap)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("Error: Field validation for overlap failed: ", err)})
+		return
+	}
+
+	exists, err := namespaceExistsById(id)
+	if err != nil {
+		log.Error("Error checking if namespace exists: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking if namespace exists"})
+		return
+	}
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Namespace not found"})
+		return
+	}
+	keys, err := getNamespaceKeysById(id)
+	if err != nil {
+		log.Error("Error getting namespace keys: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting namespace keys"})
+		return
+	}
+	retiring := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key.KeyID == newKey.KeyID() {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The key %s is already registered to the namespace", key.KeyID)})
+			return
+		}
+		retiring = append(retiring, key.KeyID)
+	}
+
+	if err = rotateNamespaceKey(id, newKey, retiring, time.Now().Add(overlap), userActor(ctx)); err != nil {
+		log.Errorf("Failed to rotate the key of namespace with id %d: %v", id, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate namespace key"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"msg": "success"})
+}
+
+// List the registry's audit log, newest first, a page at a time.
+//
+// Query against prefix, actor, action, and a time range of from and to in RFC 3339.
+// Pages start at 1, with page_size entries each
+//
+// GET /audit
+func listAuditEntries(ctx *gin.Context) {
+	queryParams := listAuditRequest{}
+	if err := ctx.ShouldBindQuery(&queryParams); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprint("Invalid query parameters: ", err)})
+		return
+	}
+	if queryParams.Page == 0 {
+		queryParams.Page = 1
+	}
+	if queryParams.PageSize == 0 {
+		queryParams.PageSize = defaultAuditPageSize
+	}
+	if queryParams.Page < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+		return
+	}
+	if queryParams.PageSize < 0 || queryParams.PageSize > maxAuditPageSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page_size must be between 1 and %d", maxAuditPageSize)})
+		return
+	}
+
+	filter := auditFilter{
+		Prefix: queryParams.Prefix,
+		Actor:  queryParams.Actor,
+		Action: AuditAction(queryParams.Action),
+		From:   queryParams.From,
+		To:     queryParams.To,
+	}
+	entries, total, err := getAuditEntries(filter, queryParams.Page, queryParams.PageSize)
+	if err != nil {
+		log.Error("Failed to get audit log entries: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Server encountered an error trying to list the audit log"})
+		return
+	}
+	ctx.JSON(http.StatusOK, listAuditResponse{
+		Entries:  entries,
+		Total:    total,
+		Page:     queryParams.Page,
+		PageSize: queryParams.PageSize,
+	})
+}
+
+// Download a consistent copy of the registry database, taken while the
+// registry keeps serving requests
+func backupRegistryDB(ctx *gin.Context) {
+	dir, err := os.MkdirTemp("", "pelican-registry-backup")
+	if err != nil {
+		log.Error("Failed to create a directory for the registry backup: ", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Server encountered an error backing up the registry"})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	backupPath := filepath.Join(dir, "registry.sqlite")
+	if err = backupDB(backupPath); err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Server encountered an error backing up the registry"})
+		return
+	}
+	ctx.FileAttachment(backupPath, fmt.Sprintf("registry-backup-%s.sqlite", time.Now().UTC().Format("20060102T150405Z")))
+}
+
 func listInstitutions(ctx *gin.Context) {
 	institutions := []Institution{}
 	if err := param.Registry_Institutions.Unmarshal(&institutions); err != nil {
@@ -563,6 +833,9 @@ func RegisterRegistryWebAPI(router *gin.RouterGroup) error {
 			createUpdateNamespace(ctx, true)
 		})
 		registryWebAPI.GET("/namespaces/:id/pubkey", getNamespaceJWKS)
+		registryWebAPI.GET("/namespaces/:id/keys", web_ui.AuthHandler, listNamespaceKeys)
+		registryWebAPI.GET("/namespaces/:id/history", web_ui.AuthHandler, getNamespaceStatusHistory)
+		registryWebAPI.POST("/namespaces/:id/keys", web_ui.AuthHandler, web_ui.AdminAuthHandler, addNamespaceKey)
 		registryWebAPI.PATCH("/namespaces/:id/approve", web_ui.AuthHandler, web_ui.AdminAuthHandler, func(ctx *gin.Context) {
 			updateNamespaceStatus(ctx, Approved)
 		})
@@ -573,5 +846,9 @@ func RegisterRegistryWebAPI(router *gin.RouterGroup) error {
 	{
 		registryWebAPI.GET("/institutions", web_ui.AuthHandler, listInstitutions)
 	}
+	{
+		registryWebAPI.GET("/audit", web_ui.AuthHandler, web_ui.AdminAuthHandler, listAuditEntries)
+		registryWebAPI.GET("/backup", web_ui.AuthHandler, web_ui.AdminAuthHandler, backupRegistryDB)
+	}
 	return nil
 }
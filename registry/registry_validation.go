@@ -21,6 +21,7 @@ package registry
 import (
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/pelicanplatform/pelican/param"
@@ -151,6 +152,42 @@ func validateJwks(jwksStr string) (jwk.Key, error) {
 	return key, nil
 }
 
+// Validate a single-key JWKS to be added to a namespace by a key rotation,
+// returning its public key with a key ID assigned
+func validateRotationKey(jwksStr string) (jwk.Key, error) {
+	key, err := validateJwks(jwksStr)
+	if err != nil {
+		return nil, err
+	}
+	// Never store a private key, should one be sent by mistake
+	pubkey, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't get the public key of the new key")
+	}
+	if pubkey.KeyID() == "" {
+		if err = jwk.AssignKeyID(pubkey); err != nil {
+			return nil, errors.Wrap(err, "Failed to assign key ID to the new key")
+		}
+	}
+	return pubkey, nil
+}
+
+// Validate how long the old keys stay valid after a key rotation, where an
+// empty string means Registry.KeyRotationOverlap
+func validateRotationOverlap(overlap string) (time.Duration, error) {
+	if overlap == "" {
+		return param.Registry_KeyRotationOverlap.GetDuration(), nil
+	}
+	duration, err := time.ParseDuration(overlap)
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid overlap")
+	}
+	if duration < 0 {
+		return 0, errors.New("Overlap can't be negative")
+	}
+	return duration, nil
+}
+
 // Validates if the instID, the id of the institution, matches the provided Registy.Institutions items.
 func validateInstitution(instID string) (bool, error) {
 	institutions := []Institution{}
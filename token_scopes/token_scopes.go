@@ -10,6 +10,7 @@ const (
 	Pelican_DirectorTestReport TokenScope = "pelican.director_test_report"
 	Pelican_DirectorServiceDiscovery TokenScope = "pelican.director_service_discovery"
 	Pelican_NamespaceDelete TokenScope = "pelican.namespace_delete"
+	Pelican_NamespaceRotateKey TokenScope = "pelican.namespace_rotate_key"
 	WebUi_Access TokenScope = "web_ui.access"
 	Monitoring_Scrape TokenScope = "monitoring.scrape"
 	Monitoring_Query TokenScope = "monitoring.query"
@@ -213,8 +213,18 @@ export const PendingCard = ({
 
     const approveNamespace = async (e: React.MouseEvent) => {
         try {
+            // The registry records why each registration was approved
+            const comment = window.prompt(`Why is ${namespace.prefix} being approved?`)
+            if (comment == null || comment.trim() == "") {
+                return
+            }
+
             const response = await secureFetch(`/api/v1.0/registry_ui/namespaces/${namespace.id}/approve`, {
-                method: "PATCH"
+                method: "PATCH",
+                headers: {
+                    "Content-Type": "application/json"
+                },
+                body: JSON.stringify({comment: comment.trim()})
             })
 
             if (!response.ok){
@@ -233,8 +243,18 @@ export const PendingCard = ({
 
     const denyNamespace = async (e: React.MouseEvent) => {
         try {
+            // The registry records why each registration was denied
+            const comment = window.prompt(`Why is ${namespace.prefix} being denied?`)
+            if (comment == null || comment.trim() == "") {
+                return
+            }
+
             const response = await secureFetch(`/api/v1.0/registry_ui/namespaces/${namespace.id}/deny`, {
-                method: "PATCH"
+                method: "PATCH",
+                headers: {
+                    "Content-Type": "application/json"
+                },
+                body: JSON.stringify({comment: comment.trim()})
             })
 
             if (!response.ok){